@@ -4,11 +4,11 @@ import (
 	"context"
 	"errors"
 	"log"
+	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-
 	"github.com/digkill/TGStickerBot/internal/admin"
 	"github.com/digkill/TGStickerBot/internal/config"
 	"github.com/digkill/TGStickerBot/internal/database"
@@ -21,6 +21,13 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+
 	cfg, err := config.Load()
 	if err != nil {
 		log.Fatalf("config: %v", err)
@@ -41,7 +48,7 @@ func main() {
 		log.Fatalf("database migrate: %v", err)
 	}
 
-	botAPI, err := tgbotapi.NewBotAPI(cfg.BotToken)
+	botAPI, err := telegram.NewAPI(cfg)
 	if err != nil {
 		log.Fatalf("telegram bot: %v", err)
 	}
@@ -53,34 +60,86 @@ func main() {
 	promoRepo := repository.NewPromoRepository(db)
 	paymentRepo := repository.NewPaymentRepository(db)
 	planRepo := repository.NewPlanRepository(db)
+	referralRepo := repository.NewReferralRepository(db)
+	statsRepo := repository.NewStatsRepository(db)
+	broadcastRepo := repository.NewBroadcastRepository(db)
+	adminRepo := repository.NewAdminRepository(db)
 
-	userService := service.NewUserService(userRepo)
+	userService := service.NewUserService(userRepo, generationRepo, paymentRepo)
 	planService := service.NewPlanService(cfg, planRepo)
 	generationService := service.NewGenerationService(cfg, logr, userRepo, generationRepo, kieClient)
-	promoService := service.NewPromoService(promoRepo, userRepo)
-	paymentService := service.NewPaymentService(cfg, paymentRepo, userRepo, planService)
+	promoService := service.NewPromoService(cfg, promoRepo)
+	referralService := service.NewReferralService(cfg, logr, referralRepo, userRepo)
+	paymentService := service.NewPaymentService(cfg, logr, paymentRepo, userRepo, planService, promoService, referralService)
+	statsService := service.NewStatsService(statsRepo)
+	broadcastService := service.NewBroadcastService(cfg, logr, broadcastRepo, userRepo)
+	adminService := service.NewAdminService(adminRepo)
 
 	if err := planService.EnsureDefaultPlan(ctx); err != nil {
 		log.Fatalf("ensure default plan: %v", err)
 	}
-
-	uploader, err := storage.NewUploader(storage.Config{
-		Endpoint:      cfg.S3Endpoint,
-		Region:        cfg.S3Region,
-		AccessKey:     cfg.S3AccessKey,
-		SecretKey:     cfg.S3SecretKey,
-		Bucket:        cfg.S3Bucket,
-		PublicBaseURL: cfg.S3PublicBaseURL,
-		UsePathStyle:  cfg.S3UsePathStyle,
-		Prefix:        cfg.S3Prefix,
-	})
+	created, err := adminService.Bootstrap(ctx, cfg.AdminUsername, cfg.AdminPassword)
 	if err != nil {
-		log.Fatalf("storage uploader: %v", err)
+		log.Fatalf("admin accounts: %v", err)
+	}
+	if created {
+		logr.Info("created superadmin from ADMIN_USERNAME/ADMIN_PASSWORD", "username", cfg.AdminUsername)
 	}
 
-	bot := telegram.NewBot(cfg, botAPI, logr, userService, generationService, promoService, paymentService, uploader)
+	var uploader telegram.ImageStorage
+	// Broadcast attachments must outlive local storage's TTL; S3 keeps everything.
+	var broadcastFiles admin.FileStorage
+	var localFiles *storage.Local
+	// Local uploads are served by the admin server; S3 serves its own.
+	var publicFiles http.Handler
+	switch cfg.StorageBackend {
+	case "local":
+		localFiles, err = storage.NewLocal(storage.LocalConfig{
+			Dir:           cfg.LocalStorageDir,
+			PublicBaseURL: cfg.LocalStoragePublicURL,
+			TTL:           cfg.LocalStorageTTL,
+		}, logr)
+		if err != nil {
+			log.Fatalf("local storage: %v", err)
+		}
+		uploader, broadcastFiles, publicFiles = localFiles, localFiles.Persistent(), localFiles
+	default:
+		uploader, err = storage.NewUploader(storage.Config{
+			Endpoint:      cfg.S3Endpoint,
+			Region:        cfg.S3Region,
+			AccessKey:     cfg.S3AccessKey,
+			SecretKey:     cfg.S3SecretKey,
+			Bucket:        cfg.S3Bucket,
+			PublicBaseURL: cfg.S3PublicBaseURL,
+			UsePathStyle:  cfg.S3UsePathStyle,
+			Prefix:        cfg.S3Prefix,
+		})
+		if err != nil {
+			log.Fatalf("storage uploader: %v", err)
+		}
+		broadcastFiles = uploader
+	}
 
-	adminServer := admin.NewServer(cfg.AdminListenAddr, cfg.AdminUsername, cfg.AdminPassword, logr, userService, planService, promoService, paymentService, botAPI)
+	bot := telegram.NewBot(cfg, botAPI, logr, userService, generationService, promoService, paymentService, referralService, statsService, broadcastService, adminService, uploader)
+
+	adminServer := admin.NewServer(cfg.AdminListenAddr, logr, adminService, userService, planService, promoService, paymentService, generationService, statsService, broadcastService, broadcastFiles, publicFiles, botAPI)
+	go func() {
+		if err := broadcastService.Run(ctx, botAPI); err != nil && !errors.Is(err, context.Canceled) {
+			logr.Error("broadcast worker stopped", "err", err)
+		}
+	}()
+	go func() {
+		if err := broadcastService.RunScheduler(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			logr.Error("broadcast scheduler stopped", "err", err)
+		}
+	}()
+	if localFiles != nil {
+		go func() {
+			if err := localFiles.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				logr.Error("local storage cleanup stopped", "err", err)
+			}
+		}()
+	}
 	go func() {
 		if err := adminServer.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
 			logr.Error("admin server stopped", "err", err)
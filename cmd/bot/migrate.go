@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/digkill/TGStickerBot/internal/config"
+	"github.com/digkill/TGStickerBot/internal/database"
+)
+
+const migrateUsage = "usage: bot migrate up [N] | down [N] | status"
+
+// runMigrate implements "bot migrate up [N]" (all pending by default), "down [N]"
+// (the last one by default) and "status".
+func runMigrate(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New(migrateUsage)
+	}
+	steps := 0
+	if args[0] == "down" {
+		steps = 1
+	}
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 || args[0] == "status" {
+			return errors.New(migrateUsage)
+		}
+		steps = n
+	}
+
+	cfg, err := config.LoadDatabase()
+	if err != nil {
+		return err
+	}
+	db, err := database.Connect(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	switch args[0] {
+	case "up":
+		done, err := database.MigrateUp(ctx, db, steps)
+		for _, m := range done {
+			fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+	case "down":
+		done, err := database.MigrateDown(ctx, db, steps)
+		for _, m := range done {
+			fmt.Printf("reverted %d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		statuses, err := database.MigrationStatuses(ctx, db)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+		for _, s := range statuses {
+			state, appliedAt := "pending", ""
+			switch {
+			case s.Dirty:
+				state = "dirty"
+			case s.Applied && !s.Known:
+				state = "applied (unknown to this binary)"
+			case s.Applied:
+				state = "applied"
+			}
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return errors.New(migrateUsage)
+	}
+}
@@ -0,0 +1,147 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/service"
+)
+
+type adminUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+	Disabled *bool  `json:"disabled"`
+}
+
+type adminTokenRequest struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+func (req adminUserRequest) input() service.AdminUserInput {
+	return service.AdminUserInput{
+		Username: req.Username,
+		Password: req.Password,
+		Role:     models.AdminRole(req.Role),
+		Disabled: req.Disabled,
+	}
+}
+
+// handleWhoAmI returns the caller's name and role.
+func (s *Server) handleWhoAmI(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, principalFrom(r.Context()))
+}
+
+func (s *Server) handleListAdminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := s.admins.ListUsers(r.Context())
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, users)
+}
+
+func (s *Server) handleCreateAdminUser(w http.ResponseWriter, r *http.Request) {
+	var req adminUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	user, err := s.admins.CreateUser(r.Context(), req.input())
+	if err != nil {
+		s.adminError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, user)
+}
+
+// handleUpdateAdminUser changes the role, password or disabled flag; omitted fields
+// are kept.
+func (s *Server) handleUpdateAdminUser(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var req adminUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	user, err := s.admins.UpdateUser(r.Context(), id, req.input())
+	if err != nil {
+		s.adminError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, user)
+}
+
+func (s *Server) handleListAdminTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := s.admins.ListTokens(r.Context())
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, tokens)
+}
+
+// handleCreateAdminToken issues a bearer token. The secret is only in this response.
+func (s *Server) handleCreateAdminToken(w http.ResponseWriter, r *http.Request) {
+	var req adminTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	token, secret, err := s.admins.CreateToken(r.Context(), req.Name, models.AdminRole(req.Role), s.actor(r))
+	if err != nil {
+		s.adminError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, map[string]any{"token": secret, "details": token})
+}
+
+func (s *Server) handleRevokeAdminToken(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := s.admins.RevokeToken(r.Context(), id); err != nil {
+		s.adminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAuditLog lists mutating admin calls, newest first, optionally for ?actor=.
+func (s *Server) handleAuditLog(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		s.badRequest(w, err)
+		return
+	}
+	entries, err := s.admins.ListAudit(r.Context(), r.URL.Query().Get("actor"), limit, offset)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, entries)
+}
+
+func (s *Server) adminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrAdminInvalid):
+		s.badRequest(w, err)
+	case errors.Is(err, service.ErrAdminExists), errors.Is(err, service.ErrAdminLastSuperadmin):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrAdminNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		s.internalError(w, err)
+	}
+}
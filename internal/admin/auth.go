@@ -0,0 +1,171 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/service"
+)
+
+// maxAuditBody caps how much of a request body is kept in the audit log.
+const maxAuditBody = 64 << 10
+
+type principalKey struct{}
+
+// redactedFields are replaced in audited JSON and form bodies.
+var redactedFields = []string{"password", "token", "secret", "csrf_token"}
+
+func principalFrom(ctx context.Context) *service.AdminPrincipal {
+	p, _ := ctx.Value(principalKey{}).(*service.AdminPrincipal)
+	return p
+}
+
+// authMiddleware accepts an "Authorization: Bearer <token>" API token or Basic Auth
+// with an admin account, and stores the caller in the request context.
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var principal *service.AdminPrincipal
+		var err error
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			principal, err = s.admins.AuthenticateToken(r.Context(), strings.TrimSpace(token))
+		} else if user, pass, ok := r.BasicAuth(); ok {
+			principal, err = s.admins.Authenticate(r.Context(), user, pass)
+		} else {
+			err = service.ErrAdminUnauthorized
+		}
+		if err != nil {
+			if !errors.Is(err, service.ErrAdminUnauthorized) {
+				s.internalError(w, err)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="stickerbot"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
+
+// requireRole lets through callers with one of roles; superadmin is always allowed.
+func requireRole(roles ...models.AdminRole) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := principalFrom(r.Context())
+			if p == nil || (p.Role != models.RoleSuperadmin && !slices.Contains(roles, p.Role)) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// auditMiddleware records every mutating request with its caller, response status and
+// body. Secrets in JSON bodies are redacted and uploaded files are left out.
+func (s *Server) auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body := auditBody(r)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		entry := &models.AuditEntry{
+			Actor:      s.actor(r),
+			Method:     r.Method,
+			Path:       r.URL.RequestURI(),
+			Status:     status,
+			Body:       body,
+			RemoteAddr: r.RemoteAddr,
+		}
+		if err := s.admins.RecordAudit(context.WithoutCancel(r.Context()), entry); err != nil {
+			s.log.Error("admin audit log", "err", err, "method", r.Method, "path", entry.Path)
+		}
+	})
+}
+
+// auditBody reads the request body for the audit log and puts it back for the handler.
+func auditBody(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		return fmt.Sprintf("[multipart form, %d bytes]", r.ContentLength)
+	}
+	if mediaType == "application/x-www-form-urlencoded" {
+		// The web UI has already parsed the form to check its CSRF token.
+		if err := r.ParseForm(); err != nil {
+			return ""
+		}
+		return redactForm(r.PostForm)
+	}
+	if r.Body == nil {
+		return ""
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxAuditBody+1))
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+	if err != nil {
+		return ""
+	}
+	if len(data) > maxAuditBody {
+		return fmt.Sprintf("[more than %d bytes, not recorded]", maxAuditBody)
+	}
+	return redact(data)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func redact(data []byte) string {
+	var body map[string]any
+	if err := json.Unmarshal(data, &body); err != nil {
+		return string(data)
+	}
+	for _, field := range redactedFields {
+		if _, ok := body[field]; ok {
+			body[field] = "[redacted]"
+		}
+	}
+	out, err := json.Marshal(body)
+	if err != nil {
+		return string(data)
+	}
+	return string(out)
+}
+
+func redactForm(form url.Values) string {
+	out := url.Values{}
+	for k, v := range form {
+		out[k] = v
+		if slices.Contains(redactedFields, k) {
+			out[k] = []string{"[redacted]"}
+		}
+	}
+	return out.Encode()
+}
+
+// actor identifies the admin performing a request.
+func (s *Server) actor(r *http.Request) string {
+	if p := principalFrom(r.Context()); p != nil {
+		return p.Name
+	}
+	return ""
+}
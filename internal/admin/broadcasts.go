@@ -0,0 +1,325 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/service"
+)
+
+// maxBroadcastUpload limits attachments uploaded with a broadcast.
+const maxBroadcastUpload = 20 << 20
+
+// FileStorage publishes uploaded broadcast attachments and returns their public URL.
+type FileStorage interface {
+	Upload(ctx context.Context, data []byte, contentType string) (string, error)
+}
+
+type broadcastButton struct {
+	Text     string `json:"text"`
+	URL      string `json:"url"`
+	Callback string `json:"callback"`
+}
+
+type broadcastRequest struct {
+	Message    string              `json:"message"`
+	ParseMode  string              `json:"parse_mode"`
+	MediaType  string              `json:"media_type"`
+	MediaURL   string              `json:"media_url"`
+	Buttons    [][]broadcastButton `json:"buttons"`
+	Silent     bool                `json:"silent"`
+	Audience   audienceRequest     `json:"audience"`
+	SendAt     string              `json:"send_at"`
+	Recurrence string              `json:"recurrence"`
+	DryRun     bool                `json:"dry_run"`
+	ChatIDs    []int64             `json:"chat_ids"`
+}
+
+// audienceRequest mirrors models.AudienceFilter. Dates accept YYYY-MM-DD or RFC 3339;
+// a date-only signup_to includes that day.
+type audienceRequest struct {
+	Languages            []string `json:"languages"`
+	SignupFrom           string   `json:"signup_from"`
+	SignupTo             string   `json:"signup_to"`
+	HasPaid              *bool    `json:"has_paid"`
+	LastGenerationAfter  string   `json:"last_generation_after"`
+	LastGenerationBefore string   `json:"last_generation_before"`
+	MinPromoCredits      *int     `json:"min_promo_credits"`
+	MaxPromoCredits      *int     `json:"max_promo_credits"`
+	MinPaidCredits       *int     `json:"min_paid_credits"`
+	MaxPaidCredits       *int     `json:"max_paid_credits"`
+	Sources              []string `json:"sources"`
+	TelegramIDs          []int64  `json:"telegram_ids"`
+}
+
+func (req audienceRequest) filter() (models.AudienceFilter, error) {
+	f := models.AudienceFilter{
+		Languages:       req.Languages,
+		HasPaid:         req.HasPaid,
+		MinPromoCredits: req.MinPromoCredits,
+		MaxPromoCredits: req.MaxPromoCredits,
+		MinPaidCredits:  req.MinPaidCredits,
+		MaxPaidCredits:  req.MaxPaidCredits,
+		Sources:         req.Sources,
+		TelegramIDs:     req.TelegramIDs,
+	}
+	dates := []struct {
+		name      string
+		value     string
+		dest      **time.Time
+		inclusive bool
+	}{
+		{"signup_from", req.SignupFrom, &f.SignupFrom, false},
+		{"signup_to", req.SignupTo, &f.SignupTo, true},
+		{"last_generation_after", req.LastGenerationAfter, &f.LastGenerationAfter, false},
+		{"last_generation_before", req.LastGenerationBefore, &f.LastGenerationBefore, false},
+	}
+	for _, d := range dates {
+		value := strings.TrimSpace(d.value)
+		if value == "" {
+			continue
+		}
+		t, dateOnly, err := parseDateOrTime(value)
+		if err != nil {
+			return f, fmt.Errorf("invalid %s", d.name)
+		}
+		if dateOnly && d.inclusive {
+			t = t.AddDate(0, 0, 1)
+		}
+		*d.dest = &t
+	}
+	return f, nil
+}
+
+func (req broadcastRequest) content() models.BroadcastContent {
+	content := models.BroadcastContent{
+		Message:   req.Message,
+		ParseMode: req.ParseMode,
+		MediaType: req.MediaType,
+		MediaURL:  req.MediaURL,
+		Silent:    req.Silent,
+	}
+	for _, row := range req.Buttons {
+		buttons := make([]models.BroadcastButton, 0, len(row))
+		for _, btn := range row {
+			buttons = append(buttons, models.BroadcastButton{Text: btn.Text, URL: btn.URL, Callback: btn.Callback})
+		}
+		content.Buttons = append(content.Buttons, buttons)
+	}
+	return content
+}
+
+func (req broadcastRequest) input(actor string) (service.BroadcastInput, error) {
+	audience, err := req.Audience.filter()
+	if err != nil {
+		return service.BroadcastInput{}, err
+	}
+	sendAt, err := parseOptionalTime(req.SendAt)
+	if err != nil {
+		return service.BroadcastInput{}, fmt.Errorf("invalid send_at")
+	}
+	return service.BroadcastInput{
+		Content:    req.content(),
+		Audience:   audience,
+		SendAt:     sendAt,
+		Recurrence: req.Recurrence,
+		Actor:      actor,
+	}, nil
+}
+
+// handleListBroadcasts lists broadcasts, optionally filtered by ?status=.
+func (s *Server) handleListBroadcasts(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		s.badRequest(w, err)
+		return
+	}
+	status := models.BroadcastStatus(r.URL.Query().Get("status"))
+	broadcasts, err := s.broadcasts.List(r.Context(), status, limit, offset)
+	if err != nil {
+		s.broadcastError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, broadcasts)
+}
+
+// handleBroadcast queues a broadcast and returns its ID right away; delivery happens in
+// the background worker. With send_at it is scheduled instead, and with dry_run it only
+// reports the audience size.
+func (s *Server) handleBroadcast(w http.ResponseWriter, r *http.Request) {
+	req, err := s.decodeBroadcast(r)
+	if err != nil {
+		s.badRequest(w, err)
+		return
+	}
+	in, err := req.input(s.actor(r))
+	if err != nil {
+		s.badRequest(w, err)
+		return
+	}
+	if req.DryRun {
+		size, err := s.broadcasts.AudienceSize(r.Context(), in.Audience)
+		if err != nil {
+			s.broadcastError(w, err)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]any{"audience": size})
+		return
+	}
+	progress, err := s.broadcasts.Create(r.Context(), in)
+	if err != nil {
+		s.broadcastError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusAccepted, progress)
+}
+
+// handleUpdateBroadcast replaces a broadcast that is still scheduled.
+func (s *Server) handleUpdateBroadcast(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	req, err := s.decodeBroadcast(r)
+	if err != nil {
+		s.badRequest(w, err)
+		return
+	}
+	in, err := req.input(s.actor(r))
+	if err != nil {
+		s.badRequest(w, err)
+		return
+	}
+	progress, err := s.broadcasts.Update(r.Context(), id, in)
+	if err != nil {
+		s.broadcastError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, progress)
+}
+
+// handleCancelBroadcast cancels a scheduled, queued or running broadcast.
+func (s *Server) handleCancelBroadcast(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	progress, err := s.broadcasts.Cancel(r.Context(), id, s.actor(r))
+	if err != nil {
+		s.broadcastError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, progress)
+}
+
+// handleBroadcastTest sends the broadcast only to chat_ids (or BROADCAST_TEST_CHAT_IDS).
+func (s *Server) handleBroadcastTest(w http.ResponseWriter, r *http.Request) {
+	req, err := s.decodeBroadcast(r)
+	if err != nil {
+		s.badRequest(w, err)
+		return
+	}
+	results, err := s.broadcasts.TestSend(r.Context(), s.bot, req.content(), req.ChatIDs)
+	if err != nil {
+		s.broadcastError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, results)
+}
+
+// handleBroadcastProgress reports sent, failed, blocked and remaining recipients.
+func (s *Server) handleBroadcastProgress(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	progress, err := s.broadcasts.Progress(r.Context(), id)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	if progress == nil {
+		http.Error(w, "broadcast not found", http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, progress)
+}
+
+// decodeBroadcast accepts either a JSON body or multipart/form-data with the JSON in a
+// "payload" field and the attachment in "file". An uploaded file is stored and its
+// public URL becomes media_url.
+func (s *Server) decodeBroadcast(r *http.Request) (broadcastRequest, error) {
+	var req broadcastRequest
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, fmt.Errorf("invalid json")
+		}
+		return req, nil
+	}
+
+	r.Body = http.MaxBytesReader(nil, r.Body, maxBroadcastUpload+1<<20)
+	if err := r.ParseMultipartForm(maxBroadcastUpload); err != nil {
+		return req, fmt.Errorf("invalid multipart form: %w", err)
+	}
+	if err := json.Unmarshal([]byte(r.FormValue("payload")), &req); err != nil {
+		return req, fmt.Errorf("invalid payload json")
+	}
+	file, header, err := r.FormFile("file")
+	if errors.Is(err, http.ErrMissingFile) {
+		return req, nil
+	}
+	if err != nil {
+		return req, fmt.Errorf("read file: %w", err)
+	}
+	defer file.Close()
+	if s.storage == nil {
+		return req, fmt.Errorf("file uploads are not configured")
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return req, fmt.Errorf("read file: %w", err)
+	}
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(data)
+	}
+	if req.MediaType == "" {
+		req.MediaType = "document"
+		if strings.HasPrefix(contentType, "image/") && contentType != "image/gif" {
+			req.MediaType = "photo"
+		}
+	}
+	url, err := s.storage.Upload(r.Context(), data, contentType)
+	if err != nil {
+		return req, fmt.Errorf("upload file: %w", err)
+	}
+	req.MediaURL = url
+	return req, nil
+}
+
+func (s *Server) broadcastError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrBroadcastEmpty), errors.Is(err, service.ErrBroadcastInvalid), errors.Is(err, service.ErrBroadcastNoTestChats):
+		s.badRequest(w, err)
+	case errors.Is(err, service.ErrBroadcastNotFound):
+		http.Error(w, "broadcast not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrBroadcastNotEditable):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		s.internalError(w, err)
+	}
+}
@@ -0,0 +1,37 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+)
+
+// handleListPayments lists payments newest first, optionally filtered by ?status=.
+func (s *Server) handleListPayments(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		s.badRequest(w, err)
+		return
+	}
+	payments, err := s.payments.List(r.Context(), r.URL.Query().Get("status"), limit, offset)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, payments)
+}
+
+// handleListGenerations lists generation logs newest first, optionally filtered by ?model=.
+func (s *Server) handleListGenerations(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		s.badRequest(w, err)
+		return
+	}
+	logs, err := s.generations.List(r.Context(), models.ModelType(r.URL.Query().Get("model")), limit, offset)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, logs)
+}
@@ -0,0 +1,105 @@
+package admin
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/digkill/TGStickerBot/internal/service"
+)
+
+type promoBatchRequest struct {
+	promoRequest
+	Count  int    `json:"count"`
+	Prefix string `json:"prefix"`
+	Length int    `json:"length"`
+}
+
+// handleGeneratePromoBatch creates N random codes for an influencer/campaign batch.
+func (s *Server) handleGeneratePromoBatch(w http.ResponseWriter, r *http.Request) {
+	var req promoBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	settings, err := req.createInput()
+	if err != nil {
+		s.badRequest(w, err)
+		return
+	}
+	codes, err := s.promos.GenerateBatch(r.Context(), service.GeneratePromoBatchInput{
+		Count:    req.Count,
+		Prefix:   req.Prefix,
+		Length:   req.Length,
+		Settings: settings,
+	})
+	if err != nil {
+		s.badRequest(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, codes)
+}
+
+// handleExportPromoBatch streams all codes of a batch with their usage as CSV.
+func (s *Server) handleExportPromoBatch(w http.ResponseWriter, r *http.Request) {
+	batch, err := url.PathUnescape(chi.URLParam(r, "batch"))
+	if err != nil || batch == "" {
+		http.Error(w, "invalid batch", http.StatusBadRequest)
+		return
+	}
+	usage, err := s.promos.BatchUsage(r.Context(), batch)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	if len(usage) == 0 {
+		http.Error(w, "batch not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, url.PathEscape(batch)))
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"code", "kind", "max_uses", "uses", "redemptions", "last_redeemed_at", "enabled", "valid_from", "valid_until", "created_at"})
+	for _, u := range usage {
+		_ = cw.Write([]string{
+			u.Code,
+			string(u.Kind),
+			strconv.Itoa(u.MaxUses),
+			strconv.Itoa(u.Uses),
+			strconv.Itoa(u.Redemptions),
+			formatOptionalTime(u.LastRedeemedAt),
+			strconv.FormatBool(u.Enabled),
+			formatOptionalTime(u.ValidFrom),
+			formatOptionalTime(u.ValidUntil),
+			u.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		s.log.Error("write promo batch csv", "batch", batch, "err", err)
+	}
+}
+
+// handlePromoCampaigns reports redemptions per batch label; ?batch= narrows it to one.
+func (s *Server) handlePromoCampaigns(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.promos.CampaignStats(r.Context(), r.URL.Query().Get("batch"))
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, stats)
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -16,55 +16,144 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
+	"github.com/digkill/TGStickerBot/internal/metrics"
+	"github.com/digkill/TGStickerBot/internal/models"
 	"github.com/digkill/TGStickerBot/internal/service"
 )
 
 type Server struct {
-	addr     string
-	username string
-	password string
-	log      *slog.Logger
-	users    *service.UserService
-	plans    *service.PlanService
-	promos   *service.PromoService
-	payments *service.PaymentService
-	bot      *tgbotapi.BotAPI
-	router   *chi.Mux
+	addr        string
+	log         *slog.Logger
+	admins      *service.AdminService
+	users       *service.UserService
+	plans       *service.PlanService
+	promos      *service.PromoService
+	payments    *service.PaymentService
+	generations *service.GenerationService
+	stats       *service.StatsService
+	broadcasts  *service.BroadcastService
+	storage     FileStorage
+	files       http.Handler
+	bot         *tgbotapi.BotAPI
+	router      *chi.Mux
 }
 
-func NewServer(addr, username, password string, log *slog.Logger, users *service.UserService, plans *service.PlanService, promos *service.PromoService, payments *service.PaymentService, bot *tgbotapi.BotAPI) *Server {
+func NewServer(addr string, log *slog.Logger, admins *service.AdminService, users *service.UserService, plans *service.PlanService, promos *service.PromoService, payments *service.PaymentService, generations *service.GenerationService, stats *service.StatsService, broadcasts *service.BroadcastService, storage FileStorage, files http.Handler, bot *tgbotapi.BotAPI) *Server {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Recoverer)
 
 	s := &Server{
-		addr:     addr,
-		username: username,
-		password: password,
-		log:      log,
-		users:    users,
-		plans:    plans,
-		promos:   promos,
-		payments: payments,
-		bot:      bot,
-		router:   r,
-	}
+		addr:        addr,
+		log:         log,
+		admins:      admins,
+		users:       users,
+		plans:       plans,
+		promos:      promos,
+		payments:    payments,
+		generations: generations,
+		stats:       stats,
+		broadcasts:  broadcasts,
+		storage:     storage,
+		files:       files,
+		bot:         bot,
+		router:      r,
+	}
+	// Every role may read; writes need the role named in requireRole (or superadmin).
+	support := requireRole(models.RoleSupport)
+	finance := requireRole(models.RoleFinance)
+	superadmin := requireRole()
+
 	r.Post("/webhook/yookassa", s.handleYooKassaWebhook)
+	// Uploads kept by local storage are public: KIE and Telegram fetch them by URL.
+	if files != nil {
+		r.Get("/files/{name}", files.ServeHTTP)
+	}
 	r.Group(func(protected chi.Router) {
-		protected.Use(s.basicAuthMiddleware())
-		protected.Post("/broadcast", s.handleBroadcast)
+		protected.Use(s.authMiddleware)
+		protected.Use(s.auditMiddleware)
+		protected.Handle("/metrics", metrics.Handler())
+		protected.Get("/me", s.handleWhoAmI)
+		protected.Route("/broadcast", func(r chi.Router) {
+			r.Get("/", s.handleListBroadcasts)
+			r.With(superadmin).Post("/", s.handleBroadcast)
+			r.With(superadmin).Post("/test", s.handleBroadcastTest)
+			r.Get("/{id}", s.handleBroadcastProgress)
+			r.With(superadmin).Put("/{id}", s.handleUpdateBroadcast)
+			r.With(superadmin).Delete("/{id}", s.handleCancelBroadcast)
+		})
 		protected.Route("/plans", func(r chi.Router) {
 			r.Get("/", s.handleListPlans)
-			r.Post("/", s.handleCreatePlan)
-			r.Put("/{id}", s.handleUpdatePlan)
-			r.Delete("/{id}", s.handleDeletePlan)
+			r.With(finance).Post("/", s.handleCreatePlan)
+			r.With(finance).Put("/{id}", s.handleUpdatePlan)
+			r.With(finance).Delete("/{id}", s.handleDeletePlan)
 		})
 		protected.Route("/promo-codes", func(r chi.Router) {
 			r.Get("/", s.handleListPromos)
-			r.Post("/", s.handleCreatePromo)
-			r.Put("/{id}", s.handleUpdatePromo)
-			r.Delete("/{id}", s.handleDeletePromo)
+			r.With(finance).Post("/", s.handleCreatePromo)
+			r.With(finance).Post("/batches", s.handleGeneratePromoBatch)
+			r.Get("/batches/{batch}/export", s.handleExportPromoBatch)
+			r.Get("/campaigns", s.handlePromoCampaigns)
+			r.Get("/stats", s.handlePromoStats)
+			r.Get("/{id}/stats", s.handlePromoStats)
+			r.Get("/{id}/redemptions", s.handlePromoRedemptions)
+			r.With(finance).Put("/{id}", s.handleUpdatePromo)
+			r.With(finance).Delete("/{id}", s.handleDeletePromo)
+		})
+		protected.Route("/users", func(r chi.Router) {
+			r.Get("/", s.handleSearchUsers)
+			r.Get("/{id}", s.handleGetUser)
+			r.With(support).Post("/{id}/credits", s.handleAdjustCredits)
+			r.With(support).Post("/{id}/ban", s.handleBanUser)
+			r.With(support).Delete("/{id}/ban", s.handleUnbanUser)
+		})
+		protected.Get("/payments", s.handleListPayments)
+		protected.Get("/generations", s.handleListGenerations)
+		protected.Route("/stats", func(r chi.Router) {
+			r.Get("/", s.handleStats)
+			r.Get("/sources", s.handleSourceStats)
+		})
+		protected.Route("/admin-users", func(r chi.Router) {
+			r.Use(superadmin)
+			r.Get("/", s.handleListAdminUsers)
+			r.Post("/", s.handleCreateAdminUser)
+			r.Put("/{id}", s.handleUpdateAdminUser)
+		})
+		protected.Route("/admin-tokens", func(r chi.Router) {
+			r.Use(superadmin)
+			r.Get("/", s.handleListAdminTokens)
+			r.Post("/", s.handleCreateAdminToken)
+			r.Delete("/{id}", s.handleRevokeAdminToken)
+		})
+		protected.With(superadmin).Get("/audit-log", s.handleAuditLog)
+	})
+	r.Route("/ui", func(ui chi.Router) {
+		ui.Get("/login", s.handleUILoginForm)
+		ui.Post("/login", s.handleUILogin)
+		ui.Group(func(r chi.Router) {
+			r.Use(s.uiAuth)
+			r.Use(s.auditMiddleware)
+			r.Get("/", s.handleUIDashboard)
+			r.Post("/logout", s.handleUILogout)
+			r.Get("/plans", s.handleUIPlans)
+			r.With(finance).Post("/plans", s.handleUICreatePlan)
+			r.With(finance).Post("/plans/{id}", s.handleUIUpdatePlan)
+			r.With(finance).Post("/plans/{id}/delete", s.handleUIDeletePlan)
+			r.Get("/promo-codes", s.handleUIPromos)
+			r.With(finance).Post("/promo-codes", s.handleUICreatePromo)
+			r.With(finance).Post("/promo-codes/{id}/toggle", s.handleUITogglePromo)
+			r.With(finance).Post("/promo-codes/{id}/delete", s.handleUIDeletePromo)
+			r.Get("/users", s.handleUIUsers)
+			r.Get("/users/{id}", s.handleUIUser)
+			r.With(support).Post("/users/{id}/credits", s.handleUIAdjustCredits)
+			r.With(support).Post("/users/{id}/ban", s.handleUIBanUser)
+			r.With(support).Post("/users/{id}/unban", s.handleUIUnbanUser)
+			r.Get("/payments", s.handleUIPayments)
+			r.Get("/generations", s.handleUIGenerations)
+			r.Get("/broadcasts", s.handleUIBroadcasts)
+			r.With(superadmin).Post("/broadcasts", s.handleUICreateBroadcast)
+			r.With(superadmin).Post("/broadcasts/{id}/cancel", s.handleUICancelBroadcast)
 		})
 	})
 	return s
@@ -94,46 +183,6 @@ func (s *Server) Run(ctx context.Context) error {
 	return nil
 }
 
-type broadcastRequest struct {
-	Message string `json:"message"`
-}
-
-func (s *Server) handleBroadcast(w http.ResponseWriter, r *http.Request) {
-	var req broadcastRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid json", http.StatusBadRequest)
-		return
-	}
-	if strings.TrimSpace(req.Message) == "" {
-		http.Error(w, "message required", http.StatusBadRequest)
-		return
-	}
-
-	ctx := r.Context()
-	ids, err := s.users.ListTelegramIDs(ctx)
-	if err != nil {
-		s.log.Error("list telegram ids", "err", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
-		return
-	}
-
-	count := 0
-	for _, id := range ids {
-		msg := tgbotapi.NewMessage(id, req.Message)
-		if _, err := s.bot.Send(msg); err != nil {
-			s.log.Error("send broadcast", "user", id, "err", err)
-			continue
-		}
-		count++
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]any{
-		"sent":  count,
-		"total": len(ids),
-	})
-}
-
 func (s *Server) handleListPlans(w http.ResponseWriter, r *http.Request) {
 	plans, err := s.plans.List(r.Context())
 	if err != nil {
@@ -224,7 +273,12 @@ func (s *Server) handleCreatePromo(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "code and max_uses required", http.StatusBadRequest)
 		return
 	}
-	promo, err := s.promos.Create(r.Context(), req.Code, req.MaxUses)
+	input, err := req.createInput()
+	if err != nil {
+		s.badRequest(w, err)
+		return
+	}
+	promo, err := s.promos.Create(r.Context(), input)
 	if err != nil {
 		s.badRequest(w, err)
 		return
@@ -252,23 +306,42 @@ func (s *Server) handleUpdatePromo(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "promo not found", http.StatusNotFound)
 		return
 	}
-	code := existing.Code
-	if req.Code != nil && *req.Code != "" {
-		code = *req.Code
-	}
-	maxUses := existing.MaxUses
-	if req.MaxUses != nil && *req.MaxUses > 0 {
-		maxUses = *req.MaxUses
-	}
-	uses := existing.Uses
-	if req.Uses != nil && *req.Uses >= 0 {
-		uses = *req.Uses
+	input := service.UpdatePromoInput{
+		Code:            req.Code,
+		MaxUses:         req.MaxUses,
+		Uses:            req.Uses,
+		BonusCredits:    req.BonusCredits,
+		DiscountPercent: req.DiscountPercent,
+		DiscountAmount:  req.DiscountAmount,
+		PlanIDs:         req.PlanIDs,
+		NewUsersOnly:    req.NewUsersOnly,
+		Enabled:         req.Enabled,
+		Batch:           req.Batch,
+	}
+	if req.Kind != nil {
+		kind := models.PromoKind(*req.Kind)
+		input.Kind = &kind
+	}
+	if req.CreditType != nil {
+		creditType := models.CostType(*req.CreditType)
+		input.CreditType = &creditType
+	}
+	// An empty string clears the bound; an absent field leaves it unchanged.
+	if req.ValidFrom != nil {
+		if input.ValidFrom, err = parseOptionalTime(*req.ValidFrom); err != nil {
+			http.Error(w, "invalid valid_from", http.StatusBadRequest)
+			return
+		}
+		input.ClearValidFrom = input.ValidFrom == nil
 	}
-	if uses > maxUses {
-		http.Error(w, "uses cannot exceed max_uses", http.StatusBadRequest)
-		return
+	if req.ValidUntil != nil {
+		if input.ValidUntil, err = parseOptionalTime(*req.ValidUntil); err != nil {
+			http.Error(w, "invalid valid_until", http.StatusBadRequest)
+			return
+		}
+		input.ClearValidUntil = input.ValidUntil == nil
 	}
-	promo, err := s.promos.Update(r.Context(), id, code, maxUses, uses)
+	promo, err := s.promos.Update(r.Context(), id, input)
 	if err != nil {
 		s.badRequest(w, err)
 		return
@@ -289,6 +362,65 @@ func (s *Server) handleDeletePromo(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (s *Server) handlePromoRedemptions(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		s.badRequest(w, err)
+		return
+	}
+	promo, err := s.promos.GetByID(r.Context(), id)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	if promo == nil {
+		http.Error(w, "promo not found", http.StatusNotFound)
+		return
+	}
+	items, total, err := s.promos.Redemptions(r.Context(), id, limit, offset)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+		"items":  items,
+	})
+}
+
+// handlePromoStats serves both /promo-codes/stats (all codes) and /promo-codes/{id}/stats.
+func (s *Server) handlePromoStats(w http.ResponseWriter, r *http.Request) {
+	var id int64
+	if raw := chi.URLParam(r, "id"); raw != "" {
+		var err error
+		if id, err = parseID(raw); err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+	}
+	stats, err := s.promos.ConversionStats(r.Context(), id)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	if id == 0 {
+		s.writeJSON(w, http.StatusOK, stats)
+		return
+	}
+	if len(stats) == 0 {
+		http.Error(w, "promo not found", http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, stats[0])
+}
+
 // handleYooKassaWebhook is public endpoint for YooKassa payment status updates.
 // Expects JSON payload from YooKassa; on success credits the user and updates payment status.
 func (s *Server) handleYooKassaWebhook(w http.ResponseWriter, r *http.Request) {
@@ -297,7 +429,7 @@ func (s *Server) handleYooKassaWebhook(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "read body error", http.StatusBadRequest)
 		return
 	}
-	if err := s.payments.HandleYooKassaWebhook(r.Context(), body); err != nil {
+	if err := s.payments.HandleYooKassaWebhook(r.Context(), s.bot, body); err != nil {
 		s.log.Error("yookassa webhook", "err", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -306,20 +438,6 @@ func (s *Server) handleYooKassaWebhook(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte("ok"))
 }
 
-func (s *Server) basicAuthMiddleware() func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			user, pass, ok := r.BasicAuth()
-			if !ok || user != s.username || pass != s.password {
-				w.Header().Set("WWW-Authenticate", `Basic realm="stickerbot"`)
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
-				return
-			}
-			next.ServeHTTP(w, r)
-		})
-	}
-}
-
 func (s *Server) writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -339,6 +457,46 @@ func parseID(value string) (int64, error) {
 	return strconv.ParseInt(strings.TrimSpace(value), 10, 64)
 }
 
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// parsePagination reads ?limit= and ?offset= with sane defaults and bounds.
+func parsePagination(r *http.Request) (int, int, error) {
+	limit, offset := defaultPageLimit, 0
+	q := r.URL.Query()
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("invalid limit")
+		}
+		limit = min(n, maxPageLimit)
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid offset")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+// parseOptionalTime parses an RFC 3339 timestamp; an empty value yields nil.
+func parseOptionalTime(value string) (*time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
 type planRequest struct {
 	Title           string `json:"title"`
 	Description     string `json:"description"`
@@ -358,12 +516,60 @@ type planUpdateRequest struct {
 }
 
 type promoRequest struct {
-	Code    string `json:"code"`
-	MaxUses int    `json:"max_uses"`
+	Code            string  `json:"code"`
+	Kind            string  `json:"kind"`
+	MaxUses         int     `json:"max_uses"`
+	BonusCredits    int     `json:"bonus_credits"`
+	DiscountPercent int     `json:"discount_percent"`
+	DiscountAmount  int     `json:"discount_amount"`
+	PlanIDs         []int64 `json:"plan_ids"`
+	CreditType      string  `json:"credit_type"`
+	ValidFrom       string  `json:"valid_from"`
+	ValidUntil      string  `json:"valid_until"`
+	NewUsersOnly    bool    `json:"new_users_only"`
+	Enabled         *bool   `json:"enabled"`
+	Batch           string  `json:"batch"`
+}
+
+func (req promoRequest) createInput() (service.CreatePromoInput, error) {
+	validFrom, err := parseOptionalTime(req.ValidFrom)
+	if err != nil {
+		return service.CreatePromoInput{}, fmt.Errorf("invalid valid_from")
+	}
+	validUntil, err := parseOptionalTime(req.ValidUntil)
+	if err != nil {
+		return service.CreatePromoInput{}, fmt.Errorf("invalid valid_until")
+	}
+	return service.CreatePromoInput{
+		Code:            req.Code,
+		Kind:            models.PromoKind(req.Kind),
+		MaxUses:         req.MaxUses,
+		BonusCredits:    req.BonusCredits,
+		DiscountPercent: req.DiscountPercent,
+		DiscountAmount:  req.DiscountAmount,
+		PlanIDs:         req.PlanIDs,
+		CreditType:      models.CostType(req.CreditType),
+		ValidFrom:       validFrom,
+		ValidUntil:      validUntil,
+		NewUsersOnly:    req.NewUsersOnly,
+		Enabled:         req.Enabled,
+		Batch:           req.Batch,
+	}, nil
 }
 
 type promoUpdateRequest struct {
-	Code    *string `json:"code"`
-	MaxUses *int    `json:"max_uses"`
-	Uses    *int    `json:"uses"`
+	Code            *string  `json:"code"`
+	Kind            *string  `json:"kind"`
+	MaxUses         *int     `json:"max_uses"`
+	Uses            *int     `json:"uses"`
+	BonusCredits    *int     `json:"bonus_credits"`
+	DiscountPercent *int     `json:"discount_percent"`
+	DiscountAmount  *int     `json:"discount_amount"`
+	PlanIDs         *[]int64 `json:"plan_ids"`
+	CreditType      *string  `json:"credit_type"`
+	ValidFrom       *string  `json:"valid_from"`
+	ValidUntil      *string  `json:"valid_until"`
+	NewUsersOnly    *bool    `json:"new_users_only"`
+	Enabled         *bool    `json:"enabled"`
+	Batch           *string  `json:"batch"`
 }
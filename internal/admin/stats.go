@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultStatsRange = 30 * 24 * time.Hour
+
+// handleStats returns the dashboard overview (activity, signups, generations, revenue,
+// promo redemptions, subscription bonuses) for ?from=&to=.
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		s.badRequest(w, err)
+		return
+	}
+	overview, err := s.stats.Overview(r.Context(), from, to)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{
+		"from":  from,
+		"to":    to,
+		"stats": overview,
+	})
+}
+
+// handleSourceStats reports signups, generations and revenue per acquisition source.
+func (s *Server) handleSourceStats(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		s.badRequest(w, err)
+		return
+	}
+	stats, err := s.stats.Sources(r.Context(), from, to)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{
+		"from":    from,
+		"to":      to,
+		"sources": stats,
+	})
+}
+
+// parseDateRange reads ?from= and ?to= as RFC 3339 timestamps or YYYY-MM-DD dates
+// (UTC). A date-only "to" includes that whole day. Defaults to the last 30 days.
+func parseDateRange(r *http.Request) (time.Time, time.Time, error) {
+	now := time.Now().UTC()
+	to := now
+	from := now.Add(-defaultStatsRange)
+	q := r.URL.Query()
+	if v := strings.TrimSpace(q.Get("from")); v != "" {
+		t, _, err := parseDateOrTime(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from")
+		}
+		from = t
+	}
+	if v := strings.TrimSpace(q.Get("to")); v != "" {
+		t, dateOnly, err := parseDateOrTime(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to")
+		}
+		if dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		to = t
+	}
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must be after from")
+	}
+	return from, to, nil
+}
+
+func parseDateOrTime(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t.UTC(), true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return t.UTC(), false, nil
+}
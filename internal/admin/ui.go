@@ -0,0 +1,325 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/service"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+const (
+	sessionCookie   = "stickerbot_session"
+	loginCSRFCookie = "stickerbot_login"
+	flashCookie     = "stickerbot_flash"
+	uiPageSize      = 50
+	// maxUIForm caps form posts; the UI has no file uploads.
+	maxUIForm = 1 << 20
+)
+
+type sessionKey struct{}
+
+// uiPages are the templates rendered inside layout.html.
+var uiPages = []string{"login", "dashboard", "plans", "promos", "users", "user", "payments", "generations", "broadcasts"}
+
+var uiTemplates = parseUITemplates()
+
+func parseUITemplates() map[string]*template.Template {
+	funcs := template.FuncMap{
+		"datetime": formatUITime,
+		"money":    formatMoney,
+		"add1":     func(n int) int { return n + 1 },
+		"sub1":     func(n int) int { return n - 1 },
+		"list":     func(items ...string) []string { return items },
+	}
+	pages := make(map[string]*template.Template, len(uiPages))
+	for _, name := range uiPages {
+		pages[name] = template.Must(template.New(name).Funcs(funcs).ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html"))
+	}
+	return pages
+}
+
+// uiPage is what every template receives.
+type uiPage struct {
+	Title   string
+	Session *models.AdminSession
+	Flash   string
+	Error   string
+	Query   url.Values
+	Page    int
+	HasNext bool
+	Data    any
+}
+
+// Can reports whether the signed-in admin may use actions reserved for role.
+func (p uiPage) Can(role string) bool {
+	return p.Session != nil && (p.Session.Role == models.RoleSuperadmin || p.Session.Role == models.AdminRole(role))
+}
+
+// PageURL links to page n of the current list, keeping its filters.
+func (p uiPage) PageURL(n int) string {
+	q := url.Values{}
+	for k, v := range p.Query {
+		q[k] = v
+	}
+	q.Set("page", strconv.Itoa(n))
+	return "?" + q.Encode()
+}
+
+func sessionFrom(ctx context.Context) *models.AdminSession {
+	s, _ := ctx.Value(sessionKey{}).(*models.AdminSession)
+	return s
+}
+
+// uiAuth requires a session cookie, checks the CSRF token of every form post and puts
+// the admin into the context the same way authMiddleware does for the JSON API.
+func (s *Server) uiAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			token = c.Value
+		}
+		session, err := s.admins.Session(r.Context(), token)
+		if err != nil {
+			if !errors.Is(err, service.ErrAdminUnauthorized) {
+				s.internalError(w, err)
+				return
+			}
+			http.Redirect(w, r, "/ui/login", http.StatusSeeOther)
+			return
+		}
+		if r.Method == http.MethodPost {
+			r.Body = http.MaxBytesReader(w, r.Body, maxUIForm)
+			if !tokensEqual(r.PostFormValue("csrf_token"), session.CSRFToken) {
+				http.Error(w, "invalid csrf token", http.StatusForbidden)
+				return
+			}
+		}
+		ctx := context.WithValue(r.Context(), principalKey{}, &service.AdminPrincipal{Name: session.Username, Role: session.Role})
+		ctx = context.WithValue(ctx, sessionKey{}, session)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// handleUILoginForm shows the login form. Before there is a session, CSRF protection
+// uses a double-submit cookie.
+func (s *Server) handleUILoginForm(w http.ResponseWriter, r *http.Request) {
+	s.renderLogin(w, r, http.StatusOK, "")
+}
+
+func (s *Server) handleUILogin(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUIForm)
+	c, err := r.Cookie(loginCSRFCookie)
+	if err != nil || !tokensEqual(r.PostFormValue("csrf_token"), c.Value) {
+		s.renderLogin(w, r, http.StatusForbidden, "Форма устарела, попробуйте ещё раз.")
+		return
+	}
+	username := strings.TrimSpace(r.PostFormValue("username"))
+	token, session, err := s.admins.Login(r.Context(), username, r.PostFormValue("password"))
+	if err != nil {
+		if !errors.Is(err, service.ErrAdminUnauthorized) {
+			s.internalError(w, err)
+			return
+		}
+		s.log.Warn("admin ui login failed", "username", username, "remote_addr", r.RemoteAddr)
+		s.renderLogin(w, r, http.StatusUnauthorized, "Неверный логин или пароль.")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: loginCSRFCookie, Path: "/ui/login", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/ui",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	s.log.Info("admin ui login", "username", session.Username, "remote_addr", r.RemoteAddr)
+	http.Redirect(w, r, "/ui/", http.StatusSeeOther)
+}
+
+func (s *Server) handleUILogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if err := s.admins.Logout(r.Context(), c.Value); err != nil {
+			s.internalError(w, err)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/ui", MaxAge: -1})
+	http.Redirect(w, r, "/ui/login", http.StatusSeeOther)
+}
+
+func (s *Server) renderLogin(w http.ResponseWriter, r *http.Request, status int, message string) {
+	csrf, err := newUIToken()
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCSRFCookie,
+		Value:    csrf,
+		Path:     "/ui/login",
+		MaxAge:   int((30 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	s.renderStatus(w, r, status, "login", uiPage{Title: "Вход", Error: message, Data: csrf})
+}
+
+func (s *Server) render(w http.ResponseWriter, r *http.Request, name string, page uiPage) {
+	s.renderStatus(w, r, http.StatusOK, name, page)
+}
+
+// renderStatus executes a page template. The flash message left by the previous
+// redirect is shown once and then cleared.
+func (s *Server) renderStatus(w http.ResponseWriter, r *http.Request, status int, name string, page uiPage) {
+	page.Session = sessionFrom(r.Context())
+	page.Query = r.URL.Query()
+	if c, err := r.Cookie(flashCookie); err == nil {
+		if kind, text, ok := decodeFlash(c.Value); ok {
+			if kind == "error" {
+				page.Error = text
+			} else {
+				page.Flash = text
+			}
+		}
+		http.SetCookie(w, &http.Cookie{Name: flashCookie, Path: "/ui", MaxAge: -1})
+	}
+	var buf bytes.Buffer
+	if err := uiTemplates[name].ExecuteTemplate(&buf, "layout", page); err != nil {
+		s.internalError(w, fmt.Errorf("render %s: %w", name, err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	_, _ = buf.WriteTo(w)
+}
+
+// redirect finishes a form post (post/redirect/get). With err the target page shows the
+// error instead of message.
+func (s *Server) redirect(w http.ResponseWriter, r *http.Request, target, message string, err error) {
+	kind, text := "ok", message
+	if err != nil {
+		s.log.Warn("admin ui action failed", "path", r.URL.Path, "actor", s.actor(r), "err", err)
+		kind, text = "error", err.Error()
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Value:    base64.RawURLEncoding.EncodeToString([]byte(kind + "\n" + text)),
+		Path:     "/ui",
+		MaxAge:   60,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+func decodeFlash(value string) (string, string, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(raw), "\n")
+}
+
+// uiPagination reads ?page= (1-based) and returns the page, limit and offset. One
+// extra row is requested to tell whether a next page exists.
+func uiPagination(r *http.Request) (int, int, int) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	return page, uiPageSize + 1, (page - 1) * uiPageSize
+}
+
+// formInt parses an optional integer form field; empty means 0.
+func formInt(r *http.Request, name string) (int, error) {
+	value := strings.TrimSpace(r.PostFormValue(name))
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+	return n, nil
+}
+
+// formTime parses a date or datetime-local form field as UTC; empty yields nil. A
+// date-only value means the end of that day when endOfDay is set.
+func formTime(r *http.Request, name string, endOfDay bool) (*time.Time, error) {
+	value := strings.TrimSpace(r.PostFormValue(name))
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse("2006-01-02T15:04", value); err == nil {
+		return &t, nil
+	}
+	t, dateOnly, err := parseDateOrTime(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s", name)
+	}
+	if dateOnly && endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Second)
+	}
+	return &t, nil
+}
+
+func formatUITime(v any) string {
+	switch t := v.(type) {
+	case time.Time:
+		return t.UTC().Format("2006-01-02 15:04")
+	case *time.Time:
+		if t != nil {
+			return t.UTC().Format("2006-01-02 15:04")
+		}
+	}
+	return "—"
+}
+
+func formatMoney(amount int, currency string) string {
+	return fmt.Sprintf("%d.%02d %s", amount/100, abs(amount%100), currency)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func tokensEqual(got, want string) bool {
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+func newUIToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
@@ -0,0 +1,347 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/service"
+)
+
+// The UI handlers call the same services as the JSON API; form posts redirect back to
+// the list with a flash message.
+
+type uiDashboard struct {
+	From, To string
+	Stats    *models.Overview
+}
+
+func (s *Server) handleUIDashboard(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		s.renderStatus(w, r, http.StatusBadRequest, "dashboard", uiPage{Title: "Сводка", Error: err.Error(), Data: uiDashboard{}})
+		return
+	}
+	overview, err := s.stats.Overview(r.Context(), from, to)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	s.render(w, r, "dashboard", uiPage{Title: "Сводка", Data: uiDashboard{
+		From:  from.Format("2006-01-02"),
+		To:    to.Format("2006-01-02"),
+		Stats: overview,
+	}})
+}
+
+func (s *Server) handleUIPlans(w http.ResponseWriter, r *http.Request) {
+	plans, err := s.plans.List(r.Context())
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	s.render(w, r, "plans", uiPage{Title: "Тарифы", Data: plans})
+}
+
+func (s *Server) handleUICreatePlan(w http.ResponseWriter, r *http.Request) {
+	price, err := formInt(r, "price_minor_units")
+	if err != nil {
+		s.redirect(w, r, "/ui/plans", "", err)
+		return
+	}
+	credits, err := formInt(r, "credits")
+	if err != nil {
+		s.redirect(w, r, "/ui/plans", "", err)
+		return
+	}
+	active := r.PostFormValue("is_active") != ""
+	plan, err := s.plans.Create(r.Context(), service.CreatePlanInput{
+		Title:           strings.TrimSpace(r.PostFormValue("title")),
+		Description:     strings.TrimSpace(r.PostFormValue("description")),
+		Currency:        strings.TrimSpace(r.PostFormValue("currency")),
+		PriceMinorUnits: price,
+		Credits:         credits,
+		IsActive:        &active,
+	})
+	if err != nil {
+		s.redirect(w, r, "/ui/plans", "", err)
+		return
+	}
+	s.redirect(w, r, "/ui/plans", fmt.Sprintf("Тариф «%s» создан.", plan.Title), nil)
+}
+
+func (s *Server) handleUIUpdatePlan(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	price, err := formInt(r, "price_minor_units")
+	if err != nil {
+		s.redirect(w, r, "/ui/plans", "", err)
+		return
+	}
+	credits, err := formInt(r, "credits")
+	if err != nil {
+		s.redirect(w, r, "/ui/plans", "", err)
+		return
+	}
+	title := strings.TrimSpace(r.PostFormValue("title"))
+	description := strings.TrimSpace(r.PostFormValue("description"))
+	active := r.PostFormValue("is_active") != ""
+	_, err = s.plans.Update(r.Context(), id, service.UpdatePlanInput{
+		Title:           &title,
+		Description:     &description,
+		PriceMinorUnits: &price,
+		Credits:         &credits,
+		IsActive:        &active,
+	})
+	s.redirect(w, r, "/ui/plans", "Тариф сохранён.", err)
+}
+
+func (s *Server) handleUIDeletePlan(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	s.redirect(w, r, "/ui/plans", "Тариф удалён.", s.plans.Delete(r.Context(), id))
+}
+
+func (s *Server) handleUIPromos(w http.ResponseWriter, r *http.Request) {
+	promos, err := s.promos.List(r.Context())
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	s.render(w, r, "promos", uiPage{Title: "Промокоды", Data: promos})
+}
+
+func (s *Server) handleUICreatePromo(w http.ResponseWriter, r *http.Request) {
+	input, err := promoFormInput(r)
+	if err != nil {
+		s.redirect(w, r, "/ui/promo-codes", "", err)
+		return
+	}
+	promo, err := s.promos.Create(r.Context(), input)
+	if err != nil {
+		s.redirect(w, r, "/ui/promo-codes", "", err)
+		return
+	}
+	s.redirect(w, r, "/ui/promo-codes", fmt.Sprintf("Промокод %s создан.", promo.Code), nil)
+}
+
+func promoFormInput(r *http.Request) (service.CreatePromoInput, error) {
+	input := service.CreatePromoInput{
+		Code:         strings.TrimSpace(r.PostFormValue("code")),
+		Kind:         models.PromoKind(r.PostFormValue("kind")),
+		CreditType:   models.CostType(r.PostFormValue("credit_type")),
+		NewUsersOnly: r.PostFormValue("new_users_only") != "",
+		Batch:        strings.TrimSpace(r.PostFormValue("batch")),
+	}
+	var err error
+	if input.Code == "" {
+		return input, fmt.Errorf("code required")
+	}
+	if input.MaxUses, err = formInt(r, "max_uses"); err != nil {
+		return input, err
+	}
+	if input.MaxUses <= 0 {
+		return input, fmt.Errorf("max_uses must be positive")
+	}
+	if input.BonusCredits, err = formInt(r, "bonus_credits"); err != nil {
+		return input, err
+	}
+	if input.DiscountPercent, err = formInt(r, "discount_percent"); err != nil {
+		return input, err
+	}
+	if input.DiscountAmount, err = formInt(r, "discount_amount"); err != nil {
+		return input, err
+	}
+	if input.ValidUntil, err = formTime(r, "valid_until", true); err != nil {
+		return input, err
+	}
+	return input, nil
+}
+
+func (s *Server) handleUITogglePromo(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	enabled := r.PostFormValue("enabled") == "true"
+	_, err = s.promos.Update(r.Context(), id, service.UpdatePromoInput{Enabled: &enabled})
+	message := "Промокод выключен."
+	if enabled {
+		message = "Промокод включён."
+	}
+	s.redirect(w, r, "/ui/promo-codes", message, err)
+}
+
+func (s *Server) handleUIDeletePromo(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	s.redirect(w, r, "/ui/promo-codes", "Промокод удалён.", s.promos.Delete(r.Context(), id))
+}
+
+func (s *Server) handleUIUsers(w http.ResponseWriter, r *http.Request) {
+	page, limit, offset := uiPagination(r)
+	users, err := s.users.Search(r.Context(), r.URL.Query().Get("q"), limit, offset)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	hasNext := len(users) > uiPageSize
+	s.render(w, r, "users", uiPage{Title: "Пользователи", Page: page, HasNext: hasNext, Data: users[:min(len(users), uiPageSize)]})
+}
+
+func (s *Server) handleUIUser(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	details, err := s.users.Details(r.Context(), id)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	if details == nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	s.render(w, r, "user", uiPage{Title: fmt.Sprintf("Пользователь #%d", id), Data: details})
+}
+
+func (s *Server) handleUIAdjustCredits(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	target := fmt.Sprintf("/ui/users/%d", id)
+	delta, err := formInt(r, "delta")
+	if err != nil {
+		s.redirect(w, r, target, "", err)
+		return
+	}
+	_, applied, err := s.users.AdjustCredits(r.Context(), id, service.AdjustCreditsInput{
+		CreditType: models.CostType(r.PostFormValue("credit_type")),
+		Delta:      delta,
+		Reason:     r.PostFormValue("reason"),
+		Actor:      s.actor(r),
+	})
+	s.redirect(w, r, target, fmt.Sprintf("Баланс изменён на %+d.", applied), err)
+}
+
+func (s *Server) handleUIBanUser(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	target := fmt.Sprintf("/ui/users/%d", id)
+	until, err := formTime(r, "until", true)
+	if err != nil {
+		s.redirect(w, r, target, "", err)
+		return
+	}
+	status := r.PostFormValue("status")
+	_, err = s.users.Ban(r.Context(), id, models.BanStatus(status), r.PostFormValue("reason"), until)
+	if err == nil {
+		s.log.Info("user banned", "user_id", id, "status", status, "actor", s.actor(r))
+	}
+	s.redirect(w, r, target, "Пользователь заблокирован.", err)
+}
+
+func (s *Server) handleUIUnbanUser(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	_, err = s.users.Unban(r.Context(), id)
+	if err == nil {
+		s.log.Info("user unbanned", "user_id", id, "actor", s.actor(r))
+	}
+	s.redirect(w, r, fmt.Sprintf("/ui/users/%d", id), "Блокировка снята.", err)
+}
+
+func (s *Server) handleUIPayments(w http.ResponseWriter, r *http.Request) {
+	page, limit, offset := uiPagination(r)
+	payments, err := s.payments.List(r.Context(), r.URL.Query().Get("status"), limit, offset)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	hasNext := len(payments) > uiPageSize
+	s.render(w, r, "payments", uiPage{Title: "Платежи", Page: page, HasNext: hasNext, Data: payments[:min(len(payments), uiPageSize)]})
+}
+
+func (s *Server) handleUIGenerations(w http.ResponseWriter, r *http.Request) {
+	page, limit, offset := uiPagination(r)
+	logs, err := s.generations.List(r.Context(), models.ModelType(r.URL.Query().Get("model")), limit, offset)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	hasNext := len(logs) > uiPageSize
+	s.render(w, r, "generations", uiPage{Title: "Генерации", Page: page, HasNext: hasNext, Data: logs[:min(len(logs), uiPageSize)]})
+}
+
+func (s *Server) handleUIBroadcasts(w http.ResponseWriter, r *http.Request) {
+	page, limit, offset := uiPagination(r)
+	broadcasts, err := s.broadcasts.List(r.Context(), models.BroadcastStatus(r.URL.Query().Get("status")), limit, offset)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	hasNext := len(broadcasts) > uiPageSize
+	s.render(w, r, "broadcasts", uiPage{Title: "Рассылки", Page: page, HasNext: hasNext, Data: broadcasts[:min(len(broadcasts), uiPageSize)]})
+}
+
+// handleUICreateBroadcast queues or schedules a text broadcast to every reachable user.
+// Attachments, buttons and audience filters remain in the JSON API.
+func (s *Server) handleUICreateBroadcast(w http.ResponseWriter, r *http.Request) {
+	sendAt, err := formTime(r, "send_at", false)
+	if err != nil {
+		s.redirect(w, r, "/ui/broadcasts", "", err)
+		return
+	}
+	progress, err := s.broadcasts.Create(r.Context(), service.BroadcastInput{
+		Content: models.BroadcastContent{
+			Message:   r.PostFormValue("message"),
+			ParseMode: r.PostFormValue("parse_mode"),
+			Silent:    r.PostFormValue("silent") != "",
+		},
+		SendAt:     sendAt,
+		Recurrence: r.PostFormValue("recurrence"),
+		Actor:      s.actor(r),
+	})
+	if err != nil {
+		s.redirect(w, r, "/ui/broadcasts", "", err)
+		return
+	}
+	message := fmt.Sprintf("Рассылка #%d поставлена в очередь, получателей: %d.", progress.ID, progress.Total)
+	if progress.Status == models.BroadcastScheduled {
+		message = fmt.Sprintf("Рассылка #%d запланирована на %s UTC.", progress.ID, formatUITime(progress.SendAt))
+	}
+	s.redirect(w, r, "/ui/broadcasts", message, nil)
+}
+
+func (s *Server) handleUICancelBroadcast(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	_, err = s.broadcasts.Cancel(r.Context(), id, s.actor(r))
+	s.redirect(w, r, "/ui/broadcasts", fmt.Sprintf("Рассылка #%d отменена.", id), err)
+}
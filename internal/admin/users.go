@@ -0,0 +1,130 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/service"
+)
+
+type adjustCreditsRequest struct {
+	CreditType string `json:"credit_type"`
+	Delta      int    `json:"delta"`
+	Reason     string `json:"reason"`
+}
+
+type banRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+	Until  string `json:"until"`
+}
+
+// handleSearchUsers looks users up by ?q= (telegram/internal id, @username or name).
+func (s *Server) handleSearchUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		s.badRequest(w, err)
+		return
+	}
+	users, err := s.users.Search(r.Context(), r.URL.Query().Get("q"), limit, offset)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, users)
+}
+
+func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	details, err := s.users.Details(r.Context(), id)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	if details == nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, details)
+}
+
+// handleAdjustCredits adds (or with a negative delta removes) promo or paid credits.
+// The reason and the admin who made the change are stored alongside.
+func (s *Server) handleAdjustCredits(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var req adjustCreditsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	user, _, err := s.users.AdjustCredits(r.Context(), id, service.AdjustCreditsInput{
+		CreditType: models.CostType(req.CreditType),
+		Delta:      req.Delta,
+		Reason:     req.Reason,
+		Actor:      s.actor(r),
+	})
+	s.writeUserResult(w, user, err)
+}
+
+// handleBanUser applies a full ("banned") or generation-only ("generation") ban,
+// optionally until an RFC 3339 timestamp.
+func (s *Server) handleBanUser(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var req banRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	until, err := parseOptionalTime(req.Until)
+	if err != nil {
+		s.badRequest(w, err)
+		return
+	}
+	user, err := s.users.Ban(r.Context(), id, models.BanStatus(req.Status), req.Reason, until)
+	if err == nil {
+		s.log.Info("user banned", "user_id", id, "status", req.Status, "actor", s.actor(r))
+	}
+	s.writeUserResult(w, user, err)
+}
+
+func (s *Server) handleUnbanUser(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	user, err := s.users.Unban(r.Context(), id)
+	if err == nil {
+		s.log.Info("user unbanned", "user_id", id, "actor", s.actor(r))
+	}
+	s.writeUserResult(w, user, err)
+}
+
+func (s *Server) writeUserResult(w http.ResponseWriter, user *models.User, err error) {
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		http.Error(w, "user not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrAdjustmentReasonRequired), errors.Is(err, service.ErrAdjustmentInvalid), errors.Is(err, service.ErrBanInvalid):
+		s.badRequest(w, err)
+	case err != nil:
+		s.internalError(w, err)
+	default:
+		s.writeJSON(w, http.StatusOK, user)
+	}
+}
@@ -16,9 +16,11 @@ import (
 // Config aggregates runtime configuration for the bot and supporting services.
 type Config struct {
 	BotToken                     string
+	TelegramAPIURL               string
 	MySQLDSN                     string
 	KIEAPIKey                    string
 	KIEBaseURL                   string
+	KIEPollInterval              time.Duration
 	Flux2Path                    string
 	NanoBananaPath               string
 	RequestTimeout               time.Duration
@@ -28,6 +30,10 @@ type Config struct {
 	SubscriptionChannelUsername  string
 	SubscriptionChannelID        int64
 	SubscriptionBonusGenerations int
+	ReferralInviteeBonus         int
+	ReferralReferrerBonus        int
+	BroadcastRatePerSecond       int
+	BroadcastTestChatIDs         []int64
 	TelegramPaymentProviderToken string
 	PaymentCurrency              string
 	PaymentPriceMinorUnits       int
@@ -39,6 +45,11 @@ type Config struct {
 	AdminListenAddr              string
 	AdminUsername                string
 	AdminPassword                string
+	AdminTelegramIDs             []int64
+	StorageBackend               string
+	LocalStorageDir              string
+	LocalStoragePublicURL        string
+	LocalStorageTTL              time.Duration
 	S3Endpoint                   string
 	S3Region                     string
 	S3AccessKey                  string
@@ -49,6 +60,19 @@ type Config struct {
 	S3Prefix                     string
 }
 
+// LoadDatabase reads only the database settings, for maintenance commands such as
+// "migrate" that must work without the bot's other credentials.
+func LoadDatabase() (Config, error) {
+	if err := loadEnvFile(); err != nil {
+		return Config{}, err
+	}
+	cfg := Config{MySQLDSN: os.Getenv("MYSQL_DSN")}
+	if cfg.MySQLDSN == "" {
+		return Config{}, fmt.Errorf("missing required environment variables: %v", []string{"MYSQL_DSN"})
+	}
+	return cfg, nil
+}
+
 // Load reads configuration from environment variables, applying sane defaults.
 func Load() (Config, error) {
 	if err := loadEnvFile(); err != nil {
@@ -58,9 +82,11 @@ func Load() (Config, error) {
 	const defaultKIEBaseURL = "https://api.kie.ai"
 
 	cfg := Config{
+		TelegramAPIURL:               strings.TrimRight(getEnv("TELEGRAM_API_URL", "https://api.telegram.org"), "/"),
 		KIEBaseURL:                   normalizeKIEBaseURL(getEnv("KIE_BASE_URL", defaultKIEBaseURL), defaultKIEBaseURL),
 		Flux2Path:                    getEnv("KIE_FLUX2_PATH", "/api/v1/run/flux-2"),
 		NanoBananaPath:               getEnv("KIE_NANO_BANANA_PATH", "/api/v1/run/nano-banana-pro"),
+		KIEPollInterval:              time.Millisecond * time.Duration(getInt("KIE_POLL_INTERVAL_MS", 2000)),
 		RequestTimeout:               time.Second * time.Duration(getInt("HTTP_TIMEOUT_SECONDS", 60)),
 		FreeDailyGenerations:         getInt("FREE_DAILY_GENERATIONS", 0),
 		PromoBonusGenerations:        getInt("PROMO_BONUS_GENERATIONS", 100),
@@ -68,6 +94,10 @@ func Load() (Config, error) {
 		SubscriptionChannelUsername:  normalizeChannelUsername(getEnv("SUBSCRIPTION_CHANNEL_USERNAME", "")),
 		SubscriptionChannelID:        getInt64("SUBSCRIPTION_CHANNEL_ID", 0),
 		SubscriptionBonusGenerations: getInt("SUBSCRIPTION_BONUS_GENERATIONS", 100),
+		ReferralInviteeBonus:         getInt("REFERRAL_INVITEE_BONUS", 20),
+		ReferralReferrerBonus:        getInt("REFERRAL_REFERRER_BONUS", 50),
+		BroadcastRatePerSecond:       getInt("BROADCAST_RATE_PER_SECOND", 20),
+		BroadcastTestChatIDs:         getInt64List("BROADCAST_TEST_CHAT_IDS"),
 		PaymentCurrency:              getEnv("PAYMENT_CURRENCY", "RUB"),
 		PaymentPriceMinorUnits:       getInt("PAYMENT_PRICE_MINOR_UNITS", 29900),
 		PaymentCreditsPerPackage:     getInt("PAYMENT_CREDITS_PER_PACKAGE", 50),
@@ -77,7 +107,12 @@ func Load() (Config, error) {
 		YooKassaReturnURL:            getEnv("YOOKASSA_RETURN_URL", ""),
 		AdminListenAddr:              getEnv("ADMIN_LISTEN_ADDR", ":8080"),
 		AdminUsername:                getEnv("ADMIN_USERNAME", "admin"),
-		AdminPassword:                getEnv("ADMIN_PASSWORD", "change-me"),
+		AdminPassword:                os.Getenv("ADMIN_PASSWORD"),
+		AdminTelegramIDs:             getInt64List("ADMIN_TELEGRAM_IDS"),
+		StorageBackend:               strings.ToLower(getEnv("STORAGE_BACKEND", "s3")),
+		LocalStorageDir:              getEnv("LOCAL_STORAGE_DIR", filepath.Join("data", "files")),
+		LocalStoragePublicURL:        os.Getenv("LOCAL_STORAGE_PUBLIC_URL"),
+		LocalStorageTTL:              time.Hour * time.Duration(getInt("LOCAL_STORAGE_TTL_HOURS", 168)),
 		S3Endpoint:                   getEnv("S3_ENDPOINT", ""),
 		S3Region:                     os.Getenv("S3_REGION"),
 		S3AccessKey:                  os.Getenv("S3_ACCESS_KEY"),
@@ -122,24 +157,37 @@ func Load() (Config, error) {
 			missing = append(missing, "YOOKASSA_SECRET_KEY")
 		}
 	}
-	if cfg.S3Region == "" {
-		missing = append(missing, "S3_REGION")
-	}
-	if cfg.S3AccessKey == "" {
-		missing = append(missing, "S3_ACCESS_KEY")
-	}
-	if cfg.S3SecretKey == "" {
-		missing = append(missing, "S3_SECRET_KEY")
-	}
-	if cfg.S3Bucket == "" {
-		missing = append(missing, "S3_BUCKET")
-	}
-	if cfg.S3PublicBaseURL == "" {
-		missing = append(missing, "S3_PUBLIC_BASE_URL")
+	switch cfg.StorageBackend {
+	case "s3":
+		if cfg.S3Region == "" {
+			missing = append(missing, "S3_REGION")
+		}
+		if cfg.S3AccessKey == "" {
+			missing = append(missing, "S3_ACCESS_KEY")
+		}
+		if cfg.S3SecretKey == "" {
+			missing = append(missing, "S3_SECRET_KEY")
+		}
+		if cfg.S3Bucket == "" {
+			missing = append(missing, "S3_BUCKET")
+		}
+		if cfg.S3PublicBaseURL == "" {
+			missing = append(missing, "S3_PUBLIC_BASE_URL")
+		}
+	case "local":
+		if cfg.LocalStoragePublicURL == "" {
+			missing = append(missing, "LOCAL_STORAGE_PUBLIC_URL")
+		}
+	default:
+		return Config{}, fmt.Errorf("unsupported STORAGE_BACKEND %q; use s3 or local", cfg.StorageBackend)
 	}
 	if len(missing) > 0 {
 		return Config{}, fmt.Errorf("missing required environment variables: %v", missing)
 	}
+	// The admin API must never run with the placeholder password from env.example.
+	if cfg.AdminPassword == "change-me" {
+		return Config{}, fmt.Errorf("ADMIN_PASSWORD is the default %q; set a real password or leave it empty once admin accounts exist", cfg.AdminPassword)
+	}
 
 	return cfg, nil
 }
@@ -204,6 +252,17 @@ func getInt64(key string, fallback int64) int64 {
 	return i
 }
 
+// getInt64List parses a comma-separated list of integers, skipping invalid entries.
+func getInt64List(key string) []int64 {
+	var values []int64
+	for _, part := range strings.Split(os.Getenv(key), ",") {
+		if v, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64); err == nil {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
 func getBool(key string, fallback bool) bool {
 	v := os.Getenv(key)
 	if v == "" {
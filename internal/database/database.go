@@ -3,21 +3,80 @@ package database
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"fmt"
 	"strings"
 	"time"
 
-	mysqlDriver "github.com/go-sql-driver/mysql"
+	"modernc.org/sqlite"
 
 	"github.com/digkill/TGStickerBot/internal/config"
 )
 
-// Connect opens the MySQL connection with sensible pooling defaults.
+// Dialect is the SQL flavour of an open database.
+type Dialect int
+
+const (
+	MySQL Dialect = iota
+	SQLite
+)
+
+func (d Dialect) String() string {
+	if d == SQLite {
+		return "sqlite"
+	}
+	return "mysql"
+}
+
+// sqliteScheme prefixes DSNs of a SQLite database file: sqlite://data/bot.db is
+// relative to the working directory, sqlite:///var/lib/bot.db is absolute.
+const sqliteScheme = "sqlite:"
+
+// sqliteParams make transactions take the write lock up front, which stands in for
+// SELECT ... FOR UPDATE. Timestamps are bound by timeConn, in the same text that
+// CURRENT_TIMESTAMP produces, so stored values and SQL-side ones compare as strings.
+var sqliteParams = []string{
+	"_txlock=immediate",
+	"_pragma=foreign_keys(1)",
+	"_pragma=busy_timeout(10000)",
+	"_pragma=journal_mode(WAL)",
+}
+
+// DialectOf reports which database db talks to.
+func DialectOf(db *sql.DB) Dialect {
+	if _, ok := db.Driver().(*sqlite.Driver); ok {
+		return SQLite
+	}
+	return MySQL
+}
+
+// Open opens the database named by dsn without connecting: a sqlite: DSN opens a
+// local file, anything else is a MySQL DSN.
+func Open(dsn string) (*sql.DB, Dialect, error) {
+	path, ok := strings.CutPrefix(dsn, sqliteScheme)
+	if !ok {
+		db, err := sql.Open("mysql", dsn)
+		if err != nil {
+			return nil, MySQL, fmt.Errorf("open mysql: %w", err)
+		}
+		return db, MySQL, nil
+	}
+	path = strings.TrimPrefix(path, "//")
+	if path == "" {
+		return nil, SQLite, fmt.Errorf("open sqlite: no file in DSN %q", dsn)
+	}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	db := sql.OpenDB(sqliteConnector{dsn: "file:" + path + sep + strings.Join(sqliteParams, "&"), driver: &sqlite.Driver{}})
+	return db, SQLite, nil
+}
+
+// Connect opens the database with sensible pooling defaults.
 func Connect(cfg config.Config) (*sql.DB, error) {
-	db, err := sql.Open("mysql", cfg.MySQLDSN)
+	db, dialect, err := Open(cfg.MySQLDSN)
 	if err != nil {
-		return nil, fmt.Errorf("open mysql: %w", err)
+		return nil, err
 	}
 
 	db.SetConnMaxLifetime(time.Minute * 5)
@@ -29,67 +88,8 @@ func Connect(cfg config.Config) (*sql.DB, error) {
 
 	if err := db.PingContext(ctx); err != nil {
 		db.Close()
-		return nil, fmt.Errorf("ping mysql: %w", err)
+		return nil, fmt.Errorf("ping %s: %w", dialect, err)
 	}
 
 	return db, nil
 }
-
-// Migrate runs the bootstrap schema to ensure required tables exist.
-func Migrate(ctx context.Context, db *sql.DB) error {
-	stmts := strings.Split(schema, ";")
-	for _, stmt := range stmts {
-		stmt = strings.TrimSpace(stmt)
-		if stmt == "" {
-			continue
-		}
-		if _, err := db.ExecContext(ctx, stmt); err != nil {
-			return fmt.Errorf("apply schema: %w", err)
-		}
-	}
-
-	optional := []struct {
-		stmt          string
-		allowedErrors []uint16
-	}{
-		{
-			stmt:          `ALTER TABLE users ADD COLUMN subscription_bonus_granted TINYINT(1) NOT NULL DEFAULT 0 AFTER paid_credits`,
-			allowedErrors: []uint16{1060},
-		},
-		{
-			stmt:          `ALTER TABLE promo_codes ADD COLUMN created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP`,
-			allowedErrors: []uint16{1060},
-		},
-		{
-			stmt:          `ALTER TABLE payments ADD COLUMN plan_id BIGINT NULL AFTER user_id`,
-			allowedErrors: []uint16{1060},
-		},
-	}
-
-	for _, opt := range optional {
-		if err := execIgnoreErrors(ctx, db, opt.stmt, opt.allowedErrors...); err != nil {
-			return fmt.Errorf("apply optional schema: %w", err)
-		}
-	}
-
-	return nil
-}
-
-func execIgnoreErrors(ctx context.Context, db *sql.DB, stmt string, allowedCodes ...uint16) error {
-	if stmt == "" {
-		return nil
-	}
-	_, err := db.ExecContext(ctx, stmt)
-	if err == nil {
-		return nil
-	}
-	var mysqlErr *mysqlDriver.MySQLError
-	if errors.As(err, &mysqlErr) {
-		for _, code := range allowedCodes {
-			if mysqlErr.Number == code {
-				return nil
-			}
-		}
-	}
-	return err
-}
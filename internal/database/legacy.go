@@ -0,0 +1,205 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	mysqlDriver "github.com/go-sql-driver/mysql"
+)
+
+// legacyColumns are the idempotent ALTERs the bot used to run on every start, before
+// versioned migrations. They bring a database created by an older release up to the
+// baseline (migration 1) and run only once, right before it is stamped. New schema
+// changes go into migrations/ instead.
+var legacyColumns = []struct {
+	stmt          string
+	allowedErrors []uint16
+}{
+	{
+		stmt:          `ALTER TABLE users ADD COLUMN subscription_bonus_granted TINYINT(1) NOT NULL DEFAULT 0 AFTER paid_credits`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE promo_codes ADD COLUMN created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE payments ADD COLUMN plan_id BIGINT NULL AFTER user_id`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE promo_codes ADD COLUMN bonus_credits INT NOT NULL DEFAULT 0 AFTER uses`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE promo_codes ADD COLUMN credit_type VARCHAR(16) NOT NULL DEFAULT 'promo' AFTER bonus_credits`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE promo_codes ADD COLUMN valid_from TIMESTAMP NULL AFTER credit_type`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE promo_codes ADD COLUMN valid_until TIMESTAMP NULL AFTER valid_from`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE promo_codes ADD COLUMN new_users_only TINYINT(1) NOT NULL DEFAULT 0 AFTER valid_until`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE promo_codes ADD COLUMN enabled TINYINT(1) NOT NULL DEFAULT 1 AFTER new_users_only`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE promo_codes ADD COLUMN kind VARCHAR(16) NOT NULL DEFAULT 'credits' AFTER code`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE promo_codes ADD COLUMN discount_percent INT NOT NULL DEFAULT 0 AFTER bonus_credits`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE promo_codes ADD COLUMN discount_amount INT NOT NULL DEFAULT 0 AFTER discount_percent`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE payments ADD COLUMN promo_code_id BIGINT NULL AFTER status`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE payments ADD COLUMN discount_minor_units INT NOT NULL DEFAULT 0 AFTER promo_code_id`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE promo_codes ADD COLUMN batch VARCHAR(64) NULL AFTER enabled`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `CREATE INDEX idx_promo_codes_batch ON promo_codes (batch)`,
+		allowedErrors: []uint16{1061},
+	},
+	{
+		stmt:          `ALTER TABLE users ADD COLUMN referral_code VARCHAR(32) NULL AFTER subscription_bonus_granted`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `CREATE UNIQUE INDEX uniq_users_referral_code ON users (referral_code)`,
+		allowedErrors: []uint16{1061},
+	},
+	{
+		stmt:          `ALTER TABLE users ADD COLUMN source VARCHAR(64) NULL AFTER referral_code`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `CREATE INDEX idx_users_source ON users (source)`,
+		allowedErrors: []uint16{1061},
+	},
+	{
+		stmt:          `ALTER TABLE users ADD COLUMN ban_status VARCHAR(16) NOT NULL DEFAULT '' AFTER source`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE users ADD COLUMN ban_reason VARCHAR(512) NULL AFTER ban_status`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE users ADD COLUMN banned_until TIMESTAMP NULL AFTER ban_reason`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE users ADD COLUMN subscription_bonus_granted_at TIMESTAMP NULL AFTER subscription_bonus_granted`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE broadcasts ADD COLUMN parse_mode VARCHAR(16) NULL AFTER message`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE broadcasts ADD COLUMN media_type VARCHAR(16) NULL AFTER parse_mode`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE broadcasts ADD COLUMN media_url VARCHAR(1024) NULL AFTER media_type`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE broadcasts ADD COLUMN media_file_id VARCHAR(255) NULL AFTER media_url`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE broadcasts ADD COLUMN buttons TEXT NULL AFTER media_file_id`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE broadcasts ADD COLUMN silent TINYINT(1) NOT NULL DEFAULT 0 AFTER buttons`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE users ADD COLUMN language_code VARCHAR(16) NULL AFTER last_name`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE broadcasts ADD COLUMN audience TEXT NULL AFTER silent`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE broadcasts ADD COLUMN send_at TIMESTAMP NULL AFTER audience`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE broadcasts ADD COLUMN recurrence VARCHAR(16) NULL AFTER send_at`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE broadcasts ADD COLUMN parent_id BIGINT NULL AFTER recurrence`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE broadcasts ADD COLUMN locked_by VARCHAR(64) NULL AFTER status`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE broadcasts ADD COLUMN locked_until TIMESTAMP NULL AFTER locked_by`,
+		allowedErrors: []uint16{1060},
+	},
+	{
+		stmt:          `ALTER TABLE users ADD COLUMN blocked_at TIMESTAMP NULL AFTER banned_until`,
+		allowedErrors: []uint16{1060},
+	},
+}
+
+// upgradeLegacy runs the baseline, which only creates missing tables, and then adds
+// the columns and indexes older releases did not have.
+func upgradeLegacy(ctx context.Context, conn *sql.Conn, baseline Migration) error {
+	if err := execStatements(ctx, conn, baseline.Up); err != nil {
+		return fmt.Errorf("apply baseline: %w", err)
+	}
+	for _, opt := range legacyColumns {
+		if err := execIgnoreErrors(ctx, conn, opt.stmt, opt.allowedErrors...); err != nil {
+			return fmt.Errorf("apply legacy schema: %w", err)
+		}
+	}
+	return nil
+}
+
+func execIgnoreErrors(ctx context.Context, conn *sql.Conn, stmt string, allowedCodes ...uint16) error {
+	if stmt == "" {
+		return nil
+	}
+	_, err := conn.ExecContext(ctx, stmt)
+	if err == nil {
+		return nil
+	}
+	var mysqlErr *mysqlDriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		for _, code := range allowedCodes {
+			if mysqlErr.Number == code {
+				return nil
+			}
+		}
+	}
+	return err
+}
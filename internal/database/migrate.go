@@ -0,0 +1,363 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/mysql/*.sql migrations/sqlite/*.sql
+var migrationFS embed.FS
+
+var ErrSchemaNewer = errors.New("database schema is newer than this binary")
+var ErrSchemaDirty = errors.New("database schema is dirty")
+
+const (
+	// migrationLock serialises migrations when several instances start at once.
+	migrationLock        = "stickerbot_schema_migrations"
+	migrationLockTimeout = 60
+)
+
+const migrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    dirty TINYINT(1) NOT NULL DEFAULT 0,
+    applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+)`
+
+// migrationFile matches migrations/<dialect>/<version>_<name>.<up|down>.sql.
+var migrationFile = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one numbered schema change with its rollback.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus describes a migration known to the binary, the database or both.
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Known     bool // shipped in this binary
+	Applied   bool
+	Dirty     bool // started but did not finish
+	AppliedAt *time.Time
+}
+
+type appliedMigration struct {
+	name      string
+	dirty     bool
+	appliedAt time.Time
+}
+
+// Migrations returns the embedded migrations for dialect ordered by version. Both
+// dialects ship the same versions; only the SQL differs.
+func Migrations(dialect Dialect) ([]Migration, error) {
+	dir := "migrations/" + dialect.String()
+	entries, err := fs.ReadDir(migrationFS, dir)
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		m := migrationFile.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file %q", entry.Name())
+		}
+		version, _ := strconv.ParseInt(m[1], 10, 64)
+		data, err := migrationFS.ReadFile(dir + "/" + entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", entry.Name(), err)
+		}
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(data)
+		} else {
+			mig.Down = string(data)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if strings.TrimSpace(mig.Up) == "" || strings.TrimSpace(mig.Down) == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrate brings the schema up to date on startup. It refuses to run against a schema
+// that has migrations this binary does not know, or one left dirty by a failed run.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	_, err := MigrateUp(ctx, db, 0)
+	return err
+}
+
+// MigrateUp applies up to steps pending migrations (all of them when steps is 0) and
+// returns the ones applied.
+func MigrateUp(ctx context.Context, db *sql.DB, steps int) ([]Migration, error) {
+	var done []Migration
+	err := withMigrationLock(ctx, db, func(conn *sql.Conn, migrations []Migration, applied map[int64]appliedMigration) error {
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if steps > 0 && len(done) == steps {
+				break
+			}
+			if err := runMigration(ctx, conn, m, true); err != nil {
+				return err
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// MigrateDown rolls back the last steps applied migrations and returns them.
+func MigrateDown(ctx context.Context, db *sql.DB, steps int) ([]Migration, error) {
+	var done []Migration
+	err := withMigrationLock(ctx, db, func(conn *sql.Conn, migrations []Migration, applied map[int64]appliedMigration) error {
+		for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, m, false); err != nil {
+				return err
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// MigrationStatuses lists every migration known to the binary or recorded in the
+// database, ordered by version.
+func MigrationStatuses(ctx context.Context, db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := Migrations(DialectOf(db))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("open connection: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, migrationsTable); err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+	applied, err := loadApplied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name, Known: true}
+		if a, ok := applied[m.Version]; ok {
+			status.Applied, status.Dirty, status.AppliedAt = true, a.dirty, &a.appliedAt
+			delete(applied, m.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for version, a := range applied {
+		statuses = append(statuses, MigrationStatus{Version: version, Name: a.name, Applied: true, Dirty: a.dirty, AppliedAt: &a.appliedAt})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// withMigrationLock runs fn on a single connection holding the migration lock, after
+// stamping a pre-migrations database and checking that the schema is safe to touch.
+// A SQLite file belongs to one process, so only MySQL takes the lock.
+func withMigrationLock(ctx context.Context, db *sql.DB, fn func(*sql.Conn, []Migration, map[int64]appliedMigration) error) error {
+	dialect := DialectOf(db)
+	migrations, err := Migrations(dialect)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("open connection: %w", err)
+	}
+	defer conn.Close()
+
+	if dialect == SQLite {
+		if _, err := conn.ExecContext(ctx, migrationsTable); err != nil {
+			return fmt.Errorf("create schema_migrations: %w", err)
+		}
+	} else {
+		var locked sql.NullInt64
+		if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, migrationLock, migrationLockTimeout).Scan(&locked); err != nil {
+			return fmt.Errorf("acquire migration lock: %w", err)
+		}
+		if locked.Int64 != 1 {
+			return fmt.Errorf("acquire migration lock: another instance is migrating")
+		}
+		defer conn.ExecContext(context.WithoutCancel(ctx), `SELECT RELEASE_LOCK(?)`, migrationLock)
+
+		if err := stampLegacy(ctx, conn, migrations[0]); err != nil {
+			return err
+		}
+	}
+	applied, err := loadApplied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if err := checkApplied(migrations, applied); err != nil {
+		return err
+	}
+	return fn(conn, migrations, applied)
+}
+
+// stampLegacy creates schema_migrations in a MySQL database. A database that already has the bot's tables
+// but no recorded migrations predates versioning: it is upgraded to the baseline with
+// the old ALTER list and recorded as being at version 1.
+func stampLegacy(ctx context.Context, conn *sql.Conn, baseline Migration) error {
+	var exists int
+	err := conn.QueryRowContext(ctx, `
+SELECT COUNT(*) FROM information_schema.tables
+WHERE table_schema = DATABASE() AND table_name = 'schema_migrations'`).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("check schema_migrations: %w", err)
+	}
+	if exists > 0 {
+		return nil
+	}
+	var legacy int
+	err = conn.QueryRowContext(ctx, `
+SELECT COUNT(*) FROM information_schema.tables
+WHERE table_schema = DATABASE() AND table_name = 'users'`).Scan(&legacy)
+	if err != nil {
+		return fmt.Errorf("check legacy schema: %w", err)
+	}
+	if legacy > 0 {
+		if err := upgradeLegacy(ctx, conn, baseline); err != nil {
+			return err
+		}
+	}
+	if _, err := conn.ExecContext(ctx, migrationsTable); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	if legacy > 0 {
+		const query = `INSERT INTO schema_migrations (version, name) VALUES (?, ?)`
+		if _, err := conn.ExecContext(ctx, query, baseline.Version, baseline.Name); err != nil {
+			return fmt.Errorf("stamp baseline: %w", err)
+		}
+	}
+	return nil
+}
+
+func loadApplied(ctx context.Context, conn *sql.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, dirty, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("list schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var version int64
+		var a appliedMigration
+		var dirty int
+		if err := rows.Scan(&version, &a.name, &dirty, &a.appliedAt); err != nil {
+			return nil, fmt.Errorf("scan schema_migrations: %w", err)
+		}
+		a.dirty = dirty != 0
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+func checkApplied(migrations []Migration, applied map[int64]appliedMigration) error {
+	known := make(map[int64]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+	}
+	var unknown []string
+	for version, a := range applied {
+		if a.dirty {
+			return fmt.Errorf("%w: migration %d_%s did not finish; repair the schema by hand, then delete its row from schema_migrations (or set dirty = 0 if it was completed)", ErrSchemaDirty, version, a.name)
+		}
+		if !known[version] {
+			unknown = append(unknown, fmt.Sprintf("%d_%s", version, a.name))
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("%w: unknown migrations %s; deploy the newer release or roll back with its `migrate down`", ErrSchemaNewer, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// runMigration applies or reverts one migration. MySQL commits DDL implicitly, so the
+// row is marked dirty first and a failure halfway leaves that mark behind.
+func runMigration(ctx context.Context, conn *sql.Conn, m Migration, up bool) error {
+	direction, script := "up", m.Up
+	if !up {
+		direction, script = "down", m.Down
+	}
+	var err error
+	if up {
+		_, err = conn.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, dirty) VALUES (?, ?, 1)`, m.Version, m.Name)
+	} else {
+		_, err = conn.ExecContext(ctx, `UPDATE schema_migrations SET dirty = 1 WHERE version = ?`, m.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("mark migration %d: %w", m.Version, err)
+	}
+	if err := execStatements(ctx, conn, script); err != nil {
+		return fmt.Errorf("migration %d_%s %s: %w", m.Version, m.Name, direction, err)
+	}
+	if up {
+		_, err = conn.ExecContext(ctx, `UPDATE schema_migrations SET dirty = 0, applied_at = CURRENT_TIMESTAMP WHERE version = ?`, m.Version)
+	} else {
+		_, err = conn.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, m.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("record migration %d: %w", m.Version, err)
+	}
+	return nil
+}
+
+// execStatements runs a migration script. Statements are separated by ";" and may not
+// contain one themselves; "--" comment lines are skipped.
+func execStatements(ctx context.Context, conn *sql.Conn, script string) error {
+	var lines []string
+	for _, line := range strings.Split(script, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";") {
+		stmt = strings.TrimSpace(stmt)
+		if stmt == "" {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
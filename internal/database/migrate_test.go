@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDialectsShipSameMigrations(t *testing.T) {
+	mysql, err := Migrations(MySQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlite, err := Migrations(SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mysql) != len(sqlite) {
+		t.Fatalf("mysql has %d migrations, sqlite %d", len(mysql), len(sqlite))
+	}
+	for i := range mysql {
+		if mysql[i].Version != sqlite[i].Version || mysql[i].Name != sqlite[i].Name {
+			t.Errorf("migration %d: mysql %d_%s, sqlite %d_%s", i, mysql[i].Version, mysql[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+	}
+}
+
+func TestSQLiteMigrateDownAndUp(t *testing.T) {
+	ctx := context.Background()
+	db, _, err := Open("sqlite://" + filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations, err := Migrations(SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(ctx, db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if err := Migrate(ctx, db); err != nil {
+		t.Fatalf("second migrate: %v", err)
+	}
+	done, err := MigrateDown(ctx, db, len(migrations))
+	if err != nil || len(done) != len(migrations) {
+		t.Fatalf("migrate down = %d, %v; want %d", len(done), err, len(migrations))
+	}
+	var tables int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'`).Scan(&tables); err != nil || tables != 0 {
+		t.Fatalf("users table after down = %d, %v", tables, err)
+	}
+	if done, err = MigrateUp(ctx, db, 0); err != nil || len(done) != len(migrations) {
+		t.Fatalf("migrate up = %d, %v; want %d", len(done), err, len(migrations))
+	}
+
+	statuses, err := MigrationStatuses(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if !s.Known || !s.Applied || s.Dirty || s.AppliedAt == nil {
+			t.Errorf("status = %+v, want applied and clean", s)
+		}
+	}
+}
+
+// Bound times are stored as the same text CURRENT_TIMESTAMP produces, so an instant
+// written by Go and by SQL compares equal.
+func TestSQLiteBindsTimesAsCurrentTimestampText(t *testing.T) {
+	ctx := context.Background()
+	db, _, err := Open("sqlite://" + filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	at := time.Date(2026, 10, 19, 3, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+	var text string
+	var equal bool
+	if err := db.QueryRowContext(ctx, `SELECT ?, ? = '2026-10-19 00:00:00'`, at, at).Scan(&text, &equal); err != nil {
+		t.Fatal(err)
+	}
+	if text != "2026-10-19 00:00:00" || !equal {
+		t.Errorf("bound time = %q (equal %v), want UTC 2026-10-19 00:00:00", text, equal)
+	}
+}
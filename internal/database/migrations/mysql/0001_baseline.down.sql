@@ -0,0 +1,18 @@
+-- Drops every table, including all data.
+
+DROP TABLE IF EXISTS admin_audit_log;
+DROP TABLE IF EXISTS admin_sessions;
+DROP TABLE IF EXISTS admin_tokens;
+DROP TABLE IF EXISTS admin_users;
+DROP TABLE IF EXISTS broadcast_recipients;
+DROP TABLE IF EXISTS broadcasts;
+DROP TABLE IF EXISTS credit_adjustments;
+DROP TABLE IF EXISTS referrals;
+DROP TABLE IF EXISTS payments;
+DROP TABLE IF EXISTS promo_redemptions;
+DROP TABLE IF EXISTS promo_code_plans;
+DROP TABLE IF EXISTS promo_codes;
+DROP TABLE IF EXISTS pricing_plans;
+DROP TABLE IF EXISTS generation_logs;
+DROP TABLE IF EXISTS user_activity;
+DROP TABLE IF EXISTS users;
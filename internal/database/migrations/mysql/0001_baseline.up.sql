@@ -0,0 +1,216 @@
+-- Baseline: the schema as it was when versioned migrations were introduced.
+-- Databases created before that are upgraded to it and stamped with version 1.
+
+CREATE TABLE IF NOT EXISTS users (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    telegram_id BIGINT NOT NULL UNIQUE,
+    username VARCHAR(255),
+    first_name VARCHAR(255),
+    last_name VARCHAR(255),
+    language_code VARCHAR(16) NULL,
+    free_daily_limit INT NOT NULL DEFAULT 5,
+    promo_credits INT NOT NULL DEFAULT 0,
+    paid_credits INT NOT NULL DEFAULT 0,
+    subscription_bonus_granted TINYINT(1) NOT NULL DEFAULT 0,
+    subscription_bonus_granted_at TIMESTAMP NULL,
+    referral_code VARCHAR(32) NULL,
+    source VARCHAR(64) NULL,
+    ban_status VARCHAR(16) NOT NULL DEFAULT '',
+    ban_reason VARCHAR(512) NULL,
+    banned_until TIMESTAMP NULL,
+    blocked_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uniq_users_referral_code (referral_code),
+    INDEX idx_users_source (source)
+);
+
+CREATE TABLE IF NOT EXISTS user_activity (
+    user_id BIGINT NOT NULL,
+    day DATE NOT NULL,
+    PRIMARY KEY (user_id, day),
+    INDEX idx_user_activity_day (day),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS generation_logs (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    model VARCHAR(32) NOT NULL,
+    prompt TEXT NOT NULL,
+    cost_type VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS pricing_plans (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    title VARCHAR(255) NOT NULL,
+    description VARCHAR(512),
+    currency VARCHAR(8) NOT NULL,
+    price_minor_units INT NOT NULL,
+    credits INT NOT NULL,
+    is_active TINYINT(1) NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS promo_codes (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    code VARCHAR(64) NOT NULL UNIQUE,
+    kind VARCHAR(16) NOT NULL DEFAULT 'credits',
+    max_uses INT NOT NULL,
+    uses INT NOT NULL DEFAULT 0,
+    bonus_credits INT NOT NULL DEFAULT 0,
+    discount_percent INT NOT NULL DEFAULT 0,
+    discount_amount INT NOT NULL DEFAULT 0,
+    credit_type VARCHAR(16) NOT NULL DEFAULT 'promo',
+    valid_from TIMESTAMP NULL,
+    valid_until TIMESTAMP NULL,
+    new_users_only TINYINT(1) NOT NULL DEFAULT 0,
+    enabled TINYINT(1) NOT NULL DEFAULT 1,
+    batch VARCHAR(64) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_promo_codes_batch (batch)
+);
+
+CREATE TABLE IF NOT EXISTS promo_code_plans (
+    promo_code_id BIGINT NOT NULL,
+    plan_id BIGINT NOT NULL,
+    PRIMARY KEY (promo_code_id, plan_id),
+    FOREIGN KEY (promo_code_id) REFERENCES promo_codes(id) ON DELETE CASCADE,
+    FOREIGN KEY (plan_id) REFERENCES pricing_plans(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS promo_redemptions (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    promo_code_id BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uniq_user_promo (user_id, promo_code_id),
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (promo_code_id) REFERENCES promo_codes(id)
+);
+
+CREATE TABLE IF NOT EXISTS payments (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    plan_id BIGINT NULL,
+    provider VARCHAR(64) NOT NULL,
+    provider_payment_charge_id VARCHAR(128),
+    currency VARCHAR(8) NOT NULL,
+    amount INT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    promo_code_id BIGINT NULL,
+    discount_minor_units INT NOT NULL DEFAULT 0,
+    raw_payload TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (plan_id) REFERENCES pricing_plans(id),
+    FOREIGN KEY (promo_code_id) REFERENCES promo_codes(id)
+);
+
+CREATE TABLE IF NOT EXISTS referrals (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    referrer_user_id BIGINT NOT NULL,
+    invitee_user_id BIGINT NOT NULL UNIQUE,
+    invitee_bonus INT NOT NULL DEFAULT 0,
+    referrer_bonus INT NOT NULL DEFAULT 0,
+    referrer_rewarded_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_referrals_referrer (referrer_user_id),
+    FOREIGN KEY (referrer_user_id) REFERENCES users(id),
+    FOREIGN KEY (invitee_user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS credit_adjustments (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    credit_type VARCHAR(16) NOT NULL,
+    delta INT NOT NULL,
+    reason VARCHAR(512) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_credit_adjustments_user (user_id, created_at),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS broadcasts (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    message TEXT NOT NULL,
+    parse_mode VARCHAR(16) NULL,
+    media_type VARCHAR(16) NULL,
+    media_url VARCHAR(1024) NULL,
+    media_file_id VARCHAR(255) NULL,
+    buttons TEXT NULL,
+    silent TINYINT(1) NOT NULL DEFAULT 0,
+    audience TEXT NULL,
+    send_at TIMESTAMP NULL,
+    recurrence VARCHAR(16) NULL,
+    parent_id BIGINT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    locked_by VARCHAR(64) NULL,
+    locked_until TIMESTAMP NULL,
+    created_by VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP NULL,
+    finished_at TIMESTAMP NULL,
+    INDEX idx_broadcasts_status (status, send_at)
+);
+
+CREATE TABLE IF NOT EXISTS broadcast_recipients (
+    broadcast_id BIGINT NOT NULL,
+    telegram_id BIGINT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    error VARCHAR(512) NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (broadcast_id, telegram_id),
+    INDEX idx_broadcast_recipients_status (broadcast_id, status),
+    FOREIGN KEY (broadcast_id) REFERENCES broadcasts(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS admin_users (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    username VARCHAR(64) NOT NULL UNIQUE,
+    password_hash VARCHAR(255) NOT NULL,
+    role VARCHAR(16) NOT NULL,
+    disabled TINYINT(1) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS admin_tokens (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(64) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    role VARCHAR(16) NOT NULL,
+    created_by VARCHAR(128) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL
+);
+
+CREATE TABLE IF NOT EXISTS admin_sessions (
+    token_hash CHAR(64) PRIMARY KEY,
+    admin_user_id BIGINT NOT NULL,
+    csrf_token VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_admin_sessions_expires_at (expires_at),
+    FOREIGN KEY (admin_user_id) REFERENCES admin_users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    actor VARCHAR(128) NOT NULL,
+    method VARCHAR(8) NOT NULL,
+    path VARCHAR(512) NOT NULL,
+    status INT NOT NULL,
+    body MEDIUMTEXT NULL,
+    remote_addr VARCHAR(64) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_admin_audit_log_actor (actor, created_at),
+    INDEX idx_admin_audit_log_created_at (created_at)
+);
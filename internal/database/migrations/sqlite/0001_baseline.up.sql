@@ -0,0 +1,203 @@
+-- Baseline: the SQLite counterpart of mysql/0001_baseline.up.sql.
+-- SQLite has no ON UPDATE CURRENT_TIMESTAMP; the repositories set updated_at
+-- explicitly in every UPDATE instead.
+
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    telegram_id BIGINT NOT NULL UNIQUE,
+    username VARCHAR(255),
+    first_name VARCHAR(255),
+    last_name VARCHAR(255),
+    language_code VARCHAR(16) NULL,
+    free_daily_limit INT NOT NULL DEFAULT 5,
+    promo_credits INT NOT NULL DEFAULT 0,
+    paid_credits INT NOT NULL DEFAULT 0,
+    subscription_bonus_granted TINYINT(1) NOT NULL DEFAULT 0,
+    subscription_bonus_granted_at TIMESTAMP NULL,
+    referral_code VARCHAR(32) NULL,
+    source VARCHAR(64) NULL,
+    ban_status VARCHAR(16) NOT NULL DEFAULT '',
+    ban_reason VARCHAR(512) NULL,
+    banned_until TIMESTAMP NULL,
+    blocked_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_users_referral_code ON users (referral_code);
+CREATE INDEX IF NOT EXISTS idx_users_source ON users (source);
+
+CREATE TABLE IF NOT EXISTS user_activity (
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    day DATE NOT NULL,
+    PRIMARY KEY (user_id, day)
+);
+CREATE INDEX IF NOT EXISTS idx_user_activity_day ON user_activity (day);
+
+CREATE TABLE IF NOT EXISTS generation_logs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    model VARCHAR(32) NOT NULL,
+    prompt TEXT NOT NULL,
+    cost_type VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS pricing_plans (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    title VARCHAR(255) NOT NULL,
+    description VARCHAR(512),
+    currency VARCHAR(8) NOT NULL,
+    price_minor_units INT NOT NULL,
+    credits INT NOT NULL,
+    is_active TINYINT(1) NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS promo_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code VARCHAR(64) NOT NULL UNIQUE,
+    kind VARCHAR(16) NOT NULL DEFAULT 'credits',
+    max_uses INT NOT NULL,
+    uses INT NOT NULL DEFAULT 0,
+    bonus_credits INT NOT NULL DEFAULT 0,
+    discount_percent INT NOT NULL DEFAULT 0,
+    discount_amount INT NOT NULL DEFAULT 0,
+    credit_type VARCHAR(16) NOT NULL DEFAULT 'promo',
+    valid_from TIMESTAMP NULL,
+    valid_until TIMESTAMP NULL,
+    new_users_only TINYINT(1) NOT NULL DEFAULT 0,
+    enabled TINYINT(1) NOT NULL DEFAULT 1,
+    batch VARCHAR(64) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_promo_codes_batch ON promo_codes (batch);
+
+CREATE TABLE IF NOT EXISTS promo_code_plans (
+    promo_code_id BIGINT NOT NULL REFERENCES promo_codes(id) ON DELETE CASCADE,
+    plan_id BIGINT NOT NULL REFERENCES pricing_plans(id) ON DELETE CASCADE,
+    PRIMARY KEY (promo_code_id, plan_id)
+);
+
+CREATE TABLE IF NOT EXISTS promo_redemptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    promo_code_id BIGINT NOT NULL REFERENCES promo_codes(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT uniq_user_promo UNIQUE (user_id, promo_code_id)
+);
+
+CREATE TABLE IF NOT EXISTS payments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    plan_id BIGINT NULL REFERENCES pricing_plans(id),
+    provider VARCHAR(64) NOT NULL,
+    provider_payment_charge_id VARCHAR(128),
+    currency VARCHAR(8) NOT NULL,
+    amount INT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    promo_code_id BIGINT NULL REFERENCES promo_codes(id),
+    discount_minor_units INT NOT NULL DEFAULT 0,
+    raw_payload TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS referrals (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    referrer_user_id BIGINT NOT NULL REFERENCES users(id),
+    invitee_user_id BIGINT NOT NULL UNIQUE REFERENCES users(id),
+    invitee_bonus INT NOT NULL DEFAULT 0,
+    referrer_bonus INT NOT NULL DEFAULT 0,
+    referrer_rewarded_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_referrals_referrer ON referrals (referrer_user_id);
+
+CREATE TABLE IF NOT EXISTS credit_adjustments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    credit_type VARCHAR(16) NOT NULL,
+    delta INT NOT NULL,
+    reason VARCHAR(512) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_credit_adjustments_user ON credit_adjustments (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS broadcasts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message TEXT NOT NULL,
+    parse_mode VARCHAR(16) NULL,
+    media_type VARCHAR(16) NULL,
+    media_url VARCHAR(1024) NULL,
+    media_file_id VARCHAR(255) NULL,
+    buttons TEXT NULL,
+    silent TINYINT(1) NOT NULL DEFAULT 0,
+    audience TEXT NULL,
+    send_at TIMESTAMP NULL,
+    recurrence VARCHAR(16) NULL,
+    parent_id BIGINT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    locked_by VARCHAR(64) NULL,
+    locked_until TIMESTAMP NULL,
+    created_by VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP NULL,
+    finished_at TIMESTAMP NULL
+);
+CREATE INDEX IF NOT EXISTS idx_broadcasts_status ON broadcasts (status, send_at);
+
+CREATE TABLE IF NOT EXISTS broadcast_recipients (
+    broadcast_id BIGINT NOT NULL REFERENCES broadcasts(id) ON DELETE CASCADE,
+    telegram_id BIGINT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    error VARCHAR(512) NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (broadcast_id, telegram_id)
+);
+CREATE INDEX IF NOT EXISTS idx_broadcast_recipients_status ON broadcast_recipients (broadcast_id, status);
+
+CREATE TABLE IF NOT EXISTS admin_users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username VARCHAR(64) NOT NULL UNIQUE,
+    password_hash VARCHAR(255) NOT NULL,
+    role VARCHAR(16) NOT NULL,
+    disabled TINYINT(1) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS admin_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(64) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    role VARCHAR(16) NOT NULL,
+    created_by VARCHAR(128) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL
+);
+
+CREATE TABLE IF NOT EXISTS admin_sessions (
+    token_hash CHAR(64) PRIMARY KEY,
+    admin_user_id BIGINT NOT NULL REFERENCES admin_users(id) ON DELETE CASCADE,
+    csrf_token VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_admin_sessions_expires_at ON admin_sessions (expires_at);
+
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor VARCHAR(128) NOT NULL,
+    method VARCHAR(8) NOT NULL,
+    path VARCHAR(512) NOT NULL,
+    status INT NOT NULL,
+    body TEXT NULL,
+    remote_addr VARCHAR(64) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_actor ON admin_audit_log (actor, created_at);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_created_at ON admin_audit_log (created_at);
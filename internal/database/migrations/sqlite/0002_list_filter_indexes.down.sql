@@ -0,0 +1,2 @@
+DROP INDEX idx_generation_logs_model;
+DROP INDEX idx_payments_status;
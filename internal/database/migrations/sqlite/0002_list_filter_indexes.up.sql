@@ -0,0 +1,4 @@
+-- Indexes for the admin payment and generation lists filtered by status and model.
+
+CREATE INDEX idx_payments_status ON payments (status, id);
+CREATE INDEX idx_generation_logs_model ON generation_logs (model, id);
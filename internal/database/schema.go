@@ -1,73 +0,0 @@
-package database
-
-const schema = `
-CREATE TABLE IF NOT EXISTS users (
-    id BIGINT AUTO_INCREMENT PRIMARY KEY,
-    telegram_id BIGINT NOT NULL UNIQUE,
-    username VARCHAR(255),
-    first_name VARCHAR(255),
-    last_name VARCHAR(255),
-    free_daily_limit INT NOT NULL DEFAULT 5,
-    promo_credits INT NOT NULL DEFAULT 0,
-    paid_credits INT NOT NULL DEFAULT 0,
-    subscription_bonus_granted TINYINT(1) NOT NULL DEFAULT 0,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
-);
-
-CREATE TABLE IF NOT EXISTS generation_logs (
-    id BIGINT AUTO_INCREMENT PRIMARY KEY,
-    user_id BIGINT NOT NULL,
-    model VARCHAR(32) NOT NULL,
-    prompt TEXT NOT NULL,
-    cost_type VARCHAR(16) NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (user_id) REFERENCES users(id)
-);
-
-CREATE TABLE IF NOT EXISTS pricing_plans (
-    id BIGINT AUTO_INCREMENT PRIMARY KEY,
-    title VARCHAR(255) NOT NULL,
-    description VARCHAR(512),
-    currency VARCHAR(8) NOT NULL,
-    price_minor_units INT NOT NULL,
-    credits INT NOT NULL,
-    is_active TINYINT(1) NOT NULL DEFAULT 1,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
-);
-
-CREATE TABLE IF NOT EXISTS promo_codes (
-    id BIGINT AUTO_INCREMENT PRIMARY KEY,
-    code VARCHAR(64) NOT NULL UNIQUE,
-    max_uses INT NOT NULL,
-    uses INT NOT NULL DEFAULT 0,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
-
-CREATE TABLE IF NOT EXISTS promo_redemptions (
-    id BIGINT AUTO_INCREMENT PRIMARY KEY,
-    user_id BIGINT NOT NULL,
-    promo_code_id BIGINT NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    UNIQUE KEY uniq_user_promo (user_id, promo_code_id),
-    FOREIGN KEY (user_id) REFERENCES users(id),
-    FOREIGN KEY (promo_code_id) REFERENCES promo_codes(id)
-);
-
-CREATE TABLE IF NOT EXISTS payments (
-    id BIGINT AUTO_INCREMENT PRIMARY KEY,
-    user_id BIGINT NOT NULL,
-    plan_id BIGINT NULL,
-    provider VARCHAR(64) NOT NULL,
-    provider_payment_charge_id VARCHAR(128),
-    currency VARCHAR(8) NOT NULL,
-    amount INT NOT NULL,
-    status VARCHAR(16) NOT NULL,
-    raw_payload TEXT,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-    FOREIGN KEY (user_id) REFERENCES users(id),
-    FOREIGN KEY (plan_id) REFERENCES pricing_plans(id)
-);
-`
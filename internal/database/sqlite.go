@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// sqliteConnector opens modernc connections wrapped in timeConn.
+type sqliteConnector struct {
+	dsn    string
+	driver *sqlite.Driver
+}
+
+func (c sqliteConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	sc, ok := conn.(sqliteConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected sqlite connection type %T", conn)
+	}
+	return timeConn{sc}, nil
+}
+
+func (c sqliteConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// sqliteConn is the part of a modernc connection that database/sql uses.
+type sqliteConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.Validator
+}
+
+// timeConn binds time.Time arguments as UTC "YYYY-MM-DD hh:mm:ss" text, the format
+// CURRENT_TIMESTAMP produces. The driver's own time format adds a zone offset, which
+// would make a stored value sort after the same instant written by SQL.
+type timeConn struct {
+	sqliteConn
+}
+
+func (c timeConn) CheckNamedValue(nv *driver.NamedValue) error {
+	v, err := driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	if err != nil {
+		return err
+	}
+	if t, ok := v.(time.Time); ok {
+		v = t.UTC().Format(time.DateTime)
+	}
+	nv.Value = v
+	return nil
+}
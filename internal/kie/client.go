@@ -13,13 +13,15 @@ import (
 	"time"
 
 	"github.com/digkill/TGStickerBot/internal/config"
+	"github.com/digkill/TGStickerBot/internal/metrics"
 )
 
 type Client struct {
-	apiKey     string
-	baseURL    string
-	httpClient *http.Client
-	log        *slog.Logger
+	apiKey       string
+	baseURL      string
+	pollInterval time.Duration
+	httpClient   *http.Client
+	log          *slog.Logger
 }
 
 type GenerateOptions struct {
@@ -42,10 +44,16 @@ func NewClient(cfg config.Config, log *slog.Logger) *Client {
 		timeout = 5 * time.Minute // Увеличиваем таймаут для асинхронных запросов
 	}
 
+	pollInterval := cfg.KIEPollInterval
+	if pollInterval <= 0 {
+		pollInterval = 2 * time.Second
+	}
+
 	trimmedBase := strings.TrimRight(cfg.KIEBaseURL, "/")
 	return &Client{
-		apiKey:  cfg.KIEAPIKey,
-		baseURL: trimmedBase,
+		apiKey:       cfg.KIEAPIKey,
+		baseURL:      trimmedBase,
+		pollInterval: pollInterval,
 		httpClient: &http.Client{
 			Timeout: timeout,
 		},
@@ -105,7 +113,9 @@ func (c *Client) GenerateNanoBanana(ctx context.Context, opts GenerateOptions) (
 // postAsync создает задачу и опрашивает статус до завершения
 func (c *Client) postAsync(ctx context.Context, payload map[string]any) (*Image, error) {
 	// Шаг 1: Создать задачу
+	start := time.Now()
 	taskID, err := c.createTask(ctx, payload)
+	metrics.KIERequestDuration.WithLabelValues("createTask", metrics.Outcome(err)).Observe(time.Since(start).Seconds())
 	if err != nil {
 		return nil, fmt.Errorf("create task: %w", err)
 	}
@@ -190,7 +200,12 @@ func (c *Client) createTask(ctx context.Context, payload map[string]any) (string
 }
 
 // pollTaskStatus опрашивает статус задачи до завершения
-func (c *Client) pollTaskStatus(ctx context.Context, taskID string) (*Image, error) {
+func (c *Client) pollTaskStatus(ctx context.Context, taskID string) (image *Image, err error) {
+	attempts := 0
+	defer func() {
+		metrics.KIEPollAttempts.WithLabelValues(metrics.Outcome(err)).Observe(float64(attempts))
+	}()
+
 	// Правильно объединяем URL
 	baseURL, err := url.Parse(c.baseURL)
 	if err != nil {
@@ -206,9 +221,9 @@ func (c *Client) pollTaskStatus(ctx context.Context, taskID string) (*Image, err
 	fullURL := baseURL.ResolveReference(endpoint).String()
 
 	maxAttempts := 60
-	pollInterval := 2 * time.Second
 
 	for attempt := 0; attempt < maxAttempts; attempt++ {
+		attempts = attempt + 1
 		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
 		if err != nil {
 			return nil, fmt.Errorf("new request: %w", err)
@@ -216,13 +231,20 @@ func (c *Client) pollTaskStatus(ctx context.Context, taskID string) (*Image, err
 		req.Header.Set("Authorization", "Bearer "+c.apiKey)
 		req.Header.Set("Accept", "application/json")
 
+		reqStart := time.Now()
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
+			metrics.KIERequestDuration.WithLabelValues("recordInfo", "error").Observe(time.Since(reqStart).Seconds())
 			return nil, fmt.Errorf("get task status: %w", err)
 		}
 
 		rawBody, err := io.ReadAll(resp.Body)
 		resp.Body.Close()
+		outcome := metrics.Outcome(err)
+		if resp.StatusCode >= 300 {
+			outcome = "error"
+		}
+		metrics.KIERequestDuration.WithLabelValues("recordInfo", outcome).Observe(time.Since(reqStart).Seconds())
 		if err != nil {
 			return nil, fmt.Errorf("read response body: %w", err)
 		}
@@ -298,7 +320,7 @@ func (c *Client) pollTaskStatus(ctx context.Context, taskID string) (*Image, err
 				select {
 				case <-ctx.Done():
 					return nil, ctx.Err()
-				case <-time.After(pollInterval):
+				case <-time.After(c.pollInterval):
 					continue
 				}
 			}
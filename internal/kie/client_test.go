@@ -0,0 +1,68 @@
+package kie_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digkill/TGStickerBot/internal/config"
+	"github.com/digkill/TGStickerBot/internal/kie"
+	"github.com/digkill/TGStickerBot/internal/kie/kietest"
+)
+
+func newClient(t *testing.T) (*kie.Client, *kietest.Server) {
+	t.Helper()
+	fake := kietest.NewServer(t)
+	client := kie.NewClient(config.Config{
+		KIEAPIKey:       kietest.APIKey,
+		KIEBaseURL:      fake.URL(),
+		KIEPollInterval: time.Millisecond,
+		RequestTimeout:  5 * time.Second,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return client, fake
+}
+
+func TestGenerateFlux2PollsUntilSuccess(t *testing.T) {
+	client, fake := newClient(t)
+	fake.Script(kietest.Waiting("queueing"), kietest.Waiting("generating"), kietest.Success("https://cdn.example/out.png"))
+
+	image, err := client.GenerateFlux2(context.Background(), kie.GenerateOptions{
+		Prompt:      "a cat sticker",
+		AspectRatio: "1:1",
+		Resolution:  "1K",
+		InputURLs:   []string{"https://cdn.example/ref.png"},
+	})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if image.URL != "https://cdn.example/out.png" {
+		t.Errorf("url = %q", image.URL)
+	}
+
+	tasks := fake.Tasks()
+	if len(tasks) != 1 {
+		t.Fatalf("tasks = %d, want 1", len(tasks))
+	}
+	if tasks[0].Model != "flux-2/pro-image-to-image" || tasks[0].Polls != 3 {
+		t.Errorf("task = %s after %d polls, want image-to-image after 3", tasks[0].Model, tasks[0].Polls)
+	}
+	if refs := tasks[0].InputURLs(); len(refs) != 1 || refs[0] != "https://cdn.example/ref.png" {
+		t.Errorf("input urls = %v", refs)
+	}
+}
+
+func TestGenerateNanoBananaReportsFailure(t *testing.T) {
+	client, fake := newClient(t)
+	fake.Script(kietest.Waiting("waiting"), kietest.Fail("500", "content policy"))
+
+	_, err := client.GenerateNanoBanana(context.Background(), kie.GenerateOptions{Prompt: "a dog"})
+	if err == nil || !strings.Contains(err.Error(), "content policy") {
+		t.Fatalf("err = %v, want the task failure", err)
+	}
+	if tasks := fake.Tasks(); len(tasks) != 1 || tasks[0].Model != "nano-banana-pro" {
+		t.Errorf("tasks = %+v", tasks)
+	}
+}
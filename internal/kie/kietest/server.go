@@ -0,0 +1,186 @@
+// Package kietest runs an in-process fake of the KIE jobs API (createTask and
+// recordInfo), so tests can exercise kie.Client and everything above it without the
+// network. Each task walks through a scripted list of states, one per poll.
+package kietest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// APIKey is the bearer token the fake accepts.
+const APIKey = "test-kie-key"
+
+// State is one recordInfo answer for a task.
+type State struct {
+	State      string
+	ResultURLs []string
+	FailCode   string
+	FailMsg    string
+}
+
+// Waiting is a state of a task that is still queued or running.
+func Waiting(state string) State {
+	return State{State: state}
+}
+
+// Success is the final state of a task that produced urls.
+func Success(urls ...string) State {
+	return State{State: "success", ResultURLs: urls}
+}
+
+// Fail is the final state of a task that failed.
+func Fail(code, msg string) State {
+	return State{State: "fail", FailCode: code, FailMsg: msg}
+}
+
+// Task is a task created through the fake.
+type Task struct {
+	ID    string
+	Model string
+	Input map[string]any
+	Polls int
+
+	script []State
+}
+
+// InputURLs returns the reference images a task was created with, whichever field
+// the model uses for them.
+func (t Task) InputURLs() []string {
+	for _, key := range []string{"input_urls", "image_input"} {
+		if raw, ok := t.Input[key].([]any); ok {
+			urls := make([]string, 0, len(raw))
+			for _, u := range raw {
+				if s, ok := u.(string); ok && strings.TrimSpace(s) != "" {
+					urls = append(urls, s)
+				}
+			}
+			return urls
+		}
+	}
+	return nil
+}
+
+// Server is the fake KIE API.
+type Server struct {
+	srv *httptest.Server
+
+	mu     sync.Mutex
+	script []State
+	tasks  []*Task
+}
+
+// NewServer starts a fake KIE API and stops it when the test ends. Until Script is
+// called, every task succeeds on the first poll with an image URL on the server.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	s := &Server{}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+// URL is the server's base URL, for config.Config.KIEBaseURL.
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Script sets the states reported for tasks created from now on, one per poll. The
+// last state repeats once the script runs out.
+func (s *Server) Script(states ...State) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = append([]State(nil), states...)
+}
+
+// Tasks returns copies of the tasks created so far, oldest first.
+func (s *Server) Tasks() []Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tasks := make([]Task, len(s.tasks))
+	for i, t := range s.tasks {
+		tasks[i] = *t
+	}
+	return tasks
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+APIKey {
+		writeJSON(w, map[string]any{"code": 401, "msg": "You do not have access permissions"})
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/jobs/createTask":
+		s.createTask(w, r)
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/jobs/recordInfo":
+		s.recordInfo(w, r.URL.Query().Get("taskId"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) createTask(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model string         `json:"model"`
+		Input map[string]any `json:"input"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Model == "" {
+		writeJSON(w, map[string]any{"code": 422, "msg": "model is required"})
+		return
+	}
+
+	s.mu.Lock()
+	task := &Task{
+		ID:     fmt.Sprintf("task-%d", len(s.tasks)+1),
+		Model:  req.Model,
+		Input:  req.Input,
+		script: s.script,
+	}
+	if len(task.script) == 0 {
+		task.script = []State{Success(s.srv.URL + "/results/" + task.ID + ".png")}
+	}
+	s.tasks = append(s.tasks, task)
+	s.mu.Unlock()
+
+	writeJSON(w, map[string]any{"code": 200, "msg": "success", "data": map[string]string{"taskId": task.ID}})
+}
+
+func (s *Server) recordInfo(w http.ResponseWriter, taskID string) {
+	s.mu.Lock()
+	var task *Task
+	for _, t := range s.tasks {
+		if t.ID == taskID {
+			task = t
+		}
+	}
+	if task == nil {
+		s.mu.Unlock()
+		writeJSON(w, map[string]any{"code": 404, "msg": "task not found"})
+		return
+	}
+	state := task.script[min(task.Polls, len(task.script)-1)]
+	task.Polls++
+	s.mu.Unlock()
+
+	data := map[string]any{
+		"taskId":   taskID,
+		"state":    state.State,
+		"failCode": state.FailCode,
+		"failMsg":  state.FailMsg,
+	}
+	if len(state.ResultURLs) > 0 {
+		result, _ := json.Marshal(map[string][]string{"resultUrls": state.ResultURLs})
+		data["resultJson"] = string(result)
+	}
+	writeJSON(w, map[string]any{"code": 200, "msg": "success", "data": data})
+}
+
+func writeJSON(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,111 @@
+// Package metrics holds the Prometheus collectors shared by the bot, services and
+// clients. Collectors live in a dedicated registry exposed via Handler.
+package metrics
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "stickerbot"
+
+var registry = prometheus.NewRegistry()
+
+var factory = promauto.With(registry)
+
+var (
+	TelegramUpdates = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_updates_total",
+		Help:      "Telegram updates received, by update type.",
+	}, []string{"type"})
+
+	Generations = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "generations_total",
+		Help:      "Generation attempts by model, outcome and cost type.",
+	}, []string{"model", "outcome", "cost_type"})
+
+	GenerationDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "generation_duration_seconds",
+		Help:      "End-to-end generation time, by model and outcome.",
+		Buckets:   []float64{5, 10, 20, 30, 45, 60, 90, 120, 180},
+	}, []string{"model", "outcome"})
+
+	KIERequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "kie_request_duration_seconds",
+		Help:      "KIE API request latency, by endpoint and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint", "outcome"})
+
+	KIEPollAttempts = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "kie_poll_attempts",
+		Help:      "recordInfo polls needed per KIE task, by final state.",
+		Buckets:   []float64{1, 2, 5, 10, 15, 20, 30, 45, 60},
+	}, []string{"outcome"})
+
+	PaymentEvents = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "payment_events_total",
+		Help:      "Payment lifecycle events by provider and status.",
+	}, []string{"provider", "status"})
+
+	S3UploadDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "s3_upload_duration_seconds",
+		Help:      "S3 PutObject latency, by outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	S3UploadBytes = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "s3_upload_bytes",
+		Help:      "Size of uploaded objects.",
+		Buckets:   prometheus.ExponentialBuckets(16*1024, 2, 10),
+	})
+)
+
+var activeSessions atomic.Pointer[func() int]
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_sessions",
+		Help:      "Chats currently in a non-idle bot dialog state.",
+	}, func() float64 {
+		if count := activeSessions.Load(); count != nil {
+			return float64((*count)())
+		}
+		return 0
+	})
+}
+
+// RegisterActiveSessions sets the source of the active_sessions gauge; the last
+// registered source wins.
+func RegisterActiveSessions(count func() int) {
+	activeSessions.Store(&count)
+}
+
+// Outcome maps an error to the "outcome" label value.
+func Outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
@@ -17,20 +17,79 @@ const (
 	CostTypePaid  CostType = "paid"
 )
 
+type PromoKind string
+
+const (
+	PromoKindCredits  PromoKind = "credits"
+	PromoKindDiscount PromoKind = "discount"
+)
+
+// BanStatus restricts what a user may do. A generation ban still allows /balance,
+// payments support and refunds; a full ban ignores the user entirely.
+type BanStatus string
+
+const (
+	BanNone       BanStatus = ""
+	BanFull       BanStatus = "banned"
+	BanGeneration BanStatus = "generation"
+)
+
 type User struct {
 	ID                       int64
 	TelegramID               int64
 	Username                 string
 	FirstName                string
 	LastName                 string
+	LanguageCode             string
 	FreeDailyLimit           int
 	PromoCredits             int
 	PaidCredits              int
 	SubscriptionBonusGranted bool
+	ReferralCode             string
+	Source                   string
+	BanStatus                BanStatus
+	BanReason                string
+	BannedUntil              *time.Time
+	BlockedAt                *time.Time // set while the user has the bot blocked
 	CreatedAt                time.Time
 	UpdatedAt                time.Time
 }
 
+// ActiveBan returns the ban in effect at now, treating expired bans as lifted.
+func (u *User) ActiveBan(now time.Time) BanStatus {
+	if u.BannedUntil != nil && !now.Before(*u.BannedUntil) {
+		return BanNone
+	}
+	return u.BanStatus
+}
+
+type Referral struct {
+	ID                 int64
+	ReferrerUserID     int64
+	InviteeUserID      int64
+	InviteeBonus       int
+	ReferrerBonus      int
+	ReferrerRewardedAt *time.Time
+	CreatedAt          time.Time
+}
+
+type ReferralStats struct {
+	Invited       int
+	Paid          int
+	EarnedCredits int
+}
+
+// CreditAdjustment is a manual balance change made from the admin API.
+type CreditAdjustment struct {
+	ID         int64
+	UserID     int64
+	CreditType CostType
+	Delta      int
+	Reason     string
+	Actor      string
+	CreatedAt  time.Time
+}
+
 type GenerationLog struct {
 	ID        int64
 	UserID    int64
@@ -41,11 +100,217 @@ type GenerationLog struct {
 }
 
 type PromoCode struct {
-	ID        int64
-	Code      string
-	MaxUses   int
-	Uses      int
-	CreatedAt time.Time
+	ID              int64
+	Code            string
+	Kind            PromoKind
+	MaxUses         int
+	Uses            int
+	BonusCredits    int
+	DiscountPercent int
+	DiscountAmount  int
+	PlanIDs         []int64
+	CreditType      CostType
+	ValidFrom       *time.Time
+	ValidUntil      *time.Time
+	NewUsersOnly    bool
+	Enabled         bool
+	Batch           string
+	CreatedAt       time.Time
+}
+
+// PromoUsage is a promo code together with its redemption counters.
+type PromoUsage struct {
+	PromoCode
+	Redemptions    int
+	LastRedeemedAt *time.Time
+}
+
+// PromoCampaignStats aggregates redemptions of all codes sharing a batch label.
+type PromoCampaignStats struct {
+	Batch           string
+	Codes           int
+	TotalMaxUses    int
+	Redemptions     int
+	UniqueUsers     int
+	FirstRedeemedAt *time.Time
+	LastRedeemedAt  *time.Time
+}
+
+// PromoRedemption is a single promo activation joined with the user who made it.
+type PromoRedemption struct {
+	UserID     int64
+	TelegramID int64
+	Username   string
+	FirstName  string
+	LastName   string
+	RedeemedAt time.Time
+}
+
+type CurrencyAmount struct {
+	Currency string
+	Amount   int
+}
+
+// PromoConversionStats shows how many redeemers went on to pay and how much revenue
+// is attributed to the code.
+type PromoConversionStats struct {
+	PromoCodeID int64
+	Code        string
+	Redemptions int
+	PaidUsers   int
+	Revenue     []CurrencyAmount
+}
+
+type BroadcastStatus string
+
+const (
+	BroadcastScheduled BroadcastStatus = "scheduled"
+	BroadcastPending   BroadcastStatus = "pending"
+	BroadcastRunning   BroadcastStatus = "running"
+	BroadcastDone      BroadcastStatus = "done"
+	BroadcastCanceled  BroadcastStatus = "canceled"
+)
+
+// Broadcast recurrences. A recurring broadcast stays "scheduled" and spawns one
+// pending broadcast (with ParentID set) per occurrence.
+const (
+	RecurrenceNone    = ""
+	RecurrenceDaily   = "daily"
+	RecurrenceWeekly  = "weekly"
+	RecurrenceMonthly = "monthly"
+)
+
+// Recipient delivery states of a broadcast.
+const (
+	RecipientPending = "pending"
+	RecipientSent    = "sent"
+	RecipientFailed  = "failed"
+	RecipientBlocked = "blocked"
+)
+
+// BroadcastButton is an inline button: either a link (URL) or a bot callback.
+type BroadcastButton struct {
+	Text     string
+	URL      string
+	Callback string
+}
+
+// BroadcastContent is what a broadcast delivers. MediaType is "", "photo" or "document";
+// MediaFileID caches Telegram's file_id after the first send so the URL is fetched once.
+type BroadcastContent struct {
+	Message     string
+	ParseMode   string
+	MediaType   string
+	MediaURL    string
+	MediaFileID string
+	Buttons     [][]BroadcastButton
+	Silent      bool
+}
+
+// AudienceFilter narrows broadcast recipients. Zero values mean "no restriction";
+// all set conditions must match.
+type AudienceFilter struct {
+	Languages            []string
+	SignupFrom           *time.Time
+	SignupTo             *time.Time
+	HasPaid              *bool
+	LastGenerationAfter  *time.Time
+	LastGenerationBefore *time.Time
+	MinPromoCredits      *int
+	MaxPromoCredits      *int
+	MinPaidCredits       *int
+	MaxPaidCredits       *int
+	Sources              []string
+	TelegramIDs          []int64
+}
+
+type Broadcast struct {
+	ID int64
+	BroadcastContent
+	Audience   AudienceFilter
+	SendAt     *time.Time
+	Recurrence string
+	ParentID   *int64
+	Status     BroadcastStatus
+	CreatedBy  string
+	CreatedAt  time.Time
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+}
+
+// BroadcastProgress is a broadcast with per-state recipient counts.
+type BroadcastProgress struct {
+	Broadcast
+	Total     int
+	Sent      int
+	Failed    int
+	Blocked   int
+	Remaining int
+}
+
+// AdminRole limits what an admin account or API token may do. Every role can read;
+// support manages users, finance manages plans and promo codes, superadmin can do
+// everything including broadcasts and admin accounts.
+type AdminRole string
+
+const (
+	RoleViewer     AdminRole = "viewer"
+	RoleSupport    AdminRole = "support"
+	RoleFinance    AdminRole = "finance"
+	RoleSuperadmin AdminRole = "superadmin"
+)
+
+// Valid reports whether r is one of the known roles.
+func (r AdminRole) Valid() bool {
+	switch r {
+	case RoleViewer, RoleSupport, RoleFinance, RoleSuperadmin:
+		return true
+	}
+	return false
+}
+
+type AdminUser struct {
+	ID           int64
+	Username     string
+	PasswordHash string `json:"-"`
+	Role         AdminRole
+	Disabled     bool
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// AdminToken is a bearer token for an integration. Only the SHA-256 of the token is
+// stored; the token itself is shown once, when it is created.
+type AdminToken struct {
+	ID         int64
+	Name       string
+	TokenHash  string `json:"-"`
+	Role       AdminRole
+	CreatedBy  string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// AdminSession is a web UI login. CSRFToken must accompany every form post.
+type AdminSession struct {
+	AdminUserID int64
+	Username    string
+	Role        AdminRole
+	CSRFToken   string
+	ExpiresAt   time.Time
+}
+
+// AuditEntry records one mutating admin API call.
+type AuditEntry struct {
+	ID         int64
+	Actor      string
+	Method     string
+	Path       string
+	Status     int
+	Body       string
+	RemoteAddr string
+	CreatedAt  time.Time
 }
 
 type Payment struct {
@@ -57,6 +322,8 @@ type Payment struct {
 	Currency       string
 	Amount         int
 	Status         string
+	PromoCodeID    *int64
+	Discount       int
 	RawPayload     string
 	CreatedAt      time.Time
 	UpdatedAt      time.Time
@@ -73,3 +340,49 @@ type Plan struct {
 	CreatedAt       time.Time
 	UpdatedAt       time.Time
 }
+
+// PeriodCount is a count for a day ("2006-01-02") or a month ("2006-01").
+type PeriodCount struct {
+	Period string
+	Count  int
+}
+
+type GenerationCount struct {
+	Model    ModelType
+	CostType CostType
+	Count    int
+}
+
+type RevenueStats struct {
+	Currency string
+	Provider string
+	Payments int
+	Amount   int
+}
+
+// Overview is the admin dashboard summary for a date range. A user counts as active
+// on a day when they interacted with the bot or generated an image. BlockedUsers is
+// the current number of users who blocked the bot; NewlyBlocked counts those whose
+// block falls in the range.
+type Overview struct {
+	ActiveUsers             int
+	DailyActiveUsers        []PeriodCount
+	MonthlyActiveUsers      []PeriodCount
+	Signups                 int
+	DailySignups            []PeriodCount
+	Generations             []GenerationCount
+	Revenue                 []RevenueStats
+	PromoRedemptions        int
+	SubscriptionBonusGrants int
+	BlockedUsers            int
+	NewlyBlocked            int
+}
+
+// SourceStats aggregates signups, generations and revenue for one acquisition source.
+// Users without a source are reported under an empty Source.
+type SourceStats struct {
+	Source      string
+	Signups     int
+	Generations int
+	Revenue     []CurrencyAmount
+}
@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+)
+
+// AdminRepository stores admin accounts, API tokens and the admin audit log.
+type AdminRepository struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func NewAdminRepository(db *sql.DB) *AdminRepository {
+	return &AdminRepository{db: db, dialect: dialectOf(db)}
+}
+
+const adminUserColumns = `id, username, password_hash, role, disabled, created_at, updated_at`
+
+func scanAdminUser(row rowScanner) (*models.AdminUser, error) {
+	var u models.AdminUser
+	var disabled int
+	if err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &disabled, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		return nil, err
+	}
+	u.Disabled = disabled != 0
+	return &u, nil
+}
+
+func (r *AdminRepository) CountUsers(ctx context.Context) (int, error) {
+	var count int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM admin_users`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count admin users: %w", err)
+	}
+	return count, nil
+}
+
+// CreateUser returns ErrDuplicate when the username is taken.
+func (r *AdminRepository) CreateUser(ctx context.Context, u *models.AdminUser) error {
+	const query = `INSERT INTO admin_users (username, password_hash, role, disabled) VALUES (?, ?, ?, ?)`
+	res, err := r.db.ExecContext(ctx, query, u.Username, u.PasswordHash, u.Role, u.Disabled)
+	if err != nil {
+		return fmt.Errorf("insert admin user: %w", wrapDuplicate(err))
+	}
+	if u.ID, err = res.LastInsertId(); err != nil {
+		return fmt.Errorf("admin user last insert id: %w", err)
+	}
+	return nil
+}
+
+// UpdateUser saves the role, password hash and disabled flag.
+// UpdateUser returns ErrLastSuperadmin, leaving the account unchanged, when the update
+// would disable or demote the last enabled superadmin.
+func (r *AdminRepository) UpdateUser(ctx context.Context, u *models.AdminUser) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin admin update tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	const query = `UPDATE admin_users SET password_hash = ?, role = ?, disabled = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	if _, err := tx.ExecContext(ctx, query, u.PasswordHash, u.Role, u.Disabled, u.ID); err != nil {
+		return fmt.Errorf("update admin user: %w", err)
+	}
+	// Locking the remaining superadmins makes concurrent demotions wait for each other.
+	var superadmins int
+	count := `SELECT COUNT(*) FROM admin_users WHERE role = ? AND disabled = ?` + r.dialect.forUpdate()
+	if err := tx.QueryRowContext(ctx, count, models.RoleSuperadmin, false).Scan(&superadmins); err != nil {
+		return fmt.Errorf("count superadmins: %w", err)
+	}
+	if superadmins == 0 {
+		return ErrLastSuperadmin
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit admin update: %w", err)
+	}
+	return nil
+}
+
+func (r *AdminRepository) FindUserByUsername(ctx context.Context, username string) (*models.AdminUser, error) {
+	return r.findUser(ctx, `username = ?`, username)
+}
+
+func (r *AdminRepository) FindUserByID(ctx context.Context, id int64) (*models.AdminUser, error) {
+	return r.findUser(ctx, `id = ?`, id)
+}
+
+func (r *AdminRepository) findUser(ctx context.Context, cond string, arg any) (*models.AdminUser, error) {
+	query := `SELECT ` + adminUserColumns + ` FROM admin_users WHERE ` + cond
+	u, err := scanAdminUser(r.db.QueryRowContext(ctx, query, arg))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("scan admin user: %w", err)
+	}
+	return u, nil
+}
+
+func (r *AdminRepository) ListUsers(ctx context.Context) ([]models.AdminUser, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+adminUserColumns+` FROM admin_users ORDER BY username`)
+	if err != nil {
+		return nil, fmt.Errorf("list admin users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]models.AdminUser, 0)
+	for rows.Next() {
+		u, err := scanAdminUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan admin user: %w", err)
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+const adminTokenColumns = `id, name, token_hash, role, created_by, created_at, last_used_at, revoked_at`
+
+func scanAdminToken(row rowScanner) (*models.AdminToken, error) {
+	var t models.AdminToken
+	var lastUsedAt, revokedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.Name, &t.TokenHash, &t.Role, &t.CreatedBy, &t.CreatedAt, &lastUsedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return &t, nil
+}
+
+func (r *AdminRepository) CreateToken(ctx context.Context, t *models.AdminToken) error {
+	const query = `INSERT INTO admin_tokens (name, token_hash, role, created_by) VALUES (?, ?, ?, ?)`
+	res, err := r.db.ExecContext(ctx, query, t.Name, t.TokenHash, t.Role, t.CreatedBy)
+	if err != nil {
+		return fmt.Errorf("insert admin token: %w", wrapDuplicate(err))
+	}
+	if t.ID, err = res.LastInsertId(); err != nil {
+		return fmt.Errorf("admin token last insert id: %w", err)
+	}
+	return nil
+}
+
+// FindActiveToken returns the non-revoked token with this hash, or nil.
+func (r *AdminRepository) FindActiveToken(ctx context.Context, hash string) (*models.AdminToken, error) {
+	query := `SELECT ` + adminTokenColumns + ` FROM admin_tokens WHERE token_hash = ? AND revoked_at IS NULL`
+	t, err := scanAdminToken(r.db.QueryRowContext(ctx, query, hash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("scan admin token: %w", err)
+	}
+	return t, nil
+}
+
+// TouchToken records that the token was just used.
+func (r *AdminRepository) TouchToken(ctx context.Context, id int64) error {
+	if _, err := r.db.ExecContext(ctx, `UPDATE admin_tokens SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("touch admin token: %w", err)
+	}
+	return nil
+}
+
+// RevokeToken reports false when the token does not exist or was already revoked.
+func (r *AdminRepository) RevokeToken(ctx context.Context, id int64) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `UPDATE admin_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL`, id)
+	if err != nil {
+		return false, fmt.Errorf("revoke admin token: %w", err)
+	}
+	return affected(res)
+}
+
+func (r *AdminRepository) ListTokens(ctx context.Context) ([]models.AdminToken, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+adminTokenColumns+` FROM admin_tokens ORDER BY id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("list admin tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := make([]models.AdminToken, 0)
+	for rows.Next() {
+		t, err := scanAdminToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan admin token: %w", err)
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// CreateSession stores a web UI session and drops expired ones.
+func (r *AdminRepository) CreateSession(ctx context.Context, tokenHash string, s *models.AdminSession) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM admin_sessions WHERE expires_at < CURRENT_TIMESTAMP`); err != nil {
+		return fmt.Errorf("delete expired admin sessions: %w", err)
+	}
+	const query = `INSERT INTO admin_sessions (token_hash, admin_user_id, csrf_token, expires_at) VALUES (?, ?, ?, ?)`
+	if _, err := r.db.ExecContext(ctx, query, tokenHash, s.AdminUserID, s.CSRFToken, s.ExpiresAt); err != nil {
+		return fmt.Errorf("insert admin session: %w", err)
+	}
+	return nil
+}
+
+// FindSession returns the unexpired session of an enabled account, or nil.
+func (r *AdminRepository) FindSession(ctx context.Context, tokenHash string) (*models.AdminSession, error) {
+	const query = `
+SELECT s.admin_user_id, u.username, u.role, s.csrf_token, s.expires_at
+FROM admin_sessions s
+JOIN admin_users u ON u.id = s.admin_user_id
+WHERE s.token_hash = ? AND s.expires_at > CURRENT_TIMESTAMP AND u.disabled = 0`
+	var s models.AdminSession
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(&s.AdminUserID, &s.Username, &s.Role, &s.CSRFToken, &s.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("scan admin session: %w", err)
+	}
+	return &s, nil
+}
+
+func (r *AdminRepository) DeleteSession(ctx context.Context, tokenHash string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM admin_sessions WHERE token_hash = ?`, tokenHash); err != nil {
+		return fmt.Errorf("delete admin session: %w", err)
+	}
+	return nil
+}
+
+func (r *AdminRepository) InsertAudit(ctx context.Context, e *models.AuditEntry) error {
+	const query = `
+INSERT INTO admin_audit_log (actor, method, path, status, body, remote_addr)
+VALUES (?, ?, SUBSTR(?, 1, 512), ?, NULLIF(?, ''), NULLIF(SUBSTR(?, 1, 64), ''))`
+	if _, err := r.db.ExecContext(ctx, query, e.Actor, e.Method, e.Path, e.Status, e.Body, e.RemoteAddr); err != nil {
+		return fmt.Errorf("insert audit entry: %w", err)
+	}
+	return nil
+}
+
+// ListAudit returns audit entries newest first, optionally only those of one actor.
+func (r *AdminRepository) ListAudit(ctx context.Context, actor string, limit, offset int) ([]models.AuditEntry, error) {
+	const query = `
+SELECT id, actor, method, path, status, COALESCE(body, ''), COALESCE(remote_addr, ''), created_at
+FROM admin_audit_log
+WHERE (? = '' OR actor = ?)
+ORDER BY id DESC
+LIMIT ? OFFSET ?`
+	rows, err := r.db.QueryContext(ctx, query, actor, actor, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("list audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]models.AuditEntry, 0)
+	for rows.Next() {
+		var e models.AuditEntry
+		if err := rows.Scan(&e.ID, &e.Actor, &e.Method, &e.Path, &e.Status, &e.Body, &e.RemoteAddr, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"strings"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+)
+
+// audienceWhere turns a broadcast audience filter into a WHERE clause over users.
+// Fully banned users are always excluded.
+func audienceWhere(f models.AudienceFilter) (string, []any) {
+	conds := []string{reachableUsers}
+	var args []any
+
+	if len(f.Languages) > 0 {
+		conds = append(conds, `LOWER(SUBSTR(COALESCE(language_code, ''), 1, INSTR(CONCAT(COALESCE(language_code, ''), '-'), '-') - 1)) IN (`+placeholders(len(f.Languages))+`)`)
+		for _, lang := range f.Languages {
+			args = append(args, strings.ToLower(lang))
+		}
+	}
+	if f.SignupFrom != nil {
+		conds = append(conds, `created_at >= ?`)
+		args = append(args, *f.SignupFrom)
+	}
+	if f.SignupTo != nil {
+		conds = append(conds, `created_at < ?`)
+		args = append(args, *f.SignupTo)
+	}
+	if f.HasPaid != nil {
+		paid := `EXISTS (SELECT 1 FROM payments p WHERE p.user_id = users.id AND p.status = 'paid')`
+		if !*f.HasPaid {
+			paid = `NOT ` + paid
+		}
+		conds = append(conds, paid)
+	}
+	if f.LastGenerationAfter != nil {
+		conds = append(conds, `EXISTS (SELECT 1 FROM generation_logs g WHERE g.user_id = users.id AND g.created_at >= ?)`)
+		args = append(args, *f.LastGenerationAfter)
+	}
+	if f.LastGenerationBefore != nil {
+		// Users who never generated also have their last generation "before" the date.
+		conds = append(conds, `NOT EXISTS (SELECT 1 FROM generation_logs g WHERE g.user_id = users.id AND g.created_at >= ?)`)
+		args = append(args, *f.LastGenerationBefore)
+	}
+	bounds := []struct {
+		cond  string
+		value *int
+	}{
+		{`promo_credits >= ?`, f.MinPromoCredits},
+		{`promo_credits <= ?`, f.MaxPromoCredits},
+		{`paid_credits >= ?`, f.MinPaidCredits},
+		{`paid_credits <= ?`, f.MaxPaidCredits},
+	}
+	for _, b := range bounds {
+		if b.value != nil {
+			conds = append(conds, b.cond)
+			args = append(args, *b.value)
+		}
+	}
+	if len(f.Sources) > 0 {
+		conds = append(conds, `COALESCE(source, '') IN (`+placeholders(len(f.Sources))+`)`)
+		for _, source := range f.Sources {
+			args = append(args, source)
+		}
+	}
+	if len(f.TelegramIDs) > 0 {
+		conds = append(conds, `telegram_id IN (`+placeholders(len(f.TelegramIDs))+`)`)
+		for _, id := range f.TelegramIDs {
+			args = append(args, id)
+		}
+	}
+	return `WHERE ` + strings.Join(conds, ` AND `), args
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
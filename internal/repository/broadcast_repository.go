@@ -0,0 +1,394 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+)
+
+type BroadcastRepository struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func NewBroadcastRepository(db *sql.DB) *BroadcastRepository {
+	return &BroadcastRepository{db: db, dialect: dialectOf(db)}
+}
+
+const broadcastColumns = `id, message, COALESCE(parse_mode, ''), COALESCE(media_type, ''), COALESCE(media_url, ''), COALESCE(media_file_id, ''), COALESCE(buttons, ''), silent, COALESCE(audience, ''), send_at, COALESCE(recurrence, ''), parent_id, status, created_by, created_at, started_at, finished_at`
+
+func scanBroadcast(row rowScanner) (*models.Broadcast, error) {
+	var b models.Broadcast
+	var buttons, audience string
+	var silent int
+	var parentID sql.NullInt64
+	var sendAt, startedAt, finishedAt sql.NullTime
+	if err := row.Scan(&b.ID, &b.Message, &b.ParseMode, &b.MediaType, &b.MediaURL, &b.MediaFileID, &buttons, &silent, &audience, &sendAt, &b.Recurrence, &parentID, &b.Status, &b.CreatedBy, &b.CreatedAt, &startedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+	if buttons != "" {
+		if err := json.Unmarshal([]byte(buttons), &b.Buttons); err != nil {
+			return nil, fmt.Errorf("decode broadcast buttons: %w", err)
+		}
+	}
+	if audience != "" {
+		if err := json.Unmarshal([]byte(audience), &b.Audience); err != nil {
+			return nil, fmt.Errorf("decode broadcast audience: %w", err)
+		}
+	}
+	b.Silent = silent != 0
+	if sendAt.Valid {
+		b.SendAt = &sendAt.Time
+	}
+	if parentID.Valid {
+		b.ParentID = &parentID.Int64
+	}
+	if startedAt.Valid {
+		b.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		b.FinishedAt = &finishedAt.Time
+	}
+	return &b, nil
+}
+
+// broadcastArgs encodes the editable columns in the order used by insert and update.
+func broadcastArgs(b *models.Broadcast) ([]any, error) {
+	var buttons string
+	if len(b.Buttons) > 0 {
+		raw, err := json.Marshal(b.Buttons)
+		if err != nil {
+			return nil, fmt.Errorf("encode broadcast buttons: %w", err)
+		}
+		buttons = string(raw)
+	}
+	audience, err := json.Marshal(b.Audience)
+	if err != nil {
+		return nil, fmt.Errorf("encode broadcast audience: %w", err)
+	}
+	silent := 0
+	if b.Silent {
+		silent = 1
+	}
+	var sendAt sql.NullTime
+	if b.SendAt != nil {
+		sendAt = sql.NullTime{Time: *b.SendAt, Valid: true}
+	}
+	return []any{b.Message, b.ParseMode, b.MediaType, b.MediaURL, buttons, silent, string(audience), sendAt, b.Recurrence}, nil
+}
+
+// Create stores the broadcast. An immediate broadcast (status pending) also gets its
+// recipients snapshotted in the same transaction, so a restart never sees a job without
+// its audience; a scheduled one gets them when the scheduler activates it.
+func (r *BroadcastRepository) Create(ctx context.Context, b *models.Broadcast) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin broadcast tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertBroadcast(ctx, tx, b); err != nil {
+		return err
+	}
+	if b.Status == models.BroadcastPending {
+		if err := insertRecipients(ctx, tx, b); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit broadcast: %w", err)
+	}
+	return nil
+}
+
+func insertBroadcast(ctx context.Context, tx *sql.Tx, b *models.Broadcast) error {
+	args, err := broadcastArgs(b)
+	if err != nil {
+		return err
+	}
+	var parentID sql.NullInt64
+	if b.ParentID != nil {
+		parentID = sql.NullInt64{Int64: *b.ParentID, Valid: true}
+	}
+	const insert = `
+INSERT INTO broadcasts (message, parse_mode, media_type, media_url, buttons, silent, audience, send_at, recurrence, parent_id, status, created_by)
+VALUES (?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, NULLIF(?, ''), ?, ?, ?)`
+	res, err := tx.ExecContext(ctx, insert, append(args, parentID, b.Status, b.CreatedBy)...)
+	if err != nil {
+		return fmt.Errorf("insert broadcast: %w", err)
+	}
+	if b.ID, err = res.LastInsertId(); err != nil {
+		return fmt.Errorf("broadcast last insert id: %w", err)
+	}
+	return nil
+}
+
+func insertRecipients(ctx context.Context, tx *sql.Tx, b *models.Broadcast) error {
+	where, args := audienceWhere(b.Audience)
+	recipients := `
+INSERT INTO broadcast_recipients (broadcast_id, telegram_id)
+SELECT ?, telegram_id FROM users ` + where
+	if _, err := tx.ExecContext(ctx, recipients, append([]any{b.ID}, args...)...); err != nil {
+		return fmt.Errorf("insert broadcast recipients: %w", err)
+	}
+	return nil
+}
+
+// CountAudience returns how many users a broadcast with this filter would reach.
+func (r *BroadcastRepository) CountAudience(ctx context.Context, f models.AudienceFilter) (int, error) {
+	where, args := audienceWhere(f)
+	var count int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users `+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count audience: %w", err)
+	}
+	return count, nil
+}
+
+// UpdateScheduled replaces the content, audience and schedule of a broadcast that has
+// not started yet. It reports false when the broadcast is no longer scheduled.
+func (r *BroadcastRepository) UpdateScheduled(ctx context.Context, b *models.Broadcast) (bool, error) {
+	args, err := broadcastArgs(b)
+	if err != nil {
+		return false, err
+	}
+	const query = `
+UPDATE broadcasts
+SET message = ?, parse_mode = NULLIF(?, ''), media_type = NULLIF(?, ''), media_url = NULLIF(?, ''), media_file_id = NULL,
+    buttons = NULLIF(?, ''), silent = ?, audience = ?, send_at = ?, recurrence = NULLIF(?, '')
+WHERE id = ? AND status = ?`
+	res, err := r.db.ExecContext(ctx, query, append(args, b.ID, models.BroadcastScheduled)...)
+	if err != nil {
+		return false, fmt.Errorf("update broadcast: %w", err)
+	}
+	return affected(res)
+}
+
+// Cancel stops a broadcast that is scheduled, queued or being sent. A running worker
+// notices on its next lease renewal.
+func (r *BroadcastRepository) Cancel(ctx context.Context, id int64) (bool, error) {
+	const query = `UPDATE broadcasts SET status = ?, finished_at = CURRENT_TIMESTAMP WHERE id = ? AND status IN (?, ?, ?)`
+	res, err := r.db.ExecContext(ctx, query, models.BroadcastCanceled, id, models.BroadcastScheduled, models.BroadcastPending, models.BroadcastRunning)
+	if err != nil {
+		return false, fmt.Errorf("cancel broadcast: %w", err)
+	}
+	return affected(res)
+}
+
+// DueScheduled lists scheduled broadcasts whose send_at has passed.
+func (r *BroadcastRepository) DueScheduled(ctx context.Context, now time.Time, limit int) ([]models.Broadcast, error) {
+	query := `SELECT ` + broadcastColumns + ` FROM broadcasts WHERE status = ? AND send_at <= ? ORDER BY send_at LIMIT ?`
+	return r.list(ctx, query, models.BroadcastScheduled, now, limit)
+}
+
+// Activate turns a due scheduled broadcast into a pending one with recipients. For a
+// recurring broadcast it instead spawns a pending copy and moves send_at to next. The
+// conditional UPDATE makes this safe when several instances race: only one wins.
+func (r *BroadcastRepository) Activate(ctx context.Context, b *models.Broadcast, next *time.Time) (*models.Broadcast, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin activate tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var res sql.Result
+	if next == nil {
+		res, err = tx.ExecContext(ctx, `UPDATE broadcasts SET status = ? WHERE id = ? AND status = ? AND send_at = ?`,
+			models.BroadcastPending, b.ID, models.BroadcastScheduled, b.SendAt)
+	} else {
+		res, err = tx.ExecContext(ctx, `UPDATE broadcasts SET send_at = ? WHERE id = ? AND status = ? AND send_at = ?`,
+			*next, b.ID, models.BroadcastScheduled, b.SendAt)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("activate broadcast: %w", err)
+	}
+	if ok, err := affected(res); err != nil || !ok {
+		return nil, err
+	}
+
+	job := *b
+	job.Status = models.BroadcastPending
+	if next != nil {
+		parentID := b.ID
+		job.ParentID = &parentID
+		job.Recurrence = models.RecurrenceNone
+		if err := insertBroadcast(ctx, tx, &job); err != nil {
+			return nil, err
+		}
+	}
+	if err := insertRecipients(ctx, tx, &job); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit activate broadcast: %w", err)
+	}
+	return &job, nil
+}
+
+func (r *BroadcastRepository) GetByID(ctx context.Context, id int64) (*models.Broadcast, error) {
+	query := `SELECT ` + broadcastColumns + ` FROM broadcasts WHERE id = ?`
+	b, err := scanBroadcast(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("scan broadcast: %w", err)
+	}
+	return b, nil
+}
+
+// List returns broadcasts newest first, optionally only those with the given status.
+func (r *BroadcastRepository) List(ctx context.Context, status models.BroadcastStatus, limit, offset int) ([]models.Broadcast, error) {
+	query := `SELECT ` + broadcastColumns + ` FROM broadcasts WHERE (? = '' OR status = ?) ORDER BY id DESC LIMIT ? OFFSET ?`
+	return r.list(ctx, query, status, status, limit, offset)
+}
+
+func (r *BroadcastRepository) list(ctx context.Context, query string, args ...any) ([]models.Broadcast, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list broadcasts: %w", err)
+	}
+	defer rows.Close()
+
+	broadcasts := make([]models.Broadcast, 0)
+	for rows.Next() {
+		b, err := scanBroadcast(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan broadcast: %w", err)
+		}
+		broadcasts = append(broadcasts, *b)
+	}
+	return broadcasts, rows.Err()
+}
+
+// NextActive returns the oldest queued or running broadcast whose lease is free or
+// expired, including one that was running when its worker stopped.
+func (r *BroadcastRepository) NextActive(ctx context.Context) (*models.Broadcast, error) {
+	query := `SELECT ` + broadcastColumns + ` FROM broadcasts
+WHERE status IN (?, ?) AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP)
+ORDER BY id LIMIT 1`
+	b, err := scanBroadcast(r.db.QueryRowContext(ctx, query, models.BroadcastPending, models.BroadcastRunning))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("scan active broadcast: %w", err)
+	}
+	return b, nil
+}
+
+// Claim takes (or renews) the delivery lease on a broadcast for owner and marks it
+// running. It reports false when another instance holds the lease or the broadcast was
+// finished or canceled meanwhile.
+func (r *BroadcastRepository) Claim(ctx context.Context, id int64, owner string, lease time.Duration) (bool, error) {
+	query := `
+UPDATE broadcasts
+SET status = ?, locked_by = ?, locked_until = ` + r.dialect.secondsFromNow() + `, started_at = COALESCE(started_at, CURRENT_TIMESTAMP)
+WHERE id = ? AND status IN (?, ?) AND (locked_by = ? OR locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP)`
+	res, err := r.db.ExecContext(ctx, query, models.BroadcastRunning, owner, int(lease.Seconds()), id, models.BroadcastPending, models.BroadcastRunning, owner)
+	if err != nil {
+		return false, fmt.Errorf("claim broadcast: %w", err)
+	}
+	return affected(res)
+}
+
+func (r *BroadcastRepository) MarkDone(ctx context.Context, id int64, owner string) error {
+	const query = `
+UPDATE broadcasts SET status = ?, finished_at = CURRENT_TIMESTAMP, locked_by = NULL, locked_until = NULL
+WHERE id = ? AND locked_by = ? AND status = ?`
+	if _, err := r.db.ExecContext(ctx, query, models.BroadcastDone, id, owner, models.BroadcastRunning); err != nil {
+		return fmt.Errorf("mark broadcast done: %w", err)
+	}
+	return nil
+}
+
+func affected(res sql.Result) (bool, error) {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("rows affected: %w", err)
+	}
+	return n > 0, nil
+}
+
+// SetMediaFileID remembers Telegram's file_id for the broadcast attachment.
+func (r *BroadcastRepository) SetMediaFileID(ctx context.Context, id int64, fileID string) error {
+	const query = `UPDATE broadcasts SET media_file_id = ? WHERE id = ? AND media_file_id IS NULL`
+	if _, err := r.db.ExecContext(ctx, query, fileID, id); err != nil {
+		return fmt.Errorf("set broadcast media file id: %w", err)
+	}
+	return nil
+}
+
+func (r *BroadcastRepository) PendingRecipients(ctx context.Context, broadcastID int64, limit int) ([]int64, error) {
+	const query = `
+SELECT telegram_id FROM broadcast_recipients
+WHERE broadcast_id = ? AND status = ?
+ORDER BY telegram_id
+LIMIT ?`
+	rows, err := r.db.QueryContext(ctx, query, broadcastID, models.RecipientPending, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list pending recipients: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan pending recipient: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SetRecipientStatus records a delivery outcome; errMsg is stored for failed sends.
+func (r *BroadcastRepository) SetRecipientStatus(ctx context.Context, broadcastID, telegramID int64, status string, attempts int, errMsg string) error {
+	const query = `
+UPDATE broadcast_recipients
+SET status = ?, attempts = attempts + ?, error = NULLIF(SUBSTR(?, 1, 512), ''), updated_at = CURRENT_TIMESTAMP
+WHERE broadcast_id = ? AND telegram_id = ?`
+	if _, err := r.db.ExecContext(ctx, query, status, attempts, errMsg, broadcastID, telegramID); err != nil {
+		return fmt.Errorf("set recipient status: %w", err)
+	}
+	return nil
+}
+
+// Progress returns the broadcast with recipient counts per delivery state, or nil.
+func (r *BroadcastRepository) Progress(ctx context.Context, id int64) (*models.BroadcastProgress, error) {
+	b, err := r.GetByID(ctx, id)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	progress := &models.BroadcastProgress{Broadcast: *b}
+
+	const query = `SELECT status, COUNT(*) FROM broadcast_recipients WHERE broadcast_id = ? GROUP BY status`
+	rows, err := r.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("broadcast progress: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("scan broadcast progress: %w", err)
+		}
+		progress.Total += count
+		switch status {
+		case models.RecipientSent:
+			progress.Sent = count
+		case models.RecipientFailed:
+			progress.Failed = count
+		case models.RecipientBlocked:
+			progress.Blocked = count
+		case models.RecipientPending:
+			progress.Remaining = count
+		}
+	}
+	return progress, rows.Err()
+}
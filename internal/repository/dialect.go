@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/digkill/TGStickerBot/internal/database"
+)
+
+// sqlDialect renders the few fragments whose syntax differs between MySQL and SQLite.
+// Everything else sticks to what both understand: CURRENT_TIMESTAMP rather than NOW(),
+// CASE rather than IF(), subqueries rather than UPDATE ... JOIN, and an explicit
+// updated_at in every UPDATE since SQLite has no ON UPDATE CURRENT_TIMESTAMP.
+type sqlDialect struct {
+	sqlite bool
+}
+
+func dialectOf(db *sql.DB) sqlDialect {
+	return sqlDialect{sqlite: database.DialectOf(db) == database.SQLite}
+}
+
+// greatest returns the larger of two expressions.
+func (d sqlDialect) greatest(a, b string) string {
+	if d.sqlite {
+		return `MAX(` + a + `, ` + b + `)`
+	}
+	return `GREATEST(` + a + `, ` + b + `)`
+}
+
+// forUpdate is appended to a SELECT inside a transaction to lock the rows it reads.
+// SQLite transactions begin with the write lock held (see database.Open), so it needs
+// nothing.
+func (d sqlDialect) forUpdate() string {
+	if d.sqlite {
+		return ``
+	}
+	return ` FOR UPDATE`
+}
+
+// insertIgnore starts an INSERT that silently skips rows violating a unique key.
+func (d sqlDialect) insertIgnore() string {
+	if d.sqlite {
+		return `INSERT OR IGNORE`
+	}
+	return `INSERT IGNORE`
+}
+
+// secondsFromNow is the current timestamp plus a number of seconds bound to the
+// placeholder.
+func (d sqlDialect) secondsFromNow() string {
+	if d.sqlite {
+		return `DATETIME('now', '+' || ? || ' seconds')`
+	}
+	return `CURRENT_TIMESTAMP + INTERVAL ? SECOND`
+}
+
+// formatDate formats a date expression with a layout of %Y, %m and %d, which both
+// databases spell the same way.
+func (d sqlDialect) formatDate(expr, layout string) string {
+	if d.sqlite {
+		return `STRFTIME('` + layout + `', ` + expr + `)`
+	}
+	return `DATE_FORMAT(` + expr + `, '` + layout + `')`
+}
+
+// nullTime scans a timestamp computed by an expression, such as MAX(created_at).
+// SQLite reports no declared type for those, so the driver hands over the stored
+// text instead of a time.Time.
+type nullTime struct {
+	sql.NullTime
+}
+
+// sqliteTimeLayout is how database.Open makes SQLite store timestamps.
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+func (t *nullTime) Scan(value any) error {
+	s, ok := value.(string)
+	if !ok {
+		return t.NullTime.Scan(value)
+	}
+	parsed, err := time.ParseInLocation(sqliteTimeLayout, s, time.UTC)
+	if err != nil {
+		return fmt.Errorf("parse timestamp %q: %w", s, err)
+	}
+	t.Time, t.Valid = parsed, true
+	return nil
+}
+
+// ptr returns the time, or nil for NULL.
+func (t nullTime) ptr() *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// ErrDuplicate is returned when an insert or update violates a unique key.
+var ErrDuplicate = errors.New("duplicate entry")
+
+// ErrNotFound is returned by write operations whose target row does not exist. Lookups
+// keep returning nil, nil instead.
+var ErrNotFound = errors.New("not found")
+
+// ErrLimitReached is returned when a usage counter is already at its maximum.
+var ErrLimitReached = errors.New("limit reached")
+
+// ErrLastSuperadmin is returned when an admin update would leave no enabled superadmin.
+var ErrLastSuperadmin = errors.New("last enabled superadmin")
+
+const mysqlErrDuplicateEntry = 1062
+
+func wrapDuplicate(err error) error {
+	var mysqlErr *mysqlDriver.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry {
+		return fmt.Errorf("%w: %s", ErrDuplicate, mysqlErr.Message)
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && (sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY) {
+		return fmt.Errorf("%w: %s", ErrDuplicate, sqliteErr.Error())
+	}
+	return err
+}
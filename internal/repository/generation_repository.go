@@ -40,3 +40,52 @@ WHERE user_id = ? AND created_at >= ? AND created_at < ?`
 	}
 	return count, nil
 }
+
+func (r *GenerationRepository) ListByUser(ctx context.Context, userID int64, limit int) ([]models.GenerationLog, error) {
+	const query = `
+SELECT id, user_id, model, prompt, cost_type, created_at
+FROM generation_logs
+WHERE user_id = ?
+ORDER BY id DESC
+LIMIT ?`
+	rows, err := r.db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list user generations: %w", err)
+	}
+	defer rows.Close()
+
+	logs := make([]models.GenerationLog, 0)
+	for rows.Next() {
+		var log models.GenerationLog
+		if err := rows.Scan(&log.ID, &log.UserID, &log.Model, &log.Prompt, &log.CostType, &log.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan generation log: %w", err)
+		}
+		logs = append(logs, log)
+	}
+	return logs, rows.Err()
+}
+
+// List returns generation logs newest first, optionally only for one model.
+func (r *GenerationRepository) List(ctx context.Context, model models.ModelType, limit, offset int) ([]models.GenerationLog, error) {
+	const query = `
+SELECT id, user_id, model, prompt, cost_type, created_at
+FROM generation_logs
+WHERE (? = '' OR model = ?)
+ORDER BY id DESC
+LIMIT ? OFFSET ?`
+	rows, err := r.db.QueryContext(ctx, query, model, model, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("list generations: %w", err)
+	}
+	defer rows.Close()
+
+	logs := make([]models.GenerationLog, 0)
+	for rows.Next() {
+		var log models.GenerationLog
+		if err := rows.Scan(&log.ID, &log.UserID, &log.Model, &log.Prompt, &log.CostType, &log.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan generation log: %w", err)
+		}
+		logs = append(logs, log)
+	}
+	return logs, rows.Err()
+}
@@ -0,0 +1,65 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+)
+
+type GenerationRepository struct {
+	db *DB
+}
+
+func (r *GenerationRepository) Log(ctx context.Context, userID int64, model models.ModelType, prompt string, cost models.CostType) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	r.db.generations = append(r.db.generations, models.GenerationLog{
+		ID:        r.db.nextID("generation_logs"),
+		UserID:    userID,
+		Model:     model,
+		Prompt:    prompt,
+		CostType:  cost,
+		CreatedAt: r.db.Now(),
+	})
+	return nil
+}
+
+func (r *GenerationRepository) CountForDay(ctx context.Context, userID int64, day time.Time) (int, error) {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	count := 0
+	for _, g := range r.db.generations {
+		if g.UserID == userID && !g.CreatedAt.Before(start) && g.CreatedAt.Before(end) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *GenerationRepository) ListByUser(ctx context.Context, userID int64, limit int) ([]models.GenerationLog, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	logs := make([]models.GenerationLog, 0)
+	for i := len(r.db.generations) - 1; i >= 0 && len(logs) < limit; i-- {
+		if r.db.generations[i].UserID == userID {
+			logs = append(logs, r.db.generations[i])
+		}
+	}
+	return logs, nil
+}
+
+func (r *GenerationRepository) List(ctx context.Context, model models.ModelType, limit, offset int) ([]models.GenerationLog, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	var matched []models.GenerationLog
+	for i := len(r.db.generations) - 1; i >= 0; i-- {
+		if model == "" || r.db.generations[i].Model == model {
+			matched = append(matched, r.db.generations[i])
+		}
+	}
+	from, to := page(len(matched), limit, offset)
+	return append(make([]models.GenerationLog, 0), matched[from:to]...), nil
+}
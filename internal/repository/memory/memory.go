@@ -0,0 +1,107 @@
+// Package memory implements the repository store interfaces in memory. It mirrors the
+// MySQL semantics the services rely on (unique keys, GREATEST(…, 0) balances, nil for
+// missing rows, atomic redemptions) and exists for unit tests.
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/repository"
+)
+
+// creditsPerGeneration matches the amount ConsumePromoCredit/ConsumePaidCredit take in
+// the MySQL repository.
+const creditsPerGeneration = 5
+
+// DB holds every table. Stores created from the same DB share data, so a promo
+// redemption or a payment shows up in the user's balance as it would in MySQL.
+type DB struct {
+	mu sync.Mutex
+
+	// Now is the clock used for created_at columns; tests may replace it.
+	Now func() time.Time
+
+	users       []*models.User
+	adjustments []models.CreditAdjustment
+	generations []models.GenerationLog
+	promos      []*models.PromoCode
+	redemptions []redemption
+	payments    []*models.Payment
+	plans       []*models.Plan
+	referrals   []*models.Referral
+
+	seq map[string]int64
+}
+
+type redemption struct {
+	id        int64
+	userID    int64
+	promoID   int64
+	createdAt time.Time
+}
+
+func New() *DB {
+	return &DB{
+		Now: func() time.Time { return time.Now().UTC() },
+		seq: make(map[string]int64),
+	}
+}
+
+func (db *DB) Users() *UserRepository             { return &UserRepository{db: db} }
+func (db *DB) Generations() *GenerationRepository { return &GenerationRepository{db: db} }
+func (db *DB) Promos() *PromoRepository           { return &PromoRepository{db: db} }
+func (db *DB) Payments() *PaymentRepository       { return &PaymentRepository{db: db} }
+func (db *DB) Plans() *PlanRepository             { return &PlanRepository{db: db} }
+func (db *DB) Referrals() *ReferralRepository     { return &ReferralRepository{db: db} }
+
+var (
+	_ repository.UserStore       = (*UserRepository)(nil)
+	_ repository.GenerationStore = (*GenerationRepository)(nil)
+	_ repository.PromoStore      = (*PromoRepository)(nil)
+	_ repository.PaymentStore    = (*PaymentRepository)(nil)
+	_ repository.PlanStore       = (*PlanRepository)(nil)
+	_ repository.ReferralStore   = (*ReferralRepository)(nil)
+)
+
+// nextID is AUTO_INCREMENT for table. The caller holds db.mu.
+func (db *DB) nextID(table string) int64 {
+	db.seq[table]++
+	return db.seq[table]
+}
+
+// user returns the stored row, not a copy. The caller holds db.mu.
+func (db *DB) user(id int64) *models.User {
+	for _, u := range db.users {
+		if u.ID == id {
+			return u
+		}
+	}
+	return nil
+}
+
+// addCredits applies a balance change clamped at zero like GREATEST(col + delta, 0).
+// The caller holds db.mu.
+func (db *DB) addCredits(userID int64, creditType models.CostType, delta int) {
+	u := db.user(userID)
+	if u == nil {
+		return
+	}
+	balance := &u.PromoCredits
+	if creditType == models.CostTypePaid {
+		balance = &u.PaidCredits
+	}
+	*balance = max(*balance+delta, 0)
+	u.UpdatedAt = db.Now()
+}
+
+// page applies LIMIT/OFFSET to n rows and returns the [from, to) bounds.
+func page(n, limit, offset int) (int, int) {
+	from := min(max(offset, 0), n)
+	to := n
+	if limit >= 0 {
+		to = min(from+limit, n)
+	}
+	return from, to
+}
@@ -0,0 +1,94 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+)
+
+type PaymentRepository struct {
+	db *DB
+}
+
+// insert stores a copy of payment and sets its ID. The caller holds db.mu.
+func (r *PaymentRepository) insert(payment *models.Payment) {
+	now := r.db.Now()
+	payment.ID = r.db.nextID("payments")
+	payment.CreatedAt, payment.UpdatedAt = now, now
+	row := *payment
+	r.db.payments = append(r.db.payments, &row)
+}
+
+func (r *PaymentRepository) Create(ctx context.Context, payment *models.Payment) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	r.insert(payment)
+	return nil
+}
+
+func (r *PaymentRepository) CreatePaid(ctx context.Context, payment *models.Payment, credits int) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	payment.Status = "paid"
+	r.insert(payment)
+	r.db.addCredits(payment.UserID, models.CostTypePaid, credits)
+	return nil
+}
+
+func (r *PaymentRepository) MarkPaid(ctx context.Context, paymentID int64, payload string, credits int) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, p := range r.db.payments {
+		if p.ID != paymentID || p.Status == "paid" {
+			continue
+		}
+		p.Status, p.RawPayload, p.UpdatedAt = "paid", payload, r.db.Now()
+		r.db.addCredits(p.UserID, models.CostTypePaid, credits)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (r *PaymentRepository) UpdateStatus(ctx context.Context, paymentID int64, status string, payload string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, p := range r.db.payments {
+		if p.ID == paymentID {
+			p.Status, p.RawPayload, p.UpdatedAt = status, payload, r.db.Now()
+		}
+	}
+	return nil
+}
+
+func (r *PaymentRepository) FindByProviderCharge(ctx context.Context, provider, chargeID string) (*models.Payment, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, p := range r.db.payments {
+		if p.Provider == provider && p.ProviderCharge == chargeID {
+			c := *p
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *PaymentRepository) list(match func(*models.Payment) bool, limit, offset int) []models.Payment {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	var matched []models.Payment
+	for i := len(r.db.payments) - 1; i >= 0; i-- {
+		if match(r.db.payments[i]) {
+			matched = append(matched, *r.db.payments[i])
+		}
+	}
+	from, to := page(len(matched), limit, offset)
+	return append(make([]models.Payment, 0), matched[from:to]...)
+}
+
+func (r *PaymentRepository) ListByUser(ctx context.Context, userID int64, limit int) ([]models.Payment, error) {
+	return r.list(func(p *models.Payment) bool { return p.UserID == userID }, limit, 0), nil
+}
+
+func (r *PaymentRepository) List(ctx context.Context, status string, limit, offset int) ([]models.Payment, error) {
+	return r.list(func(p *models.Payment) bool { return status == "" || p.Status == status }, limit, offset), nil
+}
@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+)
+
+type PlanRepository struct {
+	db *DB
+}
+
+func clonePlan(p *models.Plan) *models.Plan {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	return &c
+}
+
+func (r *PlanRepository) List(ctx context.Context) ([]models.Plan, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	var plans []models.Plan
+	for _, p := range r.db.plans {
+		plans = append(plans, *p)
+	}
+	return plans, nil
+}
+
+func (r *PlanRepository) GetDefault(ctx context.Context) (*models.Plan, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, p := range r.db.plans {
+		if p.IsActive {
+			return clonePlan(p), nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *PlanRepository) GetByID(ctx context.Context, id int64) (*models.Plan, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, p := range r.db.plans {
+		if p.ID == id {
+			return clonePlan(p), nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *PlanRepository) Create(ctx context.Context, plan *models.Plan) (*models.Plan, error) {
+	r.db.mu.Lock()
+	now := r.db.Now()
+	row := clonePlan(plan)
+	row.ID = r.db.nextID("pricing_plans")
+	row.CreatedAt, row.UpdatedAt = now, now
+	r.db.plans = append(r.db.plans, row)
+	plan.ID = row.ID
+	r.db.mu.Unlock()
+	return r.GetByID(ctx, row.ID)
+}
+
+func (r *PlanRepository) Update(ctx context.Context, plan *models.Plan) (*models.Plan, error) {
+	r.db.mu.Lock()
+	for i, p := range r.db.plans {
+		if p.ID == plan.ID {
+			row := clonePlan(plan)
+			row.CreatedAt, row.UpdatedAt = p.CreatedAt, r.db.Now()
+			r.db.plans[i] = row
+		}
+	}
+	r.db.mu.Unlock()
+	return r.GetByID(ctx, plan.ID)
+}
+
+func (r *PlanRepository) Delete(ctx context.Context, id int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for i, p := range r.db.plans {
+		if p.ID == id {
+			r.db.plans = append(r.db.plans[:i], r.db.plans[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/repository"
+)
+
+type PromoRepository struct {
+	db *DB
+}
+
+func clonePromo(p *models.PromoCode) *models.PromoCode {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	c.PlanIDs = slices.Clone(p.PlanIDs)
+	if len(c.PlanIDs) == 0 {
+		c.PlanIDs = nil
+	}
+	slices.Sort(c.PlanIDs)
+	return &c
+}
+
+// promo returns the stored row, not a copy. The caller holds db.mu.
+func (db *DB) promo(match func(*models.PromoCode) bool) *models.PromoCode {
+	for _, p := range db.promos {
+		if match(p) {
+			return p
+		}
+	}
+	return nil
+}
+
+func (r *PromoRepository) GetByCode(ctx context.Context, code string) (*models.PromoCode, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	return clonePromo(r.db.promo(func(p *models.PromoCode) bool { return p.Code == code })), nil
+}
+
+func (r *PromoRepository) GetByID(ctx context.Context, id int64) (*models.PromoCode, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	return clonePromo(r.db.promo(func(p *models.PromoCode) bool { return p.ID == id })), nil
+}
+
+func (r *PromoRepository) List(ctx context.Context) ([]models.PromoCode, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	var promos []models.PromoCode
+	for i := len(r.db.promos) - 1; i >= 0; i-- {
+		promos = append(promos, *clonePromo(r.db.promos[i]))
+	}
+	return promos, nil
+}
+
+// insertPromo stores a new code with zero uses. The caller holds db.mu.
+func (db *DB) insertPromo(template *models.PromoCode, code string) (int64, error) {
+	if db.promo(func(p *models.PromoCode) bool { return p.Code == code }) != nil {
+		return 0, repository.ErrDuplicate
+	}
+	row := clonePromo(template)
+	row.ID = db.nextID("promo_codes")
+	row.Code = code
+	row.Uses = 0
+	row.CreatedAt = db.Now()
+	db.promos = append(db.promos, row)
+	return row.ID, nil
+}
+
+func (r *PromoRepository) Create(ctx context.Context, promo *models.PromoCode) (*models.PromoCode, error) {
+	r.db.mu.Lock()
+	id, err := r.db.insertPromo(promo, promo.Code)
+	r.db.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return r.GetByID(ctx, id)
+}
+
+func (r *PromoRepository) Update(ctx context.Context, promo *models.PromoCode) (*models.PromoCode, error) {
+	r.db.mu.Lock()
+	if other := r.db.promo(func(p *models.PromoCode) bool { return p.Code == promo.Code && p.ID != promo.ID }); other != nil {
+		r.db.mu.Unlock()
+		return nil, repository.ErrDuplicate
+	}
+	if row := r.db.promo(func(p *models.PromoCode) bool { return p.ID == promo.ID }); row != nil {
+		createdAt := row.CreatedAt
+		*row = *clonePromo(promo)
+		row.CreatedAt = createdAt
+	}
+	r.db.mu.Unlock()
+	return r.GetByID(ctx, promo.ID)
+}
+
+// Delete also removes the code's redemptions, like the ON DELETE CASCADE foreign key.
+func (r *PromoRepository) Delete(ctx context.Context, id int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	r.db.promos = slices.DeleteFunc(r.db.promos, func(p *models.PromoCode) bool { return p.ID == id })
+	r.db.redemptions = slices.DeleteFunc(r.db.redemptions, func(red redemption) bool { return red.promoID == id })
+	return nil
+}
+
+func (r *PromoRepository) CreateBatch(ctx context.Context, template *models.PromoCode, codes []string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	seen := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		if seen[code] || r.db.promo(func(p *models.PromoCode) bool { return p.Code == code }) != nil {
+			return repository.ErrDuplicate
+		}
+		seen[code] = true
+	}
+	for _, code := range codes {
+		if _, err := r.db.insertPromo(template, code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *PromoRepository) ListUsageByBatch(ctx context.Context, batch string) ([]models.PromoUsage, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	var result []models.PromoUsage
+	for _, p := range r.db.promos {
+		if batch == "" || p.Batch != batch {
+			continue
+		}
+		usage := models.PromoUsage{PromoCode: *clonePromo(p)}
+		usage.PlanIDs = nil // the MySQL query does not load plan restrictions here
+		for _, red := range r.db.redemptions {
+			if red.promoID == p.ID {
+				usage.Redemptions++
+				usage.LastRedeemedAt = later(usage.LastRedeemedAt, red.createdAt)
+			}
+		}
+		result = append(result, usage)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Code < result[j].Code })
+	return result, nil
+}
+
+func (r *PromoRepository) CampaignStats(ctx context.Context, batch string) ([]models.PromoCampaignStats, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	index := make(map[string]int)
+	var result []models.PromoCampaignStats
+	users := make(map[string]map[int64]bool)
+	for _, p := range r.db.promos {
+		if p.Batch == "" || (batch != "" && p.Batch != batch) {
+			continue
+		}
+		i, ok := index[p.Batch]
+		if !ok {
+			i = len(result)
+			index[p.Batch] = i
+			result = append(result, models.PromoCampaignStats{Batch: p.Batch})
+			users[p.Batch] = make(map[int64]bool)
+		}
+		st := &result[i]
+		st.Codes++
+		st.TotalMaxUses += p.MaxUses
+		for _, red := range r.db.redemptions {
+			if red.promoID != p.ID {
+				continue
+			}
+			st.Redemptions++
+			users[p.Batch][red.userID] = true
+			st.LastRedeemedAt = later(st.LastRedeemedAt, red.createdAt)
+			if st.FirstRedeemedAt == nil || red.createdAt.Before(*st.FirstRedeemedAt) {
+				t := red.createdAt
+				st.FirstRedeemedAt = &t
+			}
+		}
+	}
+	for i := range result {
+		result[i].UniqueUsers = len(users[result[i].Batch])
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Batch < result[j].Batch })
+	return result, nil
+}
+
+func (r *PromoRepository) ListRedemptions(ctx context.Context, promoID int64, limit, offset int) ([]models.PromoRedemption, int, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	var matched []models.PromoRedemption
+	for i := len(r.db.redemptions) - 1; i >= 0; i-- {
+		red := r.db.redemptions[i]
+		if red.promoID != promoID {
+			continue
+		}
+		item := models.PromoRedemption{UserID: red.userID, RedeemedAt: red.createdAt}
+		if u := r.db.user(red.userID); u != nil {
+			item.TelegramID, item.Username, item.FirstName, item.LastName = u.TelegramID, u.Username, u.FirstName, u.LastName
+		}
+		matched = append(matched, item)
+	}
+	from, to := page(len(matched), limit, offset)
+	return append(make([]models.PromoRedemption, 0), matched[from:to]...), len(matched), nil
+}
+
+// ConversionStats attributes each paid payment to its discount code, otherwise to the
+// code the user redeemed last before paying, as the MySQL query does.
+func (r *PromoRepository) ConversionStats(ctx context.Context, promoID int64) ([]models.PromoConversionStats, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	attributed := make(map[int64][]models.Payment)
+	for _, pmt := range r.db.payments {
+		if pmt.Status != "paid" {
+			continue
+		}
+		if id, ok := r.db.attributedPromo(pmt); ok {
+			attributed[id] = append(attributed[id], *pmt)
+		}
+	}
+
+	stats := make([]models.PromoConversionStats, 0)
+	for i := len(r.db.promos) - 1; i >= 0; i-- {
+		p := r.db.promos[i]
+		if promoID != 0 && p.ID != promoID {
+			continue
+		}
+		st := models.PromoConversionStats{PromoCodeID: p.ID, Code: p.Code, Revenue: make([]models.CurrencyAmount, 0)}
+		for _, red := range r.db.redemptions {
+			if red.promoID == p.ID {
+				st.Redemptions++
+			}
+		}
+		payers := make(map[int64]bool)
+		revenue := make(map[string]int)
+		for _, pmt := range attributed[p.ID] {
+			payers[pmt.UserID] = true
+			revenue[pmt.Currency] += pmt.Amount
+		}
+		st.PaidUsers = len(payers)
+		for currency, amount := range revenue {
+			st.Revenue = append(st.Revenue, models.CurrencyAmount{Currency: currency, Amount: amount})
+		}
+		sort.Slice(st.Revenue, func(i, j int) bool { return st.Revenue[i].Currency < st.Revenue[j].Currency })
+		stats = append(stats, st)
+	}
+	return stats, nil
+}
+
+// attributedPromo returns the code a payment counts towards. The caller holds db.mu.
+func (db *DB) attributedPromo(pmt *models.Payment) (int64, bool) {
+	if pmt.PromoCodeID != nil {
+		return *pmt.PromoCodeID, true
+	}
+	var latest *redemption
+	for i, red := range db.redemptions {
+		if red.userID != pmt.UserID || red.createdAt.After(pmt.CreatedAt) {
+			continue
+		}
+		if latest == nil || !red.createdAt.Before(latest.createdAt) {
+			latest = &db.redemptions[i]
+		}
+	}
+	if latest == nil {
+		return 0, false
+	}
+	return latest.promoID, true
+}
+
+func (r *PromoRepository) HasUserRedeemed(ctx context.Context, userID, promoID int64) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	return r.db.redeemed(userID, promoID), nil
+}
+
+// redeemed reports whether the user already used the code. The caller holds db.mu.
+func (db *DB) redeemed(userID, promoID int64) bool {
+	for _, red := range db.redemptions {
+		if red.userID == userID && red.promoID == promoID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *PromoRepository) Redeem(ctx context.Context, userID, promoID int64, creditType models.CostType, credits int) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	p := r.db.promo(func(p *models.PromoCode) bool { return p.ID == promoID })
+	switch {
+	case p == nil:
+		return repository.ErrNotFound
+	case p.Uses >= p.MaxUses:
+		return repository.ErrLimitReached
+	case r.db.redeemed(userID, promoID):
+		return repository.ErrDuplicate
+	}
+	r.db.redemptions = append(r.db.redemptions, redemption{
+		id:        r.db.nextID("promo_redemptions"),
+		userID:    userID,
+		promoID:   promoID,
+		createdAt: r.db.Now(),
+	})
+	p.Uses++
+	if credits > 0 {
+		r.db.addCredits(userID, creditType, credits)
+	}
+	return nil
+}
+
+func (r *PromoRepository) Release(ctx context.Context, userID, promoID int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for i, red := range r.db.redemptions {
+		if red.userID != userID || red.promoID != promoID {
+			continue
+		}
+		r.db.redemptions = append(r.db.redemptions[:i], r.db.redemptions[i+1:]...)
+		if p := r.db.promo(func(p *models.PromoCode) bool { return p.ID == promoID }); p != nil && p.Uses > 0 {
+			p.Uses--
+		}
+		return nil
+	}
+	return nil
+}
+
+func later(current *time.Time, t time.Time) *time.Time {
+	if current != nil && !t.After(*current) {
+		return current
+	}
+	return &t
+}
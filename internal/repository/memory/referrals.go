@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/repository"
+)
+
+type ReferralRepository struct {
+	db *DB
+}
+
+func (r *ReferralRepository) Create(ctx context.Context, referrerID, inviteeID int64, inviteeBonus int) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, ref := range r.db.referrals {
+		if ref.InviteeUserID == inviteeID {
+			return repository.ErrDuplicate
+		}
+	}
+	r.db.referrals = append(r.db.referrals, &models.Referral{
+		ID:             r.db.nextID("referrals"),
+		ReferrerUserID: referrerID,
+		InviteeUserID:  inviteeID,
+		InviteeBonus:   inviteeBonus,
+		CreatedAt:      r.db.Now(),
+	})
+	if inviteeBonus > 0 {
+		r.db.addCredits(inviteeID, models.CostTypePromo, inviteeBonus)
+	}
+	return nil
+}
+
+func (r *ReferralRepository) FindByInvitee(ctx context.Context, inviteeID int64) (*models.Referral, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, ref := range r.db.referrals {
+		if ref.InviteeUserID == inviteeID {
+			c := *ref
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *ReferralRepository) RewardReferrer(ctx context.Context, referralID int64, bonus int) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, ref := range r.db.referrals {
+		if ref.ID != referralID || ref.ReferrerRewardedAt != nil {
+			continue
+		}
+		now := r.db.Now()
+		ref.ReferrerBonus, ref.ReferrerRewardedAt = bonus, &now
+		r.db.addCredits(ref.ReferrerUserID, models.CostTypePromo, bonus)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (r *ReferralRepository) Stats(ctx context.Context, referrerID int64) (models.ReferralStats, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	var stats models.ReferralStats
+	for _, ref := range r.db.referrals {
+		if ref.ReferrerUserID != referrerID {
+			continue
+		}
+		stats.Invited++
+		if ref.ReferrerRewardedAt != nil {
+			stats.Paid++
+			stats.EarnedCredits += ref.ReferrerBonus
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,270 @@
+package memory
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/repository"
+)
+
+type UserRepository struct {
+	db *DB
+}
+
+func cloneUser(u *models.User) *models.User {
+	if u == nil {
+		return nil
+	}
+	c := *u
+	return &c
+}
+
+func (r *UserRepository) find(match func(*models.User) bool) *models.User {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, u := range r.db.users {
+		if match(u) {
+			return cloneUser(u)
+		}
+	}
+	return nil
+}
+
+func (r *UserRepository) FindByTelegramID(ctx context.Context, telegramID int64) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.TelegramID == telegramID }), nil
+}
+
+func (r *UserRepository) FindByID(ctx context.Context, id int64) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.ID == id }), nil
+}
+
+func (r *UserRepository) FindByReferralCode(ctx context.Context, code string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return code != "" && u.ReferralCode == code }), nil
+}
+
+// Create inserts a user the way the MySQL repository does; tests use it to seed
+// balances that Ensure would start at zero.
+func (r *UserRepository) Create(ctx context.Context, user *models.User) (*models.User, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, u := range r.db.users {
+		if u.TelegramID == user.TelegramID {
+			return nil, repository.ErrDuplicate
+		}
+	}
+	now := r.db.Now()
+	row := cloneUser(user)
+	row.ID = r.db.nextID("users")
+	row.CreatedAt, row.UpdatedAt = now, now
+	r.db.users = append(r.db.users, row)
+	user.ID = row.ID
+	user.CreatedAt, user.UpdatedAt = now, now
+	return cloneUser(row), nil
+}
+
+func (r *UserRepository) SetReferralCode(ctx context.Context, userID int64, code string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, u := range r.db.users {
+		if u.ReferralCode == code && u.ID != userID {
+			return repository.ErrDuplicate
+		}
+	}
+	if u := r.db.user(userID); u != nil && u.ReferralCode == "" {
+		u.ReferralCode = code
+	}
+	return nil
+}
+
+func (r *UserRepository) SetSource(ctx context.Context, userID int64, source string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if u := r.db.user(userID); u != nil && u.Source == "" {
+		u.Source = source
+	}
+	return nil
+}
+
+func (r *UserRepository) SetBan(ctx context.Context, userID int64, status models.BanStatus, reason string, until *time.Time) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if u := r.db.user(userID); u != nil {
+		u.BanStatus, u.BanReason, u.BannedUntil = status, reason, until
+	}
+	return nil
+}
+
+func (r *UserRepository) Ensure(ctx context.Context, telegramID int64, username, firstName, lastName, languageCode string, freeLimit int) (*models.User, bool, error) {
+	r.db.mu.Lock()
+	for _, u := range r.db.users {
+		if u.TelegramID == telegramID {
+			existing := cloneUser(u)
+			u.Username, u.FirstName, u.LastName = username, firstName, lastName
+			if languageCode != "" {
+				u.LanguageCode = languageCode
+			}
+			r.db.mu.Unlock()
+			return existing, false, nil
+		}
+	}
+	r.db.mu.Unlock()
+	created, err := r.Create(ctx, &models.User{
+		TelegramID:     telegramID,
+		Username:       username,
+		FirstName:      firstName,
+		LastName:       lastName,
+		LanguageCode:   languageCode,
+		FreeDailyLimit: freeLimit,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return created, true, nil
+}
+
+func (r *UserRepository) UpdatePromoCredits(ctx context.Context, userID int64, delta int) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	r.db.addCredits(userID, models.CostTypePromo, delta)
+	return nil
+}
+
+func (r *UserRepository) UpdatePaidCredits(ctx context.Context, userID int64, delta int) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	r.db.addCredits(userID, models.CostTypePaid, delta)
+	return nil
+}
+
+func (r *UserRepository) SetSubscriptionBonusGranted(ctx context.Context, userID int64, granted bool) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if u := r.db.user(userID); u != nil {
+		u.SubscriptionBonusGranted = granted
+	}
+	return nil
+}
+
+func (r *UserRepository) consume(userID int64, creditType models.CostType) bool {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	u := r.db.user(userID)
+	if u == nil {
+		return false
+	}
+	balance := &u.PromoCredits
+	if creditType == models.CostTypePaid {
+		balance = &u.PaidCredits
+	}
+	if *balance < creditsPerGeneration {
+		return false
+	}
+	*balance -= creditsPerGeneration
+	return true
+}
+
+func (r *UserRepository) ConsumePromoCredit(ctx context.Context, userID int64) (bool, error) {
+	return r.consume(userID, models.CostTypePromo), nil
+}
+
+func (r *UserRepository) ConsumePaidCredit(ctx context.Context, userID int64) (bool, error) {
+	return r.consume(userID, models.CostTypePaid), nil
+}
+
+// Search supports the same query forms as the MySQL repository, matching
+// case-insensitively as MySQL's default collation does.
+func (r *UserRepository) Search(ctx context.Context, q string, limit, offset int) ([]models.User, error) {
+	q = strings.ToLower(strings.TrimSpace(q))
+	id, idErr := strconv.ParseInt(q, 10, 64)
+	match := func(u *models.User) bool {
+		switch {
+		case q == "":
+			return true
+		case strings.HasPrefix(q, "@"):
+			return strings.HasPrefix(strings.ToLower(u.Username), q[1:])
+		case idErr == nil:
+			return u.TelegramID == id || u.ID == id
+		}
+		full := strings.ToLower(strings.TrimSpace(u.FirstName + " " + u.LastName))
+		return strings.Contains(strings.ToLower(u.Username), q) || strings.Contains(full, q)
+	}
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	var found []models.User
+	for i := len(r.db.users) - 1; i >= 0; i-- {
+		if match(r.db.users[i]) {
+			found = append(found, *r.db.users[i])
+		}
+	}
+	from, to := page(len(found), limit, offset)
+	return append(make([]models.User, 0), found[from:to]...), nil
+}
+
+func (r *UserRepository) AdjustCredits(ctx context.Context, adj *models.CreditAdjustment) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	u := r.db.user(adj.UserID)
+	if u == nil {
+		return repository.ErrNotFound
+	}
+	balance := u.PromoCredits
+	if adj.CreditType == models.CostTypePaid {
+		balance = u.PaidCredits
+	}
+	adj.Delta = max(adj.Delta, -balance)
+	r.db.addCredits(adj.UserID, adj.CreditType, adj.Delta)
+	adj.ID = r.db.nextID("credit_adjustments")
+	adj.CreatedAt = r.db.Now()
+	r.db.adjustments = append(r.db.adjustments, *adj)
+	return nil
+}
+
+func (r *UserRepository) ListCreditAdjustments(ctx context.Context, userID int64, limit int) ([]models.CreditAdjustment, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	result := make([]models.CreditAdjustment, 0)
+	for i := len(r.db.adjustments) - 1; i >= 0 && len(result) < limit; i-- {
+		if r.db.adjustments[i].UserID == userID {
+			result = append(result, r.db.adjustments[i])
+		}
+	}
+	return result, nil
+}
+
+func (r *UserRepository) SetBlocked(ctx context.Context, telegramID int64, blocked bool) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, u := range r.db.users {
+		if u.TelegramID != telegramID {
+			continue
+		}
+		switch {
+		case blocked && u.BlockedAt == nil:
+			now := r.db.Now()
+			u.BlockedAt = &now
+			return true, nil
+		case !blocked && u.BlockedAt != nil:
+			u.BlockedAt = nil
+			return true, nil
+		}
+		return false, nil
+	}
+	return false, nil
+}
+
+func (r *UserRepository) ListTelegramIDs(ctx context.Context) ([]int64, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	now := r.db.Now()
+	var ids []int64
+	for _, u := range r.db.users {
+		if u.BlockedAt == nil && u.ActiveBan(now) != models.BanFull {
+			ids = append(ids, u.TelegramID)
+		}
+	}
+	return ids, nil
+}
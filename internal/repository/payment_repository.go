@@ -18,9 +18,9 @@ func NewPaymentRepository(db *sql.DB) *PaymentRepository {
 
 func (r *PaymentRepository) Create(ctx context.Context, payment *models.Payment) error {
 	const query = `
-INSERT INTO payments (user_id, plan_id, provider, provider_payment_charge_id, currency, amount, status, raw_payload)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
-	res, err := r.db.ExecContext(ctx, query, payment.UserID, payment.PlanID, payment.Provider, payment.ProviderCharge, payment.Currency, payment.Amount, payment.Status, payment.RawPayload)
+INSERT INTO payments (user_id, plan_id, provider, provider_payment_charge_id, currency, amount, status, promo_code_id, discount_minor_units, raw_payload)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := r.db.ExecContext(ctx, query, payment.UserID, payment.PlanID, payment.Provider, payment.ProviderCharge, payment.Currency, payment.Amount, payment.Status, payment.PromoCodeID, payment.Discount, payment.RawPayload)
 	if err != nil {
 		return fmt.Errorf("insert payment: %w", err)
 	}
@@ -32,29 +32,142 @@ VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 	return nil
 }
 
+// CreatePaid records an already completed payment and adds credits to the user's paid
+// balance in one transaction.
+func (r *PaymentRepository) CreatePaid(ctx context.Context, payment *models.Payment, credits int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin paid payment tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	const query = `
+INSERT INTO payments (user_id, plan_id, provider, provider_payment_charge_id, currency, amount, status, promo_code_id, discount_minor_units, raw_payload)
+VALUES (?, ?, ?, ?, ?, ?, 'paid', ?, ?, ?)`
+	res, err := tx.ExecContext(ctx, query, payment.UserID, payment.PlanID, payment.Provider, payment.ProviderCharge, payment.Currency, payment.Amount, payment.PromoCodeID, payment.Discount, payment.RawPayload)
+	if err != nil {
+		return fmt.Errorf("insert payment: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("last insert id: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET paid_credits = paid_credits + ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, credits, payment.UserID); err != nil {
+		return fmt.Errorf("add paid credits: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit paid payment: %w", err)
+	}
+	payment.ID = id
+	payment.Status = "paid"
+	return nil
+}
+
+// MarkPaid switches a pending payment to paid and adds credits to its user in one
+// transaction. It reports false, changing nothing, if the payment is already paid, so
+// concurrent webhook deliveries credit the user once.
+func (r *PaymentRepository) MarkPaid(ctx context.Context, paymentID int64, payload string, credits int) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin mark paid tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	const mark = `UPDATE payments SET status = 'paid', raw_payload = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status <> 'paid'`
+	res, err := tx.ExecContext(ctx, mark, payload, paymentID)
+	if err != nil {
+		return false, fmt.Errorf("mark payment paid: %w", err)
+	}
+	ok, err := affected(res)
+	if err != nil || !ok {
+		return false, err
+	}
+	const credit = `
+UPDATE users SET paid_credits = paid_credits + ?, updated_at = CURRENT_TIMESTAMP
+WHERE id = (SELECT user_id FROM payments WHERE id = ?)`
+	if _, err := tx.ExecContext(ctx, credit, credits, paymentID); err != nil {
+		return false, fmt.Errorf("add paid credits: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit mark paid: %w", err)
+	}
+	return true, nil
+}
+
 func (r *PaymentRepository) UpdateStatus(ctx context.Context, paymentID int64, status string, payload string) error {
-	const query = `UPDATE payments SET status = ?, raw_payload = ?, updated_at = NOW() WHERE id = ?`
+	const query = `UPDATE payments SET status = ?, raw_payload = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
 	if _, err := r.db.ExecContext(ctx, query, status, payload, paymentID); err != nil {
 		return fmt.Errorf("update payment status: %w", err)
 	}
 	return nil
 }
 
-func (r *PaymentRepository) FindByProviderCharge(ctx context.Context, provider, chargeID string) (*models.Payment, error) {
-	const query = `
-SELECT id, user_id, plan_id, provider, provider_payment_charge_id, currency, amount, status, raw_payload, created_at, COALESCE(updated_at, created_at) as updated_at
-FROM payments WHERE provider = ? AND provider_payment_charge_id = ? LIMIT 1`
-	row := r.db.QueryRowContext(ctx, query, provider, chargeID)
+const paymentColumns = `id, user_id, plan_id, provider, COALESCE(provider_payment_charge_id, ''), currency, amount, status, promo_code_id, discount_minor_units, COALESCE(raw_payload, ''), created_at, COALESCE(updated_at, created_at) as updated_at`
+
+func scanPayment(row rowScanner) (*models.Payment, error) {
 	var p models.Payment
-	var planID sql.NullInt64
-	if err := row.Scan(&p.ID, &p.UserID, &planID, &p.Provider, &p.ProviderCharge, &p.Currency, &p.Amount, &p.Status, &p.RawPayload, &p.CreatedAt, &p.UpdatedAt); err != nil {
+	var planID, promoID sql.NullInt64
+	var updatedAt nullTime
+	if err := row.Scan(&p.ID, &p.UserID, &planID, &p.Provider, &p.ProviderCharge, &p.Currency, &p.Amount, &p.Status, &promoID, &p.Discount, &p.RawPayload, &p.CreatedAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	p.UpdatedAt = updatedAt.Time
+	if planID.Valid {
+		p.PlanID = &planID.Int64
+	}
+	if promoID.Valid {
+		p.PromoCodeID = &promoID.Int64
+	}
+	return &p, nil
+}
+
+func (r *PaymentRepository) FindByProviderCharge(ctx context.Context, provider, chargeID string) (*models.Payment, error) {
+	query := `SELECT ` + paymentColumns + ` FROM payments WHERE provider = ? AND provider_payment_charge_id = ? LIMIT 1`
+	p, err := scanPayment(r.db.QueryRowContext(ctx, query, provider, chargeID))
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("scan payment: %w", err)
 	}
-	if planID.Valid {
-		p.PlanID = &planID.Int64
+	return p, nil
+}
+
+func (r *PaymentRepository) ListByUser(ctx context.Context, userID int64, limit int) ([]models.Payment, error) {
+	query := `SELECT ` + paymentColumns + ` FROM payments WHERE user_id = ? ORDER BY id DESC LIMIT ?`
+	rows, err := r.db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list user payments: %w", err)
 	}
-	return &p, nil
+	defer rows.Close()
+
+	payments := make([]models.Payment, 0)
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan user payment: %w", err)
+		}
+		payments = append(payments, *p)
+	}
+	return payments, rows.Err()
+}
+
+// List returns payments newest first, optionally only those with the given status.
+func (r *PaymentRepository) List(ctx context.Context, status string, limit, offset int) ([]models.Payment, error) {
+	query := `SELECT ` + paymentColumns + ` FROM payments WHERE (? = '' OR status = ?) ORDER BY id DESC LIMIT ? OFFSET ?`
+	rows, err := r.db.QueryContext(ctx, query, status, status, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("list payments: %w", err)
+	}
+	defer rows.Close()
+
+	payments := make([]models.Payment, 0)
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan payment: %w", err)
+		}
+		payments = append(payments, *p)
+	}
+	return payments, rows.Err()
 }
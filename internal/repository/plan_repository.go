@@ -96,7 +96,7 @@ VALUES (?, NULLIF(?, ''), ?, ?, ?, ?)`
 func (r *PlanRepository) Update(ctx context.Context, plan *models.Plan) (*models.Plan, error) {
 	const query = `
 UPDATE pricing_plans
-SET title = ?, description = NULLIF(?, ''), currency = ?, price_minor_units = ?, credits = ?, is_active = ?, updated_at = NOW()
+SET title = ?, description = NULLIF(?, ''), currency = ?, price_minor_units = ?, credits = ?, is_active = ?, updated_at = CURRENT_TIMESTAMP
 WHERE id = ?`
 	if _, err := r.db.ExecContext(ctx, query, plan.Title, plan.Description, plan.Currency, plan.PriceMinorUnits, plan.Credits, plan.IsActive, plan.ID); err != nil {
 		return nil, fmt.Errorf("update plan: %w", err)
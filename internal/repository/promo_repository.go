@@ -10,45 +10,63 @@ import (
 )
 
 type PromoRepository struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect sqlDialect
 }
 
 func NewPromoRepository(db *sql.DB) *PromoRepository {
-	return &PromoRepository{db: db}
+	return &PromoRepository{db: db, dialect: dialectOf(db)}
 }
 
-func (r *PromoRepository) DB() *sql.DB {
-	return r.db
+const promoColumns = `id, code, kind, max_uses, uses, bonus_credits, discount_percent, discount_amount, credit_type, valid_from, valid_until, new_users_only, enabled, COALESCE(batch, ''), created_at`
+
+func scanPromo(row rowScanner) (*models.PromoCode, error) {
+	var promo models.PromoCode
+	var validFrom, validUntil sql.NullTime
+	if err := row.Scan(&promo.ID, &promo.Code, &promo.Kind, &promo.MaxUses, &promo.Uses, &promo.BonusCredits, &promo.DiscountPercent, &promo.DiscountAmount, &promo.CreditType, &validFrom, &validUntil, &promo.NewUsersOnly, &promo.Enabled, &promo.Batch, &promo.CreatedAt); err != nil {
+		return nil, err
+	}
+	if validFrom.Valid {
+		promo.ValidFrom = &validFrom.Time
+	}
+	if validUntil.Valid {
+		promo.ValidUntil = &validUntil.Time
+	}
+	return &promo, nil
 }
 
 func (r *PromoRepository) GetByCode(ctx context.Context, code string) (*models.PromoCode, error) {
-	const query = `SELECT id, code, max_uses, uses, created_at FROM promo_codes WHERE code = ?`
-	row := r.db.QueryRowContext(ctx, query, code)
-	var promo models.PromoCode
-	if err := row.Scan(&promo.ID, &promo.Code, &promo.MaxUses, &promo.Uses, &promo.CreatedAt); err != nil {
+	query := `SELECT ` + promoColumns + ` FROM promo_codes WHERE code = ?`
+	promo, err := scanPromo(r.db.QueryRowContext(ctx, query, code))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("scan promo: %w", err)
 	}
-	return &promo, nil
+	if promo.PlanIDs, err = r.planIDs(ctx, promo.ID); err != nil {
+		return nil, err
+	}
+	return promo, nil
 }
 
 func (r *PromoRepository) GetByID(ctx context.Context, id int64) (*models.PromoCode, error) {
-	const query = `SELECT id, code, max_uses, uses, created_at FROM promo_codes WHERE id = ?`
-	row := r.db.QueryRowContext(ctx, query, id)
-	var promo models.PromoCode
-	if err := row.Scan(&promo.ID, &promo.Code, &promo.MaxUses, &promo.Uses, &promo.CreatedAt); err != nil {
+	query := `SELECT ` + promoColumns + ` FROM promo_codes WHERE id = ?`
+	promo, err := scanPromo(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("get promo by id: %w", err)
 	}
-	return &promo, nil
+	if promo.PlanIDs, err = r.planIDs(ctx, promo.ID); err != nil {
+		return nil, err
+	}
+	return promo, nil
 }
 
 func (r *PromoRepository) List(ctx context.Context) ([]models.PromoCode, error) {
-	const query = `SELECT id, code, max_uses, uses, created_at FROM promo_codes ORDER BY id DESC`
+	query := `SELECT ` + promoColumns + ` FROM promo_codes ORDER BY id DESC`
 	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("list promos: %w", err)
@@ -57,63 +75,367 @@ func (r *PromoRepository) List(ctx context.Context) ([]models.PromoCode, error)
 
 	var promos []models.PromoCode
 	for rows.Next() {
-		var promo models.PromoCode
-		if err := rows.Scan(&promo.ID, &promo.Code, &promo.MaxUses, &promo.Uses, &promo.CreatedAt); err != nil {
+		promo, err := scanPromo(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan promo list: %w", err)
 		}
-		promos = append(promos, promo)
+		promos = append(promos, *promo)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
-	return promos, rows.Err()
+
+	plans, err := r.allPlanIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range promos {
+		promos[i].PlanIDs = plans[promos[i].ID]
+	}
+	return promos, nil
 }
 
+func (r *PromoRepository) planIDs(ctx context.Context, promoID int64) ([]int64, error) {
+	const query = `SELECT plan_id FROM promo_code_plans WHERE promo_code_id = ? ORDER BY plan_id`
+	rows, err := r.db.QueryContext(ctx, query, promoID)
+	if err != nil {
+		return nil, fmt.Errorf("list promo plans: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan promo plan: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *PromoRepository) allPlanIDs(ctx context.Context) (map[int64][]int64, error) {
+	const query = `SELECT promo_code_id, plan_id FROM promo_code_plans ORDER BY promo_code_id, plan_id`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("list promo plans: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[int64][]int64)
+	for rows.Next() {
+		var promoID, planID int64
+		if err := rows.Scan(&promoID, &planID); err != nil {
+			return nil, fmt.Errorf("scan promo plan: %w", err)
+		}
+		result[promoID] = append(result[promoID], planID)
+	}
+	return result, rows.Err()
+}
+
+// setPlanIDs replaces the plan restriction of a promo code within tx. An empty list means the
+// code applies to every plan.
+func setPlanIDs(ctx context.Context, tx *sql.Tx, promoID int64, planIDs []int64) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM promo_code_plans WHERE promo_code_id = ?`, promoID); err != nil {
+		return fmt.Errorf("clear promo plans: %w", err)
+	}
+	for _, planID := range planIDs {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO promo_code_plans (promo_code_id, plan_id) VALUES (?, ?)`, promoID, planID); err != nil {
+			return fmt.Errorf("insert promo plan: %w", err)
+		}
+	}
+	return nil
+}
+
+// Create inserts the promo together with its plan restriction, so a failure leaves no
+// code that would apply to every plan.
 func (r *PromoRepository) Create(ctx context.Context, promo *models.PromoCode) (*models.PromoCode, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin create promo tx: %w", err)
+	}
+	defer tx.Rollback()
+
 	const query = `
-INSERT INTO promo_codes (code, max_uses, uses)
-VALUES (?, ?, 0)`
-	res, err := r.db.ExecContext(ctx, query, promo.Code, promo.MaxUses)
+INSERT INTO promo_codes (code, kind, max_uses, uses, bonus_credits, discount_percent, discount_amount, credit_type, valid_from, valid_until, new_users_only, enabled, batch)
+VALUES (?, ?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''))`
+	res, err := tx.ExecContext(ctx, query, promo.Code, promo.Kind, promo.MaxUses, promo.BonusCredits, promo.DiscountPercent, promo.DiscountAmount, promo.CreditType, promo.ValidFrom, promo.ValidUntil, promo.NewUsersOnly, promo.Enabled, promo.Batch)
 	if err != nil {
-		return nil, fmt.Errorf("create promo: %w", err)
+		return nil, fmt.Errorf("create promo: %w", wrapDuplicate(err))
 	}
 	id, err := res.LastInsertId()
 	if err != nil {
 		return nil, fmt.Errorf("promo last insert id: %w", err)
 	}
+	if err := setPlanIDs(ctx, tx, id, promo.PlanIDs); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit create promo: %w", err)
+	}
 	return r.GetByID(ctx, id)
 }
 
+// Update rewrites the promo and its plan restriction in one transaction.
 func (r *PromoRepository) Update(ctx context.Context, promo *models.PromoCode) (*models.PromoCode, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin update promo tx: %w", err)
+	}
+	defer tx.Rollback()
+
 	const query = `
 UPDATE promo_codes
-SET code = ?, max_uses = ?, uses = ?
+SET code = ?, kind = ?, max_uses = ?, uses = ?, bonus_credits = ?, discount_percent = ?, discount_amount = ?, credit_type = ?, valid_from = ?, valid_until = ?, new_users_only = ?, enabled = ?, batch = NULLIF(?, '')
 WHERE id = ?`
-	if _, err := r.db.ExecContext(ctx, query, promo.Code, promo.MaxUses, promo.Uses, promo.ID); err != nil {
-		return nil, fmt.Errorf("update promo: %w", err)
+	if _, err := tx.ExecContext(ctx, query, promo.Code, promo.Kind, promo.MaxUses, promo.Uses, promo.BonusCredits, promo.DiscountPercent, promo.DiscountAmount, promo.CreditType, promo.ValidFrom, promo.ValidUntil, promo.NewUsersOnly, promo.Enabled, promo.Batch, promo.ID); err != nil {
+		return nil, fmt.Errorf("update promo: %w", wrapDuplicate(err))
+	}
+	if err := setPlanIDs(ctx, tx, promo.ID, promo.PlanIDs); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit update promo: %w", err)
 	}
 	return r.GetByID(ctx, promo.ID)
 }
 
-func (r *PromoRepository) Delete(ctx context.Context, id int64) error {
-	const query = `DELETE FROM promo_codes WHERE id = ?`
-	if _, err := r.db.ExecContext(ctx, query, id); err != nil {
-		return fmt.Errorf("delete promo: %w", err)
+// CreateBatch inserts one promo per code with the template settings in a single
+// transaction. If any code already exists, nothing is inserted and ErrDuplicate is returned.
+func (r *PromoRepository) CreateBatch(ctx context.Context, template *models.PromoCode, codes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin promo batch tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	const insertPromo = `
+INSERT INTO promo_codes (code, kind, max_uses, uses, bonus_credits, discount_percent, discount_amount, credit_type, valid_from, valid_until, new_users_only, enabled, batch)
+VALUES (?, ?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''))`
+	const insertPlan = `INSERT INTO promo_code_plans (promo_code_id, plan_id) VALUES (?, ?)`
+	for _, code := range codes {
+		res, err := tx.ExecContext(ctx, insertPromo, code, template.Kind, template.MaxUses, template.BonusCredits, template.DiscountPercent, template.DiscountAmount, template.CreditType, template.ValidFrom, template.ValidUntil, template.NewUsersOnly, template.Enabled, template.Batch)
+		if err != nil {
+			return fmt.Errorf("insert batch promo: %w", wrapDuplicate(err))
+		}
+		if len(template.PlanIDs) == 0 {
+			continue
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("batch promo last insert id: %w", err)
+		}
+		for _, planID := range template.PlanIDs {
+			if _, err := tx.ExecContext(ctx, insertPlan, id, planID); err != nil {
+				return fmt.Errorf("insert batch promo plan: %w", err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit promo batch: %w", err)
 	}
 	return nil
 }
 
-func (r *PromoRepository) IncrementUsage(ctx context.Context, promoID int64) error {
+// ListUsageByBatch returns every code of a batch with its redemption counters.
+func (r *PromoRepository) ListUsageByBatch(ctx context.Context, batch string) ([]models.PromoUsage, error) {
+	query := `
+SELECT ` + promoColumns + `, COALESCE(r.redemptions, 0), r.last_redeemed_at
+FROM promo_codes
+LEFT JOIN (
+    SELECT promo_code_id, COUNT(*) AS redemptions, MAX(created_at) AS last_redeemed_at
+    FROM promo_redemptions
+    GROUP BY promo_code_id
+) r ON r.promo_code_id = promo_codes.id
+WHERE promo_codes.batch = ?
+ORDER BY promo_codes.code`
+	rows, err := r.db.QueryContext(ctx, query, batch)
+	if err != nil {
+		return nil, fmt.Errorf("list batch usage: %w", err)
+	}
+	defer rows.Close()
+
+	var result []models.PromoUsage
+	for rows.Next() {
+		var usage models.PromoUsage
+		var validFrom, validUntil sql.NullTime
+		var lastRedeemed nullTime
+		p := &usage.PromoCode
+		if err := rows.Scan(&p.ID, &p.Code, &p.Kind, &p.MaxUses, &p.Uses, &p.BonusCredits, &p.DiscountPercent, &p.DiscountAmount, &p.CreditType, &validFrom, &validUntil, &p.NewUsersOnly, &p.Enabled, &p.Batch, &p.CreatedAt, &usage.Redemptions, &lastRedeemed); err != nil {
+			return nil, fmt.Errorf("scan batch usage: %w", err)
+		}
+		if validFrom.Valid {
+			p.ValidFrom = &validFrom.Time
+		}
+		if validUntil.Valid {
+			p.ValidUntil = &validUntil.Time
+		}
+		usage.LastRedeemedAt = lastRedeemed.ptr()
+		result = append(result, usage)
+	}
+	return result, rows.Err()
+}
+
+// CampaignStats aggregates promo_redemptions per batch label. An empty batch returns
+// every campaign.
+func (r *PromoRepository) CampaignStats(ctx context.Context, batch string) ([]models.PromoCampaignStats, error) {
 	const query = `
-UPDATE promo_codes SET uses = uses + 1
-WHERE id = ? AND uses < max_uses`
-	res, err := r.db.ExecContext(ctx, query, promoID)
+SELECT c.batch, c.codes, c.total_max_uses, COALESCE(r.redemptions, 0), COALESCE(r.unique_users, 0), r.first_redeemed_at, r.last_redeemed_at
+FROM (
+    SELECT batch, COUNT(*) AS codes, SUM(max_uses) AS total_max_uses
+    FROM promo_codes
+    WHERE batch IS NOT NULL AND (? = '' OR batch = ?)
+    GROUP BY batch
+) c
+LEFT JOIN (
+    SELECT pc.batch, COUNT(*) AS redemptions, COUNT(DISTINCT pr.user_id) AS unique_users,
+           MIN(pr.created_at) AS first_redeemed_at, MAX(pr.created_at) AS last_redeemed_at
+    FROM promo_redemptions pr
+    JOIN promo_codes pc ON pc.id = pr.promo_code_id
+    WHERE pc.batch IS NOT NULL
+    GROUP BY pc.batch
+) r ON r.batch = c.batch
+ORDER BY c.batch`
+	rows, err := r.db.QueryContext(ctx, query, batch, batch)
 	if err != nil {
-		return fmt.Errorf("increment promo usage: %w", err)
+		return nil, fmt.Errorf("promo campaign stats: %w", err)
 	}
-	affected, err := res.RowsAffected()
+	defer rows.Close()
+
+	var result []models.PromoCampaignStats
+	for rows.Next() {
+		var stats models.PromoCampaignStats
+		var first, last nullTime
+		if err := rows.Scan(&stats.Batch, &stats.Codes, &stats.TotalMaxUses, &stats.Redemptions, &stats.UniqueUsers, &first, &last); err != nil {
+			return nil, fmt.Errorf("scan promo campaign stats: %w", err)
+		}
+		stats.FirstRedeemedAt, stats.LastRedeemedAt = first.ptr(), last.ptr()
+		result = append(result, stats)
+	}
+	return result, rows.Err()
+}
+
+// ListRedemptions returns a page of redemptions of a promo code (newest first) and the
+// total number of redemptions.
+func (r *PromoRepository) ListRedemptions(ctx context.Context, promoID int64, limit, offset int) ([]models.PromoRedemption, int, error) {
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM promo_redemptions WHERE promo_code_id = ?`, promoID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count redemptions: %w", err)
+	}
+
+	const query = `
+SELECT u.id, u.telegram_id, COALESCE(u.username, ''), COALESCE(u.first_name, ''), COALESCE(u.last_name, ''), pr.created_at
+FROM promo_redemptions pr
+JOIN users u ON u.id = pr.user_id
+WHERE pr.promo_code_id = ?
+ORDER BY pr.created_at DESC, pr.id DESC
+LIMIT ? OFFSET ?`
+	rows, err := r.db.QueryContext(ctx, query, promoID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list redemptions: %w", err)
+	}
+	defer rows.Close()
+
+	redemptions := make([]models.PromoRedemption, 0)
+	for rows.Next() {
+		var red models.PromoRedemption
+		if err := rows.Scan(&red.UserID, &red.TelegramID, &red.Username, &red.FirstName, &red.LastName, &red.RedeemedAt); err != nil {
+			return nil, 0, fmt.Errorf("scan redemption: %w", err)
+		}
+		redemptions = append(redemptions, red)
+	}
+	return redemptions, total, rows.Err()
+}
+
+// promoAttributedPayments attributes every paid payment to at most one promo code: the
+// discount code it was paid with, otherwise the code the user redeemed last before it.
+// Per-code totals therefore never add up to more than the real revenue.
+const promoAttributedPayments = `
+FROM (
+    SELECT p.user_id, p.currency, p.amount, COALESCE(p.promo_code_id, (
+        SELECT pr.promo_code_id FROM promo_redemptions pr
+        WHERE pr.user_id = p.user_id AND pr.created_at <= p.created_at
+        ORDER BY pr.created_at DESC, pr.id DESC
+        LIMIT 1
+    )) AS promo_code_id
+    FROM payments p
+    WHERE p.status = 'paid'
+) ap
+WHERE ap.promo_code_id IS NOT NULL AND (? = 0 OR ap.promo_code_id = ?)`
+
+// ConversionStats aggregates redemptions, paying users and attributed revenue per promo
+// code. promoID 0 returns all codes.
+func (r *PromoRepository) ConversionStats(ctx context.Context, promoID int64) ([]models.PromoConversionStats, error) {
+	const base = `
+SELECT pc.id, pc.code, COUNT(pr.id)
+FROM promo_codes pc
+LEFT JOIN promo_redemptions pr ON pr.promo_code_id = pc.id
+WHERE (? = 0 OR pc.id = ?)
+GROUP BY pc.id, pc.code
+ORDER BY pc.id DESC`
+	rows, err := r.db.QueryContext(ctx, base, promoID, promoID)
+	if err != nil {
+		return nil, fmt.Errorf("promo redemption counts: %w", err)
+	}
+	stats := make([]models.PromoConversionStats, 0)
+	index := make(map[int64]int)
+	for rows.Next() {
+		var st models.PromoConversionStats
+		if err := rows.Scan(&st.PromoCodeID, &st.Code, &st.Redemptions); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan promo redemption counts: %w", err)
+		}
+		st.Revenue = make([]models.CurrencyAmount, 0)
+		index[st.PromoCodeID] = len(stats)
+		stats = append(stats, st)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.db.QueryContext(ctx, `SELECT ap.promo_code_id, COUNT(DISTINCT ap.user_id)`+promoAttributedPayments+` GROUP BY ap.promo_code_id`, promoID, promoID)
 	if err != nil {
-		return fmt.Errorf("promo usage rows affected: %w", err)
+		return nil, fmt.Errorf("promo conversions: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		var paidUsers int
+		if err := rows.Scan(&id, &paidUsers); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan promo conversions: %w", err)
+		}
+		if i, ok := index[id]; ok {
+			stats[i].PaidUsers = paidUsers
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.db.QueryContext(ctx, `SELECT ap.promo_code_id, ap.currency, SUM(ap.amount)`+promoAttributedPayments+` GROUP BY ap.promo_code_id, ap.currency ORDER BY ap.currency`, promoID, promoID)
+	if err != nil {
+		return nil, fmt.Errorf("promo revenue: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var amount models.CurrencyAmount
+		if err := rows.Scan(&id, &amount.Currency, &amount.Amount); err != nil {
+			return nil, fmt.Errorf("scan promo revenue: %w", err)
+		}
+		if i, ok := index[id]; ok {
+			stats[i].Revenue = append(stats[i].Revenue, amount)
+		}
 	}
-	if affected == 0 {
-		return fmt.Errorf("promo code exhausted")
+	return stats, rows.Err()
+}
+
+func (r *PromoRepository) Delete(ctx context.Context, id int64) error {
+	const query = `DELETE FROM promo_codes WHERE id = ?`
+	if _, err := r.db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("delete promo: %w", err)
 	}
 	return nil
 }
@@ -131,12 +453,85 @@ func (r *PromoRepository) HasUserRedeemed(ctx context.Context, userID, promoID i
 	return true, nil
 }
 
-func (r *PromoRepository) RecordRedemption(ctx context.Context, userID, promoID int64) error {
-	const query = `
-INSERT INTO promo_redemptions (user_id, promo_code_id)
-VALUES (?, ?)`
-	if _, err := r.db.ExecContext(ctx, query, userID, promoID); err != nil {
-		return fmt.Errorf("record redemption: %w", err)
+// Redeem locks the promo row, enforces its usage limit, records the redemption and adds
+// credits of creditType to the user, all in one transaction. It returns ErrNotFound for
+// an unknown code, ErrLimitReached when the code is used up and ErrDuplicate when the
+// user has already redeemed it.
+func (r *PromoRepository) Redeem(ctx context.Context, userID, promoID int64, creditType models.CostType, credits int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin redeem tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var uses, maxUses int
+	row := tx.QueryRowContext(ctx, `SELECT uses, max_uses FROM promo_codes WHERE id = ?`+r.dialect.forUpdate(), promoID)
+	if err := row.Scan(&uses, &maxUses); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("lock promo: %w", err)
+	}
+	if uses >= maxUses {
+		return ErrLimitReached
+	}
+
+	var dummy int
+	err = tx.QueryRowContext(ctx, `SELECT 1 FROM promo_redemptions WHERE user_id = ? AND promo_code_id = ?`, userID, promoID).Scan(&dummy)
+	switch {
+	case err == nil:
+		return ErrDuplicate
+	case !errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("check redemption: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO promo_redemptions (user_id, promo_code_id) VALUES (?, ?)`, userID, promoID); err != nil {
+		return fmt.Errorf("insert redemption: %w", wrapDuplicate(err))
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE promo_codes SET uses = uses + 1 WHERE id = ?`, promoID); err != nil {
+		return fmt.Errorf("increment promo uses: %w", err)
+	}
+	if credits > 0 {
+		column := "promo_credits"
+		if creditType == models.CostTypePaid {
+			column = "paid_credits"
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET `+column+` = `+column+` + ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, credits, userID); err != nil {
+			return fmt.Errorf("add promo credits: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit redeem: %w", err)
+	}
+	return nil
+}
+
+// Release undoes a redemption that granted no credits, such as a discount reserved for a
+// payment that was then canceled, and gives the use back to the code. Releasing a
+// redemption that does not exist is a no-op.
+func (r *PromoRepository) Release(ctx context.Context, userID, promoID int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin release tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM promo_redemptions WHERE user_id = ? AND promo_code_id = ?`, userID, promoID)
+	if err != nil {
+		return fmt.Errorf("delete redemption: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("release rows affected: %w", err)
+	}
+	if n == 0 {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE promo_codes SET uses = uses - 1 WHERE id = ? AND uses > 0`, promoID); err != nil {
+		return fmt.Errorf("decrement promo uses: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit release: %w", err)
 	}
 	return nil
 }
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+)
+
+type ReferralRepository struct {
+	db *sql.DB
+}
+
+func NewReferralRepository(db *sql.DB) *ReferralRepository {
+	return &ReferralRepository{db: db}
+}
+
+// Create attributes the invitee to the referrer and grants the invitee bonus in one
+// transaction. A second attribution of the same invitee returns ErrDuplicate.
+func (r *ReferralRepository) Create(ctx context.Context, referrerID, inviteeID int64, inviteeBonus int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin referral tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	const insert = `
+INSERT INTO referrals (referrer_user_id, invitee_user_id, invitee_bonus)
+VALUES (?, ?, ?)`
+	if _, err := tx.ExecContext(ctx, insert, referrerID, inviteeID, inviteeBonus); err != nil {
+		return fmt.Errorf("insert referral: %w", wrapDuplicate(err))
+	}
+	if inviteeBonus > 0 {
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET promo_credits = promo_credits + ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, inviteeBonus, inviteeID); err != nil {
+			return fmt.Errorf("grant invitee bonus: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit referral: %w", err)
+	}
+	return nil
+}
+
+func (r *ReferralRepository) FindByInvitee(ctx context.Context, inviteeID int64) (*models.Referral, error) {
+	const query = `
+SELECT id, referrer_user_id, invitee_user_id, invitee_bonus, referrer_bonus, referrer_rewarded_at, created_at
+FROM referrals WHERE invitee_user_id = ?`
+	var ref models.Referral
+	var rewardedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, query, inviteeID).Scan(&ref.ID, &ref.ReferrerUserID, &ref.InviteeUserID, &ref.InviteeBonus, &ref.ReferrerBonus, &rewardedAt, &ref.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("scan referral: %w", err)
+	}
+	if rewardedAt.Valid {
+		ref.ReferrerRewardedAt = &rewardedAt.Time
+	}
+	return &ref, nil
+}
+
+// RewardReferrer marks the referral as rewarded and credits the referrer. It reports
+// false if the referrer has already been rewarded for this invitee.
+func (r *ReferralRepository) RewardReferrer(ctx context.Context, referralID int64, bonus int) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin referral reward tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	const mark = `
+UPDATE referrals SET referrer_bonus = ?, referrer_rewarded_at = CURRENT_TIMESTAMP
+WHERE id = ? AND referrer_rewarded_at IS NULL`
+	res, err := tx.ExecContext(ctx, mark, bonus, referralID)
+	if err != nil {
+		return false, fmt.Errorf("mark referral rewarded: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("referral reward rows affected: %w", err)
+	}
+	if affected == 0 {
+		return false, nil
+	}
+	const credit = `
+UPDATE users SET promo_credits = promo_credits + ?, updated_at = CURRENT_TIMESTAMP
+WHERE id = (SELECT referrer_user_id FROM referrals WHERE id = ?)`
+	if _, err := tx.ExecContext(ctx, credit, bonus, referralID); err != nil {
+		return false, fmt.Errorf("grant referrer bonus: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit referral reward: %w", err)
+	}
+	return true, nil
+}
+
+func (r *ReferralRepository) Stats(ctx context.Context, referrerID int64) (models.ReferralStats, error) {
+	const query = `
+SELECT COUNT(*), COUNT(referrer_rewarded_at), COALESCE(SUM(referrer_bonus), 0)
+FROM referrals WHERE referrer_user_id = ?`
+	var stats models.ReferralStats
+	if err := r.db.QueryRowContext(ctx, query, referrerID).Scan(&stats.Invited, &stats.Paid, &stats.EarnedCredits); err != nil {
+		return stats, fmt.Errorf("referral stats: %w", err)
+	}
+	return stats, nil
+}
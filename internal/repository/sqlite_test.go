@@ -0,0 +1,516 @@
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digkill/TGStickerBot/internal/database"
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/repository"
+)
+
+// openSQLite returns a migrated database in a fresh file.
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, dialect, err := database.Open("sqlite://" + filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if dialect != database.SQLite || database.DialectOf(db) != database.SQLite {
+		t.Fatalf("dialect = %v, want sqlite", dialect)
+	}
+	if err := database.Migrate(context.Background(), db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func createUser(t *testing.T, users *repository.UserRepository, telegramID int64, user models.User) *models.User {
+	t.Helper()
+	user.TelegramID = telegramID
+	created, err := users.Create(context.Background(), &user)
+	if err != nil {
+		t.Fatalf("create user %d: %v", telegramID, err)
+	}
+	return created
+}
+
+func reload(t *testing.T, users *repository.UserRepository, id int64) *models.User {
+	t.Helper()
+	user, err := users.FindByID(context.Background(), id)
+	if err != nil || user == nil {
+		t.Fatalf("find user %d: %v", id, err)
+	}
+	return user
+}
+
+func TestSQLiteUserCredits(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewUserRepository(openSQLite(t))
+	user := createUser(t, users, 100, models.User{PromoCredits: 7, PaidCredits: 3})
+
+	if err := users.UpdatePromoCredits(ctx, user.ID, -10); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := users.ConsumePaidCredit(ctx, user.ID); err != nil || ok {
+		t.Errorf("ConsumePaidCredit with 3 credits = %v, %v; want false", ok, err)
+	}
+	adj := &models.CreditAdjustment{UserID: user.ID, CreditType: models.CostTypePaid, Delta: -5, Reason: "refund", Actor: "admin"}
+	if err := users.AdjustCredits(ctx, adj); err != nil {
+		t.Fatal(err)
+	}
+	if adj.Delta != -3 {
+		t.Errorf("applied delta = %d, want -3", adj.Delta)
+	}
+	got := reload(t, users, user.ID)
+	if got.PromoCredits != 0 || got.PaidCredits != 0 {
+		t.Errorf("balance = %d promo, %d paid; want both clamped to 0", got.PromoCredits, got.PaidCredits)
+	}
+	if adjustments, err := users.ListCreditAdjustments(ctx, user.ID, 10); err != nil || len(adjustments) != 1 || adjustments[0].Delta != -3 {
+		t.Errorf("adjustments = %+v, %v; want the applied -3 recorded", adjustments, err)
+	}
+	if err := users.AdjustCredits(ctx, &models.CreditAdjustment{UserID: 999, CreditType: models.CostTypePaid, Delta: 1, Reason: "x"}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("unknown user err = %v, want ErrNotFound", err)
+	}
+
+	if err := users.SetSubscriptionBonusGranted(ctx, user.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := users.SetBlocked(ctx, 100, true); err != nil || !changed {
+		t.Fatalf("SetBlocked = %v, %v", changed, err)
+	}
+	got = reload(t, users, user.ID)
+	if !got.SubscriptionBonusGranted || got.BlockedAt == nil || got.BlockedAt.Location() != time.UTC {
+		t.Errorf("user = %+v, want bonus granted and a UTC blocked_at", got)
+	}
+	for range 2 {
+		if err := users.RecordActivity(ctx, user.ID); err != nil {
+			t.Fatalf("RecordActivity: %v", err)
+		}
+	}
+}
+
+func TestSQLiteUserSearch(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewUserRepository(openSQLite(t))
+	createUser(t, users, 100, models.User{Username: "anna_k", FirstName: "Анна", LastName: "Котова"})
+	createUser(t, users, 200, models.User{Username: "annaK", FirstName: "Anna"})
+	createUser(t, users, 300, models.User{Username: "bob", FirstName: "100%"})
+
+	for q, want := range map[string]int{
+		"@anna":       2,
+		"anna_":       1, // "_" is literal, not a wildcard
+		"100%":        1,
+		"Анна Котова": 1,
+		"300":         1,
+		"":            3,
+	} {
+		found, err := users.Search(ctx, q, 10, 0)
+		if err != nil {
+			t.Fatalf("Search(%q): %v", q, err)
+		}
+		if len(found) != want {
+			t.Errorf("Search(%q) found %d users, want %d", q, len(found), want)
+		}
+	}
+}
+
+func TestSQLitePromoRedeem(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	users := repository.NewUserRepository(db)
+	promos := repository.NewPromoRepository(db)
+	first := createUser(t, users, 100, models.User{})
+	second := createUser(t, users, 200, models.User{})
+
+	promo, err := promos.Create(ctx, &models.PromoCode{Code: "ONE", Kind: models.PromoKindCredits, MaxUses: 1, CreditType: models.CostTypePaid, Enabled: true, Batch: "blog"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := promos.Create(ctx, &models.PromoCode{Code: "ONE", MaxUses: 1}); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("duplicate code err = %v, want ErrDuplicate", err)
+	}
+
+	if err := promos.Redeem(ctx, first.ID, promo.ID, models.CostTypePaid, 20); err != nil {
+		t.Fatalf("Redeem: %v", err)
+	}
+	if err := promos.Redeem(ctx, second.ID, promo.ID, models.CostTypePaid, 20); !errors.Is(err, repository.ErrLimitReached) {
+		t.Errorf("second user err = %v, want ErrLimitReached", err)
+	}
+	if err := promos.Redeem(ctx, first.ID, 999, models.CostTypePaid, 20); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("unknown promo err = %v, want ErrNotFound", err)
+	}
+	if got := reload(t, users, first.ID); got.PaidCredits != 20 {
+		t.Errorf("paid credits = %d, want 20", got.PaidCredits)
+	}
+
+	stats, err := promos.CampaignStats(ctx, "blog")
+	if err != nil || len(stats) != 1 {
+		t.Fatalf("CampaignStats = %+v, %v", stats, err)
+	}
+	if stats[0].Redemptions != 1 || stats[0].LastRedeemedAt == nil || stats[0].LastRedeemedAt.IsZero() {
+		t.Errorf("campaign stats = %+v", stats[0])
+	}
+	usage, err := promos.ListUsageByBatch(ctx, "blog")
+	if err != nil || len(usage) != 1 || usage[0].LastRedeemedAt == nil {
+		t.Errorf("batch usage = %+v, %v", usage, err)
+	}
+}
+
+// A failed plan insert rolls back the promo row, so the code never applies to every plan.
+func TestSQLitePromoPlansAreAtomic(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	promos := repository.NewPromoRepository(db)
+	plan, err := repository.NewPlanRepository(db).Create(ctx, &models.Plan{Title: "S", Currency: "XTR", PriceMinorUnits: 100, Credits: 10, IsActive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := promos.Create(ctx, &models.PromoCode{Code: "BAD", Kind: models.PromoKindDiscount, DiscountPercent: 10, PlanIDs: []int64{999}}); err == nil {
+		t.Fatal("Create with an unknown plan succeeded")
+	}
+	if promo, err := promos.GetByCode(ctx, "BAD"); err != nil || promo != nil {
+		t.Errorf("promo after failed create = %+v, %v; want none", promo, err)
+	}
+
+	promo, err := promos.Create(ctx, &models.PromoCode{Code: "SALE", Kind: models.PromoKindDiscount, DiscountPercent: 10, PlanIDs: []int64{plan.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	promo.DiscountPercent = 20
+	promo.PlanIDs = []int64{plan.ID, 999}
+	if _, err := promos.Update(ctx, promo); err == nil {
+		t.Fatal("Update with an unknown plan succeeded")
+	}
+	got, err := promos.GetByID(ctx, promo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DiscountPercent != 10 || len(got.PlanIDs) != 1 || got.PlanIDs[0] != plan.ID {
+		t.Errorf("promo after failed update = %+v, want the original", got)
+	}
+}
+
+func TestSQLitePromoRelease(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	users := repository.NewUserRepository(db)
+	promos := repository.NewPromoRepository(db)
+	user := createUser(t, users, 100, models.User{})
+	promo, err := promos.Create(ctx, &models.PromoCode{Code: "SALE", Kind: models.PromoKindDiscount, DiscountPercent: 10, MaxUses: 1, CreditType: models.CostTypePromo, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := promos.Redeem(ctx, user.ID, promo.ID, "", 0); err != nil {
+		t.Fatalf("Redeem: %v", err)
+	}
+	for range 2 { // releasing twice gives the use back once
+		if err := promos.Release(ctx, user.ID, promo.ID); err != nil {
+			t.Fatalf("Release: %v", err)
+		}
+	}
+	if redeemed, _ := promos.HasUserRedeemed(ctx, user.ID, promo.ID); redeemed {
+		t.Error("redemption kept after release")
+	}
+	if got, _ := promos.GetByID(ctx, promo.ID); got.Uses != 0 {
+		t.Errorf("uses = %d, want 0", got.Uses)
+	}
+	if err := promos.Redeem(ctx, user.ID, promo.ID, "", 0); err != nil {
+		t.Errorf("Redeem after release: %v", err)
+	}
+}
+
+// Each payment counts towards one code only: its discount code, otherwise the code the
+// user redeemed last before paying.
+func TestSQLitePromoConversionStats(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	users := repository.NewUserRepository(db)
+	promos := repository.NewPromoRepository(db)
+	payments := repository.NewPaymentRepository(db)
+	first := createUser(t, users, 100, models.User{})
+	second := createUser(t, users, 200, models.User{})
+	codes := make(map[string]*models.PromoCode)
+	for _, code := range []string{"OLD", "NEW", "SALE"} {
+		promo, err := promos.Create(ctx, &models.PromoCode{Code: code, Kind: models.PromoKindCredits, MaxUses: 10, CreditType: models.CostTypePromo, Enabled: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		codes[code] = promo
+	}
+	redeem := func(user *models.User, code, at string) {
+		t.Helper()
+		if err := promos.Redeem(ctx, user.ID, codes[code].ID, models.CostTypePromo, 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.ExecContext(ctx, `UPDATE promo_redemptions SET created_at = ? WHERE user_id = ? AND promo_code_id = ?`, at, user.ID, codes[code].ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pay := func(user *models.User, charge string, promoID *int64, amount int, at string) {
+		t.Helper()
+		payment := &models.Payment{UserID: user.ID, PromoCodeID: promoID, Provider: "telegram", ProviderCharge: charge, Currency: "RUB", Amount: amount, Status: "paid"}
+		if err := payments.Create(ctx, payment); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.ExecContext(ctx, `UPDATE payments SET created_at = ? WHERE id = ?`, at, payment.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first user redeemed two codes and then paid once, without a discount.
+	redeem(first, "OLD", "2026-10-01 10:00:00")
+	redeem(first, "NEW", "2026-10-02 10:00:00")
+	pay(first, "c-1", nil, 29900, "2026-10-03 10:00:00")
+	// The second user paid with a discount; the code is redeemed after the payment.
+	redeem(second, "NEW", "2026-10-01 10:00:00")
+	pay(second, "c-2", &codes["SALE"].ID, 26910, "2026-10-03 10:00:00")
+	redeem(second, "SALE", "2026-10-03 10:00:01")
+
+	stats, err := promos.ConversionStats(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]struct{ redemptions, paidUsers, revenue int }{
+		"OLD":  {1, 0, 0},
+		"NEW":  {2, 1, 29900},
+		"SALE": {1, 1, 26910},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("stats = %+v, want %d codes", stats, len(want))
+	}
+	for _, st := range stats {
+		revenue := 0
+		for _, amount := range st.Revenue {
+			revenue += amount.Amount
+		}
+		w := want[st.Code]
+		if st.Redemptions != w.redemptions || st.PaidUsers != w.paidUsers || revenue != w.revenue {
+			t.Errorf("%s: %d redemptions, %d paid users, revenue %d; want %+v", st.Code, st.Redemptions, st.PaidUsers, revenue, w)
+		}
+	}
+}
+
+// Concurrent redemptions queue on SQLite's write lock instead of failing as busy.
+func TestSQLiteConcurrentRedeem(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	users := repository.NewUserRepository(db)
+	promos := repository.NewPromoRepository(db)
+	promo, err := promos.Create(ctx, &models.PromoCode{Code: "RACE", Kind: models.PromoKindCredits, MaxUses: 3, CreditType: models.CostTypePromo, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 10
+	results := make(chan error, n)
+	for i := range n {
+		user := createUser(t, users, int64(100+i), models.User{})
+		go func() { results <- promos.Redeem(ctx, user.ID, promo.ID, models.CostTypePromo, 10) }()
+	}
+	granted, exhausted := 0, 0
+	for range n {
+		switch err := <-results; {
+		case err == nil:
+			granted++
+		case errors.Is(err, repository.ErrLimitReached):
+			exhausted++
+		default:
+			t.Errorf("Redeem: %v", err)
+		}
+	}
+	if granted != 3 || exhausted != n-3 {
+		t.Errorf("granted %d, exhausted %d; want 3 and %d", granted, exhausted, n-3)
+	}
+}
+
+func TestSQLitePaymentMarkPaidOnce(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	users := repository.NewUserRepository(db)
+	payments := repository.NewPaymentRepository(db)
+	user := createUser(t, users, 100, models.User{})
+
+	payment := &models.Payment{UserID: user.ID, Provider: "yookassa", ProviderCharge: "yk-1", Currency: "RUB", Amount: 29900, Status: "pending"}
+	if err := payments.Create(ctx, payment); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, false} {
+		ok, err := payments.MarkPaid(ctx, payment.ID, "{}", 50)
+		if err != nil || ok != want {
+			t.Fatalf("MarkPaid #%d = %v, %v; want %v", i+1, ok, err, want)
+		}
+	}
+	if got := reload(t, users, user.ID); got.PaidCredits != 50 {
+		t.Errorf("paid credits = %d, want 50", got.PaidCredits)
+	}
+	stored, err := payments.FindByProviderCharge(ctx, "yookassa", "yk-1")
+	if err != nil || stored == nil || stored.Status != "paid" || stored.UpdatedAt.IsZero() {
+		t.Errorf("stored payment = %+v, %v", stored, err)
+	}
+}
+
+func TestSQLiteReferralReward(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	users := repository.NewUserRepository(db)
+	referrals := repository.NewReferralRepository(db)
+	referrer := createUser(t, users, 100, models.User{})
+	invitee := createUser(t, users, 200, models.User{})
+
+	if err := referrals.Create(ctx, referrer.ID, invitee.ID, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := referrals.Create(ctx, referrer.ID, invitee.ID, 10); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("second attribution err = %v, want ErrDuplicate", err)
+	}
+	ref, err := referrals.FindByInvitee(ctx, invitee.ID)
+	if err != nil || ref == nil {
+		t.Fatalf("FindByInvitee = %+v, %v", ref, err)
+	}
+	for i, want := range []bool{true, false} {
+		if ok, err := referrals.RewardReferrer(ctx, ref.ID, 50); err != nil || ok != want {
+			t.Fatalf("RewardReferrer #%d = %v, %v; want %v", i+1, ok, err, want)
+		}
+	}
+	if got := reload(t, users, referrer.ID); got.PromoCredits != 50 {
+		t.Errorf("referrer promo credits = %d, want 50", got.PromoCredits)
+	}
+	if got := reload(t, users, invitee.ID); got.PromoCredits != 10 {
+		t.Errorf("invitee promo credits = %d, want 10", got.PromoCredits)
+	}
+}
+
+func TestSQLiteBroadcastAudienceAndLease(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	users := repository.NewUserRepository(db)
+	broadcasts := repository.NewBroadcastRepository(db)
+	createUser(t, users, 100, models.User{LanguageCode: "ru-RU"})
+	createUser(t, users, 200, models.User{LanguageCode: "ru"})
+	createUser(t, users, 300, models.User{LanguageCode: "en"})
+	createUser(t, users, 400, models.User{LanguageCode: "ru"})
+	if _, err := users.SetBlocked(ctx, 400, true); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &models.Broadcast{
+		BroadcastContent: models.BroadcastContent{Message: "Привет"},
+		Audience:         models.AudienceFilter{Languages: []string{"RU"}},
+		Status:           models.BroadcastPending,
+	}
+	if err := broadcasts.Create(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	progress, err := broadcasts.Progress(ctx, b.ID)
+	if err != nil || progress == nil || progress.Total != 2 {
+		t.Fatalf("progress = %+v, %v; want 2 recipients", progress, err)
+	}
+
+	for _, claim := range []struct {
+		owner string
+		want  bool
+	}{{"a", true}, {"b", false}, {"a", true}} {
+		ok, err := broadcasts.Claim(ctx, b.ID, claim.owner, time.Minute)
+		if err != nil || ok != claim.want {
+			t.Fatalf("Claim(%s) = %v, %v; want %v", claim.owner, ok, err, claim.want)
+		}
+	}
+	if next, err := broadcasts.NextActive(ctx); err != nil || next != nil {
+		t.Errorf("NextActive under a live lease = %+v, %v; want nil", next, err)
+	}
+	if err := broadcasts.SetRecipientStatus(ctx, b.ID, 100, models.RecipientFailed, 1, strings.Repeat("x", 600)); err != nil {
+		t.Fatal(err)
+	}
+	if err := broadcasts.MarkDone(ctx, b.ID, "a"); err != nil {
+		t.Fatal(err)
+	}
+	done, err := broadcasts.GetByID(ctx, b.ID)
+	if err != nil || done.Status != models.BroadcastDone || done.StartedAt == nil || done.FinishedAt == nil {
+		t.Errorf("finished broadcast = %+v, %v", done, err)
+	}
+}
+
+func TestSQLiteStatsOverview(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	users := repository.NewUserRepository(db)
+	generations := repository.NewGenerationRepository(db)
+	user := createUser(t, users, 100, models.User{})
+	if err := users.RecordActivity(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := generations.Log(ctx, user.ID, models.ModelFlux2, "cat", models.CostTypePromo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := generations.CountForDay(ctx, user.ID, time.Now().UTC()); err != nil || n != 2 {
+		t.Errorf("CountForDay = %d, %v; want 2", n, err)
+	}
+
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+	// Activity on the exclusive end day is outside the range.
+	later := createUser(t, users, 200, models.User{})
+	if _, err := db.ExecContext(ctx, `INSERT INTO user_activity (user_id, day) VALUES (?, ?)`, later.ID, to.Format("2006-01-02")); err != nil {
+		t.Fatal(err)
+	}
+	overview, err := repository.NewStatsRepository(db).Overview(ctx, from, to)
+	if err != nil {
+		t.Fatalf("Overview: %v", err)
+	}
+	today := from.Format("2006-01-02")
+	if overview.ActiveUsers != 1 || len(overview.DailyActiveUsers) != 1 || overview.DailyActiveUsers[0].Period != today {
+		t.Errorf("active users = %d %+v, want 1 on %s", overview.ActiveUsers, overview.DailyActiveUsers, today)
+	}
+	if len(overview.MonthlyActiveUsers) != 1 || overview.MonthlyActiveUsers[0].Period != from.Format("2006-01") {
+		t.Errorf("monthly active users = %+v", overview.MonthlyActiveUsers)
+	}
+	if overview.Signups != 2 || len(overview.Generations) != 1 || overview.Generations[0].Count != 2 {
+		t.Errorf("overview = %+v", overview)
+	}
+}
+
+func TestSQLiteAdminSessions(t *testing.T) {
+	ctx := context.Background()
+	admins := repository.NewAdminRepository(openSQLite(t))
+	admin := &models.AdminUser{Username: "root", PasswordHash: "x", Role: models.RoleSuperadmin}
+	if err := admins.CreateUser(ctx, admin); err != nil {
+		t.Fatal(err)
+	}
+	if err := admins.CreateUser(ctx, &models.AdminUser{Username: "root", PasswordHash: "x", Role: models.RoleSuperadmin}); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("duplicate admin err = %v, want ErrDuplicate", err)
+	}
+
+	sessions := map[string]time.Duration{"live": time.Hour, "stale": -time.Hour}
+	for hash, ttl := range sessions {
+		s := &models.AdminSession{AdminUserID: admin.ID, CSRFToken: "csrf", ExpiresAt: time.Now().Add(ttl)}
+		if err := admins.CreateSession(ctx, hash, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s, err := admins.FindSession(ctx, "live"); err != nil || s == nil || s.Username != "root" {
+		t.Errorf("live session = %+v, %v", s, err)
+	}
+	if s, err := admins.FindSession(ctx, "stale"); err != nil || s != nil {
+		t.Errorf("expired session = %+v, %v; want none", s, err)
+	}
+
+	if err := admins.InsertAudit(ctx, &models.AuditEntry{Actor: "root", Method: "POST", Path: "/api/users/" + strings.Repeat("я", 600), Status: 200}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := admins.ListAudit(ctx, "root", 10, 0)
+	if err != nil || len(entries) != 1 || len([]rune(entries[0].Path)) != 512 {
+		t.Errorf("audit = %+v, %v; want one entry with the path cut to 512 characters", entries, err)
+	}
+}
@@ -0,0 +1,223 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+)
+
+// StatsRepository runs read-only aggregate queries for the admin reports.
+type StatsRepository struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func NewStatsRepository(db *sql.DB) *StatsRepository {
+	return &StatsRepository{db: db, dialect: dialectOf(db)}
+}
+
+// SourceStats reports signups, generations and paid revenue per acquisition source
+// for events in [from, to).
+func (r *StatsRepository) SourceStats(ctx context.Context, from, to time.Time) ([]models.SourceStats, error) {
+	stats := make([]models.SourceStats, 0)
+	index := make(map[string]int)
+	entry := func(source string) *models.SourceStats {
+		i, ok := index[source]
+		if !ok {
+			i = len(stats)
+			index[source] = i
+			stats = append(stats, models.SourceStats{Source: source, Revenue: make([]models.CurrencyAmount, 0)})
+		}
+		return &stats[i]
+	}
+
+	const signups = `
+SELECT COALESCE(source, ''), COUNT(*)
+FROM users
+WHERE created_at >= ? AND created_at < ?
+GROUP BY COALESCE(source, '')`
+	err := r.scanRows(ctx, signups, []any{from, to}, func(rows *sql.Rows) error {
+		var source string
+		var count int
+		if err := rows.Scan(&source, &count); err != nil {
+			return err
+		}
+		entry(source).Signups = count
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("source signups: %w", err)
+	}
+
+	const generations = `
+SELECT COALESCE(u.source, ''), COUNT(*)
+FROM generation_logs g
+JOIN users u ON u.id = g.user_id
+WHERE g.created_at >= ? AND g.created_at < ?
+GROUP BY COALESCE(u.source, '')`
+	err = r.scanRows(ctx, generations, []any{from, to}, func(rows *sql.Rows) error {
+		var source string
+		var count int
+		if err := rows.Scan(&source, &count); err != nil {
+			return err
+		}
+		entry(source).Generations = count
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("source generations: %w", err)
+	}
+
+	const revenue = `
+SELECT COALESCE(u.source, ''), p.currency, SUM(p.amount)
+FROM payments p
+JOIN users u ON u.id = p.user_id
+WHERE p.status = 'paid' AND p.created_at >= ? AND p.created_at < ?
+GROUP BY COALESCE(u.source, ''), p.currency
+ORDER BY p.currency`
+	err = r.scanRows(ctx, revenue, []any{from, to}, func(rows *sql.Rows) error {
+		var source string
+		var amount models.CurrencyAmount
+		if err := rows.Scan(&source, &amount.Currency, &amount.Amount); err != nil {
+			return err
+		}
+		e := entry(source)
+		e.Revenue = append(e.Revenue, amount)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("source revenue: %w", err)
+	}
+
+	return stats, nil
+}
+
+// Overview aggregates activity, signups, generations, revenue, promo redemptions and
+// subscription bonus grants for [from, to). Activity combines user_activity with
+// generation_logs so days before activity tracking still count generating users.
+func (r *StatsRepository) Overview(ctx context.Context, from, to time.Time) (*models.Overview, error) {
+	overview := &models.Overview{
+		DailyActiveUsers:   make([]models.PeriodCount, 0),
+		MonthlyActiveUsers: make([]models.PeriodCount, 0),
+		DailySignups:       make([]models.PeriodCount, 0),
+		Generations:        make([]models.GenerationCount, 0),
+		Revenue:            make([]models.RevenueStats, 0),
+	}
+
+	const activity = `
+SELECT user_id, day FROM user_activity WHERE day >= DATE(?) AND day < DATE(?)
+UNION
+SELECT user_id, DATE(created_at) FROM generation_logs WHERE created_at >= ? AND created_at < ?`
+	activityArgs := []any{from, to, from, to}
+
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(DISTINCT user_id) FROM (`+activity+`) a`, activityArgs...).Scan(&overview.ActiveUsers); err != nil {
+		return nil, fmt.Errorf("active users: %w", err)
+	}
+	periods := []struct {
+		format string
+		dest   *[]models.PeriodCount
+	}{
+		{format: "%Y-%m-%d", dest: &overview.DailyActiveUsers},
+		{format: "%Y-%m", dest: &overview.MonthlyActiveUsers},
+	}
+	for _, p := range periods {
+		query := `SELECT ` + r.dialect.formatDate(`day`, p.format) + ` AS period, COUNT(DISTINCT user_id) FROM (` + activity + `) a GROUP BY period ORDER BY period`
+		if err := r.scanPeriods(ctx, query, activityArgs, p.dest); err != nil {
+			return nil, fmt.Errorf("active users by period: %w", err)
+		}
+	}
+
+	signups := `
+SELECT ` + r.dialect.formatDate(`created_at`, `%Y-%m-%d`) + ` AS period, COUNT(*)
+FROM users
+WHERE created_at >= ? AND created_at < ?
+GROUP BY period
+ORDER BY period`
+	if err := r.scanPeriods(ctx, signups, []any{from, to}, &overview.DailySignups); err != nil {
+		return nil, fmt.Errorf("signups: %w", err)
+	}
+	for _, day := range overview.DailySignups {
+		overview.Signups += day.Count
+	}
+
+	const generations = `
+SELECT model, cost_type, COUNT(*)
+FROM generation_logs
+WHERE created_at >= ? AND created_at < ?
+GROUP BY model, cost_type
+ORDER BY model, cost_type`
+	err := r.scanRows(ctx, generations, []any{from, to}, func(rows *sql.Rows) error {
+		var g models.GenerationCount
+		if err := rows.Scan(&g.Model, &g.CostType, &g.Count); err != nil {
+			return err
+		}
+		overview.Generations = append(overview.Generations, g)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("generations: %w", err)
+	}
+
+	const revenue = `
+SELECT currency, provider, COUNT(*), SUM(amount)
+FROM payments
+WHERE status = 'paid' AND created_at >= ? AND created_at < ?
+GROUP BY currency, provider
+ORDER BY currency, provider`
+	err = r.scanRows(ctx, revenue, []any{from, to}, func(rows *sql.Rows) error {
+		var rev models.RevenueStats
+		if err := rows.Scan(&rev.Currency, &rev.Provider, &rev.Payments, &rev.Amount); err != nil {
+			return err
+		}
+		overview.Revenue = append(overview.Revenue, rev)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("revenue: %w", err)
+	}
+
+	const redemptions = `SELECT COUNT(*) FROM promo_redemptions WHERE created_at >= ? AND created_at < ?`
+	if err := r.db.QueryRowContext(ctx, redemptions, from, to).Scan(&overview.PromoRedemptions); err != nil {
+		return nil, fmt.Errorf("promo redemptions: %w", err)
+	}
+
+	const grants = `SELECT COUNT(*) FROM users WHERE subscription_bonus_granted_at >= ? AND subscription_bonus_granted_at < ?`
+	if err := r.db.QueryRowContext(ctx, grants, from, to).Scan(&overview.SubscriptionBonusGrants); err != nil {
+		return nil, fmt.Errorf("subscription bonus grants: %w", err)
+	}
+
+	const blocked = `SELECT COUNT(*), COALESCE(SUM(blocked_at >= ? AND blocked_at < ?), 0) FROM users WHERE blocked_at IS NOT NULL`
+	if err := r.db.QueryRowContext(ctx, blocked, from, to).Scan(&overview.BlockedUsers, &overview.NewlyBlocked); err != nil {
+		return nil, fmt.Errorf("blocked users: %w", err)
+	}
+
+	return overview, nil
+}
+
+func (r *StatsRepository) scanPeriods(ctx context.Context, query string, args []any, dest *[]models.PeriodCount) error {
+	return r.scanRows(ctx, query, args, func(rows *sql.Rows) error {
+		var pc models.PeriodCount
+		if err := rows.Scan(&pc.Period, &pc.Count); err != nil {
+			return err
+		}
+		*dest = append(*dest, pc)
+		return nil
+	})
+}
+
+func (r *StatsRepository) scanRows(ctx context.Context, query string, args []any, scan func(*sql.Rows) error) error {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+)
+
+// The store interfaces describe what the services need from persistence. The MySQL
+// repositories in this package implement them; internal/repository/memory provides
+// in-memory versions for tests.
+
+type UserStore interface {
+	FindByTelegramID(ctx context.Context, telegramID int64) (*models.User, error)
+	FindByID(ctx context.Context, id int64) (*models.User, error)
+	FindByReferralCode(ctx context.Context, code string) (*models.User, error)
+	SetReferralCode(ctx context.Context, userID int64, code string) error
+	SetSource(ctx context.Context, userID int64, source string) error
+	SetBan(ctx context.Context, userID int64, status models.BanStatus, reason string, until *time.Time) error
+	Ensure(ctx context.Context, telegramID int64, username, firstName, lastName, languageCode string, freeLimit int) (*models.User, bool, error)
+	UpdatePromoCredits(ctx context.Context, userID int64, delta int) error
+	UpdatePaidCredits(ctx context.Context, userID int64, delta int) error
+	SetSubscriptionBonusGranted(ctx context.Context, userID int64, granted bool) error
+	ConsumePromoCredit(ctx context.Context, userID int64) (bool, error)
+	ConsumePaidCredit(ctx context.Context, userID int64) (bool, error)
+	Search(ctx context.Context, q string, limit, offset int) ([]models.User, error)
+	AdjustCredits(ctx context.Context, adj *models.CreditAdjustment) error
+	ListCreditAdjustments(ctx context.Context, userID int64, limit int) ([]models.CreditAdjustment, error)
+	SetBlocked(ctx context.Context, telegramID int64, blocked bool) (bool, error)
+	ListTelegramIDs(ctx context.Context) ([]int64, error)
+}
+
+type GenerationStore interface {
+	Log(ctx context.Context, userID int64, model models.ModelType, prompt string, cost models.CostType) error
+	CountForDay(ctx context.Context, userID int64, day time.Time) (int, error)
+	ListByUser(ctx context.Context, userID int64, limit int) ([]models.GenerationLog, error)
+	List(ctx context.Context, model models.ModelType, limit, offset int) ([]models.GenerationLog, error)
+}
+
+type PromoStore interface {
+	GetByCode(ctx context.Context, code string) (*models.PromoCode, error)
+	GetByID(ctx context.Context, id int64) (*models.PromoCode, error)
+	List(ctx context.Context) ([]models.PromoCode, error)
+	Create(ctx context.Context, promo *models.PromoCode) (*models.PromoCode, error)
+	Update(ctx context.Context, promo *models.PromoCode) (*models.PromoCode, error)
+	Delete(ctx context.Context, id int64) error
+	CreateBatch(ctx context.Context, template *models.PromoCode, codes []string) error
+	ListUsageByBatch(ctx context.Context, batch string) ([]models.PromoUsage, error)
+	CampaignStats(ctx context.Context, batch string) ([]models.PromoCampaignStats, error)
+	ListRedemptions(ctx context.Context, promoID int64, limit, offset int) ([]models.PromoRedemption, int, error)
+	ConversionStats(ctx context.Context, promoID int64) ([]models.PromoConversionStats, error)
+	HasUserRedeemed(ctx context.Context, userID, promoID int64) (bool, error)
+	Redeem(ctx context.Context, userID, promoID int64, creditType models.CostType, credits int) error
+	Release(ctx context.Context, userID, promoID int64) error
+}
+
+type PaymentStore interface {
+	Create(ctx context.Context, payment *models.Payment) error
+	CreatePaid(ctx context.Context, payment *models.Payment, credits int) error
+	MarkPaid(ctx context.Context, paymentID int64, payload string, credits int) (bool, error)
+	UpdateStatus(ctx context.Context, paymentID int64, status string, payload string) error
+	FindByProviderCharge(ctx context.Context, provider, chargeID string) (*models.Payment, error)
+	ListByUser(ctx context.Context, userID int64, limit int) ([]models.Payment, error)
+	List(ctx context.Context, status string, limit, offset int) ([]models.Payment, error)
+}
+
+type PlanStore interface {
+	List(ctx context.Context) ([]models.Plan, error)
+	GetDefault(ctx context.Context) (*models.Plan, error)
+	GetByID(ctx context.Context, id int64) (*models.Plan, error)
+	Create(ctx context.Context, plan *models.Plan) (*models.Plan, error)
+	Update(ctx context.Context, plan *models.Plan) (*models.Plan, error)
+	Delete(ctx context.Context, id int64) error
+}
+
+type ReferralStore interface {
+	Create(ctx context.Context, referrerID, inviteeID int64, inviteeBonus int) error
+	FindByInvitee(ctx context.Context, inviteeID int64) (*models.Referral, error)
+	RewardReferrer(ctx context.Context, referralID int64, bonus int) (bool, error)
+	Stats(ctx context.Context, referrerID int64) (models.ReferralStats, error)
+}
+
+var (
+	_ UserStore       = (*UserRepository)(nil)
+	_ GenerationStore = (*GenerationRepository)(nil)
+	_ PromoStore      = (*PromoRepository)(nil)
+	_ PaymentStore    = (*PaymentRepository)(nil)
+	_ PlanStore       = (*PlanRepository)(nil)
+	_ ReferralStore   = (*ReferralRepository)(nil)
+)
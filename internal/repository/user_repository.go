@@ -5,48 +5,122 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/digkill/TGStickerBot/internal/models"
 )
 
 type UserRepository struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect sqlDialect
 }
 
 func NewUserRepository(db *sql.DB) *UserRepository {
-	return &UserRepository{db: db}
+	return &UserRepository{db: db, dialect: dialectOf(db)}
 }
 
-func (r *UserRepository) DB() *sql.DB {
-	return r.db
+const userColumns = `id, telegram_id, COALESCE(username, ''), COALESCE(first_name, ''), COALESCE(last_name, ''), COALESCE(language_code, ''), free_daily_limit, promo_credits, paid_credits, subscription_bonus_granted, COALESCE(referral_code, ''), COALESCE(source, ''), ban_status, COALESCE(ban_reason, ''), banned_until, blocked_at, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
 }
 
-func (r *UserRepository) FindByTelegramID(ctx context.Context, telegramID int64) (*models.User, error) {
-	const query = `
-SELECT id, telegram_id, COALESCE(username, ''), COALESCE(first_name, ''), COALESCE(last_name, ''), free_daily_limit, promo_credits, paid_credits, subscription_bonus_granted, created_at, updated_at
-FROM users WHERE telegram_id = ?`
-	row := r.db.QueryRowContext(ctx, query, telegramID)
+func scanUser(row rowScanner) (*models.User, error) {
 	var u models.User
 	var granted int
-	if err := row.Scan(&u.ID, &u.TelegramID, &u.Username, &u.FirstName, &u.LastName, &u.FreeDailyLimit, &u.PromoCredits, &u.PaidCredits, &granted, &u.CreatedAt, &u.UpdatedAt); err != nil {
+	var bannedUntil, blockedAt sql.NullTime
+	if err := row.Scan(&u.ID, &u.TelegramID, &u.Username, &u.FirstName, &u.LastName, &u.LanguageCode, &u.FreeDailyLimit, &u.PromoCredits, &u.PaidCredits, &granted, &u.ReferralCode, &u.Source, &u.BanStatus, &u.BanReason, &bannedUntil, &blockedAt, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		return nil, err
+	}
+	u.SubscriptionBonusGranted = granted != 0
+	if bannedUntil.Valid {
+		u.BannedUntil = &bannedUntil.Time
+	}
+	if blockedAt.Valid {
+		u.BlockedAt = &blockedAt.Time
+	}
+	return &u, nil
+}
+
+func (r *UserRepository) FindByTelegramID(ctx context.Context, telegramID int64) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE telegram_id = ?`
+	u, err := scanUser(r.db.QueryRowContext(ctx, query, telegramID))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("scan user: %w", err)
 	}
-	u.SubscriptionBonusGranted = granted != 0
-	return &u, nil
+	return u, nil
+}
+
+func (r *UserRepository) FindByID(ctx context.Context, id int64) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = ?`
+	u, err := scanUser(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("scan user by id: %w", err)
+	}
+	return u, nil
+}
+
+func (r *UserRepository) FindByReferralCode(ctx context.Context, code string) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE referral_code = ?`
+	u, err := scanUser(r.db.QueryRowContext(ctx, query, code))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("scan user by referral code: %w", err)
+	}
+	return u, nil
+}
+
+// SetReferralCode assigns a code only if the user has none yet. It returns ErrDuplicate
+// when another user already owns the code.
+func (r *UserRepository) SetReferralCode(ctx context.Context, userID int64, code string) error {
+	const query = `UPDATE users SET referral_code = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND referral_code IS NULL`
+	if _, err := r.db.ExecContext(ctx, query, code, userID); err != nil {
+		return fmt.Errorf("set referral code: %w", wrapDuplicate(err))
+	}
+	return nil
+}
+
+// SetSource records the acquisition source once; later deep links do not overwrite it.
+func (r *UserRepository) SetSource(ctx context.Context, userID int64, source string) error {
+	const query = `UPDATE users SET source = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND source IS NULL`
+	if _, err := r.db.ExecContext(ctx, query, source, userID); err != nil {
+		return fmt.Errorf("set source: %w", err)
+	}
+	return nil
+}
+
+// SetBan sets or, with models.BanNone, lifts a user's ban. until is optional.
+func (r *UserRepository) SetBan(ctx context.Context, userID int64, status models.BanStatus, reason string, until *time.Time) error {
+	const query = `UPDATE users SET ban_status = ?, ban_reason = NULLIF(?, ''), banned_until = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	var untilArg sql.NullTime
+	if until != nil {
+		untilArg = sql.NullTime{Time: *until, Valid: true}
+	}
+	if _, err := r.db.ExecContext(ctx, query, status, reason, untilArg, userID); err != nil {
+		return fmt.Errorf("set ban: %w", err)
+	}
+	return nil
 }
 
 func (r *UserRepository) Create(ctx context.Context, user *models.User) (*models.User, error) {
 	const query = `
-INSERT INTO users (telegram_id, username, first_name, last_name, free_daily_limit, promo_credits, paid_credits, subscription_bonus_granted)
-VALUES (?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?)`
+INSERT INTO users (telegram_id, username, first_name, last_name, language_code, free_daily_limit, promo_credits, paid_credits, subscription_bonus_granted)
+VALUES (?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?)`
 	granted := 0
 	if user.SubscriptionBonusGranted {
 		granted = 1
 	}
-	res, err := r.db.ExecContext(ctx, query, user.TelegramID, user.Username, user.FirstName, user.LastName, user.FreeDailyLimit, user.PromoCredits, user.PaidCredits, granted)
+	res, err := r.db.ExecContext(ctx, query, user.TelegramID, user.Username, user.FirstName, user.LastName, user.LanguageCode, user.FreeDailyLimit, user.PromoCredits, user.PaidCredits, granted)
 	if err != nil {
 		return nil, fmt.Errorf("insert user: %w", err)
 	}
@@ -58,24 +132,28 @@ VALUES (?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?)`
 	return user, nil
 }
 
-func (r *UserRepository) UpdateProfile(ctx context.Context, userID int64, username, firstName, lastName string) error {
+// UpdateProfile refreshes Telegram profile fields; an empty language code keeps the
+// stored one since Telegram does not always send it.
+func (r *UserRepository) UpdateProfile(ctx context.Context, userID int64, username, firstName, lastName, languageCode string) error {
 	const query = `
-UPDATE users SET username = NULLIF(?, ''), first_name = NULLIF(?, ''), last_name = NULLIF(?, ''), updated_at = NOW()
+UPDATE users SET username = NULLIF(?, ''), first_name = NULLIF(?, ''), last_name = NULLIF(?, ''),
+    language_code = COALESCE(NULLIF(?, ''), language_code), updated_at = CURRENT_TIMESTAMP
 WHERE id = ?`
-	if _, err := r.db.ExecContext(ctx, query, username, firstName, lastName, userID); err != nil {
+	if _, err := r.db.ExecContext(ctx, query, username, firstName, lastName, languageCode, userID); err != nil {
 		return fmt.Errorf("update profile: %w", err)
 	}
 	return nil
 }
 
-func (r *UserRepository) Ensure(ctx context.Context, telegramID int64, username, firstName, lastName string, freeLimit int) (*models.User, bool, error) {
+func (r *UserRepository) Ensure(ctx context.Context, telegramID int64, username, firstName, lastName, languageCode string, freeLimit int) (*models.User, bool, error) {
 	user, err := r.FindByTelegramID(ctx, telegramID)
 	if err != nil {
 		return nil, false, err
 	}
 	if user != nil {
 		go func() {
-			_ = r.UpdateProfile(context.Background(), user.ID, username, firstName, lastName)
+			_ = r.UpdateProfile(context.Background(), user.ID, username, firstName, lastName, languageCode)
+			_ = r.RecordActivity(context.Background(), user.ID)
 		}()
 		return user, false, nil
 	}
@@ -84,17 +162,28 @@ func (r *UserRepository) Ensure(ctx context.Context, telegramID int64, username,
 		Username:       username,
 		FirstName:      firstName,
 		LastName:       lastName,
+		LanguageCode:   languageCode,
 		FreeDailyLimit: freeLimit,
 	}
 	created, err := r.Create(ctx, newUser)
 	if err != nil {
 		return nil, false, err
 	}
+	_ = r.RecordActivity(ctx, created.ID)
 	return created, true, nil
 }
 
+// RecordActivity marks the user as active today (UTC) for DAU/MAU reporting.
+func (r *UserRepository) RecordActivity(ctx context.Context, userID int64) error {
+	query := r.dialect.insertIgnore() + ` INTO user_activity (user_id, day) VALUES (?, ?)`
+	if _, err := r.db.ExecContext(ctx, query, userID, time.Now().UTC().Format("2006-01-02")); err != nil {
+		return fmt.Errorf("record activity: %w", err)
+	}
+	return nil
+}
+
 func (r *UserRepository) UpdatePromoCredits(ctx context.Context, userID int64, delta int) error {
-	const query = `UPDATE users SET promo_credits = GREATEST(promo_credits + ?, 0), updated_at = NOW() WHERE id = ?`
+	query := `UPDATE users SET promo_credits = ` + r.dialect.greatest(`promo_credits + ?`, `0`) + `, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
 	if _, err := r.db.ExecContext(ctx, query, delta, userID); err != nil {
 		return fmt.Errorf("update promo credits: %w", err)
 	}
@@ -102,7 +191,7 @@ func (r *UserRepository) UpdatePromoCredits(ctx context.Context, userID int64, d
 }
 
 func (r *UserRepository) UpdatePaidCredits(ctx context.Context, userID int64, delta int) error {
-	const query = `UPDATE users SET paid_credits = GREATEST(paid_credits + ?, 0), updated_at = NOW() WHERE id = ?`
+	query := `UPDATE users SET paid_credits = ` + r.dialect.greatest(`paid_credits + ?`, `0`) + `, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
 	if _, err := r.db.ExecContext(ctx, query, delta, userID); err != nil {
 		return fmt.Errorf("update paid credits: %w", err)
 	}
@@ -114,8 +203,13 @@ func (r *UserRepository) SetSubscriptionBonusGranted(ctx context.Context, userID
 	if granted {
 		value = 1
 	}
-	const query = `UPDATE users SET subscription_bonus_granted = ?, updated_at = NOW() WHERE id = ?`
-	if _, err := r.db.ExecContext(ctx, query, value, userID); err != nil {
+	const query = `
+UPDATE users
+SET subscription_bonus_granted = ?,
+    subscription_bonus_granted_at = CASE WHEN ? = 1 THEN COALESCE(subscription_bonus_granted_at, CURRENT_TIMESTAMP) END,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = ?`
+	if _, err := r.db.ExecContext(ctx, query, value, value, userID); err != nil {
 		return fmt.Errorf("set subscription bonus granted: %w", err)
 	}
 	return nil
@@ -123,7 +217,7 @@ func (r *UserRepository) SetSubscriptionBonusGranted(ctx context.Context, userID
 
 func (r *UserRepository) ConsumePromoCredit(ctx context.Context, userID int64) (bool, error) {
 	const query = `
-UPDATE users SET promo_credits = promo_credits - ?, updated_at = NOW()
+UPDATE users SET promo_credits = promo_credits - ?, updated_at = CURRENT_TIMESTAMP
 WHERE id = ? AND promo_credits >= ?`
 	amount := 5
 	res, err := r.db.ExecContext(ctx, query, amount, userID, amount)
@@ -139,7 +233,7 @@ WHERE id = ? AND promo_credits >= ?`
 
 func (r *UserRepository) ConsumePaidCredit(ctx context.Context, userID int64) (bool, error) {
 	const query = `
-UPDATE users SET paid_credits = paid_credits - ?, updated_at = NOW()
+UPDATE users SET paid_credits = paid_credits - ?, updated_at = CURRENT_TIMESTAMP
 WHERE id = ? AND paid_credits >= ?`
 	amount := 5
 	res, err := r.db.ExecContext(ctx, query, amount, userID, amount)
@@ -153,8 +247,147 @@ WHERE id = ? AND paid_credits >= ?`
 	return affected > 0, nil
 }
 
+// Search finds users by numeric id/telegram id, "@username", or a substring of the
+// username or name.
+func (r *UserRepository) Search(ctx context.Context, q string, limit, offset int) ([]models.User, error) {
+	q = strings.TrimSpace(q)
+	query := `SELECT ` + userColumns + ` FROM users`
+	var args []any
+	switch {
+	case q == "":
+	case strings.HasPrefix(q, "@"):
+		query += ` WHERE username LIKE ? ESCAPE '!'`
+		args = append(args, escapeLike(strings.TrimPrefix(q, "@"))+"%")
+	default:
+		if id, err := strconv.ParseInt(q, 10, 64); err == nil {
+			query += ` WHERE telegram_id = ? OR id = ?`
+			args = append(args, id, id)
+			break
+		}
+		pattern := "%" + escapeLike(q) + "%"
+		query += ` WHERE username LIKE ? ESCAPE '!' OR first_name LIKE ? ESCAPE '!' OR last_name LIKE ? ESCAPE '!' OR CONCAT_WS(' ', first_name, last_name) LIKE ? ESCAPE '!'`
+		args = append(args, pattern, pattern, pattern, pattern)
+	}
+	query += ` ORDER BY id DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]models.User, 0)
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan user search: %w", err)
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+// AdjustCredits changes a balance and records who did it and why, atomically. Balances
+// never go below zero, so a removal is capped at the current balance; adj.Delta is set
+// to the change actually applied, which is also what gets recorded.
+func (r *UserRepository) AdjustCredits(ctx context.Context, adj *models.CreditAdjustment) error {
+	column := "promo_credits"
+	if adj.CreditType == models.CostTypePaid {
+		column = "paid_credits"
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin adjust credits tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var balance int
+	if err := tx.QueryRowContext(ctx, `SELECT `+column+` FROM users WHERE id = ?`+r.dialect.forUpdate(), adj.UserID).Scan(&balance); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("lock user balance: %w", err)
+	}
+	adj.Delta = max(adj.Delta, -balance)
+
+	update := `UPDATE users SET ` + column + ` = ` + column + ` + ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	if _, err := tx.ExecContext(ctx, update, adj.Delta, adj.UserID); err != nil {
+		return fmt.Errorf("adjust credits: %w", err)
+	}
+	const insert = `
+INSERT INTO credit_adjustments (user_id, credit_type, delta, reason, actor)
+VALUES (?, ?, ?, ?, ?)`
+	res, err := tx.ExecContext(ctx, insert, adj.UserID, adj.CreditType, adj.Delta, adj.Reason, adj.Actor)
+	if err != nil {
+		return fmt.Errorf("record credit adjustment: %w", err)
+	}
+	if adj.ID, err = res.LastInsertId(); err != nil {
+		return fmt.Errorf("credit adjustment last insert id: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit adjust credits: %w", err)
+	}
+	return nil
+}
+
+func (r *UserRepository) ListCreditAdjustments(ctx context.Context, userID int64, limit int) ([]models.CreditAdjustment, error) {
+	const query = `
+SELECT id, user_id, credit_type, delta, reason, actor, created_at
+FROM credit_adjustments
+WHERE user_id = ?
+ORDER BY id DESC
+LIMIT ?`
+	rows, err := r.db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list credit adjustments: %w", err)
+	}
+	defer rows.Close()
+
+	adjustments := make([]models.CreditAdjustment, 0)
+	for rows.Next() {
+		var adj models.CreditAdjustment
+		if err := rows.Scan(&adj.ID, &adj.UserID, &adj.CreditType, &adj.Delta, &adj.Reason, &adj.Actor, &adj.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan credit adjustment: %w", err)
+		}
+		adjustments = append(adjustments, adj)
+	}
+	return adjustments, rows.Err()
+}
+
+// escapeLike escapes LIKE wildcards for a pattern used with ESCAPE '!'. SQLite has no
+// default escape character, so the clause is spelled out.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(s)
+}
+
+// reachableUsers filters out users under an active full ban and users who blocked the
+// bot; they never get broadcasts.
+const reachableUsers = `blocked_at IS NULL AND (ban_status <> 'banned' OR (banned_until IS NOT NULL AND banned_until <= CURRENT_TIMESTAMP))`
+
+// SetBlocked records that the user blocked the bot (keeping the first timestamp) or,
+// with blocked false, that they came back. It reports whether anything changed.
+func (r *UserRepository) SetBlocked(ctx context.Context, telegramID int64, blocked bool) (bool, error) {
+	query := `UPDATE users SET blocked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE telegram_id = ? AND blocked_at IS NULL`
+	if !blocked {
+		query = `UPDATE users SET blocked_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE telegram_id = ? AND blocked_at IS NOT NULL`
+	}
+	res, err := r.db.ExecContext(ctx, query, telegramID)
+	if err != nil {
+		return false, fmt.Errorf("set user blocked: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("set user blocked rows: %w", err)
+	}
+	return n > 0, nil
+}
+
+// ListTelegramIDs returns broadcast recipients, skipping banned users and users who
+// blocked the bot.
 func (r *UserRepository) ListTelegramIDs(ctx context.Context) ([]int64, error) {
-	const query = `SELECT telegram_id FROM users`
+	const query = `SELECT telegram_id FROM users WHERE ` + reachableUsers
 	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("list telegram ids: %w", err)
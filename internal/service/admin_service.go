@@ -0,0 +1,300 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/repository"
+)
+
+var ErrAdminUnauthorized = errors.New("invalid admin credentials")
+var ErrAdminInvalid = errors.New("invalid admin account")
+var ErrAdminExists = errors.New("admin username already taken")
+var ErrAdminNotFound = errors.New("admin account not found")
+var ErrAdminLastSuperadmin = errors.New("cannot disable or demote the last enabled superadmin")
+var ErrAdminNoAccounts = errors.New("no admin accounts: set ADMIN_USERNAME and ADMIN_PASSWORD to create the first superadmin")
+
+const (
+	// defaultAdminPassword is the old placeholder from env.example; it is never accepted.
+	defaultAdminPassword = "change-me"
+	minAdminPasswordLen  = 12
+	adminTokenPrefix     = "sbt_"
+	adminSessionTTL      = 12 * time.Hour
+)
+
+// dummyPasswordHash is compared against when the username is unknown, so a failed
+// login takes the same time whether or not the account exists.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+// AdminPrincipal is who performs an admin request: an account or an API token.
+type AdminPrincipal struct {
+	Name string
+	Role models.AdminRole
+}
+
+// AdminUserInput creates or edits an admin account. On edit, empty fields are kept.
+type AdminUserInput struct {
+	Username string
+	Password string
+	Role     models.AdminRole
+	Disabled *bool
+}
+
+// AdminService authenticates admin API callers and keeps the audit log.
+type AdminService struct {
+	admins *repository.AdminRepository
+}
+
+func NewAdminService(admins *repository.AdminRepository) *AdminService {
+	return &AdminService{admins: admins}
+}
+
+// Bootstrap creates the first superadmin from the given credentials when no admin
+// accounts exist yet. Once accounts exist the credentials are ignored.
+func (s *AdminService) Bootstrap(ctx context.Context, username, password string) (bool, error) {
+	count, err := s.admins.CountUsers(ctx)
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+	if strings.TrimSpace(username) == "" || password == "" {
+		return false, ErrAdminNoAccounts
+	}
+	if _, err := s.CreateUser(ctx, AdminUserInput{Username: username, Password: password, Role: models.RoleSuperadmin}); err != nil {
+		return false, fmt.Errorf("create first admin: %w", err)
+	}
+	return true, nil
+}
+
+// Authenticate checks a username and password. Disabled accounts are rejected.
+func (s *AdminService) Authenticate(ctx context.Context, username, password string) (*AdminPrincipal, error) {
+	user, err := s.admins.FindUserByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	hash := dummyPasswordHash
+	if user != nil {
+		hash = []byte(user.PasswordHash)
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || user == nil || user.Disabled {
+		return nil, ErrAdminUnauthorized
+	}
+	return &AdminPrincipal{Name: user.Username, Role: user.Role}, nil
+}
+
+// AuthenticateToken checks a bearer token. Tokens are looked up by their SHA-256, so
+// the secret itself is never compared or stored.
+func (s *AdminService) AuthenticateToken(ctx context.Context, token string) (*AdminPrincipal, error) {
+	if !strings.HasPrefix(token, adminTokenPrefix) {
+		return nil, ErrAdminUnauthorized
+	}
+	t, err := s.admins.FindActiveToken(ctx, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrAdminUnauthorized
+	}
+	if err := s.admins.TouchToken(ctx, t.ID); err != nil {
+		return nil, err
+	}
+	return &AdminPrincipal{Name: "token:" + t.Name, Role: t.Role}, nil
+}
+
+// Login checks the credentials and opens a web UI session. It returns the session
+// cookie value, which is stored only as a hash.
+func (s *AdminService) Login(ctx context.Context, username, password string) (string, *models.AdminSession, error) {
+	principal, err := s.Authenticate(ctx, username, password)
+	if err != nil {
+		return "", nil, err
+	}
+	user, err := s.admins.FindUserByUsername(ctx, principal.Name)
+	if err != nil {
+		return "", nil, err
+	}
+	token, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	session := &models.AdminSession{
+		AdminUserID: user.ID,
+		Username:    user.Username,
+		Role:        user.Role,
+		CSRFToken:   csrf,
+		ExpiresAt:   time.Now().Add(adminSessionTTL),
+	}
+	if err := s.admins.CreateSession(ctx, hashToken(token), session); err != nil {
+		return "", nil, err
+	}
+	return token, session, nil
+}
+
+// Session resolves a session cookie; it returns ErrAdminUnauthorized when the session
+// expired, was closed or its account was disabled.
+func (s *AdminService) Session(ctx context.Context, token string) (*models.AdminSession, error) {
+	if token == "" {
+		return nil, ErrAdminUnauthorized
+	}
+	session, err := s.admins.FindSession(ctx, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, ErrAdminUnauthorized
+	}
+	return session, nil
+}
+
+func (s *AdminService) Logout(ctx context.Context, token string) error {
+	return s.admins.DeleteSession(ctx, hashToken(token))
+}
+
+func (s *AdminService) ListUsers(ctx context.Context) ([]models.AdminUser, error) {
+	return s.admins.ListUsers(ctx)
+}
+
+func (s *AdminService) CreateUser(ctx context.Context, in AdminUserInput) (*models.AdminUser, error) {
+	in.Username = strings.TrimSpace(in.Username)
+	if in.Username == "" || len(in.Username) > 64 {
+		return nil, fmt.Errorf("%w: username required (up to 64 characters)", ErrAdminInvalid)
+	}
+	if !in.Role.Valid() {
+		return nil, fmt.Errorf("%w: role must be viewer, support, finance or superadmin", ErrAdminInvalid)
+	}
+	hash, err := hashPassword(in.Password)
+	if err != nil {
+		return nil, err
+	}
+	user := &models.AdminUser{Username: in.Username, PasswordHash: hash, Role: in.Role}
+	if in.Disabled != nil {
+		user.Disabled = *in.Disabled
+	}
+	if err := s.admins.CreateUser(ctx, user); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			return nil, ErrAdminExists
+		}
+		return nil, err
+	}
+	return s.admins.FindUserByID(ctx, user.ID)
+}
+
+// UpdateUser changes the role, password or disabled flag of an account. At least one
+// enabled superadmin always remains.
+func (s *AdminService) UpdateUser(ctx context.Context, id int64, in AdminUserInput) (*models.AdminUser, error) {
+	user, err := s.admins.FindUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrAdminNotFound
+	}
+	if in.Role != "" {
+		if !in.Role.Valid() {
+			return nil, fmt.Errorf("%w: role must be viewer, support, finance or superadmin", ErrAdminInvalid)
+		}
+		user.Role = in.Role
+	}
+	if in.Password != "" {
+		if user.PasswordHash, err = hashPassword(in.Password); err != nil {
+			return nil, err
+		}
+	}
+	if in.Disabled != nil {
+		user.Disabled = *in.Disabled
+	}
+	if err := s.admins.UpdateUser(ctx, user); err != nil {
+		if errors.Is(err, repository.ErrLastSuperadmin) {
+			return nil, ErrAdminLastSuperadmin
+		}
+		return nil, err
+	}
+	return s.admins.FindUserByID(ctx, id)
+}
+
+// CreateToken issues a bearer token for an integration. The returned secret is not
+// stored and cannot be shown again.
+func (s *AdminService) CreateToken(ctx context.Context, name string, role models.AdminRole, actor string) (*models.AdminToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 64 {
+		return nil, "", fmt.Errorf("%w: token name required (up to 64 characters)", ErrAdminInvalid)
+	}
+	if !role.Valid() {
+		return nil, "", fmt.Errorf("%w: role must be viewer, support, finance or superadmin", ErrAdminInvalid)
+	}
+	raw, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	secret := adminTokenPrefix + raw
+	token := &models.AdminToken{Name: name, TokenHash: hashToken(secret), Role: role, CreatedBy: actor}
+	if err := s.admins.CreateToken(ctx, token); err != nil {
+		return nil, "", err
+	}
+	return token, secret, nil
+}
+
+func (s *AdminService) ListTokens(ctx context.Context) ([]models.AdminToken, error) {
+	return s.admins.ListTokens(ctx)
+}
+
+func (s *AdminService) RevokeToken(ctx context.Context, id int64) error {
+	ok, err := s.admins.RevokeToken(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrAdminNotFound
+	}
+	return nil
+}
+
+func (s *AdminService) RecordAudit(ctx context.Context, entry *models.AuditEntry) error {
+	return s.admins.InsertAudit(ctx, entry)
+}
+
+func (s *AdminService) ListAudit(ctx context.Context, actor string, limit, offset int) ([]models.AuditEntry, error) {
+	return s.admins.ListAudit(ctx, actor, limit, offset)
+}
+
+func hashPassword(password string) (string, error) {
+	if password == defaultAdminPassword {
+		return "", fmt.Errorf("%w: the default password %q is not allowed", ErrAdminInvalid, defaultAdminPassword)
+	}
+	if len(password) < minAdminPasswordLen {
+		return "", fmt.Errorf("%w: password must be at least %d characters", ErrAdminInvalid, minAdminPasswordLen)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrAdminInvalid, err)
+	}
+	return string(hash), nil
+}
+
+func randomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,49 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/digkill/TGStickerBot/internal/database"
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/repository"
+	"github.com/digkill/TGStickerBot/internal/service"
+)
+
+func TestUpdateUserKeepsLastSuperadmin(t *testing.T) {
+	ctx := context.Background()
+	db, _, err := database.Open("sqlite://" + filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.Migrate(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	admins := service.NewAdminService(repository.NewAdminRepository(db))
+	create := func(username string) *models.AdminUser {
+		t.Helper()
+		user, err := admins.CreateUser(ctx, service.AdminUserInput{Username: username, Password: "long-enough-password", Role: models.RoleSuperadmin})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+	root, backup := create("root"), create("backup")
+	disabled := true
+
+	if _, err := admins.UpdateUser(ctx, backup.ID, service.AdminUserInput{Disabled: &disabled}); err != nil {
+		t.Fatalf("disable one of two superadmins: %v", err)
+	}
+	if _, err := admins.UpdateUser(ctx, root.ID, service.AdminUserInput{Role: models.RoleViewer}); !errors.Is(err, service.ErrAdminLastSuperadmin) {
+		t.Errorf("demote last superadmin err = %v, want ErrAdminLastSuperadmin", err)
+	}
+	if _, err := admins.UpdateUser(ctx, root.ID, service.AdminUserInput{Disabled: &disabled}); !errors.Is(err, service.ErrAdminLastSuperadmin) {
+		t.Errorf("disable last superadmin err = %v, want ErrAdminLastSuperadmin", err)
+	}
+	if got, err := admins.UpdateUser(ctx, root.ID, service.AdminUserInput{Password: "another-long-password"}); err != nil || got.Role != models.RoleSuperadmin || got.Disabled {
+		t.Errorf("password change = %+v, %v; want an enabled superadmin", got, err)
+	}
+}
@@ -0,0 +1,595 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/google/uuid"
+
+	"github.com/digkill/TGStickerBot/internal/config"
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/repository"
+)
+
+var ErrBroadcastEmpty = errors.New("broadcast message required")
+var ErrBroadcastInvalid = errors.New("invalid broadcast")
+var ErrBroadcastNoTestChats = errors.New("no test chat ids given or configured")
+var ErrBroadcastNotFound = errors.New("broadcast not found")
+var ErrBroadcastNotEditable = errors.New("broadcast already started or canceled")
+
+// errLeaseLost stops delivery of a broadcast this instance no longer owns.
+var errLeaseLost = errors.New("broadcast canceled or lease lost")
+
+const (
+	broadcastBatchSize    = 100
+	broadcastIdleInterval = 5 * time.Second
+	broadcastMaxAttempts  = 5
+	broadcastLease        = 2 * time.Minute
+	broadcastMaxWait      = broadcastLease / 2
+	broadcastScheduleTick = 15 * time.Second
+	broadcastDueBatch     = 50
+	maxMessageLen         = 4096
+	maxCaptionLen         = 1024
+	maxAudienceIDs        = 10000
+)
+
+// broadcastCallbacks lists bot callbacks a broadcast button may trigger.
+var broadcastCallbacks = map[string]bool{
+	CallbackBuyCredits: true,
+}
+
+// BroadcastTestResult is the outcome of a test send to one chat; Error is empty on success.
+type BroadcastTestResult struct {
+	ChatID int64
+	Error  string
+}
+
+// BroadcastInput describes a broadcast to create or edit. Without SendAt it is queued
+// right away; Recurrence ("daily", "weekly", "monthly") requires SendAt.
+type BroadcastInput struct {
+	Content    models.BroadcastContent
+	Audience   models.AudienceFilter
+	SendAt     *time.Time
+	Recurrence string
+	Actor      string
+}
+
+// BroadcastService persists broadcast jobs and delivers them from a background worker
+// at a fixed rate. Progress lives in the database, so a restart resumes where the
+// previous process stopped. A job is delivered under a lease (locked_by/locked_until),
+// so with several instances running each broadcast has exactly one sender.
+type BroadcastService struct {
+	cfg        config.Config
+	log        *slog.Logger
+	broadcasts *repository.BroadcastRepository
+	users      repository.UserStore
+	owner      string
+	wake       chan struct{}
+}
+
+func NewBroadcastService(cfg config.Config, log *slog.Logger, broadcasts *repository.BroadcastRepository, users repository.UserStore) *BroadcastService {
+	return &BroadcastService{
+		cfg:        cfg,
+		log:        log,
+		broadcasts: broadcasts,
+		users:      users,
+		owner:      uuid.NewString(),
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Create queues (or schedules) a broadcast to the users matching the audience and
+// returns its initial progress.
+func (s *BroadcastService) Create(ctx context.Context, in BroadcastInput) (*models.BroadcastProgress, error) {
+	if err := validateBroadcastInput(&in); err != nil {
+		return nil, err
+	}
+	b := &models.Broadcast{
+		BroadcastContent: in.Content,
+		Audience:         in.Audience,
+		SendAt:           in.SendAt,
+		Recurrence:       in.Recurrence,
+		Status:           models.BroadcastPending,
+		CreatedBy:        in.Actor,
+	}
+	if b.SendAt != nil {
+		b.Status = models.BroadcastScheduled
+	}
+	if err := s.broadcasts.Create(ctx, b); err != nil {
+		return nil, err
+	}
+	if b.Status == models.BroadcastPending {
+		s.notify()
+	}
+	return s.broadcasts.Progress(ctx, b.ID)
+}
+
+// Update replaces a scheduled broadcast. Once it has been queued for delivery it can
+// only be canceled.
+func (s *BroadcastService) Update(ctx context.Context, id int64, in BroadcastInput) (*models.BroadcastProgress, error) {
+	if err := validateBroadcastInput(&in); err != nil {
+		return nil, err
+	}
+	if in.SendAt == nil {
+		return nil, fmt.Errorf("%w: send_at required", ErrBroadcastInvalid)
+	}
+	b := &models.Broadcast{
+		ID:               id,
+		BroadcastContent: in.Content,
+		Audience:         in.Audience,
+		SendAt:           in.SendAt,
+		Recurrence:       in.Recurrence,
+	}
+	ok, err := s.broadcasts.UpdateScheduled(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, s.notChanged(ctx, id)
+	}
+	s.log.Info("broadcast updated", "broadcast_id", id, "actor", in.Actor)
+	return s.broadcasts.Progress(ctx, id)
+}
+
+// Cancel stops a scheduled, queued or running broadcast. Recipients already messaged
+// stay sent; a recurring broadcast stops spawning occurrences.
+func (s *BroadcastService) Cancel(ctx context.Context, id int64, actor string) (*models.BroadcastProgress, error) {
+	ok, err := s.broadcasts.Cancel(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, s.notChanged(ctx, id)
+	}
+	s.log.Info("broadcast canceled", "broadcast_id", id, "actor", actor)
+	return s.broadcasts.Progress(ctx, id)
+}
+
+func (s *BroadcastService) notChanged(ctx context.Context, id int64) error {
+	b, err := s.broadcasts.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		return ErrBroadcastNotFound
+	}
+	return ErrBroadcastNotEditable
+}
+
+// List returns broadcasts newest first; an empty status lists all of them.
+func (s *BroadcastService) List(ctx context.Context, status models.BroadcastStatus, limit, offset int) ([]models.Broadcast, error) {
+	switch status {
+	case "", models.BroadcastScheduled, models.BroadcastPending, models.BroadcastRunning, models.BroadcastDone, models.BroadcastCanceled:
+	default:
+		return nil, fmt.Errorf("%w: unknown status %q", ErrBroadcastInvalid, status)
+	}
+	return s.broadcasts.List(ctx, status, limit, offset)
+}
+
+// AudienceSize is the dry run of Create: how many users the filter currently matches.
+func (s *BroadcastService) AudienceSize(ctx context.Context, audience models.AudienceFilter) (int, error) {
+	if err := validateAudience(audience); err != nil {
+		return 0, err
+	}
+	return s.broadcasts.CountAudience(ctx, audience)
+}
+
+// TestSend delivers content right away to the given chats, or to BROADCAST_TEST_CHAT_IDS
+// when none are given, so admins can check the rendering before the real broadcast.
+func (s *BroadcastService) TestSend(ctx context.Context, bot *tgbotapi.BotAPI, content models.BroadcastContent, chatIDs []int64) ([]BroadcastTestResult, error) {
+	if err := validateBroadcast(&content); err != nil {
+		return nil, err
+	}
+	if len(chatIDs) == 0 {
+		chatIDs = s.cfg.BroadcastTestChatIDs
+	}
+	if len(chatIDs) == 0 {
+		return nil, ErrBroadcastNoTestChats
+	}
+	results := make([]BroadcastTestResult, 0, len(chatIDs))
+	for _, chatID := range chatIDs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		result := BroadcastTestResult{ChatID: chatID}
+		if _, err := bot.Send(broadcastMessage(&content, chatID)); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Progress returns nil when the broadcast does not exist.
+func (s *BroadcastService) Progress(ctx context.Context, id int64) (*models.BroadcastProgress, error) {
+	return s.broadcasts.Progress(ctx, id)
+}
+
+// RunScheduler queues scheduled broadcasts once their send_at passes, until ctx is
+// cancelled. Activation is a conditional update, so when several instances run the
+// scheduler each occurrence is queued once.
+func (s *BroadcastService) RunScheduler(ctx context.Context) error {
+	ticker := time.NewTicker(broadcastScheduleTick)
+	defer ticker.Stop()
+
+	for {
+		if err := s.activateDue(ctx); err != nil && ctx.Err() == nil {
+			s.log.Error("broadcast: activate scheduled", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *BroadcastService) activateDue(ctx context.Context) error {
+	now := time.Now()
+	due, err := s.broadcasts.DueScheduled(ctx, now, broadcastDueBatch)
+	if err != nil {
+		return err
+	}
+	for i := range due {
+		b := &due[i]
+		next := nextOccurrence(b.Recurrence, *b.SendAt, now)
+		job, err := s.broadcasts.Activate(ctx, b, next)
+		if err != nil {
+			return err
+		}
+		if job == nil {
+			continue
+		}
+		s.log.Info("scheduled broadcast queued", "broadcast_id", job.ID, "schedule_id", b.ID, "next", next)
+		s.notify()
+	}
+	return nil
+}
+
+// nextOccurrence returns the first occurrence of a recurring broadcast after now, or
+// nil for a one-off. Occurrences missed while no instance was running are skipped
+// rather than sent in a burst.
+func nextOccurrence(recurrence string, from, now time.Time) *time.Time {
+	step := func(t time.Time) time.Time {
+		switch recurrence {
+		case models.RecurrenceDaily:
+			return t.AddDate(0, 0, 1)
+		case models.RecurrenceWeekly:
+			return t.AddDate(0, 0, 7)
+		default:
+			return t.AddDate(0, 1, 0)
+		}
+	}
+	if recurrence == models.RecurrenceNone {
+		return nil
+	}
+	next := step(from)
+	for !next.After(now) {
+		next = step(next)
+	}
+	return &next
+}
+
+func (s *BroadcastService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run processes queued broadcasts one at a time until ctx is cancelled.
+func (s *BroadcastService) Run(ctx context.Context, bot *tgbotapi.BotAPI) error {
+	rate := max(s.cfg.BroadcastRatePerSecond, 1)
+	limiter := time.NewTicker(time.Second / time.Duration(rate))
+	defer limiter.Stop()
+
+	for {
+		b, err := s.broadcasts.NextActive(ctx)
+		if err != nil {
+			s.log.Error("broadcast: next job", "err", err)
+		} else if b != nil {
+			err = s.process(ctx, bot, b, limiter.C)
+			if err == nil {
+				continue
+			}
+			if ctx.Err() == nil {
+				s.log.Error("broadcast: process", "broadcast_id", b.ID, "err", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.wake:
+		case <-time.After(broadcastIdleInterval):
+		}
+	}
+}
+
+// process delivers b while holding its lease. It stops quietly when another instance
+// owns the lease or the broadcast gets canceled.
+func (s *BroadcastService) process(ctx context.Context, bot *tgbotapi.BotAPI, b *models.Broadcast, tick <-chan time.Time) error {
+	ok, err := s.broadcasts.Claim(ctx, b.ID, s.owner, broadcastLease)
+	if err != nil || !ok {
+		return err
+	}
+	if b.Status == models.BroadcastPending {
+		s.log.Info("broadcast started", "broadcast_id", b.ID)
+	}
+	for {
+		ids, err := s.broadcasts.PendingRecipients(ctx, b.ID, broadcastBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			s.log.Info("broadcast finished", "broadcast_id", b.ID)
+			return s.broadcasts.MarkDone(ctx, b.ID, s.owner)
+		}
+		for _, chatID := range ids {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-tick:
+			}
+			// Renewing before every send also picks up a cancel within one tick.
+			if err := s.renew(ctx, b.ID); err != nil {
+				return s.stopped(b, err)
+			}
+			status, attempts, sendErr := s.deliver(ctx, bot, b, chatID)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if status == models.RecipientPending {
+				// The lease could not be renewed before a retry; whoever holds it now
+				// delivers this recipient.
+				return s.stopped(b, sendErr)
+			}
+			errMsg := ""
+			if sendErr != nil {
+				errMsg = sendErr.Error()
+			}
+			if err := s.broadcasts.SetRecipientStatus(ctx, b.ID, chatID, status, attempts, errMsg); err != nil {
+				return err
+			}
+			if status == models.RecipientBlocked {
+				if _, err := s.users.SetBlocked(ctx, chatID, true); err != nil {
+					s.log.Error("broadcast: mark user blocked", "telegram_id", chatID, "err", err)
+				}
+			}
+		}
+	}
+}
+
+// renew extends this instance's lease on a broadcast. It returns errLeaseLost when the
+// broadcast was canceled or another instance took it over.
+func (s *BroadcastService) renew(ctx context.Context, id int64) error {
+	ok, err := s.broadcasts.Claim(ctx, id, s.owner, broadcastLease)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errLeaseLost
+	}
+	return nil
+}
+
+// stopped ends processing of b after a failed renewal; losing the lease is not an error.
+func (s *BroadcastService) stopped(b *models.Broadcast, err error) error {
+	if errors.Is(err, errLeaseLost) {
+		s.log.Info("broadcast stopped: canceled or lease lost", "broadcast_id", b.ID)
+		return nil
+	}
+	return err
+}
+
+// deliver sends one message, waiting out Telegram's retry_after on 429 responses. A wait
+// longer than broadcastMaxWait fails the recipient. It returns the recipient's final
+// state and the number of attempts made, or RecipientPending when delivery must stop.
+func (s *BroadcastService) deliver(ctx context.Context, bot *tgbotapi.BotAPI, b *models.Broadcast, chatID int64) (string, int, error) {
+	msg := broadcastMessage(&b.BroadcastContent, chatID)
+	for attempt := 1; ; attempt++ {
+		sent, err := bot.Send(msg)
+		if err == nil {
+			s.rememberFileID(ctx, b, sent)
+			return models.RecipientSent, attempt, nil
+		}
+		if IsBotBlocked(err) {
+			return models.RecipientBlocked, attempt, err
+		}
+		var apiErr *tgbotapi.Error
+		if !errors.As(err, &apiErr) {
+			return models.RecipientFailed, attempt, err
+		}
+		switch {
+		case apiErr.RetryAfter > 0 && attempt < broadcastMaxAttempts:
+			wait := time.Duration(apiErr.RetryAfter) * time.Second
+			if wait > broadcastMaxWait {
+				return models.RecipientFailed, attempt, fmt.Errorf("telegram %d: retry after %s is longer than the lease allows: %w", apiErr.Code, wait, err)
+			}
+			// Waiting on a fresh lease keeps it valid until the retry goes out.
+			if err := s.renew(ctx, b.ID); err != nil {
+				return models.RecipientPending, attempt, err
+			}
+			s.log.Warn("broadcast: flood limit", "broadcast_id", b.ID, "retry_after", wait)
+			select {
+			case <-ctx.Done():
+				return models.RecipientPending, attempt, ctx.Err()
+			case <-time.After(wait):
+			}
+		default:
+			return models.RecipientFailed, attempt, fmt.Errorf("telegram %d: %w", apiErr.Code, err)
+		}
+	}
+}
+
+// IsBotBlocked reports whether a Bot API error means the user can no longer be messaged:
+// they blocked the bot or deleted their account (403 Forbidden).
+func IsBotBlocked(err error) bool {
+	var apiErr *tgbotapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden
+}
+
+// rememberFileID switches the rest of the broadcast to Telegram's file_id after the
+// first successful media send.
+func (s *BroadcastService) rememberFileID(ctx context.Context, b *models.Broadcast, sent tgbotapi.Message) {
+	if b.MediaType == "" || b.MediaFileID != "" {
+		return
+	}
+	var fileID string
+	switch {
+	case len(sent.Photo) > 0:
+		fileID = sent.Photo[len(sent.Photo)-1].FileID
+	case sent.Document != nil:
+		fileID = sent.Document.FileID
+	}
+	if fileID == "" {
+		return
+	}
+	b.MediaFileID = fileID
+	if err := s.broadcasts.SetMediaFileID(ctx, b.ID, fileID); err != nil {
+		s.log.Error("broadcast: save file id", "broadcast_id", b.ID, "err", err)
+	}
+}
+
+func validateBroadcastInput(in *BroadcastInput) error {
+	if err := validateBroadcast(&in.Content); err != nil {
+		return err
+	}
+	if err := validateAudience(in.Audience); err != nil {
+		return err
+	}
+	switch in.Recurrence {
+	case models.RecurrenceNone, models.RecurrenceDaily, models.RecurrenceWeekly, models.RecurrenceMonthly:
+	default:
+		return fmt.Errorf("%w: recurrence must be daily, weekly or monthly", ErrBroadcastInvalid)
+	}
+	if in.Recurrence != models.RecurrenceNone && in.SendAt == nil {
+		return fmt.Errorf("%w: recurrence requires send_at", ErrBroadcastInvalid)
+	}
+	if in.SendAt != nil && !in.SendAt.After(time.Now()) {
+		return fmt.Errorf("%w: send_at must be in the future", ErrBroadcastInvalid)
+	}
+	return nil
+}
+
+func validateAudience(f models.AudienceFilter) error {
+	if len(f.TelegramIDs) > maxAudienceIDs {
+		return fmt.Errorf("%w: at most %d telegram_ids", ErrBroadcastInvalid, maxAudienceIDs)
+	}
+	if f.SignupFrom != nil && f.SignupTo != nil && !f.SignupTo.After(*f.SignupFrom) {
+		return fmt.Errorf("%w: signup_to must be after signup_from", ErrBroadcastInvalid)
+	}
+	ranges := []struct {
+		name     string
+		min, max *int
+	}{
+		{"promo_credits", f.MinPromoCredits, f.MaxPromoCredits},
+		{"paid_credits", f.MinPaidCredits, f.MaxPaidCredits},
+	}
+	for _, r := range ranges {
+		if r.min != nil && r.max != nil && *r.min > *r.max {
+			return fmt.Errorf("%w: min_%s greater than max_%s", ErrBroadcastInvalid, r.name, r.name)
+		}
+	}
+	return nil
+}
+
+func validateBroadcast(c *models.BroadcastContent) error {
+	c.Message = strings.TrimSpace(c.Message)
+	c.MediaURL = strings.TrimSpace(c.MediaURL)
+	if c.Message == "" && c.MediaURL == "" {
+		return ErrBroadcastEmpty
+	}
+	switch c.ParseMode {
+	case "", tgbotapi.ModeHTML, tgbotapi.ModeMarkdownV2:
+	default:
+		return fmt.Errorf("%w: parse_mode must be HTML or MarkdownV2", ErrBroadcastInvalid)
+	}
+	switch c.MediaType {
+	case "":
+		if c.MediaURL != "" {
+			return fmt.Errorf("%w: media_type required with media_url", ErrBroadcastInvalid)
+		}
+		if len([]rune(c.Message)) > maxMessageLen {
+			return fmt.Errorf("%w: message longer than %d characters", ErrBroadcastInvalid, maxMessageLen)
+		}
+	case "photo", "document":
+		if c.MediaURL == "" {
+			return fmt.Errorf("%w: media_url required for %s", ErrBroadcastInvalid, c.MediaType)
+		}
+		if len([]rune(c.Message)) > maxCaptionLen {
+			return fmt.Errorf("%w: caption longer than %d characters", ErrBroadcastInvalid, maxCaptionLen)
+		}
+	default:
+		return fmt.Errorf("%w: media_type must be photo or document", ErrBroadcastInvalid)
+	}
+	for _, row := range c.Buttons {
+		for _, btn := range row {
+			if strings.TrimSpace(btn.Text) == "" {
+				return fmt.Errorf("%w: button text required", ErrBroadcastInvalid)
+			}
+			switch {
+			case btn.URL != "" && btn.Callback != "":
+				return fmt.Errorf("%w: button %q has both url and callback", ErrBroadcastInvalid, btn.Text)
+			case btn.URL != "":
+				if !strings.HasPrefix(btn.URL, "https://") && !strings.HasPrefix(btn.URL, "http://") && !strings.HasPrefix(btn.URL, "tg://") {
+					return fmt.Errorf("%w: button %q url must be http(s) or tg", ErrBroadcastInvalid, btn.Text)
+				}
+			case !broadcastCallbacks[btn.Callback]:
+				return fmt.Errorf("%w: button %q callback %q not allowed", ErrBroadcastInvalid, btn.Text, btn.Callback)
+			}
+		}
+	}
+	return nil
+}
+
+func broadcastMessage(c *models.BroadcastContent, chatID int64) tgbotapi.Chattable {
+	var markup any
+	if len(c.Buttons) > 0 {
+		rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(c.Buttons))
+		for _, row := range c.Buttons {
+			buttons := make([]tgbotapi.InlineKeyboardButton, 0, len(row))
+			for _, btn := range row {
+				if btn.URL != "" {
+					buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonURL(btn.Text, btn.URL))
+				} else {
+					buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(btn.Text, btn.Callback))
+				}
+			}
+			rows = append(rows, buttons)
+		}
+		markup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+
+	var file tgbotapi.RequestFileData = tgbotapi.FileURL(c.MediaURL)
+	if c.MediaFileID != "" {
+		file = tgbotapi.FileID(c.MediaFileID)
+	}
+	switch c.MediaType {
+	case "photo":
+		msg := tgbotapi.NewPhoto(chatID, file)
+		msg.Caption = c.Message
+		msg.ParseMode = c.ParseMode
+		msg.DisableNotification = c.Silent
+		msg.ReplyMarkup = markup
+		return msg
+	case "document":
+		msg := tgbotapi.NewDocument(chatID, file)
+		msg.Caption = c.Message
+		msg.ParseMode = c.ParseMode
+		msg.DisableNotification = c.Silent
+		msg.ReplyMarkup = markup
+		return msg
+	default:
+		msg := tgbotapi.NewMessage(chatID, c.Message)
+		msg.ParseMode = c.ParseMode
+		msg.DisableNotification = c.Silent
+		msg.ReplyMarkup = markup
+		return msg
+	}
+}
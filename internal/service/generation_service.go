@@ -9,20 +9,28 @@ import (
 
 	"github.com/digkill/TGStickerBot/internal/config"
 	"github.com/digkill/TGStickerBot/internal/kie"
+	"github.com/digkill/TGStickerBot/internal/metrics"
 	"github.com/digkill/TGStickerBot/internal/models"
 	"github.com/digkill/TGStickerBot/internal/repository"
 )
 
 var ErrCreditsRequired = errors.New("insufficient credits, payment required")
+var ErrGenerationBanned = errors.New("generation is not allowed for this user")
 
 const creditsPerGeneration = 5
 
+// ImageGenerator is the part of the KIE client GenerationService uses.
+type ImageGenerator interface {
+	GenerateFlux2(ctx context.Context, opts kie.GenerateOptions) (*kie.Image, error)
+	GenerateNanoBanana(ctx context.Context, opts kie.GenerateOptions) (*kie.Image, error)
+}
+
 type GenerationService struct {
 	cfg         config.Config
 	log         *slog.Logger
-	users       *repository.UserRepository
-	generations *repository.GenerationRepository
-	kie         *kie.Client
+	users       repository.UserStore
+	generations repository.GenerationStore
+	images      ImageGenerator
 }
 
 type GenerationRequest struct {
@@ -41,17 +49,42 @@ type GenerationResult struct {
 	Model  models.ModelType
 }
 
-func NewGenerationService(cfg config.Config, log *slog.Logger, users *repository.UserRepository, generations *repository.GenerationRepository, client *kie.Client) *GenerationService {
+func NewGenerationService(cfg config.Config, log *slog.Logger, users repository.UserStore, generations repository.GenerationStore, images ImageGenerator) *GenerationService {
 	return &GenerationService{
 		cfg:         cfg,
 		log:         log,
 		users:       users,
 		generations: generations,
-		kie:         client,
+		images:      images,
 	}
 }
 
 func (s *GenerationService) Generate(ctx context.Context, user *models.User, req GenerationRequest) (*GenerationResult, error) {
+	start := time.Now()
+	result, err := s.generate(ctx, user, req)
+
+	outcome, cost := "success", "none"
+	switch {
+	case err == nil:
+		cost = string(result.Cost)
+	case errors.Is(err, ErrCreditsRequired):
+		outcome = "no_credits"
+	case errors.Is(err, ErrGenerationBanned):
+		outcome = "banned"
+	default:
+		outcome = "error"
+	}
+	metrics.Generations.WithLabelValues(string(req.Model), outcome, cost).Inc()
+	if outcome == "success" || outcome == "error" {
+		metrics.GenerationDuration.WithLabelValues(string(req.Model), outcome).Observe(time.Since(start).Seconds())
+	}
+	return result, err
+}
+
+func (s *GenerationService) generate(ctx context.Context, user *models.User, req GenerationRequest) (*GenerationResult, error) {
+	if user.ActiveBan(time.Now()) != models.BanNone {
+		return nil, ErrGenerationBanned
+	}
 	if req.Prompt == "" {
 		return nil, fmt.Errorf("prompt cannot be empty")
 	}
@@ -92,9 +125,9 @@ func (s *GenerationService) Generate(ctx context.Context, user *models.User, req
 	var err error
 	switch req.Model {
 	case models.ModelFlux2:
-		image, err = s.kie.GenerateFlux2(ctx, opts)
+		image, err = s.images.GenerateFlux2(ctx, opts)
 	case models.ModelNanoBanana:
-		image, err = s.kie.GenerateNanoBanana(ctx, opts)
+		image, err = s.images.GenerateNanoBanana(ctx, opts)
 	default:
 		return nil, fmt.Errorf("unsupported model: %s", req.Model)
 	}
@@ -156,3 +189,8 @@ func (s *GenerationService) Generate(ctx context.Context, user *models.User, req
 func (s *GenerationService) DailyCount(ctx context.Context, userID int64) (int, error) {
 	return s.generations.CountForDay(ctx, userID, time.Now().UTC())
 }
+
+// List returns generation logs newest first; an empty model lists all of them.
+func (s *GenerationService) List(ctx context.Context, model models.ModelType, limit, offset int) ([]models.GenerationLog, error) {
+	return s.generations.List(ctx, model, limit, offset)
+}
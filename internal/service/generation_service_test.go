@@ -0,0 +1,194 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/digkill/TGStickerBot/internal/config"
+	"github.com/digkill/TGStickerBot/internal/kie"
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/repository/memory"
+	"github.com/digkill/TGStickerBot/internal/service"
+)
+
+// fakeGenerator records requests and returns a fixed image or error.
+type fakeGenerator struct {
+	calls []models.ModelType
+	opts  []kie.GenerateOptions
+	err   error
+}
+
+func (g *fakeGenerator) generate(model models.ModelType, opts kie.GenerateOptions) (*kie.Image, error) {
+	g.calls = append(g.calls, model)
+	g.opts = append(g.opts, opts)
+	if g.err != nil {
+		return nil, g.err
+	}
+	return &kie.Image{URL: "https://example.com/image.png"}, nil
+}
+
+func (g *fakeGenerator) GenerateFlux2(ctx context.Context, opts kie.GenerateOptions) (*kie.Image, error) {
+	return g.generate(models.ModelFlux2, opts)
+}
+
+func (g *fakeGenerator) GenerateNanoBanana(ctx context.Context, opts kie.GenerateOptions) (*kie.Image, error) {
+	return g.generate(models.ModelNanoBanana, opts)
+}
+
+func newGenerationService(db *memory.DB, gen *fakeGenerator) *service.GenerationService {
+	return service.NewGenerationService(config.Config{}, discardLogger(), db.Users(), db.Generations(), gen)
+}
+
+func TestGenerateSpendsPromoCreditsFirst(t *testing.T) {
+	ctx := context.Background()
+	db := memory.New()
+	gen := &fakeGenerator{}
+	user := seedUser(t, db, 100, 10, 10)
+
+	result, err := newGenerationService(db, gen).Generate(ctx, user, service.GenerationRequest{Model: models.ModelFlux2, Prompt: "cat"})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if result.Cost != models.CostTypePromo {
+		t.Errorf("cost = %q, want promo", result.Cost)
+	}
+	if promo, paid := balance(t, db, user.ID); promo != 5 || paid != 10 {
+		t.Errorf("balance = %d promo, %d paid; want 5, 10", promo, paid)
+	}
+	if user.PromoCredits != 5 {
+		t.Errorf("user.PromoCredits = %d, want 5", user.PromoCredits)
+	}
+
+	logs, _ := db.Generations().ListByUser(ctx, user.ID, 10)
+	if len(logs) != 1 || logs[0].Prompt != "cat" || logs[0].CostType != models.CostTypePromo {
+		t.Errorf("generation logs = %+v", logs)
+	}
+	if len(gen.opts) != 1 || gen.opts[0].AspectRatio != "1:1" || gen.opts[0].Resolution != "1K" {
+		t.Errorf("generator options = %+v, want defaults 1:1 and 1K", gen.opts)
+	}
+}
+
+func TestGenerateFallsBackToPaidCredits(t *testing.T) {
+	db := memory.New()
+	gen := &fakeGenerator{}
+	user := seedUser(t, db, 100, 4, 5)
+
+	result, err := newGenerationService(db, gen).Generate(context.Background(), user, service.GenerationRequest{Model: models.ModelNanoBanana, Prompt: "dog", AspectRatio: "16:9"})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if result.Cost != models.CostTypePaid {
+		t.Errorf("cost = %q, want paid", result.Cost)
+	}
+	if promo, paid := balance(t, db, user.ID); promo != 4 || paid != 0 {
+		t.Errorf("balance = %d promo, %d paid; want 4, 0", promo, paid)
+	}
+	if len(gen.calls) != 1 || gen.calls[0] != models.ModelNanoBanana || gen.opts[0].AspectRatio != "16:9" {
+		t.Errorf("generator calls = %v %+v", gen.calls, gen.opts)
+	}
+}
+
+func TestGenerateRequiresCredits(t *testing.T) {
+	db := memory.New()
+	gen := &fakeGenerator{}
+	user := seedUser(t, db, 100, 4, 4)
+
+	_, err := newGenerationService(db, gen).Generate(context.Background(), user, service.GenerationRequest{Model: models.ModelFlux2, Prompt: "cat"})
+	if !errors.Is(err, service.ErrCreditsRequired) {
+		t.Fatalf("err = %v, want ErrCreditsRequired", err)
+	}
+	if len(gen.calls) != 0 {
+		t.Errorf("generator called %d times without credits", len(gen.calls))
+	}
+}
+
+// A stale user snapshot must not let a generation through once the stored balance is gone.
+func TestGenerateChecksStoredBalance(t *testing.T) {
+	ctx := context.Background()
+	db := memory.New()
+	user := seedUser(t, db, 100, 5, 0)
+	if err := db.Users().UpdatePromoCredits(ctx, user.ID, -5); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := newGenerationService(db, &fakeGenerator{}).Generate(ctx, user, service.GenerationRequest{Model: models.ModelFlux2, Prompt: "cat"})
+	if !errors.Is(err, service.ErrCreditsRequired) {
+		t.Fatalf("err = %v, want ErrCreditsRequired", err)
+	}
+	if logs, _ := db.Generations().ListByUser(ctx, user.ID, 10); len(logs) != 0 {
+		t.Errorf("logged %d generations, want none", len(logs))
+	}
+}
+
+func TestGenerateKeepsCreditsOnFailure(t *testing.T) {
+	ctx := context.Background()
+	db := memory.New()
+	gen := &fakeGenerator{err: errors.New("kie unavailable")}
+	user := seedUser(t, db, 100, 10, 0)
+
+	if _, err := newGenerationService(db, gen).Generate(ctx, user, service.GenerationRequest{Model: models.ModelFlux2, Prompt: "cat"}); err == nil {
+		t.Fatal("Generate succeeded, want error")
+	}
+	if promo, _ := balance(t, db, user.ID); promo != 10 {
+		t.Errorf("promo credits = %d, want 10", promo)
+	}
+	if logs, _ := db.Generations().ListByUser(ctx, user.ID, 10); len(logs) != 0 {
+		t.Errorf("logged %d generations, want none", len(logs))
+	}
+}
+
+func TestGenerateRejectsBannedUser(t *testing.T) {
+	db := memory.New()
+	gen := &fakeGenerator{}
+	user := seedUser(t, db, 100, 10, 0)
+	user.BanStatus = models.BanGeneration
+
+	_, err := newGenerationService(db, gen).Generate(context.Background(), user, service.GenerationRequest{Model: models.ModelFlux2, Prompt: "cat"})
+	if !errors.Is(err, service.ErrGenerationBanned) {
+		t.Fatalf("err = %v, want ErrGenerationBanned", err)
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	user.BannedUntil = &expired
+	if _, err := newGenerationService(db, gen).Generate(context.Background(), user, service.GenerationRequest{Model: models.ModelFlux2, Prompt: "cat"}); err != nil {
+		t.Fatalf("Generate after ban expired: %v", err)
+	}
+}
+
+func TestGenerateValidatesRequest(t *testing.T) {
+	db := memory.New()
+	gen := &fakeGenerator{}
+	user := seedUser(t, db, 100, 10, 0)
+	svc := newGenerationService(db, gen)
+
+	if _, err := svc.Generate(context.Background(), user, service.GenerationRequest{Model: models.ModelFlux2}); err == nil {
+		t.Error("empty prompt accepted")
+	}
+	if _, err := svc.Generate(context.Background(), user, service.GenerationRequest{Model: "dall-e", Prompt: "cat"}); err == nil {
+		t.Error("unknown model accepted")
+	}
+	if len(gen.calls) != 0 {
+		t.Errorf("generator called %d times for invalid requests", len(gen.calls))
+	}
+	if promo, _ := balance(t, db, user.ID); promo != 10 {
+		t.Errorf("promo credits = %d, want 10", promo)
+	}
+}
+
+func TestDailyCount(t *testing.T) {
+	ctx := context.Background()
+	db := memory.New()
+	user := seedUser(t, db, 100, 20, 0)
+	svc := newGenerationService(db, &fakeGenerator{})
+
+	for range 2 {
+		if _, err := svc.Generate(ctx, user, service.GenerationRequest{Model: models.ModelFlux2, Prompt: "cat"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := svc.DailyCount(ctx, user.ID); err != nil || n != 2 {
+		t.Errorf("DailyCount = %d, %v; want 2", n, err)
+	}
+}
@@ -0,0 +1,39 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/repository/memory"
+)
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// seedUser creates a user with the given balances and returns the stored row.
+func seedUser(t *testing.T, db *memory.DB, telegramID int64, promoCredits, paidCredits int) *models.User {
+	t.Helper()
+	user, err := db.Users().Create(context.Background(), &models.User{
+		TelegramID:   telegramID,
+		PromoCredits: promoCredits,
+		PaidCredits:  paidCredits,
+	})
+	if err != nil {
+		t.Fatalf("seed user: %v", err)
+	}
+	return user
+}
+
+// balance reloads the user's credits from the store.
+func balance(t *testing.T, db *memory.DB, userID int64) (promo, paid int) {
+	t.Helper()
+	user, err := db.Users().FindByID(context.Background(), userID)
+	if err != nil || user == nil {
+		t.Fatalf("find user %d: %v", userID, err)
+	}
+	return user.PromoCredits, user.PaidCredits
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -11,32 +12,60 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
 	"github.com/digkill/TGStickerBot/internal/config"
+	"github.com/digkill/TGStickerBot/internal/metrics"
 	"github.com/digkill/TGStickerBot/internal/models"
 	"github.com/digkill/TGStickerBot/internal/repository"
 )
 
+// CallbackBuyCredits is the inline button payload that asks the bot to resend an invoice.
+const CallbackBuyCredits = "buy"
+
 type PaymentService struct {
-	cfg      config.Config
-	payments *repository.PaymentRepository
-	users    *repository.UserRepository
-	plans    *PlanService
-	client   *http.Client
+	cfg       config.Config
+	log       *slog.Logger
+	payments  repository.PaymentStore
+	users     repository.UserStore
+	plans     *PlanService
+	promos    *PromoService
+	referrals *ReferralService
+	client    *http.Client
 }
 
-func NewPaymentService(cfg config.Config, payments *repository.PaymentRepository, users *repository.UserRepository, plans *PlanService) *PaymentService {
+func NewPaymentService(cfg config.Config, log *slog.Logger, payments repository.PaymentStore, users repository.UserStore, plans *PlanService, promos *PromoService, referrals *ReferralService) *PaymentService {
 	return &PaymentService{
-		cfg:      cfg,
-		payments: payments,
-		users:    users,
-		plans:    plans,
+		cfg:       cfg,
+		log:       log,
+		payments:  payments,
+		users:     users,
+		plans:     plans,
+		promos:    promos,
+		referrals: referrals,
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
 	}
 }
 
-// SendInvoice sends payment link/invoice depending on configured provider.
-func (s *PaymentService) SendInvoice(ctx context.Context, bot *tgbotapi.BotAPI, user *models.User, chatID int64) error {
+// List returns payments newest first; an empty status lists all of them.
+func (s *PaymentService) List(ctx context.Context, status string, limit, offset int) ([]models.Payment, error) {
+	return s.payments.List(ctx, status, limit, offset)
+}
+
+// invoicePayload is embedded into Telegram invoices and read back on successful payment.
+type invoicePayload struct {
+	PlanID      int64 `json:"plan_id"`
+	PromoCodeID int64 `json:"promo_code_id,omitempty"`
+	Discount    int   `json:"discount,omitempty"`
+}
+
+// SendInvoice sends payment link/invoice depending on configured provider. A non-empty
+// promoCode must be a discount code; it lowers the price. A Telegram invoice redeems it
+// only once the payment succeeds, a YooKassa link reserves it until the payment is
+// canceled.
+func (s *PaymentService) SendInvoice(ctx context.Context, bot *tgbotapi.BotAPI, user *models.User, chatID int64, promoCode string) error {
+	if user.ActiveBan(time.Now()) != models.BanNone {
+		return ErrUserBanned
+	}
 	plan, err := s.plans.GetDefault(ctx)
 	if err != nil {
 		return fmt.Errorf("get default plan: %w", err)
@@ -45,17 +74,25 @@ func (s *PaymentService) SendInvoice(ctx context.Context, bot *tgbotapi.BotAPI,
 		return fmt.Errorf("no active plan configured")
 	}
 
+	var discount *Discount
+	if promoCode != "" {
+		discount, err = s.promos.ResolveDiscount(ctx, user, promoCode, plan)
+		if err != nil {
+			return err
+		}
+	}
+
 	switch strings.ToLower(s.cfg.PaymentProvider) {
 	case "telegram", "":
-		return s.sendTelegramInvoice(plan, bot, chatID)
+		return s.sendTelegramInvoice(plan, discount, bot, chatID)
 	case "yookassa":
-		return s.sendYooKassaPayment(ctx, plan, bot, user, chatID)
+		return s.sendYooKassaPayment(ctx, plan, discount, bot, user, chatID)
 	default:
 		return fmt.Errorf("unsupported payment provider: %s", s.cfg.PaymentProvider)
 	}
 }
 
-func (s *PaymentService) sendTelegramInvoice(plan *models.Plan, bot *tgbotapi.BotAPI, chatID int64) error {
+func (s *PaymentService) sendTelegramInvoice(plan *models.Plan, discount *Discount, bot *tgbotapi.BotAPI, chatID int64) error {
 	prices := []tgbotapi.LabeledPrice{
 		{
 			Label:  fmt.Sprintf("%d кредитов", plan.Credits),
@@ -63,9 +100,16 @@ func (s *PaymentService) sendTelegramInvoice(plan *models.Plan, bot *tgbotapi.Bo
 		},
 	}
 
-	payload, _ := json.Marshal(map[string]any{
-		"plan_id": plan.ID,
-	})
+	payload := invoicePayload{PlanID: plan.ID}
+	if discount != nil {
+		prices = append(prices, tgbotapi.LabeledPrice{
+			Label:  fmt.Sprintf("Скидка по промокоду %s", discount.Code),
+			Amount: -discount.Amount,
+		})
+		payload.PromoCodeID = discount.PromoCodeID
+		payload.Discount = discount.Amount
+	}
+	rawPayload, _ := json.Marshal(payload)
 
 	description := plan.Description
 	if description == "" {
@@ -75,7 +119,7 @@ func (s *PaymentService) sendTelegramInvoice(plan *models.Plan, bot *tgbotapi.Bo
 	invoice := tgbotapi.NewInvoice(chatID,
 		plan.Title,
 		description,
-		string(payload),
+		string(rawPayload),
 		s.cfg.TelegramPaymentProviderToken,
 		"topup",
 		plan.Currency,
@@ -85,11 +129,31 @@ func (s *PaymentService) sendTelegramInvoice(plan *models.Plan, bot *tgbotapi.Bo
 	if _, err := bot.Send(invoice); err != nil {
 		return fmt.Errorf("send invoice: %w", err)
 	}
+	metrics.PaymentEvents.WithLabelValues("telegram", "invoice_sent").Inc()
+	return nil
+}
+
+func (s *PaymentService) sendYooKassaPayment(ctx context.Context, plan *models.Plan, discount *Discount, bot *tgbotapi.BotAPI, user *models.User, chatID int64) error {
+	amount := plan.PriceMinorUnits
+	if discount != nil {
+		amount = discount.FinalPrice
+		// The link is paid outside the bot with no pre-checkout step, so the code is
+		// taken now; otherwise every link requested with it could be paid.
+		if err := s.promos.RedeemDiscount(ctx, user.ID, discount.PromoCodeID); err != nil {
+			return err
+		}
+	}
+	if err := s.createYooKassaLink(ctx, plan, discount, amount, bot, user, chatID); err != nil {
+		if discount != nil {
+			s.releaseDiscount(ctx, user.ID, &discount.PromoCodeID)
+		}
+		return err
+	}
 	return nil
 }
 
-func (s *PaymentService) sendYooKassaPayment(ctx context.Context, plan *models.Plan, bot *tgbotapi.BotAPI, user *models.User, chatID int64) error {
-	payment, err := s.createYooKassaPayment(ctx, plan)
+func (s *PaymentService) createYooKassaLink(ctx context.Context, plan *models.Plan, discount *Discount, amount int, bot *tgbotapi.BotAPI, user *models.User, chatID int64) error {
+	payment, err := s.createYooKassaPayment(ctx, plan, amount)
 	if err != nil {
 		return err
 	}
@@ -101,16 +165,26 @@ func (s *PaymentService) sendYooKassaPayment(ctx context.Context, plan *models.P
 		Provider:       "yookassa",
 		ProviderCharge: payment.ID,
 		Currency:       plan.Currency,
-		Amount:         plan.PriceMinorUnits,
+		Amount:         amount,
 		Status:         payment.Status,
 		RawPayload:     string(jsonMustMarshal(payment)),
 	}
+	if discount != nil {
+		promoID := discount.PromoCodeID
+		record.PromoCodeID = &promoID
+		record.Discount = discount.Amount
+	}
 	if err := s.payments.Create(ctx, record); err != nil {
 		return fmt.Errorf("record payment: %w", err)
 	}
+	metrics.PaymentEvents.WithLabelValues("yookassa", "created").Inc()
 
-	text := fmt.Sprintf("Оплата через ЮKassa:\nПлан: %s\nСумма: %.2f %s\nСсылка на оплату: %s\nПосле оплаты кредиты будут добавлены автоматически по webhook или вручную.",
-		plan.Title, float64(plan.PriceMinorUnits)/100, plan.Currency, payment.Confirmation.URL)
+	priceLine := fmt.Sprintf("%.2f %s", float64(amount)/100, plan.Currency)
+	if discount != nil {
+		priceLine = fmt.Sprintf("%s (скидка %.2f по промокоду %s)", priceLine, float64(discount.Amount)/100, discount.Code)
+	}
+	text := fmt.Sprintf("Оплата через ЮKassa:\nПлан: %s\nСумма: %s\nСсылка на оплату: %s\nПосле оплаты кредиты будут добавлены автоматически по webhook или вручную.",
+		plan.Title, priceLine, payment.Confirmation.URL)
 
 	msg := tgbotapi.NewMessage(chatID, text)
 	if _, err := bot.Send(msg); err != nil {
@@ -119,21 +193,75 @@ func (s *PaymentService) sendYooKassaPayment(ctx context.Context, plan *models.P
 	return nil
 }
 
-func (s *PaymentService) HandlePreCheckout(bot *tgbotapi.BotAPI, query *tgbotapi.PreCheckoutQuery) error {
+// HandlePreCheckout rejects checkouts from banned users and invoices whose discount
+// code can no longer be used, e.g. because another invoice with it was paid first.
+func (s *PaymentService) HandlePreCheckout(ctx context.Context, bot *tgbotapi.BotAPI, query *tgbotapi.PreCheckoutQuery) error {
 	response := tgbotapi.PreCheckoutConfig{
 		PreCheckoutQueryID: query.ID,
 		OK:                 true,
 	}
+	var user *models.User
+	if query.From != nil {
+		var err error
+		user, err = s.users.FindByTelegramID(ctx, query.From.ID)
+		if err != nil {
+			return fmt.Errorf("pre-checkout user lookup: %w", err)
+		}
+		if user != nil && user.ActiveBan(time.Now()) != models.BanNone {
+			response.OK = false
+			response.ErrorMessage = "Оплата недоступна: аккаунт заблокирован."
+		}
+	}
+	if response.OK {
+		if err := s.checkInvoiceDiscount(ctx, user, query.InvoicePayload); err != nil {
+			s.log.Info("pre-checkout discount rejected", "err", err)
+			response.OK = false
+			response.ErrorMessage = "Промокод из этого счёта больше не действует. Запросите новый счёт: /buy"
+		}
+	}
 	if _, err := bot.Request(response); err != nil {
 		return fmt.Errorf("answer pre-checkout: %w", err)
 	}
+	status := "pre_checkout_ok"
+	if !response.OK {
+		status = "pre_checkout_rejected"
+	}
+	metrics.PaymentEvents.WithLabelValues("telegram", status).Inc()
 	return nil
 }
 
-func (s *PaymentService) HandleSuccessfulPayment(ctx context.Context, user *models.User, payment *tgbotapi.SuccessfulPayment) error {
-	var payload struct {
-		PlanID int64 `json:"plan_id"`
+// checkInvoiceDiscount resolves the invoice's discount code again and checks that it
+// still gives the discount the invoice was issued with.
+func (s *PaymentService) checkInvoiceDiscount(ctx context.Context, user *models.User, rawPayload string) error {
+	var payload invoicePayload
+	if err := json.Unmarshal([]byte(rawPayload), &payload); err != nil {
+		return fmt.Errorf("parse invoice payload: %w", err)
+	}
+	if payload.PromoCodeID == 0 {
+		return nil
+	}
+	if user == nil {
+		return fmt.Errorf("discount invoice from unknown user")
+	}
+	plan, err := s.planFromPayload(ctx, payload.PlanID)
+	if err != nil {
+		return err
+	}
+	if plan == nil {
+		return fmt.Errorf("no plan available for invoice")
 	}
+	discount, err := s.promos.ResolveDiscountByID(ctx, user, payload.PromoCodeID, plan)
+	if err != nil {
+		return err
+	}
+	if discount.Amount != payload.Discount {
+		return fmt.Errorf("discount changed from %d to %d", payload.Discount, discount.Amount)
+	}
+	return nil
+}
+
+func (s *PaymentService) HandleSuccessfulPayment(ctx context.Context, bot *tgbotapi.BotAPI, user *models.User, payment *tgbotapi.SuccessfulPayment) error {
+	var payload invoicePayload
 	if err := json.Unmarshal([]byte(payment.InvoicePayload), &payload); err != nil {
 		return fmt.Errorf("parse payment payload: %w", err)
 	}
@@ -146,10 +274,6 @@ func (s *PaymentService) HandleSuccessfulPayment(ctx context.Context, user *mode
 		return fmt.Errorf("no plan available for payment recording")
 	}
 
-	if err := s.users.UpdatePaidCredits(ctx, user.ID, plan.Credits); err != nil {
-		return fmt.Errorf("add paid credits: %w", err)
-	}
-
 	planID := plan.ID
 	record := &models.Payment{
 		UserID:         user.ID,
@@ -158,16 +282,45 @@ func (s *PaymentService) HandleSuccessfulPayment(ctx context.Context, user *mode
 		ProviderCharge: payment.ProviderPaymentChargeID,
 		Currency:       payment.Currency,
 		Amount:         payment.TotalAmount,
-		Status:         "paid",
 		RawPayload:     string(jsonMustMarshal(payment)),
 	}
-	if err := s.payments.Create(ctx, record); err != nil {
+	if payload.PromoCodeID > 0 {
+		promoID := payload.PromoCodeID
+		record.PromoCodeID = &promoID
+		record.Discount = payload.Discount
+	}
+	if err := s.payments.CreatePaid(ctx, record, plan.Credits); err != nil {
 		return fmt.Errorf("record payment: %w", err)
 	}
+	metrics.PaymentEvents.WithLabelValues("telegram", "paid").Inc()
+	s.redeemDiscount(ctx, user.ID, record.PromoCodeID)
+	s.referrals.RewardReferrer(ctx, bot, user.ID)
 
 	return nil
 }
 
+// redeemDiscount marks the checkout discount as used. The money is already taken at this
+// point, so a failure (e.g. the code ran out concurrently) is logged instead of returned.
+func (s *PaymentService) redeemDiscount(ctx context.Context, userID int64, promoID *int64) {
+	if promoID == nil {
+		return
+	}
+	if err := s.promos.RedeemDiscount(ctx, userID, *promoID); err != nil {
+		s.log.Error("redeem discount promo", "user_id", userID, "promo_code_id", *promoID, "err", err)
+	}
+}
+
+// releaseDiscount gives back a discount reserved for a YooKassa payment that will not be
+// paid. A failure only keeps the code used up, so it is logged.
+func (s *PaymentService) releaseDiscount(ctx context.Context, userID int64, promoID *int64) {
+	if promoID == nil {
+		return
+	}
+	if err := s.promos.ReleaseDiscount(ctx, userID, *promoID); err != nil {
+		s.log.Error("release discount promo", "user_id", userID, "promo_code_id", *promoID, "err", err)
+	}
+}
+
 func (s *PaymentService) planFromPayload(ctx context.Context, planID int64) (*models.Plan, error) {
 	var plan *models.Plan
 	var err error
@@ -199,12 +352,12 @@ type yooPaymentResponse struct {
 	} `json:"amount"`
 }
 
-func (s *PaymentService) createYooKassaPayment(ctx context.Context, plan *models.Plan) (*yooPaymentResponse, error) {
+func (s *PaymentService) createYooKassaPayment(ctx context.Context, plan *models.Plan, amount int) (*yooPaymentResponse, error) {
 	if s.cfg.YooKassaShopID == "" || s.cfg.YooKassaSecretKey == "" {
 		return nil, fmt.Errorf("yookassa credentials are not configured")
 	}
 
-	value := fmt.Sprintf("%.2f", float64(plan.PriceMinorUnits)/100)
+	value := fmt.Sprintf("%.2f", float64(amount)/100)
 	returnURL := s.cfg.YooKassaReturnURL
 	if returnURL == "" {
 		returnURL = "https://t.me"
@@ -250,8 +403,9 @@ func (s *PaymentService) createYooKassaPayment(ctx context.Context, plan *models
 	return &parsed, nil
 }
 
-// HandleYooKassaWebhook processes payment status updates and credits the user.
-func (s *PaymentService) HandleYooKassaWebhook(ctx context.Context, payload []byte) error {
+// HandleYooKassaWebhook processes payment status updates, credits the user and
+// notifies them in Telegram about the outcome.
+func (s *PaymentService) HandleYooKassaWebhook(ctx context.Context, bot *tgbotapi.BotAPI, payload []byte) error {
 	var evt struct {
 		Event  string `json:"event"`
 		Object struct {
@@ -281,6 +435,9 @@ func (s *PaymentService) HandleYooKassaWebhook(ctx context.Context, payload []by
 	if pmt.Status == "paid" {
 		return nil // already processed
 	}
+	if pmt.Status == evt.Object.Status {
+		return nil // duplicate delivery, user was already notified
+	}
 
 	// Mark as paid only on success
 	if evt.Object.Status == "succeeded" {
@@ -294,12 +451,17 @@ func (s *PaymentService) HandleYooKassaWebhook(ctx context.Context, payload []by
 		if plan == nil {
 			return fmt.Errorf("plan not found for payment")
 		}
-		if err := s.users.UpdatePaidCredits(ctx, pmt.UserID, plan.Credits); err != nil {
-			return fmt.Errorf("add paid credits: %w", err)
+		paid, err := s.payments.MarkPaid(ctx, pmt.ID, string(payload), plan.Credits)
+		if err != nil {
+			return fmt.Errorf("mark payment paid: %w", err)
 		}
-		if err := s.payments.UpdateStatus(ctx, pmt.ID, "paid", string(payload)); err != nil {
-			return fmt.Errorf("update payment status: %w", err)
+		if !paid {
+			return nil // a concurrent delivery got there first
 		}
+		metrics.PaymentEvents.WithLabelValues("yookassa", "paid").Inc()
+		// The discount, if any, was reserved when the link was created.
+		s.notifyPaymentSucceeded(ctx, bot, pmt.UserID, plan.Credits)
+		s.referrals.RewardReferrer(ctx, bot, pmt.UserID)
 		return nil
 	}
 
@@ -307,9 +469,56 @@ func (s *PaymentService) HandleYooKassaWebhook(ctx context.Context, payload []by
 	if err := s.payments.UpdateStatus(ctx, pmt.ID, evt.Object.Status, string(payload)); err != nil {
 		return fmt.Errorf("update payment status: %w", err)
 	}
+	metrics.PaymentEvents.WithLabelValues("yookassa", webhookStatusLabel(evt.Object.Status)).Inc()
+	switch evt.Object.Status {
+	case "canceled", "failed":
+		s.releaseDiscount(ctx, pmt.UserID, pmt.PromoCodeID)
+		s.notifyPaymentFailed(ctx, bot, pmt.UserID)
+	}
 	return nil
 }
 
+// webhookStatusLabel maps a YooKassa status to a fixed metric label. The webhook is
+// unauthenticated, so an arbitrary status must not create a new time series.
+func webhookStatusLabel(status string) string {
+	switch status {
+	case "canceled", "failed", "pending", "waiting_for_capture":
+		return status
+	default:
+		return "other"
+	}
+}
+
+// notifyPaymentSucceeded tells the user that credits arrived. Delivery errors are only
+// logged: the payment is already recorded and must not be retried because of Telegram.
+func (s *PaymentService) notifyPaymentSucceeded(ctx context.Context, bot *tgbotapi.BotAPI, userID int64, credits int) {
+	user, err := s.users.FindByID(ctx, userID)
+	if err != nil || user == nil {
+		s.log.Error("payment notify: resolve user", "user_id", userID, "err", err)
+		return
+	}
+	text := fmt.Sprintf("Оплата получена! Зачислено %d кредитов.\n\nБаланс:\nПромо кредиты: %d\nПлатные кредиты: %d",
+		credits, user.PromoCredits, user.PaidCredits)
+	if _, err := bot.Send(tgbotapi.NewMessage(user.TelegramID, text)); err != nil {
+		s.log.Error("payment notify: send success", "user_id", userID, "err", err)
+	}
+}
+
+func (s *PaymentService) notifyPaymentFailed(ctx context.Context, bot *tgbotapi.BotAPI, userID int64) {
+	user, err := s.users.FindByID(ctx, userID)
+	if err != nil || user == nil {
+		s.log.Error("payment notify: resolve user", "user_id", userID, "err", err)
+		return
+	}
+	msg := tgbotapi.NewMessage(user.TelegramID, "Оплата не прошла или была отменена. Кредиты не списаны и не зачислены.")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Повторить оплату", CallbackBuyCredits)),
+	)
+	if _, err := bot.Send(msg); err != nil {
+		s.log.Error("payment notify: send failure", "user_id", userID, "err", err)
+	}
+}
+
 func jsonMustMarshal(v any) []byte {
 	b, err := json.Marshal(v)
 	if err != nil {
@@ -0,0 +1,386 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/digkill/TGStickerBot/internal/config"
+	"github.com/digkill/TGStickerBot/internal/metrics"
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/repository/memory"
+	"github.com/digkill/TGStickerBot/internal/service"
+	"github.com/digkill/TGStickerBot/internal/telegram/telegramtest"
+)
+
+type paymentFixture struct {
+	db       *memory.DB
+	payments *service.PaymentService
+	promos   *service.PromoService
+	plan     *models.Plan
+	telegram *telegramtest.Server
+	bot      *tgbotapi.BotAPI
+}
+
+func newPaymentFixture(t *testing.T) *paymentFixture {
+	t.Helper()
+	cfg := config.Config{
+		PaymentProvider:       "telegram",
+		ReferralReferrerBonus: 50,
+		PromoBonusGenerations: 100,
+	}
+	db := memory.New()
+	plan, err := db.Plans().Create(context.Background(), &models.Plan{
+		Title:           "50 кредитов",
+		Currency:        "RUB",
+		PriceMinorUnits: 29900,
+		Credits:         50,
+		IsActive:        true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := discardLogger()
+	plans := service.NewPlanService(cfg, db.Plans())
+	promos := service.NewPromoService(cfg, db.Promos())
+	referrals := service.NewReferralService(cfg, log, db.Referrals(), db.Users())
+	telegram := telegramtest.NewServer(t)
+	return &paymentFixture{
+		db:       db,
+		payments: service.NewPaymentService(cfg, log, db.Payments(), db.Users(), plans, promos, referrals),
+		promos:   promos,
+		plan:     plan,
+		telegram: telegram,
+		bot:      telegram.NewBotAPI(t),
+	}
+}
+
+// seedYooKassaPayment records a pending YooKassa payment for the fixture plan.
+func (f *paymentFixture) seedYooKassaPayment(t *testing.T, userID int64, chargeID string) *models.Payment {
+	t.Helper()
+	planID := f.plan.ID
+	payment := &models.Payment{
+		UserID:         userID,
+		PlanID:         &planID,
+		Provider:       "yookassa",
+		ProviderCharge: chargeID,
+		Currency:       "RUB",
+		Amount:         f.plan.PriceMinorUnits,
+		Status:         "pending",
+	}
+	if err := f.db.Payments().Create(context.Background(), payment); err != nil {
+		t.Fatal(err)
+	}
+	return payment
+}
+
+func yooKassaEvent(chargeID, status string) []byte {
+	return []byte(`{"event":"payment.` + status + `","object":{"id":"` + chargeID + `","status":"` + status + `"}}`)
+}
+
+func TestSendTelegramInvoiceWithDiscount(t *testing.T) {
+	ctx := context.Background()
+	f := newPaymentFixture(t)
+	promo, err := f.promos.Create(ctx, service.CreatePromoInput{Code: "SALE", MaxUses: 5, Kind: models.PromoKindDiscount, DiscountPercent: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := seedUser(t, f.db, 100, 0, 0)
+
+	if err := f.payments.SendInvoice(ctx, f.bot, user, 100, "SALE"); err != nil {
+		t.Fatalf("SendInvoice: %v", err)
+	}
+	calls := f.telegram.Calls("sendInvoice")
+	if len(calls) != 1 {
+		t.Fatalf("sendInvoice calls = %d, want 1", len(calls))
+	}
+	var prices []tgbotapi.LabeledPrice
+	if err := json.Unmarshal([]byte(calls[0].Params.Get("prices")), &prices); err != nil {
+		t.Fatalf("decode prices: %v", err)
+	}
+	if len(prices) != 2 || prices[0].Amount != 29900 || prices[1].Amount != -2990 {
+		t.Errorf("prices = %+v, want plan price and a 2990 discount", prices)
+	}
+	var payload struct {
+		PlanID      int64 `json:"plan_id"`
+		PromoCodeID int64 `json:"promo_code_id"`
+	}
+	if err := json.Unmarshal([]byte(calls[0].Params.Get("payload")), &payload); err != nil {
+		t.Fatalf("decode invoice payload: %v", err)
+	}
+	if payload.PlanID != f.plan.ID || payload.PromoCodeID != promo.ID {
+		t.Errorf("invoice payload = %+v", payload)
+	}
+	if stored, _ := f.db.Promos().GetByID(ctx, promo.ID); stored.Uses != 0 {
+		t.Errorf("discount redeemed before payment: uses = %d", stored.Uses)
+	}
+}
+
+func TestSendInvoiceRejectsBannedUser(t *testing.T) {
+	f := newPaymentFixture(t)
+	user := seedUser(t, f.db, 100, 0, 0)
+	user.BanStatus = models.BanFull
+
+	if err := f.payments.SendInvoice(context.Background(), f.bot, user, 100, ""); !errors.Is(err, service.ErrUserBanned) {
+		t.Fatalf("err = %v, want ErrUserBanned", err)
+	}
+	if calls := f.telegram.Calls("sendInvoice"); len(calls) != 0 {
+		t.Errorf("sent %d invoices to a banned user", len(calls))
+	}
+}
+
+func TestHandlePreCheckout(t *testing.T) {
+	ctx := context.Background()
+	f := newPaymentFixture(t)
+	seedUser(t, f.db, 100, 0, 0)
+	banned := seedUser(t, f.db, 200, 0, 0)
+	if err := f.db.Users().SetBan(ctx, banned.ID, models.BanFull, "spam", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, from := range []int64{100, 200} {
+		query := &tgbotapi.PreCheckoutQuery{ID: "q", From: &tgbotapi.User{ID: from}, InvoicePayload: `{}`}
+		if err := f.payments.HandlePreCheckout(ctx, f.bot, query); err != nil {
+			t.Fatalf("HandlePreCheckout(%d): %v", from, err)
+		}
+	}
+	calls := f.telegram.Calls("answerPreCheckoutQuery")
+	if len(calls) != 2 {
+		t.Fatalf("answers = %d, want 2", len(calls))
+	}
+	if calls[0].Params.Get("ok") != "true" {
+		t.Errorf("regular user answer = %v, want ok", calls[0].Params)
+	}
+	if calls[1].Params.Get("ok") == "true" || calls[1].Params.Get("error_message") == "" {
+		t.Errorf("banned user answer = %v, want a rejection", calls[1].Params)
+	}
+}
+
+func TestHandlePreCheckoutRechecksDiscount(t *testing.T) {
+	ctx := context.Background()
+	f := newPaymentFixture(t)
+	promo, err := f.promos.Create(ctx, service.CreatePromoInput{Code: "SALE", MaxUses: 5, Kind: models.PromoKindDiscount, DiscountPercent: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := seedUser(t, f.db, 100, 0, 0)
+	payload := `{"plan_id":` + jsonInt(f.plan.ID) + `,"promo_code_id":` + jsonInt(promo.ID) + `,"discount":2990}`
+	preCheckout := func() url.Values {
+		t.Helper()
+		query := &tgbotapi.PreCheckoutQuery{ID: "q", From: &tgbotapi.User{ID: 100}, InvoicePayload: payload}
+		if err := f.payments.HandlePreCheckout(ctx, f.bot, query); err != nil {
+			t.Fatalf("HandlePreCheckout: %v", err)
+		}
+		calls := f.telegram.Calls("answerPreCheckoutQuery")
+		return calls[len(calls)-1].Params
+	}
+
+	if answer := preCheckout(); answer.Get("ok") != "true" {
+		t.Fatalf("answer = %v, want ok for a usable code", answer)
+	}
+	// A second invoice with the same code was paid first.
+	if err := f.promos.RedeemDiscount(ctx, user.ID, promo.ID); err != nil {
+		t.Fatal(err)
+	}
+	if answer := preCheckout(); answer.Get("ok") == "true" || answer.Get("error_message") == "" {
+		t.Errorf("answer = %v, want a rejection for a redeemed code", answer)
+	}
+
+	if err := f.promos.ReleaseDiscount(ctx, user.ID, promo.ID); err != nil {
+		t.Fatal(err)
+	}
+	percent := 20
+	if _, err := f.promos.Update(ctx, promo.ID, service.UpdatePromoInput{DiscountPercent: &percent}); err != nil {
+		t.Fatal(err)
+	}
+	if answer := preCheckout(); answer.Get("ok") == "true" {
+		t.Errorf("answer = %v, want a rejection after the discount changed", answer)
+	}
+}
+
+func TestHandleSuccessfulPayment(t *testing.T) {
+	ctx := context.Background()
+	f := newPaymentFixture(t)
+	promo, err := f.promos.Create(ctx, service.CreatePromoInput{Code: "SALE", MaxUses: 5, Kind: models.PromoKindDiscount, DiscountAmount: 5000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	referrer := seedUser(t, f.db, 100, 0, 0)
+	user := seedUser(t, f.db, 200, 0, 0)
+	if err := f.db.Referrals().Create(ctx, referrer.ID, user.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	payment := &tgbotapi.SuccessfulPayment{
+		Currency:                "RUB",
+		TotalAmount:             24900,
+		InvoicePayload:          `{"plan_id":` + jsonInt(f.plan.ID) + `,"promo_code_id":` + jsonInt(promo.ID) + `,"discount":5000}`,
+		ProviderPaymentChargeID: "charge-1",
+	}
+	if err := f.payments.HandleSuccessfulPayment(ctx, f.bot, user, payment); err != nil {
+		t.Fatalf("HandleSuccessfulPayment: %v", err)
+	}
+
+	if _, paid := balance(t, f.db, user.ID); paid != 50 {
+		t.Errorf("paid credits = %d, want 50", paid)
+	}
+	recorded, _ := f.db.Payments().FindByProviderCharge(ctx, "telegram", "charge-1")
+	if recorded == nil || recorded.Status != "paid" || recorded.Amount != 24900 || recorded.Discount != 5000 {
+		t.Fatalf("recorded payment = %+v", recorded)
+	}
+	if redeemed, _ := f.db.Promos().HasUserRedeemed(ctx, user.ID, promo.ID); !redeemed {
+		t.Error("discount code not redeemed after payment")
+	}
+	if promoCredits, _ := balance(t, f.db, referrer.ID); promoCredits != 50 {
+		t.Errorf("referrer bonus = %d, want 50", promoCredits)
+	}
+	if msgs := f.telegram.Calls("sendMessage"); len(msgs) != 1 || msgs[0].Params.Get("chat_id") != "100" {
+		t.Errorf("referrer notifications = %+v", msgs)
+	}
+}
+
+func TestHandleSuccessfulPaymentFallsBackToDefaultPlan(t *testing.T) {
+	f := newPaymentFixture(t)
+	user := seedUser(t, f.db, 100, 0, 0)
+
+	payment := &tgbotapi.SuccessfulPayment{Currency: "RUB", TotalAmount: 29900, InvoicePayload: `{}`, ProviderPaymentChargeID: "legacy"}
+	if err := f.payments.HandleSuccessfulPayment(context.Background(), f.bot, user, payment); err != nil {
+		t.Fatalf("HandleSuccessfulPayment: %v", err)
+	}
+	if _, paid := balance(t, f.db, user.ID); paid != f.plan.Credits {
+		t.Errorf("paid credits = %d, want %d", paid, f.plan.Credits)
+	}
+}
+
+func TestYooKassaWebhookCreditsOnce(t *testing.T) {
+	ctx := context.Background()
+	f := newPaymentFixture(t)
+	user := seedUser(t, f.db, 100, 0, 0)
+	f.seedYooKassaPayment(t, user.ID, "yk-1")
+
+	for range 2 {
+		if err := f.payments.HandleYooKassaWebhook(ctx, f.bot, yooKassaEvent("yk-1", "succeeded")); err != nil {
+			t.Fatalf("HandleYooKassaWebhook: %v", err)
+		}
+	}
+	if _, paid := balance(t, f.db, user.ID); paid != 50 {
+		t.Errorf("paid credits = %d, want 50 after a duplicate delivery", paid)
+	}
+	payment, _ := f.db.Payments().FindByProviderCharge(ctx, "yookassa", "yk-1")
+	if payment.Status != "paid" {
+		t.Errorf("status = %q, want paid", payment.Status)
+	}
+	msgs := f.telegram.Calls("sendMessage")
+	if len(msgs) != 1 || !strings.Contains(msgs[0].Params.Get("text"), "Зачислено 50") {
+		t.Errorf("notifications = %+v, want one success message", msgs)
+	}
+}
+
+func TestYooKassaWebhookCanceled(t *testing.T) {
+	ctx := context.Background()
+	f := newPaymentFixture(t)
+	user := seedUser(t, f.db, 100, 0, 0)
+	f.seedYooKassaPayment(t, user.ID, "yk-2")
+
+	for range 2 {
+		if err := f.payments.HandleYooKassaWebhook(ctx, f.bot, yooKassaEvent("yk-2", "canceled")); err != nil {
+			t.Fatalf("HandleYooKassaWebhook: %v", err)
+		}
+	}
+	if _, paid := balance(t, f.db, user.ID); paid != 0 {
+		t.Errorf("paid credits = %d, want 0", paid)
+	}
+	payment, _ := f.db.Payments().FindByProviderCharge(ctx, "yookassa", "yk-2")
+	if payment.Status != "canceled" {
+		t.Errorf("status = %q, want canceled", payment.Status)
+	}
+	if msgs := f.telegram.Calls("sendMessage"); len(msgs) != 1 {
+		t.Errorf("failure notifications = %d, want 1", len(msgs))
+	}
+}
+
+func TestYooKassaCancelReleasesDiscount(t *testing.T) {
+	ctx := context.Background()
+	f := newPaymentFixture(t)
+	promo, err := f.promos.Create(ctx, service.CreatePromoInput{Code: "SALE", MaxUses: 1, Kind: models.PromoKindDiscount, DiscountPercent: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := seedUser(t, f.db, 100, 0, 0)
+	// The link reserved the code when it was created.
+	if err := f.promos.RedeemDiscount(ctx, user.ID, promo.ID); err != nil {
+		t.Fatal(err)
+	}
+	planID := f.plan.ID
+	if err := f.db.Payments().Create(ctx, &models.Payment{UserID: user.ID, PlanID: &planID, PromoCodeID: &promo.ID, Provider: "yookassa", ProviderCharge: "yk-3", Currency: "RUB", Amount: 26910, Status: "pending"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.promos.ResolveDiscount(ctx, user, "SALE", f.plan); !errors.Is(err, service.ErrPromoExhausted) {
+		t.Fatalf("ResolveDiscount while reserved err = %v, want ErrPromoExhausted", err)
+	}
+
+	if err := f.payments.HandleYooKassaWebhook(ctx, f.bot, yooKassaEvent("yk-3", "canceled")); err != nil {
+		t.Fatalf("HandleYooKassaWebhook: %v", err)
+	}
+	if _, err := f.promos.ResolveDiscount(ctx, user, "SALE", f.plan); err != nil {
+		t.Errorf("ResolveDiscount after cancel: %v, want the code usable again", err)
+	}
+}
+
+func TestYooKassaLinkFailureReleasesDiscount(t *testing.T) {
+	ctx := context.Background()
+	f := newPaymentFixture(t)
+	f.payments = service.NewPaymentService(config.Config{PaymentProvider: "yookassa"}, discardLogger(), f.db.Payments(), f.db.Users(),
+		service.NewPlanService(config.Config{}, f.db.Plans()), f.promos, service.NewReferralService(config.Config{}, discardLogger(), f.db.Referrals(), f.db.Users()))
+	promo, err := f.promos.Create(ctx, service.CreatePromoInput{Code: "SALE", MaxUses: 5, Kind: models.PromoKindDiscount, DiscountPercent: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := seedUser(t, f.db, 100, 0, 0)
+
+	// No YooKassa credentials are configured, so creating the link fails.
+	if err := f.payments.SendInvoice(ctx, f.bot, user, 100, "SALE"); err == nil {
+		t.Fatal("SendInvoice succeeded without YooKassa credentials")
+	}
+	if redeemed, _ := f.db.Promos().HasUserRedeemed(ctx, user.ID, promo.ID); redeemed {
+		t.Error("discount still reserved after the link failed")
+	}
+	if stored, _ := f.db.Promos().GetByID(ctx, promo.ID); stored.Uses != 0 {
+		t.Errorf("uses = %d, want 0", stored.Uses)
+	}
+}
+
+func TestYooKassaWebhookStatusLabel(t *testing.T) {
+	f := newPaymentFixture(t)
+	user := seedUser(t, f.db, 100, 0, 0)
+	f.seedYooKassaPayment(t, user.ID, "yk-4")
+	before := testutil.ToFloat64(metrics.PaymentEvents.WithLabelValues("yookassa", "other"))
+
+	if err := f.payments.HandleYooKassaWebhook(context.Background(), f.bot, yooKassaEvent("yk-4", "made_up")); err != nil {
+		t.Fatalf("HandleYooKassaWebhook: %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.PaymentEvents.WithLabelValues("yookassa", "other")) - before; got != 1 {
+		t.Errorf("other events = %v, want 1", got)
+	}
+}
+
+func TestYooKassaWebhookUnknownPayment(t *testing.T) {
+	f := newPaymentFixture(t)
+	if err := f.payments.HandleYooKassaWebhook(context.Background(), f.bot, yooKassaEvent("missing", "succeeded")); err == nil {
+		t.Fatal("webhook for an unknown payment succeeded")
+	}
+	if err := f.payments.HandleYooKassaWebhook(context.Background(), f.bot, []byte(`{"object":{}}`)); err == nil {
+		t.Fatal("webhook without a payment id succeeded")
+	}
+}
+
+func jsonInt(n int64) string {
+	b, _ := json.Marshal(n)
+	return string(b)
+}
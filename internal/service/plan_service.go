@@ -11,7 +11,7 @@ import (
 
 type PlanService struct {
 	cfg  config.Config
-	repo *repository.PlanRepository
+	repo repository.PlanStore
 }
 
 type CreatePlanInput struct {
@@ -32,7 +32,7 @@ type UpdatePlanInput struct {
 	IsActive        *bool
 }
 
-func NewPlanService(cfg config.Config, repo *repository.PlanRepository) *PlanService {
+func NewPlanService(cfg config.Config, repo repository.PlanStore) *PlanService {
 	return &PlanService{cfg: cfg, repo: repo}
 }
 
@@ -2,79 +2,262 @@ package service
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/digkill/TGStickerBot/internal/config"
 	"github.com/digkill/TGStickerBot/internal/models"
 	"github.com/digkill/TGStickerBot/internal/repository"
 )
 
 var ErrPromoInvalid = errors.New("promo code invalid")
 var ErrPromoAlreadyRedeemed = errors.New("promo code already redeemed")
+var ErrPromoExhausted = errors.New("promo code exhausted")
+var ErrPromoDisabled = errors.New("promo code disabled")
+var ErrPromoNotStarted = errors.New("promo code not active yet")
+var ErrPromoExpired = errors.New("promo code expired")
+var ErrPromoNewUsersOnly = errors.New("promo code is for new users only")
+var ErrPromoDiscountOnly = errors.New("promo code is a checkout discount")
+var ErrPromoNotDiscount = errors.New("promo code is not a discount")
+var ErrPromoNotForPlan = errors.New("promo code does not apply to this plan")
+var ErrPromoDiscountTooLarge = errors.New("promo discount exceeds plan price")
 
 type PromoService struct {
-	promos *repository.PromoRepository
-	users  *repository.UserRepository
+	cfg    config.Config
+	promos repository.PromoStore
 }
 
-func NewPromoService(promos *repository.PromoRepository, users *repository.UserRepository) *PromoService {
-	return &PromoService{promos: promos, users: users}
+type CreatePromoInput struct {
+	Code            string
+	Kind            models.PromoKind
+	MaxUses         int
+	BonusCredits    int
+	DiscountPercent int
+	DiscountAmount  int
+	PlanIDs         []int64
+	CreditType      models.CostType
+	ValidFrom       *time.Time
+	ValidUntil      *time.Time
+	NewUsersOnly    bool
+	Enabled         *bool
+	Batch           string
 }
 
-func (s *PromoService) Apply(ctx context.Context, userID int64, code string, bonus int) error {
+// UpdatePromoInput carries partial updates. ClearValidFrom/ClearValidUntil remove the
+// corresponding bound, since a nil pointer means "leave unchanged".
+type UpdatePromoInput struct {
+	Code            *string
+	Kind            *models.PromoKind
+	MaxUses         *int
+	Uses            *int
+	BonusCredits    *int
+	DiscountPercent *int
+	DiscountAmount  *int
+	PlanIDs         *[]int64
+	CreditType      *models.CostType
+	ValidFrom       *time.Time
+	ValidUntil      *time.Time
+	ClearValidFrom  bool
+	ClearValidUntil bool
+	NewUsersOnly    *bool
+	Enabled         *bool
+	Batch           *string
+}
+
+// GeneratePromoBatchInput describes a campaign of random codes sharing one set of settings.
+type GeneratePromoBatchInput struct {
+	Count    int
+	Prefix   string
+	Length   int
+	Settings CreatePromoInput
+}
+
+// Discount is a checkout price reduction resolved from a discount promo code.
+type Discount struct {
+	PromoCodeID int64
+	Code        string
+	Amount      int
+	FinalPrice  int
+}
+
+// PromoActivation describes what a successful Apply granted to the user.
+type PromoActivation struct {
+	Code       string
+	Credits    int
+	CreditType models.CostType
+}
+
+func NewPromoService(cfg config.Config, promos repository.PromoStore) *PromoService {
+	return &PromoService{cfg: cfg, promos: promos}
+}
+
+func (s *PromoService) Apply(ctx context.Context, user *models.User, code string) (*PromoActivation, error) {
 	promo, err := s.promos.GetByCode(ctx, code)
 	if err != nil {
-		return fmt.Errorf("get promo: %w", err)
+		return nil, fmt.Errorf("get promo: %w", err)
 	}
 	if promo == nil {
-		return ErrPromoInvalid
+		return nil, ErrPromoInvalid
+	}
+	if promo.Kind == models.PromoKindDiscount {
+		return nil, ErrPromoDiscountOnly
+	}
+	if err := checkPromoAvailable(promo, user, time.Now()); err != nil {
+		return nil, err
+	}
+
+	activation := &PromoActivation{
+		Code:       promo.Code,
+		Credits:    s.bonusFor(promo),
+		CreditType: promo.CreditType,
+	}
+	if err := s.redeem(ctx, user.ID, promo.ID, activation.CreditType, activation.Credits); err != nil {
+		return nil, err
 	}
 
-	tx, err := s.promos.DB().BeginTx(ctx, &sql.TxOptions{})
+	if activation.CreditType == models.CostTypePaid {
+		user.PaidCredits += activation.Credits
+	} else {
+		user.PromoCredits += activation.Credits
+	}
+	return activation, nil
+}
+
+// ResolveDiscount validates a discount code for the given plan without redeeming it.
+// Redemption happens in RedeemDiscount once the payment actually succeeds.
+func (s *PromoService) ResolveDiscount(ctx context.Context, user *models.User, code string, plan *models.Plan) (*Discount, error) {
+	promo, err := s.promos.GetByCode(ctx, code)
 	if err != nil {
-		return fmt.Errorf("begin tx: %w", err)
+		return nil, fmt.Errorf("get promo: %w", err)
 	}
-	defer tx.Rollback()
+	return s.resolveDiscount(ctx, user, promo, plan)
+}
 
-	var uses, maxUses int
-	row := tx.QueryRowContext(ctx, `SELECT uses, max_uses FROM promo_codes WHERE id = ? FOR UPDATE`, promo.ID)
-	if err := row.Scan(&uses, &maxUses); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return ErrPromoInvalid
-		}
-		return fmt.Errorf("lock promo: %w", err)
+// ResolveDiscountByID is ResolveDiscount for a code already referenced by an invoice,
+// used to check the discount again right before the money is taken.
+func (s *PromoService) ResolveDiscountByID(ctx context.Context, user *models.User, promoID int64, plan *models.Plan) (*Discount, error) {
+	promo, err := s.promos.GetByID(ctx, promoID)
+	if err != nil {
+		return nil, fmt.Errorf("get promo: %w", err)
+	}
+	return s.resolveDiscount(ctx, user, promo, plan)
+}
+
+func (s *PromoService) resolveDiscount(ctx context.Context, user *models.User, promo *models.PromoCode, plan *models.Plan) (*Discount, error) {
+	if promo == nil {
+		return nil, ErrPromoInvalid
+	}
+	if promo.Kind != models.PromoKindDiscount {
+		return nil, ErrPromoNotDiscount
+	}
+	if err := checkPromoAvailable(promo, user, time.Now()); err != nil {
+		return nil, err
+	}
+	redeemed, err := s.promos.HasUserRedeemed(ctx, user.ID, promo.ID)
+	if err != nil {
+		return nil, err
+	}
+	if redeemed {
+		return nil, ErrPromoAlreadyRedeemed
 	}
-	if uses >= maxUses {
-		return fmt.Errorf("promo code exhausted")
+	if len(promo.PlanIDs) > 0 && !containsID(promo.PlanIDs, plan.ID) {
+		return nil, ErrPromoNotForPlan
 	}
 
-	row = tx.QueryRowContext(ctx, `SELECT 1 FROM promo_redemptions WHERE user_id = ? AND promo_code_id = ?`, userID, promo.ID)
-	var dummy int
-	if err := row.Scan(&dummy); err != nil {
-		if !errors.Is(err, sql.ErrNoRows) {
-			return fmt.Errorf("check redemption: %w", err)
-		}
-	} else {
-		return ErrPromoAlreadyRedeemed
+	amount := promo.DiscountAmount
+	if promo.DiscountPercent > 0 {
+		amount = plan.PriceMinorUnits * promo.DiscountPercent / 100
+	}
+	final := plan.PriceMinorUnits - amount
+	if final <= 0 {
+		return nil, ErrPromoDiscountTooLarge
 	}
+	return &Discount{
+		PromoCodeID: promo.ID,
+		Code:        promo.Code,
+		Amount:      amount,
+		FinalPrice:  final,
+	}, nil
+}
+
+// RedeemDiscount records a discount code as used after its payment succeeded, or
+// reserves it for a payment link that will be paid outside Telegram.
+func (s *PromoService) RedeemDiscount(ctx context.Context, userID, promoID int64) error {
+	return s.redeem(ctx, userID, promoID, "", 0)
+}
 
-	if _, err := tx.ExecContext(ctx, `INSERT INTO promo_redemptions (user_id, promo_code_id) VALUES (?, ?)`, userID, promo.ID); err != nil {
-		return fmt.Errorf("insert redemption: %w", err)
+// ReleaseDiscount gives back a discount reserved by RedeemDiscount when its payment
+// is canceled, so the user can use the code again.
+func (s *PromoService) ReleaseDiscount(ctx context.Context, userID, promoID int64) error {
+	if err := s.promos.Release(ctx, userID, promoID); err != nil {
+		return fmt.Errorf("release promo: %w", err)
 	}
+	return nil
+}
 
-	if _, err := tx.ExecContext(ctx, `UPDATE promo_codes SET uses = uses + 1 WHERE id = ?`, promo.ID); err != nil {
-		return fmt.Errorf("increment promo uses: %w", err)
+// redeem records the redemption and grants credits atomically, translating the
+// repository errors into the promo errors the bot shows to users.
+func (s *PromoService) redeem(ctx context.Context, userID, promoID int64, creditType models.CostType, credits int) error {
+	err := s.promos.Redeem(ctx, userID, promoID, creditType, credits)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, repository.ErrNotFound):
+		return ErrPromoInvalid
+	case errors.Is(err, repository.ErrLimitReached):
+		return ErrPromoExhausted
+	case errors.Is(err, repository.ErrDuplicate):
+		return ErrPromoAlreadyRedeemed
+	default:
+		return fmt.Errorf("redeem promo: %w", err)
 	}
+}
 
-	if _, err := tx.ExecContext(ctx, `UPDATE users SET promo_credits = promo_credits + ?, updated_at = NOW() WHERE id = ?`, bonus, userID); err != nil {
-		return fmt.Errorf("add promo credits: %w", err)
+func containsID(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
 	}
+	return false
+}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("commit promo tx: %w", err)
+// bonusFor returns the per-code bonus, falling back to the global default for codes
+// created before bonuses were configurable.
+func (s *PromoService) bonusFor(promo *models.PromoCode) int {
+	if promo.BonusCredits > 0 {
+		return promo.BonusCredits
 	}
+	return s.cfg.PromoBonusGenerations
+}
 
+// checkPromoAvailable validates everything that does not need the row lock. A code is
+// "new users only" relative to its activation start: users registered before
+// valid_from (or before the code was created) cannot redeem it.
+func checkPromoAvailable(promo *models.PromoCode, user *models.User, now time.Time) error {
+	if !promo.Enabled {
+		return ErrPromoDisabled
+	}
+	if promo.ValidFrom != nil && now.Before(*promo.ValidFrom) {
+		return ErrPromoNotStarted
+	}
+	if promo.ValidUntil != nil && !now.Before(*promo.ValidUntil) {
+		return ErrPromoExpired
+	}
+	if promo.Uses >= promo.MaxUses {
+		return ErrPromoExhausted
+	}
+	if promo.NewUsersOnly {
+		start := promo.CreatedAt
+		if promo.ValidFrom != nil {
+			start = *promo.ValidFrom
+		}
+		if user.CreatedAt.Before(start) {
+			return ErrPromoNewUsersOnly
+		}
+	}
 	return nil
 }
 
@@ -82,22 +265,249 @@ func (s *PromoService) List(ctx context.Context) ([]models.PromoCode, error) {
 	return s.promos.List(ctx)
 }
 
-func (s *PromoService) Create(ctx context.Context, code string, maxUses int) (*models.PromoCode, error) {
+func (s *PromoService) Create(ctx context.Context, input CreatePromoInput) (*models.PromoCode, error) {
+	if input.Code == "" {
+		return nil, fmt.Errorf("code is required")
+	}
+	if input.MaxUses <= 0 {
+		return nil, fmt.Errorf("max_uses must be positive")
+	}
+	enabled := true
+	if input.Enabled != nil {
+		enabled = *input.Enabled
+	}
 	promo := &models.PromoCode{
-		Code:    code,
-		MaxUses: maxUses,
+		Code:            input.Code,
+		Kind:            input.Kind,
+		MaxUses:         input.MaxUses,
+		BonusCredits:    input.BonusCredits,
+		DiscountPercent: input.DiscountPercent,
+		DiscountAmount:  input.DiscountAmount,
+		PlanIDs:         input.PlanIDs,
+		CreditType:      input.CreditType,
+		ValidFrom:       input.ValidFrom,
+		ValidUntil:      input.ValidUntil,
+		NewUsersOnly:    input.NewUsersOnly,
+		Enabled:         enabled,
+		Batch:           strings.TrimSpace(input.Batch),
+	}
+	if promo.Kind == "" {
+		promo.Kind = models.PromoKindCredits
+	}
+	if promo.CreditType == "" {
+		promo.CreditType = models.CostTypePromo
+	}
+	if err := validatePromo(promo); err != nil {
+		return nil, err
 	}
 	return s.promos.Create(ctx, promo)
 }
 
-func (s *PromoService) Update(ctx context.Context, id int64, code string, maxUses int, uses int) (*models.PromoCode, error) {
-	promo := &models.PromoCode{
-		ID:      id,
-		Code:    code,
-		MaxUses: maxUses,
-		Uses:    uses,
+func (s *PromoService) Update(ctx context.Context, id int64, input UpdatePromoInput) (*models.PromoCode, error) {
+	existing, err := s.promos.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, fmt.Errorf("promo not found")
+	}
+	if input.Code != nil && *input.Code != "" {
+		existing.Code = *input.Code
+	}
+	if input.MaxUses != nil && *input.MaxUses > 0 {
+		existing.MaxUses = *input.MaxUses
+	}
+	if input.Uses != nil && *input.Uses >= 0 {
+		existing.Uses = *input.Uses
+	}
+	if input.Kind != nil && *input.Kind != "" {
+		existing.Kind = *input.Kind
+	}
+	if input.BonusCredits != nil {
+		existing.BonusCredits = *input.BonusCredits
+	}
+	if input.DiscountPercent != nil {
+		existing.DiscountPercent = *input.DiscountPercent
+	}
+	if input.DiscountAmount != nil {
+		existing.DiscountAmount = *input.DiscountAmount
+	}
+	if input.PlanIDs != nil {
+		existing.PlanIDs = *input.PlanIDs
+	}
+	if input.CreditType != nil && *input.CreditType != "" {
+		existing.CreditType = *input.CreditType
+	}
+	if input.ClearValidFrom {
+		existing.ValidFrom = nil
+	} else if input.ValidFrom != nil {
+		existing.ValidFrom = input.ValidFrom
+	}
+	if input.ClearValidUntil {
+		existing.ValidUntil = nil
+	} else if input.ValidUntil != nil {
+		existing.ValidUntil = input.ValidUntil
+	}
+	if input.NewUsersOnly != nil {
+		existing.NewUsersOnly = *input.NewUsersOnly
+	}
+	if input.Enabled != nil {
+		existing.Enabled = *input.Enabled
+	}
+	if input.Batch != nil {
+		existing.Batch = strings.TrimSpace(*input.Batch)
+	}
+	if existing.Uses > existing.MaxUses {
+		return nil, fmt.Errorf("uses cannot exceed max_uses")
+	}
+	if err := validatePromo(existing); err != nil {
+		return nil, err
+	}
+	return s.promos.Update(ctx, existing)
+}
+
+const (
+	maxPromoBatchSize     = 10000
+	defaultPromoCodeLen   = 8
+	promoBatchMaxAttempts = 3
+	// promoCodeAlphabet omits look-alike characters (0/O, 1/I/L) so codes survive being
+	// read aloud or retyped from a video.
+	promoCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+)
+
+// GenerateBatch creates Count unique random codes labelled with Settings.Batch. The whole
+// batch is inserted atomically; on a collision with an existing code it is regenerated.
+func (s *PromoService) GenerateBatch(ctx context.Context, input GeneratePromoBatchInput) ([]models.PromoUsage, error) {
+	batch := strings.TrimSpace(input.Settings.Batch)
+	if batch == "" {
+		return nil, fmt.Errorf("batch is required")
+	}
+	if input.Count <= 0 || input.Count > maxPromoBatchSize {
+		return nil, fmt.Errorf("count must be between 1 and %d", maxPromoBatchSize)
+	}
+	if input.Length == 0 {
+		input.Length = defaultPromoCodeLen
+	}
+	if input.Length < 4 || input.Length > 32 {
+		return nil, fmt.Errorf("length must be between 4 and 32")
+	}
+	prefix := strings.ToUpper(strings.TrimSpace(input.Prefix))
+	if len(prefix)+input.Length > 64 {
+		return nil, fmt.Errorf("prefix and length exceed 64 characters")
+	}
+	if input.Settings.MaxUses <= 0 {
+		return nil, fmt.Errorf("max_uses must be positive")
+	}
+
+	enabled := true
+	if input.Settings.Enabled != nil {
+		enabled = *input.Settings.Enabled
+	}
+	template := &models.PromoCode{
+		Kind:            input.Settings.Kind,
+		MaxUses:         input.Settings.MaxUses,
+		BonusCredits:    input.Settings.BonusCredits,
+		DiscountPercent: input.Settings.DiscountPercent,
+		DiscountAmount:  input.Settings.DiscountAmount,
+		PlanIDs:         input.Settings.PlanIDs,
+		CreditType:      input.Settings.CreditType,
+		ValidFrom:       input.Settings.ValidFrom,
+		ValidUntil:      input.Settings.ValidUntil,
+		NewUsersOnly:    input.Settings.NewUsersOnly,
+		Enabled:         enabled,
+		Batch:           batch,
 	}
-	return s.promos.Update(ctx, promo)
+	if template.Kind == "" {
+		template.Kind = models.PromoKindCredits
+	}
+	if template.CreditType == "" {
+		template.CreditType = models.CostTypePromo
+	}
+	if err := validatePromo(template); err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		codes, err := randomPromoCodes(prefix, input.Length, input.Count)
+		if err != nil {
+			return nil, err
+		}
+		err = s.promos.CreateBatch(ctx, template, codes)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, repository.ErrDuplicate) || attempt >= promoBatchMaxAttempts {
+			return nil, err
+		}
+	}
+	return s.promos.ListUsageByBatch(ctx, batch)
+}
+
+// randomPromoCodes returns count distinct codes; uniqueness against the database is
+// enforced by the unique key on insert.
+func randomPromoCodes(prefix string, length, count int) ([]string, error) {
+	seen := make(map[string]struct{}, count)
+	codes := make([]string, 0, count)
+	for len(codes) < count {
+		suffix, err := randomString(promoCodeAlphabet, length)
+		if err != nil {
+			return nil, err
+		}
+		code := prefix + suffix
+		if _, dup := seen[code]; dup {
+			continue
+		}
+		seen[code] = struct{}{}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func (s *PromoService) BatchUsage(ctx context.Context, batch string) ([]models.PromoUsage, error) {
+	return s.promos.ListUsageByBatch(ctx, batch)
+}
+
+func (s *PromoService) CampaignStats(ctx context.Context, batch string) ([]models.PromoCampaignStats, error) {
+	return s.promos.CampaignStats(ctx, batch)
+}
+
+func (s *PromoService) Redemptions(ctx context.Context, promoID int64, limit, offset int) ([]models.PromoRedemption, int, error) {
+	return s.promos.ListRedemptions(ctx, promoID, limit, offset)
+}
+
+// ConversionStats reports paying redeemers and attributed revenue; promoID 0 covers all codes.
+func (s *PromoService) ConversionStats(ctx context.Context, promoID int64) ([]models.PromoConversionStats, error) {
+	return s.promos.ConversionStats(ctx, promoID)
+}
+
+func validatePromo(promo *models.PromoCode) error {
+	switch promo.Kind {
+	case models.PromoKindCredits:
+	case models.PromoKindDiscount:
+		if promo.DiscountPercent < 0 || promo.DiscountPercent > 99 {
+			return fmt.Errorf("discount_percent must be between 1 and 99: the final price cannot be zero")
+		}
+		if promo.DiscountAmount < 0 {
+			return fmt.Errorf("discount_amount cannot be negative")
+		}
+		if (promo.DiscountPercent > 0) == (promo.DiscountAmount > 0) {
+			return fmt.Errorf("discount code needs exactly one of discount_percent or discount_amount")
+		}
+	default:
+		return fmt.Errorf("kind must be %q or %q", models.PromoKindCredits, models.PromoKindDiscount)
+	}
+	if promo.BonusCredits < 0 {
+		return fmt.Errorf("bonus_credits cannot be negative")
+	}
+	switch promo.CreditType {
+	case models.CostTypePromo, models.CostTypePaid:
+	default:
+		return fmt.Errorf("credit_type must be %q or %q", models.CostTypePromo, models.CostTypePaid)
+	}
+	if promo.ValidFrom != nil && promo.ValidUntil != nil && !promo.ValidUntil.After(*promo.ValidFrom) {
+		return fmt.Errorf("valid_until must be after valid_from")
+	}
+	return nil
 }
 
 func (s *PromoService) Delete(ctx context.Context, id int64) error {
@@ -0,0 +1,255 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digkill/TGStickerBot/internal/config"
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/repository/memory"
+	"github.com/digkill/TGStickerBot/internal/service"
+)
+
+func newPromoService(db *memory.DB) *service.PromoService {
+	return service.NewPromoService(config.Config{PromoBonusGenerations: 100}, db.Promos())
+}
+
+func createPromo(t *testing.T, svc *service.PromoService, input service.CreatePromoInput) *models.PromoCode {
+	t.Helper()
+	if input.MaxUses == 0 {
+		input.MaxUses = 10
+	}
+	promo, err := svc.Create(context.Background(), input)
+	if err != nil {
+		t.Fatalf("create promo %s: %v", input.Code, err)
+	}
+	return promo
+}
+
+func TestApplyGrantsCredits(t *testing.T) {
+	ctx := context.Background()
+	db := memory.New()
+	svc := newPromoService(db)
+	createPromo(t, svc, service.CreatePromoInput{Code: "PROMO", BonusCredits: 30})
+	createPromo(t, svc, service.CreatePromoInput{Code: "PAID", BonusCredits: 15, CreditType: models.CostTypePaid})
+	createPromo(t, svc, service.CreatePromoInput{Code: "DEFAULT"})
+	user := seedUser(t, db, 100, 0, 0)
+
+	for _, tc := range []struct {
+		code       string
+		credits    int
+		creditType models.CostType
+	}{
+		{"PROMO", 30, models.CostTypePromo},
+		{"PAID", 15, models.CostTypePaid},
+		{"DEFAULT", 100, models.CostTypePromo},
+	} {
+		activation, err := svc.Apply(ctx, user, tc.code)
+		if err != nil {
+			t.Fatalf("Apply(%s): %v", tc.code, err)
+		}
+		if activation.Credits != tc.credits || activation.CreditType != tc.creditType {
+			t.Errorf("Apply(%s) = %+v, want %d %s credits", tc.code, activation, tc.credits, tc.creditType)
+		}
+	}
+
+	if promo, paid := balance(t, db, user.ID); promo != 130 || paid != 15 {
+		t.Errorf("balance = %d promo, %d paid; want 130, 15", promo, paid)
+	}
+	if user.PromoCredits != 130 || user.PaidCredits != 15 {
+		t.Errorf("user snapshot = %d promo, %d paid; want 130, 15", user.PromoCredits, user.PaidCredits)
+	}
+	promo, _ := db.Promos().GetByCode(ctx, "PROMO")
+	if promo.Uses != 1 {
+		t.Errorf("uses = %d, want 1", promo.Uses)
+	}
+}
+
+func TestApplyTwiceIsRejected(t *testing.T) {
+	ctx := context.Background()
+	db := memory.New()
+	svc := newPromoService(db)
+	createPromo(t, svc, service.CreatePromoInput{Code: "ONCE", BonusCredits: 10})
+	user := seedUser(t, db, 100, 0, 0)
+
+	if _, err := svc.Apply(ctx, user, "ONCE"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Apply(ctx, user, "ONCE"); !errors.Is(err, service.ErrPromoAlreadyRedeemed) {
+		t.Fatalf("second Apply err = %v, want ErrPromoAlreadyRedeemed", err)
+	}
+	if promo, _ := balance(t, db, user.ID); promo != 10 {
+		t.Errorf("promo credits = %d, want 10", promo)
+	}
+}
+
+func TestApplyRespectsMaxUsesConcurrently(t *testing.T) {
+	ctx := context.Background()
+	db := memory.New()
+	svc := newPromoService(db)
+	createPromo(t, svc, service.CreatePromoInput{Code: "RACE", MaxUses: 3, BonusCredits: 10})
+
+	const users = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	granted, exhausted := 0, 0
+	for i := range users {
+		user := seedUser(t, db, int64(100+i), 0, 0)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := svc.Apply(ctx, user, "RACE")
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				granted++
+			case errors.Is(err, service.ErrPromoExhausted):
+				exhausted++
+			default:
+				t.Errorf("Apply: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if granted != 3 || exhausted != users-3 {
+		t.Errorf("granted %d, exhausted %d; want 3 and %d", granted, exhausted, users-3)
+	}
+}
+
+func TestApplyChecksAvailability(t *testing.T) {
+	ctx := context.Background()
+	db := memory.New()
+	svc := newPromoService(db)
+	now := time.Now()
+	future, past := now.Add(time.Hour), now.Add(-time.Hour)
+	disabled := false
+	createPromo(t, svc, service.CreatePromoInput{Code: "OFF", Enabled: &disabled})
+	createPromo(t, svc, service.CreatePromoInput{Code: "LATER", ValidFrom: &future})
+	createPromo(t, svc, service.CreatePromoInput{Code: "OVER", ValidUntil: &past})
+	createPromo(t, svc, service.CreatePromoInput{Code: "NEWBIES", NewUsersOnly: true})
+	createPromo(t, svc, service.CreatePromoInput{Code: "SALE", Kind: models.PromoKindDiscount, DiscountPercent: 10})
+
+	user := seedUser(t, db, 100, 0, 0)
+	user.CreatedAt = now.Add(-24 * time.Hour)
+
+	for code, want := range map[string]error{
+		"MISSING": service.ErrPromoInvalid,
+		"OFF":     service.ErrPromoDisabled,
+		"LATER":   service.ErrPromoNotStarted,
+		"OVER":    service.ErrPromoExpired,
+		"NEWBIES": service.ErrPromoNewUsersOnly,
+		"SALE":    service.ErrPromoDiscountOnly,
+	} {
+		if _, err := svc.Apply(ctx, user, code); !errors.Is(err, want) {
+			t.Errorf("Apply(%s) err = %v, want %v", code, err, want)
+		}
+	}
+	if promo, paid := balance(t, db, user.ID); promo != 0 || paid != 0 {
+		t.Errorf("balance = %d promo, %d paid; want nothing granted", promo, paid)
+	}
+}
+
+func TestResolveDiscount(t *testing.T) {
+	ctx := context.Background()
+	db := memory.New()
+	svc := newPromoService(db)
+	plan := &models.Plan{ID: 1, PriceMinorUnits: 29900}
+	createPromo(t, svc, service.CreatePromoInput{Code: "TEN", Kind: models.PromoKindDiscount, DiscountPercent: 10})
+	createPromo(t, svc, service.CreatePromoInput{Code: "FLAT", Kind: models.PromoKindDiscount, DiscountAmount: 5000})
+	createPromo(t, svc, service.CreatePromoInput{Code: "HUGE", Kind: models.PromoKindDiscount, DiscountAmount: 29900})
+	createPromo(t, svc, service.CreatePromoInput{Code: "OTHER", Kind: models.PromoKindDiscount, DiscountPercent: 50, PlanIDs: []int64{2}})
+	createPromo(t, svc, service.CreatePromoInput{Code: "BONUS", BonusCredits: 5})
+	user := seedUser(t, db, 100, 0, 0)
+
+	discount, err := svc.ResolveDiscount(ctx, user, "TEN", plan)
+	if err != nil {
+		t.Fatalf("ResolveDiscount(TEN): %v", err)
+	}
+	if discount.Amount != 2990 || discount.FinalPrice != 26910 {
+		t.Errorf("TEN discount = %+v, want 2990 off 29900", discount)
+	}
+	if discount, err = svc.ResolveDiscount(ctx, user, "FLAT", plan); err != nil || discount.FinalPrice != 24900 {
+		t.Errorf("FLAT discount = %+v, %v; want final price 24900", discount, err)
+	}
+
+	for code, want := range map[string]error{
+		"HUGE":  service.ErrPromoDiscountTooLarge,
+		"OTHER": service.ErrPromoNotForPlan,
+		"BONUS": service.ErrPromoNotDiscount,
+	} {
+		if _, err := svc.ResolveDiscount(ctx, user, code, plan); !errors.Is(err, want) {
+			t.Errorf("ResolveDiscount(%s) err = %v, want %v", code, err, want)
+		}
+	}
+
+	// Resolving does not use the code up; redeeming after payment does.
+	promo, _ := db.Promos().GetByCode(ctx, "TEN")
+	if promo.Uses != 0 {
+		t.Errorf("uses after resolve = %d, want 0", promo.Uses)
+	}
+	if err := svc.RedeemDiscount(ctx, user.ID, promo.ID); err != nil {
+		t.Fatalf("RedeemDiscount: %v", err)
+	}
+	if _, err := svc.ResolveDiscount(ctx, user, "TEN", plan); !errors.Is(err, service.ErrPromoAlreadyRedeemed) {
+		t.Errorf("ResolveDiscount after redeem err = %v, want ErrPromoAlreadyRedeemed", err)
+	}
+	if promo, paid := balance(t, db, user.ID); promo != 0 || paid != 0 {
+		t.Errorf("discount redemption granted credits: %d promo, %d paid", promo, paid)
+	}
+}
+
+func TestCreateRejectsFreeDiscount(t *testing.T) {
+	svc := newPromoService(memory.New())
+	_, err := svc.Create(context.Background(), service.CreatePromoInput{Code: "FREE", MaxUses: 1, Kind: models.PromoKindDiscount, DiscountPercent: 100})
+	if err == nil || !strings.Contains(err.Error(), "between 1 and 99") {
+		t.Errorf("Create(100%%) err = %v, want the 1-99 range error", err)
+	}
+	createPromo(t, svc, service.CreatePromoInput{Code: "ALMOST", Kind: models.PromoKindDiscount, DiscountPercent: 99})
+}
+
+func TestGenerateBatch(t *testing.T) {
+	ctx := context.Background()
+	db := memory.New()
+	svc := newPromoService(db)
+
+	codes, err := svc.GenerateBatch(ctx, service.GeneratePromoBatchInput{
+		Count:    20,
+		Prefix:   "blog-",
+		Length:   6,
+		Settings: service.CreatePromoInput{Batch: "blogger", MaxUses: 1, BonusCredits: 25},
+	})
+	if err != nil {
+		t.Fatalf("GenerateBatch: %v", err)
+	}
+	if len(codes) != 20 {
+		t.Fatalf("generated %d codes, want 20", len(codes))
+	}
+	seen := make(map[string]bool)
+	for _, c := range codes {
+		if !strings.HasPrefix(c.Code, "BLOG-") || len(c.Code) != len("BLOG-")+6 {
+			t.Errorf("code %q does not match prefix and length", c.Code)
+		}
+		if seen[c.Code] {
+			t.Errorf("duplicate code %q", c.Code)
+		}
+		seen[c.Code] = true
+	}
+
+	user := seedUser(t, db, 100, 0, 0)
+	if _, err := svc.Apply(ctx, user, codes[0].Code); err != nil {
+		t.Fatalf("Apply batch code: %v", err)
+	}
+	stats, err := svc.CampaignStats(ctx, "blogger")
+	if err != nil || len(stats) != 1 {
+		t.Fatalf("CampaignStats = %+v, %v", stats, err)
+	}
+	if stats[0].Codes != 20 || stats[0].Redemptions != 1 || stats[0].UniqueUsers != 1 {
+		t.Errorf("campaign stats = %+v", stats[0])
+	}
+}
@@ -0,0 +1,23 @@
+package service
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// randomString returns length characters drawn uniformly from alphabet using crypto/rand.
+func randomString(alphabet string, length int) (string, error) {
+	alphabetLen := big.NewInt(int64(len(alphabet)))
+	var sb strings.Builder
+	sb.Grow(length)
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, alphabetLen)
+		if err != nil {
+			return "", fmt.Errorf("generate random string: %w", err)
+		}
+		sb.WriteByte(alphabet[n.Int64()])
+	}
+	return sb.String(), nil
+}
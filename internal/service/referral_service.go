@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/digkill/TGStickerBot/internal/config"
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/repository"
+)
+
+var ErrReferralInvalid = errors.New("referral code invalid")
+var ErrReferralSelf = errors.New("self referral is not allowed")
+var ErrReferralAlreadyAttributed = errors.New("user already attributed to a referrer")
+
+const (
+	referralCodeLen         = 8
+	referralCodeAlphabet    = "abcdefghijkmnpqrstuvwxyz23456789"
+	referralCodeMaxAttempts = 5
+)
+
+type ReferralService struct {
+	cfg       config.Config
+	log       *slog.Logger
+	referrals repository.ReferralStore
+	users     repository.UserStore
+}
+
+func NewReferralService(cfg config.Config, log *slog.Logger, referrals repository.ReferralStore, users repository.UserStore) *ReferralService {
+	return &ReferralService{cfg: cfg, log: log, referrals: referrals, users: users}
+}
+
+// Code returns the user's referral code, generating one on first use.
+func (s *ReferralService) Code(ctx context.Context, user *models.User) (string, error) {
+	if user.ReferralCode != "" {
+		return user.ReferralCode, nil
+	}
+	for attempt := 1; ; attempt++ {
+		code, err := randomString(referralCodeAlphabet, referralCodeLen)
+		if err != nil {
+			return "", err
+		}
+		err = s.users.SetReferralCode(ctx, user.ID, code)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, repository.ErrDuplicate) || attempt >= referralCodeMaxAttempts {
+			return "", err
+		}
+	}
+	// Re-read instead of trusting the generated value: a concurrent /ref may have won.
+	fresh, err := s.users.FindByID(ctx, user.ID)
+	if err != nil {
+		return "", err
+	}
+	if fresh == nil || fresh.ReferralCode == "" {
+		return "", fmt.Errorf("referral code not saved")
+	}
+	user.ReferralCode = fresh.ReferralCode
+	return fresh.ReferralCode, nil
+}
+
+// Attribute links a freshly registered invitee to the owner of code and grants the
+// invitee bonus. Callers must only pass users created by the current /start.
+func (s *ReferralService) Attribute(ctx context.Context, invitee *models.User, code string) (*models.User, error) {
+	if code == "" {
+		return nil, ErrReferralInvalid
+	}
+	referrer, err := s.users.FindByReferralCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if referrer == nil {
+		return nil, ErrReferralInvalid
+	}
+	if referrer.ID == invitee.ID || referrer.TelegramID == invitee.TelegramID {
+		return nil, ErrReferralSelf
+	}
+
+	bonus := max(s.cfg.ReferralInviteeBonus, 0)
+	if err := s.referrals.Create(ctx, referrer.ID, invitee.ID, bonus); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			return nil, ErrReferralAlreadyAttributed
+		}
+		return nil, err
+	}
+	invitee.PromoCredits += bonus
+	return referrer, nil
+}
+
+// RewardReferrer grants the referrer bonus the first time the invitee pays and tells
+// the referrer about it. With the bonus disabled the referral is still marked as paid,
+// so /ref counts it. It is safe to call on every successful payment.
+func (s *ReferralService) RewardReferrer(ctx context.Context, bot *tgbotapi.BotAPI, inviteeID int64) {
+	ref, err := s.referrals.FindByInvitee(ctx, inviteeID)
+	if err != nil {
+		s.log.Error("referral reward: find referral", "invitee_id", inviteeID, "err", err)
+		return
+	}
+	if ref == nil || ref.ReferrerRewardedAt != nil {
+		return
+	}
+	bonus := max(s.cfg.ReferralReferrerBonus, 0)
+	rewarded, err := s.referrals.RewardReferrer(ctx, ref.ID, bonus)
+	if err != nil {
+		s.log.Error("referral reward: grant bonus", "referral_id", ref.ID, "err", err)
+		return
+	}
+	if !rewarded || bonus == 0 {
+		return
+	}
+
+	referrer, err := s.users.FindByID(ctx, ref.ReferrerUserID)
+	if err != nil || referrer == nil {
+		s.log.Error("referral reward: resolve referrer", "user_id", ref.ReferrerUserID, "err", err)
+		return
+	}
+	text := fmt.Sprintf("Приглашённый вами друг совершил первую покупку! +%d бонусных кредитов.", bonus)
+	if _, err := bot.Send(tgbotapi.NewMessage(referrer.TelegramID, text)); err != nil {
+		s.log.Error("referral reward: notify referrer", "user_id", referrer.ID, "err", err)
+	}
+}
+
+func (s *ReferralService) Stats(ctx context.Context, userID int64) (models.ReferralStats, error) {
+	return s.referrals.Stats(ctx, userID)
+}
@@ -0,0 +1,39 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/digkill/TGStickerBot/internal/config"
+	"github.com/digkill/TGStickerBot/internal/repository/memory"
+	"github.com/digkill/TGStickerBot/internal/service"
+	"github.com/digkill/TGStickerBot/internal/telegram/telegramtest"
+)
+
+func TestRewardReferrerWithoutBonusCountsPayment(t *testing.T) {
+	ctx := context.Background()
+	db := memory.New()
+	referrals := service.NewReferralService(config.Config{ReferralReferrerBonus: 0}, discardLogger(), db.Referrals(), db.Users())
+	telegram := telegramtest.NewServer(t)
+	referrer := seedUser(t, db, 100, 0, 0)
+	invitee := seedUser(t, db, 200, 0, 0)
+	if err := db.Referrals().Create(ctx, referrer.ID, invitee.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	referrals.RewardReferrer(ctx, telegram.NewBotAPI(t), invitee.ID)
+
+	stats, err := referrals.Stats(ctx, referrer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Invited != 1 || stats.Paid != 1 || stats.EarnedCredits != 0 {
+		t.Errorf("stats = %+v, want 1 invited, 1 paid, 0 earned", stats)
+	}
+	if promo, _ := balance(t, db, referrer.ID); promo != 0 {
+		t.Errorf("referrer promo credits = %d, want 0", promo)
+	}
+	if msgs := telegram.Calls("sendMessage"); len(msgs) != 0 {
+		t.Errorf("referrer notified without a bonus: %+v", msgs)
+	}
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/repository"
+)
+
+type StatsService struct {
+	stats *repository.StatsRepository
+}
+
+func NewStatsService(stats *repository.StatsRepository) *StatsService {
+	return &StatsService{stats: stats}
+}
+
+func (s *StatsService) Sources(ctx context.Context, from, to time.Time) ([]models.SourceStats, error) {
+	if !to.After(from) {
+		return nil, fmt.Errorf("date range is empty")
+	}
+	return s.stats.SourceStats(ctx, from, to)
+}
+
+func (s *StatsService) Overview(ctx context.Context, from, to time.Time) (*models.Overview, error) {
+	if !to.After(from) {
+		return nil, fmt.Errorf("date range is empty")
+	}
+	return s.stats.Overview(ctx, from, to)
+}
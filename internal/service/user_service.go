@@ -2,22 +2,52 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/digkill/TGStickerBot/internal/models"
 	"github.com/digkill/TGStickerBot/internal/repository"
 )
 
+var ErrUserNotFound = errors.New("user not found")
+var ErrAdjustmentReasonRequired = errors.New("adjustment reason is required")
+var ErrAdjustmentInvalid = errors.New("invalid credit adjustment")
+var ErrUserBanned = errors.New("user is banned")
+var ErrBanInvalid = errors.New("invalid ban")
+
+// userDetailsHistory caps how many generations/payments/adjustments are returned with
+// user details.
+const userDetailsHistory = 20
+
 type UserService struct {
-	users *repository.UserRepository
+	users       repository.UserStore
+	generations repository.GenerationStore
+	payments    repository.PaymentStore
+}
+
+func NewUserService(users repository.UserStore, generations repository.GenerationStore, payments repository.PaymentStore) *UserService {
+	return &UserService{users: users, generations: generations, payments: payments}
+}
+
+// UserDetails is a user with their recent activity, for the admin API.
+type UserDetails struct {
+	User        *models.User
+	Generations []models.GenerationLog
+	Payments    []models.Payment
+	Adjustments []models.CreditAdjustment
 }
 
-func NewUserService(users *repository.UserRepository) *UserService {
-	return &UserService{users: users}
+type AdjustCreditsInput struct {
+	CreditType models.CostType
+	Delta      int
+	Reason     string
+	Actor      string
 }
 
-func (s *UserService) Ensure(ctx context.Context, telegramID int64, username, firstName, lastName string, freeLimit int) (*models.User, bool, error) {
-	user, created, err := s.users.Ensure(ctx, telegramID, username, firstName, lastName, freeLimit)
+func (s *UserService) Ensure(ctx context.Context, telegramID int64, username, firstName, lastName, languageCode string, freeLimit int) (*models.User, bool, error) {
+	user, created, err := s.users.Ensure(ctx, telegramID, username, firstName, lastName, languageCode, freeLimit)
 	if err != nil {
 		return nil, false, fmt.Errorf("ensure user: %w", err)
 	}
@@ -43,3 +73,110 @@ func (s *UserService) ListTelegramIDs(ctx context.Context) ([]int64, error) {
 func (s *UserService) SetSubscriptionBonusGranted(ctx context.Context, userID int64, granted bool) error {
 	return s.users.SetSubscriptionBonusGranted(ctx, userID, granted)
 }
+
+func (s *UserService) SetSource(ctx context.Context, userID int64, source string) error {
+	return s.users.SetSource(ctx, userID, source)
+}
+
+// SetBlocked marks the user as having blocked the bot, or active again. It reports
+// whether the stored state changed.
+func (s *UserService) SetBlocked(ctx context.Context, telegramID int64, blocked bool) (bool, error) {
+	return s.users.SetBlocked(ctx, telegramID, blocked)
+}
+
+func (s *UserService) FindByTelegramID(ctx context.Context, telegramID int64) (*models.User, error) {
+	return s.users.FindByTelegramID(ctx, telegramID)
+}
+
+// Ban applies a full or generation-only ban; until is optional and must be in the future.
+func (s *UserService) Ban(ctx context.Context, userID int64, status models.BanStatus, reason string, until *time.Time) (*models.User, error) {
+	if status != models.BanFull && status != models.BanGeneration {
+		return nil, fmt.Errorf("%w: status must be banned or generation", ErrBanInvalid)
+	}
+	if until != nil && !until.After(time.Now()) {
+		return nil, fmt.Errorf("%w: until must be in the future", ErrBanInvalid)
+	}
+	return s.setBan(ctx, userID, status, strings.TrimSpace(reason), until)
+}
+
+func (s *UserService) Unban(ctx context.Context, userID int64) (*models.User, error) {
+	return s.setBan(ctx, userID, models.BanNone, "", nil)
+}
+
+func (s *UserService) setBan(ctx context.Context, userID int64, status models.BanStatus, reason string, until *time.Time) (*models.User, error) {
+	user, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if err := s.users.SetBan(ctx, userID, status, reason, until); err != nil {
+		return nil, err
+	}
+	return s.users.FindByID(ctx, userID)
+}
+
+func (s *UserService) Search(ctx context.Context, query string, limit, offset int) ([]models.User, error) {
+	users, err := s.users.Search(ctx, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("search users: %w", err)
+	}
+	return users, nil
+}
+
+// Details returns nil when the user does not exist.
+func (s *UserService) Details(ctx context.Context, userID int64) (*UserDetails, error) {
+	user, err := s.users.FindByID(ctx, userID)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	generations, err := s.generations.ListByUser(ctx, userID, userDetailsHistory)
+	if err != nil {
+		return nil, err
+	}
+	payments, err := s.payments.ListByUser(ctx, userID, userDetailsHistory)
+	if err != nil {
+		return nil, err
+	}
+	adjustments, err := s.users.ListCreditAdjustments(ctx, userID, userDetailsHistory)
+	if err != nil {
+		return nil, err
+	}
+	return &UserDetails{User: user, Generations: generations, Payments: payments, Adjustments: adjustments}, nil
+}
+
+// AdjustCredits manually changes a promo or paid balance and returns the updated user
+// and the change actually applied: removing more credits than the user has only takes
+// the balance down to zero.
+func (s *UserService) AdjustCredits(ctx context.Context, userID int64, input AdjustCreditsInput) (*models.User, int, error) {
+	input.Reason = strings.TrimSpace(input.Reason)
+	if input.Reason == "" {
+		return nil, 0, ErrAdjustmentReasonRequired
+	}
+	if input.Delta == 0 {
+		return nil, 0, fmt.Errorf("%w: delta must be non-zero", ErrAdjustmentInvalid)
+	}
+	if input.CreditType != models.CostTypePromo && input.CreditType != models.CostTypePaid {
+		return nil, 0, fmt.Errorf("%w: credit_type must be promo or paid", ErrAdjustmentInvalid)
+	}
+
+	adj := &models.CreditAdjustment{
+		UserID:     userID,
+		CreditType: input.CreditType,
+		Delta:      input.Delta,
+		Reason:     input.Reason,
+		Actor:      input.Actor,
+	}
+	if err := s.users.AdjustCredits(ctx, adj); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, 0, ErrUserNotFound
+		}
+		return nil, 0, err
+	}
+	user, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return user, adj.Delta, nil
+}
@@ -0,0 +1,41 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/repository/memory"
+	"github.com/digkill/TGStickerBot/internal/service"
+)
+
+func TestAdjustCreditsReportsAppliedDelta(t *testing.T) {
+	ctx := context.Background()
+	db := memory.New()
+	svc := service.NewUserService(db.Users(), db.Generations(), db.Payments())
+	user := seedUser(t, db, 100, 7, 0)
+
+	for _, tc := range []struct {
+		delta, applied, balance int
+	}{
+		{5, 5, 12},
+		{-20, -12, 0},
+	} {
+		updated, applied, err := svc.AdjustCredits(ctx, user.ID, service.AdjustCreditsInput{CreditType: models.CostTypePromo, Delta: tc.delta, Reason: "support", Actor: "admin"})
+		if err != nil {
+			t.Fatalf("AdjustCredits(%d): %v", tc.delta, err)
+		}
+		if applied != tc.applied || updated.PromoCredits != tc.balance {
+			t.Errorf("AdjustCredits(%d) applied %d, balance %d; want %d, %d", tc.delta, applied, updated.PromoCredits, tc.applied, tc.balance)
+		}
+	}
+
+	history, err := db.Users().ListCreditAdjustments(ctx, user.ID, 10)
+	if err != nil || len(history) != 2 || history[0].Delta != -12 {
+		t.Errorf("history = %+v, %v; want the applied -12 last", history, err)
+	}
+	if _, _, err := svc.AdjustCredits(ctx, 999, service.AdjustCreditsInput{CreditType: models.CostTypePromo, Delta: 1, Reason: "support"}); !errors.Is(err, service.ErrUserNotFound) {
+		t.Errorf("unknown user err = %v, want ErrUserNotFound", err)
+	}
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// localName is a stored file: an optional keepPrefix, 256 random bits in hex and an
+// extension.
+var localName = regexp.MustCompile(`^(keep-)?[0-9a-f]{64}\.[a-z]+$`)
+
+// tempPrefix marks files still being written; they are never served.
+const tempPrefix = ".upload-"
+
+// keepPrefix marks files uploaded through Persistent; they never expire.
+const keepPrefix = "keep-"
+
+// LocalConfig configures Local. PublicBaseURL is where its handler is reachable from
+// outside; the admin server mounts it at /files.
+type LocalConfig struct {
+	Dir           string
+	PublicBaseURL string
+	TTL           time.Duration
+}
+
+// Local keeps uploads in a directory and serves them over HTTP, for deployments without
+// S3. A URL is unguessable rather than secret: anyone holding it (KIE, Telegram) can
+// fetch the file until it is TTL old. Run deletes expired files.
+type Local struct {
+	cfg  LocalConfig
+	log  *slog.Logger
+	keep bool
+}
+
+func NewLocal(cfg LocalConfig, log *slog.Logger) (*Local, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("local storage dir is required")
+	}
+	if cfg.PublicBaseURL == "" {
+		return nil, fmt.Errorf("local storage public base url is required")
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 7 * 24 * time.Hour
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create local storage dir: %w", err)
+	}
+	return &Local{cfg: cfg, log: log}, nil
+}
+
+// Persistent returns a store sharing l's directory and handler whose uploads never
+// expire. Broadcast attachments need it: a scheduled or recurring broadcast may fetch
+// its media by URL long after the TTL.
+func (l *Local) Persistent() *Local {
+	return &Local{cfg: l.cfg, log: l.log, keep: true}
+}
+
+func (l *Local) Upload(ctx context.Context, data []byte, contentType string) (string, error) {
+	if len(data) == 0 {
+		return "", fmt.Errorf("no data to upload")
+	}
+	if contentType == "" {
+		contentType = "image/jpeg"
+	}
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("generate file name: %w", err)
+	}
+	name := hex.EncodeToString(token) + extensionFromContentType(contentType)
+	if l.keep {
+		name = keepPrefix + name
+	}
+
+	// Write to a temporary file and rename it, so a half-written file is never served.
+	tmp, err := os.CreateTemp(l.cfg.Dir, tempPrefix+"*")
+	if err != nil {
+		return "", fmt.Errorf("create file: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(l.cfg.Dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("write file: %w", err)
+	}
+	return strings.TrimRight(l.cfg.PublicBaseURL, "/") + "/" + name, nil
+}
+
+// ServeHTTP serves a stored file, persistent or not, by the last element of the request
+// path. Unknown, malformed and expired names are all plain 404s.
+func (l *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	if !localName.MatchString(name) {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(filepath.Join(l.cfg.Dir, name))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	left := l.cfg.TTL
+	if !strings.HasPrefix(name, keepPrefix) {
+		left -= time.Since(info.ModTime())
+	}
+	if left <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeFromExtension(path.Ext(name)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(left.Seconds())))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// Run deletes expired files every hour until ctx is done.
+func (l *Local) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		removed, err := l.sweep(time.Now())
+		if err != nil {
+			l.log.Error("local storage cleanup", "err", err)
+		} else if removed > 0 {
+			l.log.Info("local storage cleanup", "removed", removed)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep removes files, including abandoned temporary ones, older than the TTL at now.
+// Persistent files are left alone.
+func (l *Local) sweep(now time.Time) (int, error) {
+	entries, err := os.ReadDir(l.cfg.Dir)
+	if err != nil {
+		return 0, fmt.Errorf("list local storage: %w", err)
+	}
+	removed := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, keepPrefix) || (!localName.MatchString(name) && !strings.HasPrefix(name, tempPrefix)) {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return removed, fmt.Errorf("stat %s: %w", name, err)
+		}
+		if now.Sub(info.ModTime()) < l.cfg.TTL {
+			continue
+		}
+		if err := os.Remove(filepath.Join(l.cfg.Dir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, fmt.Errorf("remove %s: %w", name, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
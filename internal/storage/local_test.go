@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newLocal(t *testing.T) *Local {
+	t.Helper()
+	local, err := NewLocal(LocalConfig{
+		Dir:           filepath.Join(t.TempDir(), "files"),
+		PublicBaseURL: "https://bot.example/files/",
+		TTL:           time.Hour,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return local
+}
+
+func get(local *Local, url string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	local.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	return rec
+}
+
+func TestLocalUploadAndServe(t *testing.T) {
+	local := newLocal(t)
+	data := []byte("\x89PNG\r\n\x1a\nfake image")
+
+	url, err := local.Upload(context.Background(), data, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := path.Base(url)
+	if !strings.HasPrefix(url, "https://bot.example/files/") || !localName.MatchString(name) || path.Ext(name) != ".png" {
+		t.Fatalf("url = %q, want a random .png name under the public base", url)
+	}
+	other, err := local.Upload(context.Background(), data, "image/png")
+	if err != nil || other == url {
+		t.Fatalf("second upload = %q, %v; want a different url", other, err)
+	}
+
+	rec := get(local, "/files/"+name)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), data) {
+		t.Fatalf("GET = %d %q", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("content type = %q", ct)
+	}
+	if rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Error("missing nosniff")
+	}
+}
+
+func TestLocalRejectsUnknownNames(t *testing.T) {
+	local := newLocal(t)
+	url, err := local.Upload(context.Background(), []byte("data"), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := path.Base(url)
+	// A file that exists in the directory but was not written by Upload.
+	if err := os.WriteFile(filepath.Join(local.cfg.Dir, "notes.txt"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{
+		"/files/notes.txt",
+		"/files/" + strings.Repeat("0", 64) + ".jpg",
+		"/files/" + strings.ToUpper(name),
+		"/files/../notes.txt",
+	} {
+		if rec := get(local, p); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", p, rec.Code)
+		}
+	}
+}
+
+func TestLocalExpiry(t *testing.T) {
+	local := newLocal(t)
+	ctx := context.Background()
+	oldURL, err := local.Upload(ctx, []byte("old"), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	freshURL, err := local.Upload(ctx, []byte("fresh"), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPath := filepath.Join(local.cfg.Dir, path.Base(oldURL))
+	past := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(oldPath, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := get(local, "/files/"+path.Base(oldURL)); rec.Code != http.StatusNotFound {
+		t.Errorf("expired file = %d, want 404", rec.Code)
+	}
+
+	removed, err := local.sweep(time.Now())
+	if err != nil || removed != 1 {
+		t.Fatalf("sweep = %d, %v; want 1 removed", removed, err)
+	}
+	if _, err := os.Stat(oldPath); !os.IsNotExist(err) {
+		t.Errorf("expired file still on disk: %v", err)
+	}
+	if rec := get(local, "/files/"+path.Base(freshURL)); rec.Code != http.StatusOK {
+		t.Errorf("fresh file = %d, want 200", rec.Code)
+	}
+}
+
+func TestLocalPersistentNeverExpires(t *testing.T) {
+	local := newLocal(t)
+	url, err := local.Persistent().Upload(context.Background(), []byte("broadcast"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := path.Base(url)
+	if !strings.HasPrefix(url, "https://bot.example/files/") || !localName.MatchString(name) {
+		t.Fatalf("url = %q, want a name served by the same handler", url)
+	}
+	past := time.Now().Add(-30 * 24 * time.Hour)
+	if err := os.Chtimes(filepath.Join(local.cfg.Dir, name), past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	if removed, err := local.sweep(time.Now()); err != nil || removed != 0 {
+		t.Fatalf("sweep = %d, %v; want the persistent file kept", removed, err)
+	}
+	if rec := get(local, "/files/"+name); rec.Code != http.StatusOK || rec.Body.String() != "broadcast" {
+		t.Errorf("GET persistent file = %d %q, want 200", rec.Code, rec.Body.String())
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/google/uuid"
+
+	"github.com/digkill/TGStickerBot/internal/metrics"
 )
 
 type Config struct {
@@ -74,6 +76,7 @@ func (u *Uploader) Upload(ctx context.Context, data []byte, contentType string)
 	}
 
 	key := u.generateKey(contentType)
+	start := time.Now()
 	_, err := u.client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:      aws.String(u.cfg.Bucket),
 		Key:         aws.String(key),
@@ -81,9 +84,11 @@ func (u *Uploader) Upload(ctx context.Context, data []byte, contentType string)
 		ContentType: aws.String(contentType),
 		ACL:         types.ObjectCannedACLPublicRead,
 	})
+	metrics.S3UploadDuration.WithLabelValues(metrics.Outcome(err)).Observe(time.Since(start).Seconds())
 	if err != nil {
 		return "", fmt.Errorf("upload to s3: %w", err)
 	}
+	metrics.S3UploadBytes.Observe(float64(len(data)))
 	return strings.TrimRight(u.cfg.PublicBaseURL, "/") + "/" + key, nil
 }
 
@@ -103,7 +108,33 @@ func extensionFromContentType(contentType string) string {
 		return ".jpg"
 	case "image/webp":
 		return ".webp"
+	case "image/gif":
+		return ".gif"
+	case "application/pdf":
+		return ".pdf"
+	case "application/zip":
+		return ".zip"
 	default:
 		return ".bin"
 	}
 }
+
+// contentTypeFromExtension is the inverse of extensionFromContentType.
+func contentTypeFromExtension(ext string) string {
+	switch ext {
+	case ".png":
+		return "image/png"
+	case ".jpg":
+		return "image/jpeg"
+	case ".webp":
+		return "image/webp"
+	case ".gif":
+		return "image/gif"
+	case ".pdf":
+		return "application/pdf"
+	case ".zip":
+		return "application/zip"
+	default:
+		return "application/octet-stream"
+	}
+}
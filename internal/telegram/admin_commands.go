@@ -0,0 +1,414 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/service"
+)
+
+const (
+	callbackBroadcastConfirm = "admin_broadcast_confirm"
+	callbackBroadcastCancel  = "admin_broadcast_cancel"
+	defaultStatsDays         = 7
+	maxUserMatches           = 5
+)
+
+const adminHelp = `Команды администратора:
+/stats [дней] — сводка за период (по умолчанию 7 дней)
+/user <id|telegram id|@username> — карточка пользователя
+/addcredits <id> <±n> [paid] <причина> — начислить или списать кредиты (по умолчанию промо)
+/ban <id> [generation] [дней] <причина> — бан (generation — только генерация)
+/unban <id> — снять бан
+/promo_create <КОД> <кредиты> <активаций> [дней] — промокод на кредиты
+/broadcast <текст> — рассылка всем (с подтверждением)`
+
+// isAdmin reports whether the sender is in ADMIN_TELEGRAM_IDS. Admin commands only
+// work in the private chat with the bot, so user data never leaks into groups.
+func (b *Bot) isAdmin(msg *tgbotapi.Message) bool {
+	return msg.From != nil && msg.Chat.IsPrivate() && b.adminIDs[msg.From.ID]
+}
+
+// handleAdminCommand runs a hidden admin command and reports whether msg was one.
+// The commands call the same services as the admin HTTP API.
+func (b *Bot) handleAdminCommand(ctx context.Context, msg *tgbotapi.Message) bool {
+	var err error
+	switch msg.Command() {
+	case "admin":
+		b.sendText(msg.Chat.ID, adminHelp)
+		return true
+	case "stats":
+		err = b.adminStats(ctx, msg)
+	case "user":
+		err = b.adminUser(ctx, msg)
+	case "addcredits":
+		err = b.adminAddCredits(ctx, msg)
+	case "ban":
+		err = b.adminBan(ctx, msg)
+	case "unban":
+		err = b.adminUnban(ctx, msg)
+	case "promo_create":
+		err = b.adminPromoCreate(ctx, msg)
+	case "broadcast":
+		err = b.adminBroadcast(ctx, msg)
+	default:
+		return false
+	}
+	if err != nil {
+		b.sendText(msg.Chat.ID, "Ошибка: "+err.Error())
+	}
+	return true
+}
+
+func adminActor(from *tgbotapi.User) string {
+	if from.UserName != "" {
+		return fmt.Sprintf("tg:%d:@%s", from.ID, from.UserName)
+	}
+	return fmt.Sprintf("tg:%d", from.ID)
+}
+
+// audit records a mutating admin command in the same log as the admin HTTP API.
+func (b *Bot) audit(ctx context.Context, from *tgbotapi.User, command, text string, err error) {
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusBadRequest
+	}
+	entry := &models.AuditEntry{
+		Actor:  adminActor(from),
+		Method: "BOT",
+		Path:   "/" + command,
+		Status: status,
+		Body:   text,
+	}
+	if err := b.admins.RecordAudit(context.WithoutCancel(ctx), entry); err != nil {
+		b.log.Error("admin audit log", "err", err, "command", command)
+	}
+}
+
+func (b *Bot) adminStats(ctx context.Context, msg *tgbotapi.Message) error {
+	days := defaultStatsDays
+	if arg := strings.TrimSpace(msg.CommandArguments()); arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 || n > 366 {
+			return errors.New("укажите число дней от 1 до 366")
+		}
+		days = n
+	}
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -days)
+	overview, err := b.stats.Overview(ctx, from, to)
+	if err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Статистика за %d дн.\n", days)
+	fmt.Fprintf(&sb, "Активные пользователи: %d\n", overview.ActiveUsers)
+	fmt.Fprintf(&sb, "Регистрации: %d\n", overview.Signups)
+	generations := 0
+	for _, g := range overview.Generations {
+		generations += g.Count
+	}
+	fmt.Fprintf(&sb, "Генерации: %d\n", generations)
+	for _, g := range overview.Generations {
+		fmt.Fprintf(&sb, "  %s / %s: %d\n", g.Model, g.CostType, g.Count)
+	}
+	if len(overview.Revenue) == 0 {
+		sb.WriteString("Выручка: 0\n")
+	}
+	for _, rev := range overview.Revenue {
+		fmt.Fprintf(&sb, "Выручка %s (%s): %s, платежей %d\n", rev.Currency, rev.Provider, formatAmount(rev.Amount), rev.Payments)
+	}
+	fmt.Fprintf(&sb, "Активации промокодов: %d\n", overview.PromoRedemptions)
+	fmt.Fprintf(&sb, "Бонусы за подписку: %d\n", overview.SubscriptionBonusGrants)
+	fmt.Fprintf(&sb, "Заблокировали бота: %d (за период %d)", overview.BlockedUsers, overview.NewlyBlocked)
+	b.sendText(msg.Chat.ID, sb.String())
+	return nil
+}
+
+func (b *Bot) adminUser(ctx context.Context, msg *tgbotapi.Message) error {
+	query := strings.TrimSpace(msg.CommandArguments())
+	if query == "" {
+		return errors.New("использование: /user <id|telegram id|@username>")
+	}
+	users, err := b.users.Search(ctx, query, maxUserMatches+1, 0)
+	if err != nil {
+		return err
+	}
+	switch len(users) {
+	case 0:
+		return service.ErrUserNotFound
+	case 1:
+	default:
+		var sb strings.Builder
+		sb.WriteString("Найдено несколько пользователей:\n")
+		for i, u := range users {
+			if i == maxUserMatches {
+				sb.WriteString("…")
+				break
+			}
+			fmt.Fprintf(&sb, "#%d tg %d %s\n", u.ID, u.TelegramID, userLabel(&u))
+		}
+		b.sendText(msg.Chat.ID, sb.String())
+		return nil
+	}
+
+	details, err := b.users.Details(ctx, users[0].ID)
+	if err != nil {
+		return err
+	}
+	if details == nil {
+		return service.ErrUserNotFound
+	}
+	u := details.User
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "#%d · tg %d · %s\n", u.ID, u.TelegramID, userLabel(u))
+	fmt.Fprintf(&sb, "Кредиты: промо %d, платные %d\n", u.PromoCredits, u.PaidCredits)
+	fmt.Fprintf(&sb, "Регистрация: %s", u.CreatedAt.Format("02.01.2006"))
+	if u.Source != "" {
+		fmt.Fprintf(&sb, ", источник %s", u.Source)
+	}
+	sb.WriteString("\n")
+	if ban := u.ActiveBan(time.Now()); ban != models.BanNone {
+		fmt.Fprintf(&sb, "Бан: %s", ban)
+		if u.BanReason != "" {
+			fmt.Fprintf(&sb, " (%s)", u.BanReason)
+		}
+		if u.BannedUntil != nil {
+			fmt.Fprintf(&sb, " до %s", u.BannedUntil.Format("02.01.2006 15:04"))
+		}
+		sb.WriteString("\n")
+	}
+	if u.BlockedAt != nil {
+		fmt.Fprintf(&sb, "Заблокировал бота: %s\n", u.BlockedAt.Format("02.01.2006 15:04"))
+	}
+	fmt.Fprintf(&sb, "Последние генерации: %d, платежи: %d", len(details.Generations), len(details.Payments))
+	if len(details.Generations) > 0 {
+		fmt.Fprintf(&sb, "\nПоследняя генерация: %s", details.Generations[0].CreatedAt.Format("02.01.2006 15:04"))
+	}
+	for _, adj := range details.Adjustments {
+		fmt.Fprintf(&sb, "\n%s %+d %s — %s (%s)", adj.CreatedAt.Format("02.01.2006"), adj.Delta, adj.CreditType, adj.Reason, adj.Actor)
+	}
+	b.sendText(msg.Chat.ID, sb.String())
+	return nil
+}
+
+func (b *Bot) adminAddCredits(ctx context.Context, msg *tgbotapi.Message) (err error) {
+	defer func() { b.audit(ctx, msg.From, msg.Command(), msg.Text, err) }()
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) < 3 {
+		return errors.New("использование: /addcredits <id> <±n> [paid] <причина>")
+	}
+	user, err := b.resolveUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	delta, err := strconv.Atoi(args[1])
+	if err != nil {
+		return errors.New("количество кредитов должно быть целым числом")
+	}
+	creditType := models.CostTypePromo
+	rest := args[2:]
+	if len(rest) > 1 && (rest[0] == string(models.CostTypePaid) || rest[0] == string(models.CostTypePromo)) {
+		creditType = models.CostType(rest[0])
+		rest = rest[1:]
+	}
+	updated, applied, err := b.users.AdjustCredits(ctx, user.ID, service.AdjustCreditsInput{
+		CreditType: creditType,
+		Delta:      delta,
+		Reason:     strings.Join(rest, " "),
+		Actor:      adminActor(msg.From),
+	})
+	if err != nil {
+		return err
+	}
+	b.sendText(msg.Chat.ID, fmt.Sprintf("Готово. #%d: изменено на %+d, промо %d, платные %d", updated.ID, applied, updated.PromoCredits, updated.PaidCredits))
+	return nil
+}
+
+func (b *Bot) adminBan(ctx context.Context, msg *tgbotapi.Message) (err error) {
+	defer func() { b.audit(ctx, msg.From, msg.Command(), msg.Text, err) }()
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) < 1 {
+		return errors.New("использование: /ban <id> [generation] [дней] <причина>")
+	}
+	user, err := b.resolveUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	status := models.BanFull
+	rest := args[1:]
+	if len(rest) > 0 && rest[0] == string(models.BanGeneration) {
+		status = models.BanGeneration
+		rest = rest[1:]
+	}
+	var until *time.Time
+	if len(rest) > 0 {
+		if days, err := strconv.Atoi(rest[0]); err == nil && days > 0 {
+			t := time.Now().UTC().AddDate(0, 0, days)
+			until = &t
+			rest = rest[1:]
+		}
+	}
+	updated, err := b.users.Ban(ctx, user.ID, status, strings.Join(rest, " "), until)
+	if err != nil {
+		return err
+	}
+	b.log.Info("user banned", "user_id", updated.ID, "status", status, "actor", adminActor(msg.From))
+	b.sendText(msg.Chat.ID, fmt.Sprintf("Пользователь #%d забанен (%s).", updated.ID, status))
+	return nil
+}
+
+func (b *Bot) adminUnban(ctx context.Context, msg *tgbotapi.Message) (err error) {
+	defer func() { b.audit(ctx, msg.From, msg.Command(), msg.Text, err) }()
+	arg := strings.TrimSpace(msg.CommandArguments())
+	if arg == "" {
+		return errors.New("использование: /unban <id>")
+	}
+	user, err := b.resolveUser(ctx, arg)
+	if err != nil {
+		return err
+	}
+	if _, err := b.users.Unban(ctx, user.ID); err != nil {
+		return err
+	}
+	b.log.Info("user unbanned", "user_id", user.ID, "actor", adminActor(msg.From))
+	b.sendText(msg.Chat.ID, fmt.Sprintf("Бан пользователя #%d снят.", user.ID))
+	return nil
+}
+
+func (b *Bot) adminPromoCreate(ctx context.Context, msg *tgbotapi.Message) (err error) {
+	defer func() { b.audit(ctx, msg.From, msg.Command(), msg.Text, err) }()
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) < 3 || len(args) > 4 {
+		return errors.New("использование: /promo_create <КОД> <кредиты> <активаций> [дней]")
+	}
+	credits, err := strconv.Atoi(args[1])
+	if err != nil || credits <= 0 {
+		return errors.New("кредиты должны быть положительным числом")
+	}
+	maxUses, err := strconv.Atoi(args[2])
+	if err != nil || maxUses <= 0 {
+		return errors.New("число активаций должно быть положительным")
+	}
+	input := service.CreatePromoInput{
+		Code:         args[0],
+		Kind:         models.PromoKindCredits,
+		MaxUses:      maxUses,
+		BonusCredits: credits,
+	}
+	if len(args) == 4 {
+		days, err := strconv.Atoi(args[3])
+		if err != nil || days <= 0 {
+			return errors.New("срок действия — положительное число дней")
+		}
+		until := time.Now().UTC().AddDate(0, 0, days)
+		input.ValidUntil = &until
+	}
+	promo, err := b.promo.Create(ctx, input)
+	if err != nil {
+		return err
+	}
+	b.sendText(msg.Chat.ID, fmt.Sprintf("Промокод %s создан: %d кредитов, %d активаций.", promo.Code, promo.BonusCredits, promo.MaxUses))
+	return nil
+}
+
+// adminBroadcast keeps the text as a draft and asks for confirmation with the current
+// audience size; nothing is sent until the admin presses the button.
+func (b *Bot) adminBroadcast(ctx context.Context, msg *tgbotapi.Message) error {
+	text := strings.TrimSpace(msg.CommandArguments())
+	if text == "" {
+		return errors.New("использование: /broadcast <текст>")
+	}
+	audience, err := b.broadcasts.AudienceSize(ctx, models.AudienceFilter{})
+	if err != nil {
+		return err
+	}
+	session := b.state.Get(msg.Chat.ID)
+	session.BroadcastDraft = text
+	b.state.Set(msg.Chat.ID, session)
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Рассылка для %d пользователей:\n\n%s", audience, text))
+	reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Отправить", callbackBroadcastConfirm),
+		tgbotapi.NewInlineKeyboardButtonData("Отмена", callbackBroadcastCancel),
+	))
+	if _, err := b.api.Send(reply); err != nil {
+		b.sendFailed(msg.Chat.ID, "send broadcast preview", err)
+	}
+	return nil
+}
+
+// handleBroadcastDecision handles the confirm and cancel buttons of adminBroadcast.
+func (b *Bot) handleBroadcastDecision(ctx context.Context, cb *tgbotapi.CallbackQuery) {
+	chatID := cb.Message.Chat.ID
+	session := b.state.Get(chatID)
+	draft := session.BroadcastDraft
+	session.BroadcastDraft = ""
+	b.state.Set(chatID, session)
+
+	answer := "Рассылка отменена"
+	switch {
+	case !b.adminIDs[cb.From.ID]:
+		answer = "Недоступно"
+	case draft == "":
+		answer = "Черновик не найден, отправьте /broadcast ещё раз"
+	case cb.Data == callbackBroadcastConfirm:
+		progress, err := b.broadcasts.Create(ctx, service.BroadcastInput{
+			Content: models.BroadcastContent{Message: draft},
+			Actor:   adminActor(cb.From),
+		})
+		b.audit(ctx, cb.From, "broadcast", draft, err)
+		if err != nil {
+			b.log.Error("admin broadcast", "err", err)
+			answer = "Не удалось создать рассылку"
+			b.sendText(chatID, "Ошибка: "+err.Error())
+			break
+		}
+		answer = "Рассылка поставлена в очередь"
+		b.sendText(chatID, fmt.Sprintf("Рассылка #%d поставлена в очередь: %d получателей.", progress.ID, progress.Total))
+	}
+	if _, err := b.api.Request(tgbotapi.NewCallback(cb.ID, answer)); err != nil {
+		b.log.Error("callback ack", "err", err)
+	}
+}
+
+// resolveUser finds exactly one user by internal id, telegram id or @username.
+func (b *Bot) resolveUser(ctx context.Context, query string) (*models.User, error) {
+	users, err := b.users.Search(ctx, query, 2, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch len(users) {
+	case 0:
+		return nil, service.ErrUserNotFound
+	case 1:
+		return &users[0], nil
+	default:
+		return nil, fmt.Errorf("%q подходит нескольким пользователям, уточните через /user", query)
+	}
+}
+
+func userLabel(u *models.User) string {
+	name := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	if u.Username != "" {
+		name = strings.TrimSpace("@" + u.Username + " " + name)
+	}
+	if name == "" {
+		return "без имени"
+	}
+	return name
+}
+
+// formatAmount renders minor currency units as "1234.56".
+func formatAmount(minor int) string {
+	return fmt.Sprintf("%d.%02d", minor/100, minor%100)
+}
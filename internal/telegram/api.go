@@ -0,0 +1,36 @@
+package telegram
+
+import (
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/digkill/TGStickerBot/internal/config"
+)
+
+// DefaultAPIURL is the public Bot API server.
+const DefaultAPIURL = "https://api.telegram.org"
+
+// NewAPI connects to the Bot API server at cfg.TelegramAPIURL, which may be a
+// self-hosted telegram-bot-api instance.
+func NewAPI(cfg config.Config) (*tgbotapi.BotAPI, error) {
+	return tgbotapi.NewBotAPIWithAPIEndpoint(cfg.BotToken, apiEndpoint(cfg.TelegramAPIURL))
+}
+
+// apiEndpoint is the method URL format (token, method) for a Bot API server.
+func apiEndpoint(baseURL string) string {
+	return apiBaseURL(baseURL) + "/bot%s/%s"
+}
+
+// fileEndpoint is the download URL format (token, file path) for a Bot API server.
+func fileEndpoint(baseURL string) string {
+	return apiBaseURL(baseURL) + "/file/bot%s/%s"
+}
+
+func apiBaseURL(raw string) string {
+	raw = strings.TrimRight(strings.TrimSpace(raw), "/")
+	if raw == "" {
+		return DefaultAPIURL
+	}
+	return raw
+}
@@ -7,6 +7,8 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"os"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -14,13 +16,22 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
 	"github.com/digkill/TGStickerBot/internal/config"
+	"github.com/digkill/TGStickerBot/internal/metrics"
 	"github.com/digkill/TGStickerBot/internal/models"
 	"github.com/digkill/TGStickerBot/internal/service"
 )
 
 const maxReferenceImages = 8
 
+// maxSourceTagLen matches the users.source column width.
+const maxSourceTagLen = 64
+
+// maxReferenceBytes caps a reference image. It matches the 20 MB the public Bot API
+// lets bots download; a local server would otherwise hand over files of any size.
+const maxReferenceBytes = 20 << 20
+
 var errReferenceNotImage = errors.New("reference not image")
+var errReferenceTooLarge = errors.New("reference too large")
 
 type ImageStorage interface {
 	Upload(ctx context.Context, data []byte, contentType string) (string, error)
@@ -34,15 +45,21 @@ type Bot struct {
 	generation                  *service.GenerationService
 	promo                       *service.PromoService
 	payments                    *service.PaymentService
+	referrals                   *service.ReferralService
+	stats                       *service.StatsService
+	broadcasts                  *service.BroadcastService
+	admins                      *service.AdminService
+	adminIDs                    map[int64]bool
 	storage                     ImageStorage
 	state                       *StateManager
 	httpClient                  *http.Client
+	fileEndpoint                string
 	subscriptionChannelUsername string
 	subscriptionChannelID       int64
 	subscriptionChannelLink     string
 }
 
-func NewBot(cfg config.Config, api *tgbotapi.BotAPI, log *slog.Logger, users *service.UserService, generation *service.GenerationService, promo *service.PromoService, payments *service.PaymentService, storage ImageStorage) *Bot {
+func NewBot(cfg config.Config, api *tgbotapi.BotAPI, log *slog.Logger, users *service.UserService, generation *service.GenerationService, promo *service.PromoService, payments *service.PaymentService, referrals *service.ReferralService, stats *service.StatsService, broadcasts *service.BroadcastService, admins *service.AdminService, storage ImageStorage) *Bot {
 	username := strings.TrimSpace(cfg.SubscriptionChannelUsername)
 	var channelID int64
 	if cfg.SubscriptionChannelID != 0 {
@@ -58,6 +75,14 @@ func NewBot(cfg config.Config, api *tgbotapi.BotAPI, log *slog.Logger, users *se
 		link = fmt.Sprintf("https://t.me/%s", username)
 	}
 
+	state := NewStateManager()
+	metrics.RegisterActiveSessions(state.Active)
+
+	adminIDs := make(map[int64]bool, len(cfg.AdminTelegramIDs))
+	for _, id := range cfg.AdminTelegramIDs {
+		adminIDs[id] = true
+	}
+
 	return &Bot{
 		cfg:                         cfg,
 		api:                         api,
@@ -66,9 +91,15 @@ func NewBot(cfg config.Config, api *tgbotapi.BotAPI, log *slog.Logger, users *se
 		generation:                  generation,
 		promo:                       promo,
 		payments:                    payments,
+		referrals:                   referrals,
+		stats:                       stats,
+		broadcasts:                  broadcasts,
+		admins:                      admins,
+		adminIDs:                    adminIDs,
 		storage:                     storage,
-		state:                       NewStateManager(),
+		state:                       state,
 		httpClient:                  &http.Client{Timeout: 60 * time.Second},
+		fileEndpoint:                fileEndpoint(cfg.TelegramAPIURL),
 		subscriptionChannelUsername: username,
 		subscriptionChannelID:       channelID,
 		subscriptionChannelLink:     link,
@@ -78,6 +109,8 @@ func NewBot(cfg config.Config, api *tgbotapi.BotAPI, log *slog.Logger, users *se
 func (b *Bot) Run(ctx context.Context) error {
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
+	// my_chat_member tells us when a user blocks or unblocks the bot.
+	u.AllowedUpdates = []string{"message", "callback_query", "pre_checkout_query", "my_chat_member"}
 
 	updates := b.api.GetUpdatesChan(u)
 	b.log.Info("telegram bot started")
@@ -85,14 +118,17 @@ func (b *Bot) Run(ctx context.Context) error {
 	for {
 		select {
 		case update := <-updates:
+			metrics.TelegramUpdates.WithLabelValues(updateType(update)).Inc()
 			if update.Message != nil {
 				b.handleMessage(ctx, update.Message)
 			} else if update.CallbackQuery != nil {
 				b.handleCallback(ctx, update.CallbackQuery)
 			} else if update.PreCheckoutQuery != nil {
-				if err := b.payments.HandlePreCheckout(b.api, update.PreCheckoutQuery); err != nil {
+				if err := b.payments.HandlePreCheckout(ctx, b.api, update.PreCheckoutQuery); err != nil {
 					b.log.Error("pre-checkout failed", "err", err)
 				}
+			} else if update.MyChatMember != nil {
+				b.handleMyChatMember(ctx, update.MyChatMember)
 			}
 		case <-ctx.Done():
 			b.api.StopReceivingUpdates()
@@ -101,17 +137,80 @@ func (b *Bot) Run(ctx context.Context) error {
 	}
 }
 
+func updateType(update tgbotapi.Update) string {
+	switch {
+	case update.Message != nil:
+		return "message"
+	case update.CallbackQuery != nil:
+		return "callback_query"
+	case update.PreCheckoutQuery != nil:
+		return "pre_checkout_query"
+	case update.MyChatMember != nil:
+		return "my_chat_member"
+	default:
+		return "other"
+	}
+}
+
+// handleMyChatMember tracks users blocking ("kicked") and unblocking ("member") the bot
+// in their private chat, so broadcasts skip them while blocked.
+func (b *Bot) handleMyChatMember(ctx context.Context, upd *tgbotapi.ChatMemberUpdated) {
+	if !upd.Chat.IsPrivate() {
+		return
+	}
+	var blocked bool
+	switch upd.NewChatMember.Status {
+	case "kicked":
+		blocked = true
+	case "member":
+		blocked = false
+	default:
+		return
+	}
+	b.setBlocked(ctx, upd.Chat.ID, blocked)
+}
+
+func (b *Bot) setBlocked(ctx context.Context, telegramID int64, blocked bool) {
+	changed, err := b.users.SetBlocked(ctx, telegramID, blocked)
+	if err != nil {
+		b.log.Error("set user blocked", "telegram_id", telegramID, "blocked", blocked, "err", err)
+		return
+	}
+	if changed {
+		b.log.Info("user block status changed", "telegram_id", telegramID, "blocked", blocked)
+	}
+}
+
+// sendFailed logs a failed send and marks the user inactive when Telegram reports the
+// bot was blocked.
+func (b *Bot) sendFailed(chatID int64, what string, err error) {
+	b.log.Error(what, "chat_id", chatID, "err", err)
+	if service.IsBotBlocked(err) {
+		b.setBlocked(context.Background(), chatID, true)
+	}
+}
+
 func (b *Bot) handleMessage(ctx context.Context, msg *tgbotapi.Message) {
 	if msg.SuccessfulPayment != nil {
 		b.handleSuccessfulPayment(ctx, msg)
 		return
 	}
 
+	if user := b.fullyBanned(ctx, msg.From); user != nil {
+		if msg.IsCommand() {
+			b.sendText(msg.Chat.ID, banText(user))
+		}
+		return
+	}
+
 	if len(msg.Photo) > 0 || msg.Document != nil {
 		if err := b.handleReferenceImage(ctx, msg); err != nil {
-			if errors.Is(err, errReferenceNotImage) {
+			switch {
+			case errors.Is(err, errReferenceNotImage):
 				b.sendText(msg.Chat.ID, "Это не изображение. Пришлите фото или картинку.")
-			} else {
+			case errors.Is(err, errReferenceTooLarge):
+				b.sendText(msg.Chat.ID, fmt.Sprintf("Файл слишком большой. Пришлите изображение до %d МБ.", maxReferenceBytes>>20))
+			default:
 				b.log.Error("reference upload failed", "err", err)
 				b.sendText(msg.Chat.ID, "Не удалось сохранить референс, попробуйте снова.")
 			}
@@ -139,7 +238,7 @@ func (b *Bot) handleSuccessfulPayment(ctx context.Context, msg *tgbotapi.Message
 		b.log.Error("ensure user payment", "err", err)
 		return
 	}
-	if err := b.payments.HandleSuccessfulPayment(ctx, user, msg.SuccessfulPayment); err != nil {
+	if err := b.payments.HandleSuccessfulPayment(ctx, b.api, user, msg.SuccessfulPayment); err != nil {
 		b.log.Error("process successful payment", "err", err)
 		return
 	}
@@ -147,22 +246,20 @@ func (b *Bot) handleSuccessfulPayment(ctx context.Context, msg *tgbotapi.Message
 }
 
 func (b *Bot) handleCommand(ctx context.Context, msg *tgbotapi.Message) {
+	if b.isAdmin(msg) && b.handleAdminCommand(ctx, msg) {
+		return
+	}
 	switch msg.Command() {
 	case "start":
+		b.handleStart(ctx, msg)
+	case "generate":
 		user, _, err := b.ensureUser(ctx, msg.From, msg.Chat.ID)
 		if err != nil {
 			b.log.Error("ensure user", "err", err)
 			return
 		}
-		b.tryGrantSubscriptionBonus(ctx, user, msg.From, msg.Chat.ID, true)
-		text := fmt.Sprintf(
-			"Привет, %s!\n\nГенерация стоит 5 кредитов за изображение. Добавь до %d референсов и отправь промпт.\n\nКоманды:\n/generate — начать генерацию\n/clearrefs — очистить референсы\n/promo <код> — активировать промокод\n/balance — проверить баланс\n/buy — купить кредиты\n/bonus — получить бонус за подписку",
-			user.FirstName, maxReferenceImages,
-		)
-		b.sendText(msg.Chat.ID, text)
-	case "generate":
-		if _, _, err := b.ensureUser(ctx, msg.From, msg.Chat.ID); err != nil {
-			b.log.Error("ensure user", "err", err)
+		if user.ActiveBan(time.Now()) != models.BanNone {
+			b.sendText(msg.Chat.ID, banText(user))
 			return
 		}
 		b.promptModelSelection(msg.Chat.ID)
@@ -171,18 +268,12 @@ func (b *Bot) handleCommand(ctx context.Context, msg *tgbotapi.Message) {
 	case "balance":
 		b.handleBalance(ctx, msg)
 	case "buy":
-		user, _, err := b.ensureUser(ctx, msg.From, msg.Chat.ID)
-		if err != nil {
-			b.log.Error("ensure user buy", "err", err)
-			return
-		}
-		if err := b.payments.SendInvoice(ctx, b.api, user, msg.Chat.ID); err != nil {
-			b.log.Error("send invoice", "err", err)
-			b.sendText(msg.Chat.ID, "Не удалось отправить счет. Попробуйте позже.")
-		}
+		b.handleBuy(ctx, msg.From, msg.Chat.ID, strings.TrimSpace(msg.CommandArguments()))
 	case "clearrefs":
 		b.state.ClearReferences(msg.Chat.ID)
 		b.sendText(msg.Chat.ID, "Референсы очищены.")
+	case "ref":
+		b.handleReferral(ctx, msg)
 	case "bonus":
 		user, _, err := b.ensureUser(ctx, msg.From, msg.Chat.ID)
 		if err != nil {
@@ -195,6 +286,95 @@ func (b *Bot) handleCommand(ctx context.Context, msg *tgbotapi.Message) {
 	}
 }
 
+func (b *Bot) handleStart(ctx context.Context, msg *tgbotapi.Message) {
+	user, created, err := b.ensureUser(ctx, msg.From, msg.Chat.ID)
+	if err != nil {
+		b.log.Error("ensure user", "err", err)
+		return
+	}
+	if user.BlockedAt != nil {
+		b.setBlocked(ctx, user.TelegramID, false)
+	}
+	// Deep links arrive as "/start <payload>", e.g. t.me/<bot>?start=ref_abc123.
+	payload := strings.TrimSpace(msg.CommandArguments())
+	switch {
+	case strings.HasPrefix(payload, "ref_"):
+		if created {
+			b.applyReferral(ctx, user, strings.TrimPrefix(payload, "ref_"), msg.Chat.ID)
+		}
+	case strings.HasPrefix(payload, "promo_"):
+		b.applyPromo(ctx, user, strings.TrimPrefix(payload, "promo_"), msg.Chat.ID)
+	case strings.HasPrefix(payload, "src_"):
+		if created {
+			b.recordSource(ctx, user, strings.TrimPrefix(payload, "src_"))
+		}
+	}
+
+	b.tryGrantSubscriptionBonus(ctx, user, msg.From, msg.Chat.ID, true)
+	text := fmt.Sprintf(
+		"Привет, %s!\n\nГенерация стоит 5 кредитов за изображение. Добавь до %d референсов и отправь промпт.\n\nКоманды:\n/generate — начать генерацию\n/clearrefs — очистить референсы\n/promo <код> — активировать промокод\n/balance — проверить баланс\n/buy [код] — купить кредиты (можно указать промокод на скидку)\n/bonus — получить бонус за подписку\n/ref — пригласить друзей",
+		user.FirstName, maxReferenceImages,
+	)
+	b.sendText(msg.Chat.ID, text)
+}
+
+func (b *Bot) applyReferral(ctx context.Context, user *models.User, code string, chatID int64) {
+	if _, err := b.referrals.Attribute(ctx, user, code); err != nil {
+		switch {
+		case errors.Is(err, service.ErrReferralInvalid), errors.Is(err, service.ErrReferralSelf), errors.Is(err, service.ErrReferralAlreadyAttributed):
+			b.log.Info("referral rejected", "user_id", user.ID, "code", code, "reason", err)
+		default:
+			b.log.Error("apply referral", "user_id", user.ID, "err", err)
+		}
+		return
+	}
+	if b.cfg.ReferralInviteeBonus > 0 {
+		b.sendText(chatID, fmt.Sprintf("Вы пришли по приглашению друга! +%d бонусных кредитов.", b.cfg.ReferralInviteeBonus))
+	}
+}
+
+// recordSource stores the ad channel a new user came from (first touch wins).
+func (b *Bot) recordSource(ctx context.Context, user *models.User, tag string) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return
+	}
+	if len(tag) > maxSourceTagLen {
+		tag = tag[:maxSourceTagLen]
+	}
+	if err := b.users.SetSource(ctx, user.ID, tag); err != nil {
+		b.log.Error("record source", "user_id", user.ID, "err", err)
+		return
+	}
+	user.Source = tag
+}
+
+func (b *Bot) handleReferral(ctx context.Context, msg *tgbotapi.Message) {
+	user, _, err := b.ensureUser(ctx, msg.From, msg.Chat.ID)
+	if err != nil {
+		b.log.Error("ensure user ref", "err", err)
+		return
+	}
+	code, err := b.referrals.Code(ctx, user)
+	if err != nil {
+		b.log.Error("referral code", "err", err)
+		b.sendText(msg.Chat.ID, "Не удалось получить ссылку, попробуйте позже.")
+		return
+	}
+	stats, err := b.referrals.Stats(ctx, user.ID)
+	if err != nil {
+		b.log.Error("referral stats", "err", err)
+		b.sendText(msg.Chat.ID, "Не удалось получить статистику, попробуйте позже.")
+		return
+	}
+	link := fmt.Sprintf("https://t.me/%s?start=ref_%s", b.api.Self.UserName, code)
+	text := fmt.Sprintf(
+		"Приглашайте друзей по ссылке:\n%s\n\nДруг получит +%d кредитов при регистрации, а вы — +%d, когда он совершит первую покупку.\n\nПриглашено: %d\nСовершили покупку: %d\nЗаработано кредитов: %d",
+		link, b.cfg.ReferralInviteeBonus, b.cfg.ReferralReferrerBonus, stats.Invited, stats.Paid, stats.EarnedCredits,
+	)
+	b.sendText(msg.Chat.ID, text)
+}
+
 func (b *Bot) handlePromo(ctx context.Context, msg *tgbotapi.Message) {
 	user, _, err := b.ensureUser(ctx, msg.From, msg.Chat.ID)
 	if err != nil {
@@ -210,19 +390,79 @@ func (b *Bot) handlePromo(ctx context.Context, msg *tgbotapi.Message) {
 		b.sendText(msg.Chat.ID, "Формат: /promo КОД")
 		return
 	}
-	if err := b.promo.Apply(ctx, user.ID, code, b.cfg.PromoBonusGenerations); err != nil {
-		switch err {
-		case service.ErrPromoInvalid:
-			b.sendText(msg.Chat.ID, "Промокод недействителен.")
-		case service.ErrPromoAlreadyRedeemed:
-			b.sendText(msg.Chat.ID, "Этот промокод уже использован.")
-		default:
+	b.applyPromo(ctx, user, code, msg.Chat.ID)
+}
+
+func (b *Bot) applyPromo(ctx context.Context, user *models.User, code string, chatID int64) {
+	activation, err := b.promo.Apply(ctx, user, code)
+	if err != nil {
+		text, ok := promoErrorText(err)
+		if !ok {
 			b.log.Error("apply promo", "err", err)
-			b.sendText(msg.Chat.ID, "Не удалось применить промокод, попробуйте позже.")
+			text = "Не удалось применить промокод, попробуйте позже."
 		}
+		b.sendText(chatID, text)
+		return
+	}
+	b.sendText(chatID, promoActivatedText(activation))
+}
+
+// promoErrorText maps promo validation errors to user-facing messages. It reports false
+// for unexpected errors that should be logged instead.
+func promoErrorText(err error) (string, bool) {
+	switch {
+	case errors.Is(err, service.ErrPromoInvalid):
+		return "Промокод недействителен.", true
+	case errors.Is(err, service.ErrPromoAlreadyRedeemed):
+		return "Этот промокод уже использован.", true
+	case errors.Is(err, service.ErrPromoExhausted):
+		return "Лимит активаций этого промокода исчерпан.", true
+	case errors.Is(err, service.ErrPromoDisabled):
+		return "Промокод отключён.", true
+	case errors.Is(err, service.ErrPromoNotStarted):
+		return "Промокод ещё не действует. Попробуйте позже.", true
+	case errors.Is(err, service.ErrPromoExpired):
+		return "Срок действия промокода истёк.", true
+	case errors.Is(err, service.ErrPromoNewUsersOnly):
+		return "Этот промокод доступен только новым пользователям.", true
+	case errors.Is(err, service.ErrPromoDiscountOnly):
+		return "Это промокод на скидку. Используйте его при покупке: /buy КОД", true
+	case errors.Is(err, service.ErrPromoNotDiscount):
+		return "Это не промокод на скидку. Активируйте его командой /promo КОД", true
+	case errors.Is(err, service.ErrPromoNotForPlan):
+		return "Промокод не действует для этого тарифа.", true
+	case errors.Is(err, service.ErrPromoDiscountTooLarge):
+		return "Промокод не может быть применён к этому тарифу.", true
+	default:
+		return "", false
+	}
+}
+
+func promoActivatedText(activation *service.PromoActivation) string {
+	if activation.CreditType == models.CostTypePaid {
+		return fmt.Sprintf("Промокод активирован! +%d платных кредитов.", activation.Credits)
+	}
+	return fmt.Sprintf("Промокод активирован! +%d кредитов.", activation.Credits)
+}
+
+func (b *Bot) handleBuy(ctx context.Context, from *tgbotapi.User, chatID int64, promoCode string) {
+	user, _, err := b.ensureUser(ctx, from, chatID)
+	if err != nil {
+		b.log.Error("ensure user buy", "err", err)
 		return
 	}
-	b.sendText(msg.Chat.ID, fmt.Sprintf("Промокод активирован! +%d кредитов.", b.cfg.PromoBonusGenerations))
+	if err := b.payments.SendInvoice(ctx, b.api, user, chatID, promoCode); err != nil {
+		if errors.Is(err, service.ErrUserBanned) {
+			b.sendText(chatID, "Покупка недоступна: "+banText(user))
+			return
+		}
+		if text, ok := promoErrorText(err); ok {
+			b.sendText(chatID, text)
+			return
+		}
+		b.log.Error("send invoice", "err", err)
+		b.sendText(chatID, "Не удалось отправить счет. Попробуйте позже.")
+	}
 }
 
 func (b *Bot) handleBalance(ctx context.Context, msg *tgbotapi.Message) {
@@ -252,12 +492,20 @@ func (b *Bot) promptModelSelection(chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, "Выберите модель. Можно добавить до 8 референсов, затем отправьте промпт.")
 	msg.ReplyMarkup = keyboard
 	if _, err := b.api.Send(msg); err != nil {
-		b.log.Error("send keyboard", "err", err)
+		b.sendFailed(chatID, "send keyboard", err)
 	}
 }
 
 func (b *Bot) handleCallback(ctx context.Context, cb *tgbotapi.CallbackQuery) {
+	if b.fullyBanned(ctx, cb.From) != nil {
+		if _, err := b.api.Request(tgbotapi.NewCallback(cb.ID, "Аккаунт заблокирован")); err != nil {
+			b.log.Error("callback ack", "err", err)
+		}
+		return
+	}
 	switch cb.Data {
+	case callbackBroadcastConfirm, callbackBroadcastCancel:
+		b.handleBroadcastDecision(ctx, cb)
 	case string(models.ModelFlux2), string(models.ModelNanoBanana):
 		session := b.state.Get(cb.Message.Chat.ID)
 		session.State = StateAwaitingPrompt
@@ -267,6 +515,11 @@ func (b *Bot) handleCallback(ctx context.Context, cb *tgbotapi.CallbackQuery) {
 			b.log.Error("callback ack", "err", err)
 		}
 		b.sendText(cb.Message.Chat.ID, "Пришлите до 8 изображений (если нужны референсы), затем отправьте промпт.")
+	case service.CallbackBuyCredits:
+		if _, err := b.api.Request(tgbotapi.NewCallback(cb.ID, "")); err != nil {
+			b.log.Error("callback ack", "err", err)
+		}
+		b.handleBuy(ctx, cb.From, cb.Message.Chat.ID, "")
 	default:
 		if _, err := b.api.Request(tgbotapi.NewCallback(cb.ID, "Неизвестный выбор")); err != nil {
 			b.log.Error("callback error", "err", err)
@@ -303,6 +556,11 @@ func (b *Bot) handlePrompt(ctx context.Context, msg *tgbotapi.Message, session *
 
 	result, err := b.generation.Generate(ctx, user, req)
 	if err != nil {
+		if errors.Is(err, service.ErrGenerationBanned) {
+			b.sendText(msg.Chat.ID, banText(user))
+			b.state.Reset(msg.Chat.ID)
+			return
+		}
 		if errors.Is(err, service.ErrCreditsRequired) {
 			b.sendText(msg.Chat.ID, "Недостаточно кредитов. Используйте /buy для покупки или /promo для ввода промокода.")
 			return
@@ -333,7 +591,7 @@ func (b *Bot) deliverImage(chatID int64, result *service.GenerationResult) {
 	}
 	cfg.Caption = fmt.Sprintf("Модель: %s\nТип списания: %s", result.Model, result.Cost)
 	if _, err := b.api.Send(cfg); err != nil {
-		b.log.Error("send image", "err", err)
+		b.sendFailed(chatID, "send image", err)
 	}
 }
 
@@ -349,6 +607,9 @@ func (b *Bot) handleReferenceImage(ctx context.Context, msg *tgbotapi.Message) e
 		if mt := strings.ToLower(msg.Document.MimeType); mt != "" && !strings.HasPrefix(mt, "image/") {
 			return errReferenceNotImage
 		}
+		if msg.Document.FileSize > maxReferenceBytes {
+			return errReferenceTooLarge
+		}
 		fileID = msg.Document.FileID
 		if msg.Document.MimeType != "" {
 			contentType = msg.Document.MimeType
@@ -389,7 +650,35 @@ func (b *Bot) downloadFile(ctx context.Context, fileID string) ([]byte, string,
 	if file.FilePath == "" {
 		return nil, "", fmt.Errorf("file path empty")
 	}
-	url := fmt.Sprintf("https://api.telegram.org/file/bot%s/%s", b.api.Token, file.FilePath)
+	if file.FileSize > maxReferenceBytes {
+		return nil, "", errReferenceTooLarge
+	}
+
+	var body []byte
+	var headerCT string
+	// A local Bot API server started with --local reports absolute paths on its own
+	// disk instead of serving downloads; the bot must see the same directory.
+	if path.IsAbs(file.FilePath) {
+		body, err = readLocalFile(file.FilePath)
+		if err != nil {
+			return nil, "", err
+		}
+	} else {
+		body, headerCT, err = b.fetchFile(ctx, file.FilePath)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	ct, err := normalizeImageContentType(headerCT, body)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, ct, nil
+}
+
+// fetchFile downloads a file from the Bot API server's file endpoint.
+func (b *Bot) fetchFile(ctx context.Context, filePath string) ([]byte, string, error) {
+	url := fmt.Sprintf(b.fileEndpoint, b.api.Token, filePath)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, "", fmt.Errorf("build request: %w", err)
@@ -402,15 +691,37 @@ func (b *Bot) downloadFile(ctx context.Context, fileID string) ([]byte, string,
 	if resp.StatusCode >= 300 {
 		return nil, "", fmt.Errorf("telegram file status: %d", resp.StatusCode)
 	}
-	body, err := io.ReadAll(resp.Body)
+	body, err := readReference(resp.Body)
 	if err != nil {
 		return nil, "", fmt.Errorf("read file body: %w", err)
 	}
-	ct, err := normalizeImageContentType(resp.Header.Get("Content-Type"), body)
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+func readLocalFile(name string) ([]byte, error) {
+	f, err := os.Open(name)
 	if err != nil {
-		return nil, "", err
+		return nil, fmt.Errorf("open local file: %w", err)
 	}
-	return body, ct, nil
+	defer f.Close()
+	body, err := readReference(f)
+	if err != nil {
+		return nil, fmt.Errorf("read local file: %w", err)
+	}
+	return body, nil
+}
+
+// readReference reads r up to maxReferenceBytes, in case the reported size was missing
+// or wrong.
+func readReference(r io.Reader) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, maxReferenceBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxReferenceBytes {
+		return nil, errReferenceTooLarge
+	}
+	return body, nil
 }
 
 func (b *Bot) ensureUser(ctx context.Context, from *tgbotapi.User, chatID int64) (*models.User, bool, error) {
@@ -420,25 +731,58 @@ func (b *Bot) ensureUser(ctx context.Context, from *tgbotapi.User, chatID int64)
 	}
 	firstName := ""
 	lastName := ""
+	languageCode := ""
 	if from != nil {
 		firstName = from.FirstName
 		lastName = from.LastName
+		languageCode = from.LanguageCode
 	}
 	telegramID := chatID
 	if from != nil {
 		telegramID = int64(from.ID)
 	}
-	user, created, err := b.users.Ensure(ctx, telegramID, username, firstName, lastName, b.cfg.FreeDailyGenerations)
+	user, created, err := b.users.Ensure(ctx, telegramID, username, firstName, lastName, languageCode, b.cfg.FreeDailyGenerations)
 	if err != nil {
 		return nil, false, err
 	}
 	return user, created, nil
 }
 
+// fullyBanned returns the sender when they are under an active full ban. Lookup errors
+// are logged and treated as not banned so an outage does not lock everyone out.
+func (b *Bot) fullyBanned(ctx context.Context, from *tgbotapi.User) *models.User {
+	if from == nil {
+		return nil
+	}
+	user, err := b.users.FindByTelegramID(ctx, from.ID)
+	if err != nil {
+		b.log.Error("ban check", "telegram_id", from.ID, "err", err)
+		return nil
+	}
+	if user == nil || user.ActiveBan(time.Now()) != models.BanFull {
+		return nil
+	}
+	return user
+}
+
+func banText(user *models.User) string {
+	text := "Ваш аккаунт заблокирован."
+	if user.ActiveBan(time.Now()) == models.BanGeneration {
+		text = "Генерация для вашего аккаунта ограничена. Баланс доступен через /balance."
+	}
+	if user.BanReason != "" {
+		text += "\nПричина: " + user.BanReason
+	}
+	if user.BannedUntil != nil {
+		text += "\nДо: " + user.BannedUntil.Format("02.01.2006 15:04")
+	}
+	return text
+}
+
 func (b *Bot) sendText(chatID int64, text string) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	if _, err := b.api.Send(msg); err != nil {
-		b.log.Error("send text", "err", err)
+		b.sendFailed(chatID, "send text", err)
 	}
 }
 
@@ -0,0 +1,320 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/digkill/TGStickerBot/internal/config"
+	"github.com/digkill/TGStickerBot/internal/database"
+	"github.com/digkill/TGStickerBot/internal/kie"
+	"github.com/digkill/TGStickerBot/internal/kie/kietest"
+	"github.com/digkill/TGStickerBot/internal/models"
+	"github.com/digkill/TGStickerBot/internal/repository"
+	"github.com/digkill/TGStickerBot/internal/service"
+	"github.com/digkill/TGStickerBot/internal/storage"
+	"github.com/digkill/TGStickerBot/internal/telegram/telegramtest"
+)
+
+// waitTimeout bounds every wait for the bot to react to an update.
+const waitTimeout = 5 * time.Second
+
+// e2e is a bot wired like cmd/bot does it, over SQLite, talking to fake Telegram and
+// KIE servers.
+type e2e struct {
+	tg       *telegramtest.Server
+	kie      *kietest.Server
+	filesDir string
+	users    *service.UserService
+}
+
+func newE2E(t *testing.T) *e2e {
+	t.Helper()
+	ctx := context.Background()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tg := telegramtest.NewServer(t)
+	kieServer := kietest.NewServer(t)
+	cfg := config.Config{
+		BotToken:                     telegramtest.Token,
+		TelegramAPIURL:               tg.URL(),
+		KIEAPIKey:                    kietest.APIKey,
+		KIEBaseURL:                   kieServer.URL(),
+		KIEPollInterval:              time.Millisecond,
+		RequestTimeout:               5 * time.Second,
+		SubscriptionChannelUsername:  "stickers",
+		SubscriptionBonusGenerations: 100,
+		PaymentProvider:              "telegram",
+		TelegramPaymentProviderToken: "test-provider-token",
+		PaymentCurrency:              "RUB",
+		PaymentPriceMinorUnits:       29900,
+		PaymentCreditsPerPackage:     50,
+		BroadcastRatePerSecond:       20,
+	}
+
+	db, _, err := database.Open("sqlite://" + filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.Migrate(ctx, db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	userRepo := repository.NewUserRepository(db)
+	generationRepo := repository.NewGenerationRepository(db)
+	paymentRepo := repository.NewPaymentRepository(db)
+	referralRepo := repository.NewReferralRepository(db)
+
+	userService := service.NewUserService(userRepo, generationRepo, paymentRepo)
+	planService := service.NewPlanService(cfg, repository.NewPlanRepository(db))
+	generationService := service.NewGenerationService(cfg, log, userRepo, generationRepo, kie.NewClient(cfg, log))
+	promoService := service.NewPromoService(cfg, repository.NewPromoRepository(db))
+	referralService := service.NewReferralService(cfg, log, referralRepo, userRepo)
+	paymentService := service.NewPaymentService(cfg, log, paymentRepo, userRepo, planService, promoService, referralService)
+	statsService := service.NewStatsService(repository.NewStatsRepository(db))
+	broadcastService := service.NewBroadcastService(cfg, log, repository.NewBroadcastRepository(db), userRepo)
+	adminService := service.NewAdminService(repository.NewAdminRepository(db))
+	if err := planService.EnsureDefaultPlan(ctx); err != nil {
+		t.Fatalf("default plan: %v", err)
+	}
+
+	api, err := NewAPI(cfg)
+	if err != nil {
+		t.Fatalf("connect to fake telegram: %v", err)
+	}
+	filesDir := t.TempDir()
+	files := httptest.NewServer(nil)
+	t.Cleanup(files.Close)
+	local, err := storage.NewLocal(storage.LocalConfig{Dir: filesDir, PublicBaseURL: files.URL + "/files"}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files.Config.Handler = local
+	bot := NewBot(cfg, api, log, userService, generationService, promoService, paymentService, referralService, statsService, broadcastService, adminService, local)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = bot.Run(runCtx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return &e2e{tg: tg, kie: kieServer, filesDir: filesDir, users: userService}
+}
+
+// credits reloads a user's balances.
+func (e *e2e) credits(t *testing.T, telegramID int64) (promo, paid int) {
+	t.Helper()
+	user, err := e.users.FindByTelegramID(context.Background(), telegramID)
+	if err != nil || user == nil {
+		t.Fatalf("find user %d: %v", telegramID, err)
+	}
+	return user.PromoCredits, user.PaidCredits
+}
+
+// waitText waits for a sendMessage to the user containing substr.
+func (e *e2e) waitText(t *testing.T, chatID int64, substr string) {
+	t.Helper()
+	e.tg.WaitFor(t, "sendMessage", func(c telegramtest.Call) bool {
+		return c.Params.Get("chat_id") == fmt.Sprint(chatID) && strings.Contains(c.Params.Get("text"), substr)
+	}, waitTimeout)
+}
+
+// stored returns the contents of the files kept by local storage.
+func (e *e2e) stored(t *testing.T) [][]byte {
+	t.Helper()
+	entries, err := os.ReadDir(e.filesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files [][]byte
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(e.filesDir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, data)
+	}
+	return files
+}
+
+func pngBytes(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestE2EGenerateWithReference(t *testing.T) {
+	e := newE2E(t)
+	user := tgbotapi.User{ID: 1001, FirstName: "Анна", UserName: "anna"}
+	e.tg.SetChatMember(user.ID, "member")
+
+	e.tg.SendMessage(user, "/start")
+	e.waitText(t, user.ID, "Спасибо за подписку! +100")
+	e.waitText(t, user.ID, "Привет, Анна!")
+
+	e.tg.SendMessage(user, "/generate")
+	e.waitText(t, user.ID, "Выберите модель")
+	e.tg.SendCallback(user, string(models.ModelFlux2))
+	e.tg.WaitFor(t, "answerCallbackQuery", nil, waitTimeout)
+	e.waitText(t, user.ID, "Пришлите до 8 изображений")
+
+	ref := pngBytes(t)
+	e.tg.AddFile("ref-1", ref)
+	e.tg.SendPhoto(user, "ref-1")
+	e.waitText(t, user.ID, "Референс сохранён (1/8)")
+
+	result := "https://cdn.test/results/cat.png"
+	e.kie.Script(kietest.Waiting("queueing"), kietest.Waiting("generating"), kietest.Success(result))
+	e.tg.SendMessage(user, "котик в шляпе")
+	photo := e.tg.WaitFor(t, "sendPhoto", nil, waitTimeout)
+
+	if got := photo.Params.Get("photo"); got != result {
+		t.Errorf("photo = %q, want %q", got, result)
+	}
+	if caption := photo.Params.Get("caption"); !strings.Contains(caption, "Тип списания: promo") {
+		t.Errorf("caption = %q", caption)
+	}
+
+	tasks := e.kie.Tasks()
+	if len(tasks) != 1 {
+		t.Fatalf("kie tasks = %d, want 1", len(tasks))
+	}
+	if tasks[0].Model != "flux-2/pro-image-to-image" || tasks[0].Input["prompt"] != "котик в шляпе" {
+		t.Errorf("task = %s %v", tasks[0].Model, tasks[0].Input)
+	}
+	refs := tasks[0].InputURLs()
+	if len(refs) != 1 {
+		t.Fatalf("task references = %v, want 1", refs)
+	}
+	// KIE fetches the reference from local storage's public URL.
+	resp, err := http.Get(refs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, ref) || resp.Header.Get("Content-Type") != "image/png" {
+		t.Errorf("GET %s = %d %s, want the reference as image/png", refs[0], resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if promo, paid := e.credits(t, user.ID); promo != 95 || paid != 0 {
+		t.Errorf("credits = %d promo, %d paid; want 95, 0", promo, paid)
+	}
+}
+
+func TestE2EReferenceFromLocalBotAPI(t *testing.T) {
+	e := newE2E(t)
+	e.tg.ServeLocalFiles(t.TempDir())
+	user := tgbotapi.User{ID: 1004, FirstName: "Глеб"}
+
+	e.tg.SendMessage(user, "/generate")
+	e.waitText(t, user.ID, "Выберите модель")
+	e.tg.SendCallback(user, string(models.ModelNanoBanana))
+	e.waitText(t, user.ID, "Пришлите до 8 изображений")
+
+	ref := pngBytes(t)
+	e.tg.AddFile("local-ref", ref)
+	e.tg.SendPhoto(user, "local-ref")
+	e.waitText(t, user.ID, "Референс сохранён (1/8)")
+
+	if files := e.stored(t); len(files) != 1 || !bytes.Equal(files[0], ref) {
+		t.Fatalf("stored %d files, want the reference read from disk", len(files))
+	}
+}
+
+func TestE2ERejectsLargeReference(t *testing.T) {
+	e := newE2E(t)
+	doc := tgbotapi.User{ID: 1005, FirstName: "Дина"}
+	photo := tgbotapi.User{ID: 1006, FirstName: "Егор"}
+	for _, user := range []tgbotapi.User{doc, photo} {
+		e.tg.SendMessage(user, "/generate")
+		e.waitText(t, user.ID, "Выберите модель")
+		e.tg.SendCallback(user, string(models.ModelNanoBanana))
+		e.waitText(t, user.ID, "Пришлите до 8 изображений")
+	}
+
+	// The reported size is enough to refuse a document without downloading it.
+	e.tg.SendDocument(doc, "huge-doc", "image/png", 2<<30)
+	e.waitText(t, doc.ID, "Файл слишком большой")
+	if calls := e.tg.Calls("getFile"); len(calls) != 0 {
+		t.Errorf("getFile called %d times for an oversized document", len(calls))
+	}
+
+	e.tg.AddFile("huge-photo", make([]byte, maxReferenceBytes+1))
+	e.tg.SendPhoto(photo, "huge-photo")
+	e.waitText(t, photo.ID, "Файл слишком большой")
+	if files := e.stored(t); len(files) != 0 {
+		t.Errorf("stored %d oversized references", len(files))
+	}
+}
+
+func TestE2EFailedGenerationKeepsCredits(t *testing.T) {
+	e := newE2E(t)
+	user := tgbotapi.User{ID: 1002, FirstName: "Борис"}
+	e.tg.SetChatMember(user.ID, "member")
+
+	e.tg.SendMessage(user, "/start")
+	e.waitText(t, user.ID, "Привет, Борис!")
+	e.tg.SendMessage(user, "/generate")
+	e.waitText(t, user.ID, "Выберите модель")
+	e.tg.SendCallback(user, string(models.ModelNanoBanana))
+	e.waitText(t, user.ID, "Пришлите до 8 изображений")
+
+	e.kie.Script(kietest.Waiting("waiting"), kietest.Fail("400", "content policy"))
+	e.tg.SendMessage(user, "что-то запрещённое")
+	e.waitText(t, user.ID, "Не удалось запустить генерацию")
+
+	if photos := e.tg.Calls("sendPhoto"); len(photos) != 0 {
+		t.Errorf("sent %d photos after a failed task", len(photos))
+	}
+	if promo, _ := e.credits(t, user.ID); promo != 100 {
+		t.Errorf("promo credits = %d, want 100 untouched", promo)
+	}
+}
+
+func TestE2EBuyCredits(t *testing.T) {
+	e := newE2E(t)
+	user := tgbotapi.User{ID: 1003, FirstName: "Вера"}
+
+	e.tg.SendMessage(user, "/buy")
+	invoice := e.tg.WaitFor(t, "sendInvoice", nil, waitTimeout)
+	if invoice.Params.Get("currency") != "RUB" || invoice.Params.Get("provider_token") != "test-provider-token" {
+		t.Errorf("invoice = %v", invoice.Params)
+	}
+
+	queryID := e.tg.SendPreCheckout(user, invoice)
+	answer := e.tg.WaitFor(t, "answerPreCheckoutQuery", nil, waitTimeout)
+	if answer.Params.Get("pre_checkout_query_id") != queryID || answer.Params.Get("ok") != "true" {
+		t.Errorf("pre-checkout answer = %v", answer.Params)
+	}
+
+	e.tg.SendSuccessfulPayment(user, invoice)
+	e.waitText(t, user.ID, "Оплата успешно получена")
+	if promo, paid := e.credits(t, user.ID); promo != 0 || paid != 50 {
+		t.Errorf("credits = %d promo, %d paid; want 0, 50", promo, paid)
+	}
+
+	e.tg.SendMessage(user, "/balance")
+	e.waitText(t, user.ID, "Платные кредиты: 50")
+}
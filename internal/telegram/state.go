@@ -20,6 +20,8 @@ type Session struct {
 	AspectRatio   string
 	Resolution    string
 	ReferenceURLs []string
+	// BroadcastDraft is an admin's /broadcast text awaiting confirmation.
+	BroadcastDraft string
 }
 
 type StateManager struct {
@@ -63,6 +65,19 @@ func (m *StateManager) Reset(chatID int64) {
 	})
 }
 
+// Active counts chats that are in the middle of a dialog (not idle).
+func (m *StateManager) Active() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n := 0
+	for _, session := range m.sessions {
+		if session.State != StateIdle {
+			n++
+		}
+	}
+	return n
+}
+
 func (m *StateManager) ClearReferences(chatID int64) {
 	m.mu.Lock()
 	if session, ok := m.sessions[chatID]; ok {
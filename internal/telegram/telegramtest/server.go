@@ -0,0 +1,405 @@
+// Package telegramtest runs an in-process fake of the Telegram Bot API, so tests can
+// drive the bot through the real tgbotapi client: updates are queued with Send*,
+// and everything the bot calls is recorded for assertions.
+package telegramtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Token is the bot token the fake accepts.
+const Token = "123456:test-token"
+
+// BotID is the user ID of the bot itself, as reported by getMe.
+const BotID = 123456
+
+// maxPoll caps how long getUpdates holds a request open when no update is queued.
+const maxPoll = time.Second
+
+// Call is one Bot API request received by the server.
+type Call struct {
+	Method string
+	Params url.Values
+}
+
+// Server is the fake Bot API. Methods it does not know are accepted and answered
+// with a generic message, the way the send* family would be.
+type Server struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	updates  []tgbotapi.Update
+	nextID   int
+	calls    []Call
+	files    map[string][]byte
+	members  map[int64]string
+	localDir string
+	notify   chan struct{}
+	closing  chan struct{}
+	closeOne sync.Once
+}
+
+// NewServer starts a fake Bot API and stops it when the test ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	s := &Server{
+		nextID:  1,
+		files:   make(map[string][]byte),
+		members: make(map[int64]string),
+		notify:  make(chan struct{}),
+		closing: make(chan struct{}),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// URL is the server's base URL, for config.Config.TelegramAPIURL.
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// NewBotAPI returns a client connected to the server, for tests that call services
+// directly instead of running the bot.
+func (s *Server) NewBotAPI(t testing.TB) *tgbotapi.BotAPI {
+	t.Helper()
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint(Token, s.srv.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatalf("connect to fake telegram: %v", err)
+	}
+	return bot
+}
+
+// Close releases pending long polls and shuts the server down.
+func (s *Server) Close() {
+	s.closeOne.Do(func() {
+		close(s.closing)
+		s.srv.Close()
+	})
+}
+
+// SendUpdate queues an update for getUpdates and returns its update ID.
+func (s *Server) SendUpdate(update tgbotapi.Update) int {
+	s.mu.Lock()
+	update.UpdateID = s.nextID
+	s.nextID++
+	s.updates = append(s.updates, update)
+	close(s.notify)
+	s.notify = make(chan struct{})
+	s.mu.Unlock()
+	return update.UpdateID
+}
+
+// SendMessage queues a private text message from user. Text starting with "/" is
+// marked as a bot command.
+func (s *Server) SendMessage(from tgbotapi.User, text string) {
+	msg := s.message(from)
+	msg.Text = text
+	if strings.HasPrefix(text, "/") {
+		length := len(text)
+		if i := strings.IndexByte(text, ' '); i >= 0 {
+			length = i
+		}
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: length}}
+	}
+	s.SendUpdate(tgbotapi.Update{Message: msg})
+}
+
+// SendPhoto queues a photo message; fileID must have been added with AddFile.
+func (s *Server) SendPhoto(from tgbotapi.User, fileID string) {
+	msg := s.message(from)
+	msg.Photo = []tgbotapi.PhotoSize{{FileID: fileID, FileUniqueID: fileID, Width: 512, Height: 512}}
+	s.SendUpdate(tgbotapi.Update{Message: msg})
+}
+
+// SendDocument queues a file sent as a document. Its file is not downloadable unless
+// fileID was added with AddFile.
+func (s *Server) SendDocument(from tgbotapi.User, fileID, mimeType string, size int) {
+	msg := s.message(from)
+	msg.Document = &tgbotapi.Document{FileID: fileID, FileUniqueID: fileID, MimeType: mimeType, FileSize: size}
+	s.SendUpdate(tgbotapi.Update{Message: msg})
+}
+
+// SendCallback queues a press of an inline button carrying data.
+func (s *Server) SendCallback(from tgbotapi.User, data string) {
+	s.SendUpdate(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "cb-" + strconv.Itoa(s.peekID()),
+		From:    &from,
+		Message: s.message(from),
+		Data:    data,
+	}})
+}
+
+// SendPreCheckout queues the query Telegram sends when the user confirms paying
+// invoice, and returns its ID.
+func (s *Server) SendPreCheckout(from tgbotapi.User, invoice Call) string {
+	id := "pcq-" + strconv.Itoa(s.peekID())
+	s.SendUpdate(tgbotapi.Update{PreCheckoutQuery: &tgbotapi.PreCheckoutQuery{
+		ID:             id,
+		From:           &from,
+		Currency:       invoice.Params.Get("currency"),
+		TotalAmount:    totalAmount(invoice.Params.Get("prices")),
+		InvoicePayload: invoice.Params.Get("payload"),
+	}})
+	return id
+}
+
+// SendSuccessfulPayment queues the service message Telegram sends once an invoice is
+// paid. The payload is taken from the invoice the bot sent.
+func (s *Server) SendSuccessfulPayment(from tgbotapi.User, invoice Call) {
+	msg := s.message(from)
+	msg.SuccessfulPayment = &tgbotapi.SuccessfulPayment{
+		Currency:                invoice.Params.Get("currency"),
+		TotalAmount:             totalAmount(invoice.Params.Get("prices")),
+		InvoicePayload:          invoice.Params.Get("payload"),
+		TelegramPaymentChargeID: "tg-charge-" + strconv.Itoa(s.peekID()),
+		ProviderPaymentChargeID: "provider-charge-" + strconv.Itoa(s.peekID()),
+	}
+	s.SendUpdate(tgbotapi.Update{Message: msg})
+}
+
+// AddFile makes data downloadable through getFile and the file endpoint.
+func (s *Server) AddFile(fileID string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[fileID] = data
+}
+
+// ServeLocalFiles switches to the behaviour of a Bot API server started with --local:
+// getFile reports absolute paths of copies written under dir, and the file download
+// endpoint is gone.
+func (s *Server) ServeLocalFiles(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.localDir = dir
+}
+
+// SetChatMember sets the status getChatMember reports for a user, in any chat.
+// Unknown users are "left".
+func (s *Server) SetChatMember(userID int64, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.members[userID] = status
+}
+
+// Calls returns the recorded requests to method, in order.
+func (s *Server) Calls(method string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var calls []Call
+	for _, c := range s.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// WaitFor blocks until the bot has made a call to method that satisfies match (nil
+// matches any) and returns it, failing the test after timeout.
+func (s *Server) WaitFor(t testing.TB, method string, match func(Call) bool, timeout time.Duration) Call {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		for _, c := range s.Calls(method) {
+			if match == nil || match(c) {
+				return c
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no %s call matched within %s; got %v", method, timeout, s.Calls(method))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (s *Server) peekID() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nextID
+}
+
+func (s *Server) message(from tgbotapi.User) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		MessageID: s.peekID(),
+		From:      &from,
+		Date:      int(time.Now().Unix()),
+		Chat:      &tgbotapi.Chat{ID: from.ID, Type: "private", FirstName: from.FirstName, UserName: from.UserName},
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/file/bot"+Token+"/") {
+		s.serveFile(w, r, strings.TrimPrefix(r.URL.Path, "/file/bot"+Token+"/"))
+		return
+	}
+	prefix := "/bot" + Token + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, prefix)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		_ = r.ParseMultipartForm(32 << 20)
+	} else {
+		_ = r.ParseForm()
+	}
+
+	switch method {
+	case "getMe":
+		writeResult(w, tgbotapi.User{ID: BotID, IsBot: true, FirstName: "Test", UserName: "test_bot"})
+		return
+	case "getUpdates":
+		s.serveUpdates(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	s.calls = append(s.calls, Call{Method: method, Params: r.PostForm})
+	s.mu.Unlock()
+
+	switch method {
+	case "getFile":
+		s.serveGetFile(w, r.PostForm.Get("file_id"))
+	case "getChatMember":
+		userID, _ := strconv.ParseInt(r.PostForm.Get("user_id"), 10, 64)
+		s.mu.Lock()
+		status, ok := s.members[userID]
+		s.mu.Unlock()
+		if !ok {
+			status = "left"
+		}
+		writeResult(w, tgbotapi.ChatMember{User: &tgbotapi.User{ID: userID}, Status: status})
+	case "answerCallbackQuery", "answerPreCheckoutQuery", "setMyCommands", "deleteMessage":
+		writeResult(w, true)
+	default:
+		chatID, _ := strconv.ParseInt(r.PostForm.Get("chat_id"), 10, 64)
+		writeResult(w, tgbotapi.Message{
+			MessageID: s.peekID(),
+			Date:      int(time.Now().Unix()),
+			Chat:      &tgbotapi.Chat{ID: chatID, Type: "private"},
+			Text:      r.PostForm.Get("text"),
+		})
+	}
+}
+
+// serveUpdates long-polls like the real getUpdates: it answers as soon as an update
+// at or after offset is queued, or with an empty list after the timeout.
+func (s *Server) serveUpdates(w http.ResponseWriter, r *http.Request) {
+	offset, _ := strconv.Atoi(r.PostForm.Get("offset"))
+	timeout := maxPoll
+	if secs, err := strconv.Atoi(r.PostForm.Get("timeout")); err == nil && time.Duration(secs)*time.Second < timeout {
+		timeout = time.Duration(secs) * time.Second
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		var pending []tgbotapi.Update
+		for _, u := range s.updates {
+			if u.UpdateID >= offset {
+				pending = append(pending, u)
+			}
+		}
+		notify := s.notify
+		s.mu.Unlock()
+		if len(pending) > 0 {
+			writeResult(w, pending)
+			return
+		}
+		select {
+		case <-notify:
+		case <-timer.C:
+			writeResult(w, []tgbotapi.Update{})
+			return
+		case <-s.closing:
+			writeResult(w, []tgbotapi.Update{})
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) serveGetFile(w http.ResponseWriter, fileID string) {
+	s.mu.Lock()
+	data, ok := s.files[fileID]
+	localDir := s.localDir
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Bad Request: invalid file_id")
+		return
+	}
+	filePath := "photos/" + fileID
+	if localDir != "" {
+		filePath = filepath.Join(localDir, "photos", fileID)
+		err := os.MkdirAll(filepath.Dir(filePath), 0o755)
+		if err == nil {
+			err = os.WriteFile(filePath, data, 0o644)
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	writeResult(w, tgbotapi.File{
+		FileID:       fileID,
+		FileUniqueID: fileID,
+		FileSize:     len(data),
+		FilePath:     filePath,
+	})
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, path string) {
+	s.mu.Lock()
+	data, ok := s.files[strings.TrimPrefix(path, "photos/")]
+	local := s.localDir != ""
+	s.mu.Unlock()
+	if !ok || local {
+		http.NotFound(w, r)
+		return
+	}
+	_, _ = w.Write(data)
+}
+
+func writeResult(w http.ResponseWriter, result any) {
+	raw, err := json.Marshal(result)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = fmt.Fprintf(w, `{"ok":true,"result":%s}`, raw)
+}
+
+func writeError(w http.ResponseWriter, status int, description string) {
+	raw, _ := json.Marshal(description)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, fmt.Sprintf(`{"ok":false,"error_code":%d,"description":%s}`, status, raw))
+}
+
+// totalAmount sums the prices of an invoice, as Telegram charges them.
+func totalAmount(pricesJSON string) int {
+	var prices []tgbotapi.LabeledPrice
+	_ = json.Unmarshal([]byte(pricesJSON), &prices)
+	total := 0
+	for _, p := range prices {
+		total += p.Amount
+	}
+	return total
+}